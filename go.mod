@@ -11,6 +11,7 @@ require (
 	github.com/jonyTF/go-webdav v0.5.2
 	github.com/k2-fsa/sherpa-onnx-go v1.12.6
 	github.com/sirupsen/logrus v1.9.3
+	github.com/teambition/rrule-go v1.8.2
 	golang.org/x/crypto v0.41.0
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -22,6 +23,7 @@ require (
 	github.com/k2-fsa/sherpa-onnx-go-linux v1.12.6 // indirect
 	github.com/k2-fsa/sherpa-onnx-go-macos v1.12.6 // indirect
 	github.com/k2-fsa/sherpa-onnx-go-windows v1.12.6 // indirect
-	github.com/teambition/rrule-go v1.8.2 // indirect
+	golang.org/x/net v0.42.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
 )
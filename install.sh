@@ -121,6 +121,48 @@ else
     echo "Voice models skipped. You can download them later by following the instructions in README.md"
 fi
 
+# Ask user if they want to download the wake word model
+echo ""
+read -p "Do you want to download the wake word model (~20MB)? (y/N): " -n 1 -r
+echo
+if [[ $REPLY =~ ^[Yy]$ ]]; then
+    mkdir -p ~/srm/resources/models/kws
+    cd ~/srm/resources/models/kws
+
+    echo "Downloading wake word model..."
+    if wget -q --show-progress https://github.com/k2-fsa/sherpa-onnx/releases/download/kws-models/sherpa-onnx-kws-zipformer-gigaspeech-3.3M-2024-01-01.tar.bz2; then
+        echo "Extracting wake word model..."
+        tar xf sherpa-onnx-kws-zipformer-gigaspeech-3.3M-2024-01-01.tar.bz2
+        rm sherpa-onnx-kws-zipformer-gigaspeech-3.3M-2024-01-01.tar.bz2
+        echo "Wake word model installed successfully."
+    else
+        echo "Warning: Failed to download the wake word model."
+    fi
+
+    cd - > /dev/null
+fi
+
+# Ask user if they want to download the speech recognition model
+echo ""
+read -p "Do you want to download the speech recognition model for voice commands (~100MB)? (y/N): " -n 1 -r
+echo
+if [[ $REPLY =~ ^[Yy]$ ]]; then
+    mkdir -p ~/srm/resources/models/asr
+    cd ~/srm/resources/models/asr
+
+    echo "Downloading speech recognition model..."
+    if wget -q --show-progress https://github.com/k2-fsa/sherpa-onnx/releases/download/asr-models/sherpa-onnx-whisper-tiny.en.tar.bz2; then
+        echo "Extracting speech recognition model..."
+        tar xf sherpa-onnx-whisper-tiny.en.tar.bz2
+        rm sherpa-onnx-whisper-tiny.en.tar.bz2
+        echo "Speech recognition model installed successfully."
+    else
+        echo "Warning: Failed to download the speech recognition model."
+    fi
+
+    cd - > /dev/null
+fi
+
 # Install systemd service
 echo "Installing systemd user service..."
 sudo cp systemd/simple-reminder-user.service /etc/systemd/user
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// alarmGracePeriod is how late an alarm can still be announced, after that
+// it is considered missed, for example because the device was off.
+const alarmGracePeriod = 5 * time.Minute
+
+// parseAlarms returns the trigger times of the VALARMs of an event.
+func parseAlarms(ev ical.Event, start, end time.Time) []time.Time {
+	alarms := []time.Time{}
+	for _, child := range ev.Children {
+		if child.Name != ical.CompAlarm {
+			continue
+		}
+
+		trigger := child.Props.Get(ical.PropTrigger)
+		if trigger == nil {
+			continue
+		}
+
+		// absolute trigger
+		if trigger.ValueType() == ical.ValueDateTime {
+			if t := eventTimeToTime(trigger); !t.IsZero() {
+				alarms = append(alarms, t)
+			}
+			continue
+		}
+
+		// relative trigger, to the start unless RELATED=END
+		offset, err := trigger.Duration()
+		if err != nil {
+			logError("failed to parse alarm trigger %q: %v", trigger.Value, err)
+			continue
+		}
+		if trigger.Params.Get(ical.ParamRelated) == "END" {
+			alarms = append(alarms, end.Add(offset))
+		} else {
+			alarms = append(alarms, start.Add(offset))
+		}
+	}
+
+	sort.Slice(alarms, func(i, j int) bool { return alarms[i].Before(alarms[j]) })
+	return alarms
+}
+
+// shiftAlarms moves the alarms of a recurring event from the master's start to an occurrence.
+func shiftAlarms(alarms []time.Time, from, to time.Time) []time.Time {
+	shifted := make([]time.Time, len(alarms))
+	for i, a := range alarms {
+		shifted[i] = a.Add(to.Sub(from))
+	}
+	return shifted
+}
+
+// dueAlarm returns the alarm of the event that should be announced now, if any.
+// The alarms of a snoozed event are skipped until the end of the snooze.
+func dueAlarm(e *LocalEvent) (time.Time, bool) {
+	if !SysConfig.CalendarAlarms.Enabled || e.Acknowledged {
+		return time.Time{}, false
+	}
+
+	now := clockNow()
+	if now.After(e.Event.EndTime) || now.Before(e.SnoozedUntil) {
+		return time.Time{}, false
+	}
+
+	for _, alarm := range e.Event.Alarms {
+		if now.Before(alarm) || now.Sub(alarm) > alarmGracePeriod || e.alarmAnnounced(alarm) {
+			continue
+		}
+		return alarm, true
+	}
+
+	return time.Time{}, false
+}
+
+// hasCalendarAlarms returns true if the event reminders come from the calendar alarms.
+func hasCalendarAlarms(e *LocalEvent) bool {
+	return SysConfig.CalendarAlarms.Enabled && len(e.Event.Alarms) > 0
+}
+
+func renderAlarmMessage(e *LocalEvent) string {
+	// alarms after the start are just reminders
+	startsIn := clockUntil(e.Event.StartTime)
+	if startsIn < time.Minute {
+		return renderRemindMessage(e)
+	}
+
+	defaultMessage := fmt.Sprintf("Heads up! \"%s\" starts in %s.", e.spokenTitle(), formatDuration(startsIn))
+	tmplText := SysConfig.CalendarAlarms.MessageTemplate.pick()
+	if tmplText == "" {
+		tmplText = "Heads up! \"{{.Event}}\" starts in {{.StartsIn}}."
+	}
+
+	tmpl, err := template.New("alarm").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse alarm template: %v", err)
+		return defaultMessage
+	}
+
+	data := newEventTemplateData(e)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logError("failed to execute alarm template: %v", err)
+		return defaultMessage
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiTokenPrefix makes the tokens recognizable, e.g. by secret scanners.
+const apiTokenPrefix = "rbr_"
+
+// APIToken is a long-lived token accepted by the /api endpoints in place of
+// a session. Only the hash of the token is kept, it is shown once when
+// created.
+type APIToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash,omitempty"`
+	Role      string    `json:"role"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var apiTokens sync.Mutex
+
+// loadAPITokens returns the tokens, without their hashes.
+func loadAPITokens() ([]APIToken, error) {
+	apiTokens.Lock()
+	defer apiTokens.Unlock()
+
+	tokens, err := readAPITokens()
+	if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		tokens[i].Hash = ""
+	}
+	return tokens, nil
+}
+
+func readAPITokens() ([]APIToken, error) {
+	tokens := []APIToken{}
+	data, err := os.ReadFile(realPath(apiTokensPath))
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API tokens: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse API tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+func writeAPITokens(tokens []APIToken) error {
+	data, err := json.MarshalIndent(tokens, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal API tokens: %v", err)
+	}
+	return writeFileAtomically(realPath(apiTokensPath), data, 0600)
+}
+
+// hashAPIToken returns the hash a token is kept as. The tokens are random,
+// so a plain hash is as safe as bcrypt and fast enough for every request.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIToken adds a token and returns it along with its secret value.
+func createAPIToken(name, role, createdBy string) (APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return APIToken{}, "", fmt.Errorf("a token needs a name")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return APIToken{}, "", err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return APIToken{}, "", err
+	}
+	value := apiTokenPrefix + hex.EncodeToString(secret)
+
+	apiTokens.Lock()
+	defer apiTokens.Unlock()
+
+	tokens, err := readAPITokens()
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	token := APIToken{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hashAPIToken(value),
+		Role:      userRole(role),
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if err := writeAPITokens(append(tokens, token)); err != nil {
+		return APIToken{}, "", err
+	}
+
+	token.Hash = ""
+	return token, value, nil
+}
+
+// revokeAPIToken deletes a token, it stops working right away.
+func revokeAPIToken(id string) error {
+	apiTokens.Lock()
+	defer apiTokens.Unlock()
+
+	tokens, err := readAPITokens()
+	if err != nil {
+		return err
+	}
+	for i, t := range tokens {
+		if t.ID == id {
+			return writeAPITokens(append(tokens[:i], tokens[i+1:]...))
+		}
+	}
+	return fmt.Errorf("token %s not found", id)
+}
+
+// authenticateAPIToken returns the token with that value.
+func authenticateAPIToken(value string) (APIToken, bool) {
+	if !strings.HasPrefix(value, apiTokenPrefix) {
+		return APIToken{}, false
+	}
+
+	apiTokens.Lock()
+	defer apiTokens.Unlock()
+
+	tokens, err := readAPITokens()
+	if err != nil {
+		logError("Failed to check the API token: %v", err)
+		return APIToken{}, false
+	}
+	hash := hashAPIToken(value)
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
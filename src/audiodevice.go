@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// AudioDevice is an ALSA output device, as listed by aplay.
+type AudioDevice struct {
+	Name        string `json:"name"`        // Device name for audio_device, e.g. "plughw:CARD=Device,DEV=0"
+	Description string `json:"description"` // What the device is, e.g. "USB Audio Device, USB Audio"
+}
+
+// listAudioDevices returns the ALSA output devices.
+func listAudioDevices() ([]AudioDevice, error) {
+	out, err := exec.Command("aplay", "-L").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audio devices: %v", err)
+	}
+
+	// device names start at the beginning of a line, their description
+	// follows on indented lines
+	devices := []AudioDevice{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			devices = append(devices, AudioDevice{Name: strings.TrimSpace(line)})
+			continue
+		}
+		if len(devices) > 0 {
+			d := &devices[len(devices)-1]
+			d.Description = strings.TrimPrefix(d.Description+", "+strings.TrimSpace(line), ", ")
+		}
+	}
+	return devices, nil
+}
+
+// playOnDevice plays 16-bit little-endian PCM on the named ALSA device with
+// aplay, the audio library only plays on the default device.
+func playOnDevice(device string, pcm []byte, sampleRate, channels int) error {
+	cmd := exec.Command("aplay", "-q", "-D", device, "-t", "raw", "-f", "S16_LE",
+		"-r", strconv.Itoa(sampleRate), "-c", strconv.Itoa(channels))
+	cmd.Stdin = bytes.NewReader(pcm)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to play on %s: %v: %s", device, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/oto/v2"
+)
+
+// The audio output is opened once in this format, the clips are converted to
+// it before they are played.
+const (
+	audioOutputRate     = 44100
+	audioOutputChannels = 2
+)
+
+var (
+	// audioMutex guards audioContext, the audio library supports a single
+	// context per process.
+	audioMutex   sync.Mutex
+	audioContext *oto.Context
+)
+
+// initAudio opens the audio output at startup, so the first announcement
+// doesn't wait for it. Playing opens it again if this fails.
+func initAudio() error {
+	if SysConfig.AudioDevice != "" || SysConfig.DryRun {
+		return nil
+	}
+
+	audioMutex.Lock()
+	defer audioMutex.Unlock()
+
+	_, err := openAudio()
+	return err
+}
+
+// openAudio returns the audio context, opening it again if the device failed,
+// e.g. a USB speaker that was unplugged. The caller must hold audioMutex.
+func openAudio() (*oto.Context, error) {
+	if audioContext != nil {
+		err := audioContext.Err()
+		if err == nil {
+			return audioContext, nil
+		}
+		logWarn("Audio output failed, opening it again: %v", err)
+		audioContext = nil
+	}
+
+	ctx, ready, err := oto.NewContext(audioOutputRate, audioOutputChannels, oto.FormatSignedInt16LE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audio context: %w", err)
+	}
+	<-ready
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to open audio output: %w", err)
+	}
+
+	logInfo("Audio output opened (Sample Rate: %d, Channels: %d)", audioOutputRate, audioOutputChannels)
+	audioContext = ctx
+	return ctx, nil
+}
+
+// playOnContext plays 16-bit little-endian PCM in the output format on the
+// shared audio context, and returns once it has been played. If the device
+// fails while playing, the clip is played again on a new context.
+func playOnContext(pcm []byte) error {
+	audioMutex.Lock()
+	defer audioMutex.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var ctx *oto.Context
+		if ctx, err = openAudio(); err != nil {
+			return err
+		}
+		if err = playPlayer(ctx, pcm); err == nil {
+			return nil
+		}
+		logWarn("Playback failed: %v", err)
+	}
+	return err
+}
+
+// playPlayer plays the PCM on the context until it is done, or the context fails.
+func playPlayer(ctx *oto.Context, pcm []byte) error {
+	player := ctx.NewPlayer(bytes.NewReader(pcm))
+	defer player.Close()
+	player.Play()
+
+	// the player stops once all the samples went to the device, a failed
+	// device stops taking them and the player never stops
+	for player.IsPlaying() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		time.Sleep(playbackPollInterval)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return player.Err()
+}
+
+// convertClip resamples the clip to the given rate and number of channels,
+// with linear interpolation. Extra channels are copies of the last one, and
+// missing ones are mixed down.
+func convertClip(clip audioClip, rate, channels int) audioClip {
+	if clip.SampleRate == rate && clip.Channels == channels {
+		return clip
+	}
+	if clip.SampleRate <= 0 || clip.Channels <= 0 {
+		return audioClip{SampleRate: rate, Channels: channels}
+	}
+
+	inFrames := len(clip.PCM) / (2 * clip.Channels)
+	sample := func(frame, channel int) float64 {
+		return float64(int16(binary.LittleEndian.Uint16(clip.PCM[(frame*clip.Channels+channel)*2:])))
+	}
+	// the value of an output channel at an input frame
+	value := func(frame, channel int) float64 {
+		if clip.Channels > channels {
+			sum := 0.0
+			for c := 0; c < clip.Channels; c++ {
+				sum += sample(frame, c)
+			}
+			return sum / float64(clip.Channels)
+		}
+		return sample(frame, min(channel, clip.Channels-1))
+	}
+
+	outFrames := int(int64(inFrames) * int64(rate) / int64(clip.SampleRate))
+	pcm := make([]byte, outFrames*channels*2)
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * float64(clip.SampleRate) / float64(rate)
+		frame := int(pos)
+		next := min(frame+1, inFrames-1)
+		frac := pos - float64(frame)
+		for c := 0; c < channels; c++ {
+			v := value(frame, c)*(1-frac) + value(next, c)*frac
+			binary.LittleEndian.PutUint16(pcm[(i*channels+c)*2:], uint16(int16(v)))
+		}
+	}
+	return audioClip{PCM: pcm, SampleRate: rate, Channels: channels}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	maxRestoreBytes     = 50 << 20
+	backupEventsDir     = "events/"
+	backupConfigName    = "configs/config.yml"
+	backupSecretsName   = "configs/secrets.yml"
+	backupRemindersName = "reminders.json"
+	backupReviewsName   = "history/reviews.jsonl"
+	backupPauseName     = "pause.json"
+)
+
+// backupFiles maps the names of the single files in a backup to their
+// location on disk, the events are stored under backupEventsDir.
+func backupFiles() map[string]string {
+	return map[string]string{
+		backupConfigName:    realPath(defaultConfig),
+		backupSecretsName:   realPath(defaultSecrets),
+		backupRemindersName: realPath(remindersPath),
+		backupReviewsName:   realPath(reviewsPath),
+		backupPauseName:     realPath(pausePath),
+	}
+}
+
+// writeBackup writes a tar.gz of the configuration, secrets and event state to w.
+func writeBackup(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for name, file := range backupFiles() {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		if err := addTarFile(tw, name, data); err != nil {
+			return err
+		}
+	}
+
+	events, err := readEventFiles()
+	if err != nil {
+		return err
+	}
+	for name, data := range events {
+		if err := addTarFile(tw, backupEventsDir+name, data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish backup archive: %v", err)
+	}
+	return gz.Close()
+}
+
+// readEventFiles returns the content of the event files, read while the
+// events are held still so the backup matches what is in memory.
+func readEventFiles() (map[string][]byte, error) {
+	syncEvent.Lock()
+	defer syncEvent.Unlock()
+
+	eventDir := realPath(SysConfig.EventsPath)
+	files, err := os.ReadDir(eventDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read events directory: %v", err)
+	}
+
+	events := make(map[string][]byte)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(eventDir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read event %s: %v", file.Name(), err)
+		}
+		events[file.Name()] = data
+	}
+	return events, nil
+}
+
+func addTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to add %s to backup: %v", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to add %s to backup: %v", name, err)
+	}
+	return nil
+}
+
+// restoreBackup validates a backup made by writeBackup and applies it. The
+// whole archive is checked before anything is written, so a broken backup
+// leaves the current state untouched.
+func restoreBackup(r io.Reader) error {
+	gz, err := gzip.NewReader(io.LimitReader(r, maxRestoreBytes))
+	if err != nil {
+		return fmt.Errorf("not a gzip archive: %v", err)
+	}
+	defer gz.Close()
+
+	known := backupFiles()
+	files := make(map[string][]byte)
+	events := make(map[string][]byte)
+	total := 0
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		total += int(header.Size)
+		if total > maxRestoreBytes {
+			return fmt.Errorf("backup is too large")
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", header.Name, err)
+		}
+
+		name := path.Clean(header.Name)
+		switch {
+		case known[name] != "":
+			files[name] = data
+		case strings.HasPrefix(name, backupEventsDir) && strings.HasSuffix(name, ".json") &&
+			path.Dir(name) == strings.TrimSuffix(backupEventsDir, "/"):
+			events[path.Base(name)] = data
+		default:
+			return fmt.Errorf("unexpected file %s in backup", header.Name)
+		}
+	}
+
+	if err := validateBackup(files, events); err != nil {
+		return err
+	}
+
+	for name, data := range files {
+		perm := os.FileMode(0644)
+		if strings.HasPrefix(name, "configs/") {
+			perm = 0600
+		}
+		if err := os.MkdirAll(filepath.Dir(known[name]), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %v", name, err)
+		}
+		if err := writeFileAtomically(known[name], data, perm); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", name, err)
+		}
+	}
+
+	if err := restoreEvents(events); err != nil {
+		return err
+	}
+
+	resetPause()
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("restored configuration failed to load: %v", err)
+	}
+	requestRefresh()
+
+	logInfo("Restored backup with %d files and %d events", len(files), len(events))
+	return nil
+}
+
+// validateBackup checks that every file of the backup can be parsed.
+func validateBackup(files map[string][]byte, events map[string][]byte) error {
+	if _, ok := files[backupConfigName]; !ok {
+		return fmt.Errorf("backup has no %s", backupConfigName)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(files[backupConfigName], &config); err != nil {
+		return fmt.Errorf("invalid %s: %v", backupConfigName, err)
+	}
+	if data, ok := files[backupSecretsName]; ok {
+		var secrets Secrets
+		if err := yaml.Unmarshal(data, &secrets); err != nil {
+			return fmt.Errorf("invalid %s: %v", backupSecretsName, err)
+		}
+	}
+	if data, ok := files[backupRemindersName]; ok {
+		var reminders []LocalReminder
+		if err := json.Unmarshal(data, &reminders); err != nil {
+			return fmt.Errorf("invalid %s: %v", backupRemindersName, err)
+		}
+	}
+
+	if data, ok := files[backupPauseName]; ok {
+		var pause PauseState
+		if err := json.Unmarshal(data, &pause); err != nil {
+			return fmt.Errorf("invalid %s: %v", backupPauseName, err)
+		}
+	}
+
+	for name, data := range events {
+		_, e, _, err := decodeEvent(data)
+		if err != nil {
+			return fmt.Errorf("invalid event %s: %v", name, err)
+		}
+		fileName, err := eventFileName(e.Event.ID)
+		if err != nil {
+			return fmt.Errorf("invalid event %s: %v", name, err)
+		}
+		if fileName != name {
+			return fmt.Errorf("event %s has a mismatching id %q", name, e.Event.ID)
+		}
+	}
+
+	return nil
+}
+
+// restoreEvents replaces the local events with the ones of the backup.
+func restoreEvents(events map[string][]byte) error {
+	syncEvent.Lock()
+	defer syncEvent.Unlock()
+
+	eventDir := realPath(SysConfig.EventsPath)
+	if err := os.MkdirAll(eventDir, 0755); err != nil {
+		return fmt.Errorf("failed to create events directory: %v", err)
+	}
+
+	files, err := os.ReadDir(eventDir)
+	if err != nil {
+		return fmt.Errorf("failed to read events directory: %v", err)
+	}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			if err := os.Remove(filepath.Join(eventDir, file.Name())); err != nil {
+				return fmt.Errorf("failed to remove event %s: %v", file.Name(), err)
+			}
+		}
+	}
+
+	for name, data := range events {
+		if err := writeFileAtomically(filepath.Join(eventDir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to restore event %s: %v", name, err)
+		}
+	}
+
+	// read the restored events on next use
+	eventCache = make(map[string]cachedEvent)
+	eventCacheLoaded = false
+	reschedule()
+	return nil
+}
+
+// backupFileName returns the name offered for downloading a backup.
+func backupFileName() string {
+	return "pivoicereminder-backup-" + time.Now().Format("20060102-150405") + ".tar.gz"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// reminderDueWithin returns true if the next reminder of the event is due
+// within the given time, so it can be given along with another one. It is
+// never due while the event is snoozed.
+func reminderDueWithin(e *LocalEvent, within time.Duration) bool {
+	now := clockNow()
+	if now.Before(e.SnoozedUntil) {
+		return false
+	}
+	if e.EndAnnounced || e.Acknowledged || e.Event.EndTime.IsZero() || now.Before(e.Event.StartTime) || now.After(e.Event.EndTime) || e.scheduledNearEnd() {
+		return false
+	}
+	if hasCalendarAlarms(e) && SysConfig.CalendarAlarms.ReplaceReminders {
+		return false
+	}
+	return now.Add(within).After(e.LastTimeReminded.Add(reminderInterval(e)))
+}
+
+// remindEvents gives the reminders that are due, in a single announcement
+// if there are several and batching is enabled.
+func remindEvents(events []LocalEvent) {
+	if len(events) == 1 || !SysConfig.BatchReminders.Enabled {
+		for i := range events {
+			e := &events[i]
+			// Remind it, louder if it keeps being ignored
+			text := renderRemindMessage(e)
+			// Set reminded time to now
+			e.setReminderSent()
+			announceTask(e, announceKindRemind, text)
+			escalate(e)
+		}
+		return
+	}
+
+	logDebug("Reminding %d events at once", len(events))
+	text := renderBatchMessage(events)
+	for i := range events {
+		events[i].setReminderSent()
+	}
+
+	err := takeSpeechSlot(nil, announceKindRemind)
+	if err != nil {
+		logWarn("Dropping %s announcement \"%s\": %v", announceKindRemind, text, err)
+	} else if err = aiSpeakAs(announceKindRemind, nil, text); err != nil {
+		logError("failed to announce tasks: %v", err)
+	}
+	for i := range events {
+		recordAnnouncement(&events[i], announceKindRemind, text, err)
+		escalate(&events[i])
+	}
+}
+
+// batchTemplateData is what the combined reminder template can use.
+type batchTemplateData struct {
+	Count  int
+	Events string
+	Items  []EventTemplateData
+}
+
+func renderBatchMessage(events []LocalEvent) string {
+	items := make([]EventTemplateData, 0, len(events))
+	parts := make([]string, 0, len(events))
+	titles := make([]string, 0, len(events))
+	for i := range events {
+		item := newEventTemplateData(&events[i])
+		items = append(items, item)
+		parts = append(parts, fmt.Sprintf("\"%s\" with %s left", item.Event, item.TimeLeft))
+		titles = append(titles, item.Event)
+	}
+	defaultMessage := fmt.Sprintf("You have %d things going on: %s.", len(events), joinSpoken(parts))
+
+	tmplText := SysConfig.BatchReminders.MessageTemplate.pick()
+	if tmplText == "" {
+		return defaultMessage
+	}
+
+	tmpl, err := template.New("batch").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse batch template: %v", err)
+		return defaultMessage
+	}
+
+	data := batchTemplateData{
+		Count:  len(events),
+		Events: joinSpoken(titles),
+		Items:  items,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logError("failed to execute batch template: %v", err)
+		return defaultMessage
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bluetoothCommandTimeout = 20 * time.Second
+	// bluetoothRetryDelay is the pause between the reconnection attempts of
+	// an announcement waiting for the speaker.
+	bluetoothRetryDelay = 2 * time.Second
+)
+
+var (
+	// bluetoothMutex serializes the connection attempts, from the monitor
+	// and from the announcements.
+	bluetoothMutex sync.Mutex
+	// bluetoothConnected is the last known state of the speakers, by address
+	bluetoothConnected = make(map[string]bool)
+)
+
+// bluetoothEnabled returns true if the announcements are played on a
+// Bluetooth speaker.
+func bluetoothEnabled() bool {
+	return SysConfig.Bluetooth.Address != ""
+}
+
+// bluetoothAddresses returns the speakers to keep connected, the one of
+// bluetooth and those of the sinks.
+func bluetoothAddresses() []string {
+	addresses := []string{}
+	if bluetoothEnabled() {
+		addresses = append(addresses, SysConfig.Bluetooth.Address)
+	}
+	for _, sink := range configuredSinks() {
+		if sink.Type == sinkBluetooth && !slices.Contains(addresses, sink.Address) {
+			addresses = append(addresses, sink.Address)
+		}
+	}
+	return addresses
+}
+
+// bluetoothDevice returns the ALSA device of the speaker, the bluez-alsa one
+// unless configured.
+func bluetoothDevice(address, device string) string {
+	if device != "" {
+		return device
+	}
+	return fmt.Sprintf("bluealsa:DEV=%s,PROFILE=a2dp", address)
+}
+
+// bluetoothctl runs a bluetoothctl command and returns its output.
+func bluetoothctl(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bluetoothCommandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "bluetoothctl", args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("bluetoothctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// checkBluetooth returns true if the speaker is connected, trying to connect
+// it if not. The caller must hold bluetoothMutex.
+func checkBluetooth(address string) bool {
+	out, err := bluetoothctl("info", address)
+	connected := err == nil && strings.Contains(out, "Connected: yes")
+
+	if !connected {
+		if _, err := bluetoothctl("connect", address); err != nil {
+			logDebug("Bluetooth speaker %s is not reachable: %v", address, err)
+		} else {
+			connected = true
+		}
+	}
+
+	if connected != bluetoothConnected[address] {
+		if connected {
+			logInfo("Bluetooth speaker %s connected", address)
+		} else {
+			logWarn("Bluetooth speaker %s disconnected", address)
+		}
+		bluetoothConnected[address] = connected
+	}
+	return connected
+}
+
+// monitorBluetooth keeps the speakers connected, they can be switched off
+// or go out of range at any time.
+func monitorBluetooth() {
+	for {
+		for _, address := range bluetoothAddresses() {
+			bluetoothMutex.Lock()
+			checkBluetooth(address)
+			bluetoothMutex.Unlock()
+		}
+		time.Sleep(SysConfig.Bluetooth.CheckInterval)
+	}
+}
+
+// playOnBluetooth plays 16-bit little-endian PCM on the speaker, waiting up
+// to the configured time for it to come back if it is disconnected.
+func playOnBluetooth(address, device string, pcm []byte, sampleRate, channels int) error {
+	deadline := time.Now().Add(SysConfig.Bluetooth.Wait)
+	for {
+		bluetoothMutex.Lock()
+		connected := bluetoothConnected[address] || checkBluetooth(address)
+		bluetoothMutex.Unlock()
+
+		err := fmt.Errorf("bluetooth speaker %s is not connected", address)
+		if connected {
+			if err = playOnDevice(bluetoothDevice(address, device), pcm, sampleRate, channels); err == nil {
+				return nil
+			}
+			// the state is only known for sure once checked again
+			bluetoothMutex.Lock()
+			bluetoothConnected[address] = false
+			bluetoothMutex.Unlock()
+		}
+
+		if time.Now().After(deadline) {
+			return err
+		}
+		logDebug("Waiting for the Bluetooth speaker: %v", err)
+		time.Sleep(bluetoothRetryDelay)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// buttonRetry is how long to wait before opening the button again
+	// after it failed, e.g. the pin is used by something else.
+	buttonRetry = time.Minute
+	// configCheckInterval is how often the pins are checked for a change
+	// of the config.
+	configCheckInterval = 10 * time.Second
+)
+
+// runButton acknowledges the last event announced when the button is
+// pressed, and snoozes it when the button is held for button.long_press.
+func runButton() {
+	warned := false
+	for {
+		cfg := SysConfig.Button
+		if !cfg.Enabled {
+			time.Sleep(buttonRetry)
+			continue
+		}
+		if err := watchButton(cfg); err != nil {
+			if !warned {
+				logError("Failed to watch the button on pin %d of %s, retrying every %s: %v", cfg.Pin, cfg.Chip, buttonRetry, err)
+				warned = true
+			}
+			time.Sleep(buttonRetry)
+			continue
+		}
+		warned = false
+	}
+}
+
+// watchButton waits for the presses of the button until the config changes.
+func watchButton(cfg ButtonConfig) error {
+	lines, err := requestGPIOInputs(cfg.Chip, []int{cfg.Pin}, !cfg.ActiveHigh, cfg.Debounce)
+	if err != nil {
+		return err
+	}
+	defer lines.Close()
+	var panicButton *gpioLines
+	if cfg.PanicPin > 0 {
+		panicButton, err = requestGPIOInputs(cfg.Chip, []int{cfg.PanicPin}, !cfg.ActiveHigh, cfg.Debounce)
+		if err != nil {
+			return err
+		}
+		defer panicButton.Close()
+	}
+	logInfo("Watching the button on pin %d of %s", cfg.Pin, cfg.Chip)
+
+	// closing the pin ends the wait for a press
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(configCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if SysConfig.Button != cfg {
+					lines.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	if panicButton != nil {
+		go func() {
+			for {
+				event, err := panicButton.readEvent()
+				if err != nil {
+					// the other button is not read either then
+					lines.Close()
+					return
+				}
+				if event.Rising {
+					go triggerPanic(fmt.Sprintf("the emergency button on pin %d", cfg.PanicPin))
+				}
+			}
+		}()
+	}
+
+	var held *time.Timer
+	for {
+		event, err := lines.readEvent()
+		if err != nil {
+			if SysConfig.Button != cfg {
+				logInfo("Button config changed, opening it again")
+				return nil
+			}
+			return err
+		}
+
+		if event.Rising {
+			// snoozed as soon as it was held long enough, without waiting for the release
+			held = time.AfterFunc(cfg.LongPress, func() { buttonPressed(true) })
+			continue
+		}
+		if held != nil && held.Stop() {
+			go buttonPressed(false)
+		}
+		held = nil
+	}
+}
+
+// buttonPressed acknowledges or snoozes the last event announced, stops or
+// snoozes the wake-up alarm, or answers the review question, and says so.
+func buttonPressed(long bool) {
+	reply, err := buttonAction(long)
+	if err != nil {
+		logError("Failed to act on the button: %v", err)
+		reply = "Sorry, that didn't work."
+	}
+	if reply == "" {
+		return
+	}
+	if err := aiSpeak(reply); err != nil {
+		logError("Failed to answer the button: %v", err)
+	}
+}
+
+func buttonAction(long bool) (string, error) {
+	// a ringing wake-up alarm comes first
+	if wakeUpRinging() {
+		if long {
+			return snoozeWakeUp(SysConfig.WakeUp.Snooze), nil
+		}
+		return stopWakeUp(), nil
+	}
+	// then the review question, a press is a yes and a long press a no; the
+	// next question is the answer
+	if reviewActive.Load() {
+		answer := reviewAnswerYes
+		if long {
+			answer = reviewAnswerNo
+		}
+		if err := answerReview(answer); err != nil {
+			return "", err
+		}
+		logInfo("Review question answered %s with the button", answer)
+		return "", nil
+	}
+
+	e, ok := lastAnnouncedEvent()
+	if !ok {
+		return "There is nothing to acknowledge.", nil
+	}
+
+	if long {
+		d := SysConfig.Button.Snooze
+		if err := e.setSnoozed(d); err != nil {
+			return "", err
+		}
+		logInfo("Event %s snoozed for %s with the button", e.Event.ID, d)
+		return fmt.Sprintf("Okay, I'll remind you about %s in %s.", e.spokenTitle(), formatDuration(d)), nil
+	}
+
+	if err := e.setAcknowledged(); err != nil {
+		return "", err
+	}
+	logInfo("Event %s acknowledged with the button", e.Event.ID)
+	return "Okay.", nil
+}
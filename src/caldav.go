@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-ical"
+	webdav "github.com/jonyTF/go-webdav"
+	"github.com/jonyTF/go-webdav/caldav"
+)
+
+// calDAVCompleteAttempts is how many times marking an event as completed is
+// tried when the calendar object keeps changing on the server meanwhile.
+const calDAVCompleteAttempts = 3
+
+// errObjectChanged is returned when the calendar object changed on the server
+// since it was read.
+var errObjectChanged = errors.New("calendar object changed on the server")
+
+// calDAVSource is a CalendarSource backed by a CalDAV server, like iCloud.
+type calDAVSource struct {
+	name     string
+	baseURL  string
+	username string
+	password string
+
+	// optional push notifications, see push.go
+	push      string
+	pushURL   string
+	pushToken string
+
+	// the discovered calendars are kept between refreshes, discovery
+	// takes several round trips and rarely changes.
+	mutex   sync.Mutex
+	session *calendarSession
+}
+
+type calendarSession struct {
+	ctx          context.Context
+	cDavClient   *caldav.Client
+	calendars    []caldav.Calendar
+	httpClient   *statusTrackingClient
+	authClient   webdav.HTTPClient // httpClient with the credentials
+	baseURL      string
+	discoveredAt time.Time
+}
+
+// statusTrackingClient is an HTTP client that remembers if the server
+// rejected the credentials, so the session can be discovered again.
+type statusTrackingClient struct {
+	client       webdav.HTTPClient
+	unauthorized atomic.Bool
+}
+
+// Do implements webdav.HTTPClient.
+func (c *statusTrackingClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		c.unauthorized.Store(true)
+	}
+	return resp, err
+}
+
+func newCalDAVSource(name, baseURL, username, password string) *calDAVSource {
+	return &calDAVSource{
+		name:     name,
+		baseURL:  baseURL,
+		username: username,
+		password: password,
+	}
+}
+
+// Name returns the name of the source.
+func (s *calDAVSource) Name() string {
+	return s.name
+}
+
+// FetchEvents returns the events of all the calendars of the account between start and end.
+func (s *calDAVSource) FetchEvents(start, end time.Time) ([]CalendarEvent, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ss, err := s.currentSession()
+	if err != nil {
+		return nil, fmt.Errorf("error finding calendars: %v", err)
+	}
+
+	events, err := s.queryEvents(ss, start, end)
+	if !ss.httpClient.unauthorized.Load() {
+		return events, err
+	}
+
+	// the session went stale, discover the calendars again and retry once
+	logInfo("CalDAV source %s rejected the session, authenticating again", s.name)
+	s.session = nil
+	ss, err = s.currentSession()
+	if err != nil {
+		return nil, fmt.Errorf("error finding calendars: %v", err)
+	}
+	events, err = s.queryEvents(ss, start, end)
+	if ss.httpClient.unauthorized.Load() {
+		s.session = nil
+		return nil, fmt.Errorf("authentication failed")
+	}
+
+	return events, err
+}
+
+// currentSession returns the cached session, discovering the calendars again
+// if there is none yet or it is older than the rediscovery interval.
+func (s *calDAVSource) currentSession() (*calendarSession, error) {
+	if s.session != nil && time.Since(s.session.discoveredAt) < SysConfig.CalDAVRediscoveryInterval {
+		return s.session, nil
+	}
+
+	ss, err := s.getCalendarSession()
+	if err != nil {
+		return nil, err
+	}
+
+	logDebug("Discovered %d calendars for source %s", len(ss.calendars), s.name)
+	s.session = ss
+	return ss, nil
+}
+
+// queryEvents queries all the calendars of the session for events between start and end.
+func (s *calDAVSource) queryEvents(ss *calendarSession, start, end time.Time) ([]CalendarEvent, error) {
+	query := &caldav.CalendarQuery{
+		CompRequest: caldav.CalendarCompRequest{
+			Name: "VCALENDAR",
+			Comps: []caldav.CalendarCompRequest{{
+				Name: "VEVENT",
+				Props: []string{
+					"SUMMARY",
+					"LOCATION",
+					"DESCRIPTION",
+					"UID",
+					"DTSTART",
+					"DTEND",
+					"DURATION",
+					"RRULE",
+					"RDATE",
+					"EXDATE",
+					"RECURRENCE-ID",
+					"STATUS",
+					"TRANSP",
+					"CATEGORIES",
+					"CLASS",
+					"PRIORITY",
+				},
+				Comps: []caldav.CalendarCompRequest{{
+					Name: "VALARM",
+					Props: []string{
+						"ACTION",
+						"TRIGGER",
+					},
+				}},
+			}, {
+				// custom zones referenced by TZID are defined here
+				Name:     "VTIMEZONE",
+				AllProps: true,
+				AllComps: true,
+			}},
+			Expand: &caldav.CalendarExpandRequest{
+				Start: start,
+				End:   end,
+			},
+		},
+		CompFilter: caldav.CompFilter{
+			Name: "VCALENDAR",
+			Comps: []caldav.CompFilter{{
+				Name:  "VEVENT",
+				Start: start,
+				End:   end,
+			}},
+		},
+	}
+
+	// a calendar that can't be read fails the whole fetch, returning the
+	// rest would make its cached events look deleted
+	allEvents := []CalendarEvent{}
+	for _, cal := range ss.calendars {
+		calQuery, err := ss.cDavClient.QueryCalendar(ss.ctx, cal.Path, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query events for cal %s: %v", cal.Path, err)
+		}
+
+		events := getEventsFromCalQuery(calQuery, start, end)
+		name := cal.Name
+		if name == "" {
+			name = path.Base(strings.TrimSuffix(cal.Path, "/"))
+		}
+		for i := range events {
+			events[i].Calendar = name
+		}
+		allEvents = append(allEvents, events...)
+	}
+
+	return allEvents, nil
+}
+
+func (s *calDAVSource) getCalendarSession() (*calendarSession, error) {
+	ctx := context.Background()
+	httpClient := &statusTrackingClient{client: http.DefaultClient}
+	client := webdav.HTTPClientWithBasicAuth(httpClient, s.username, s.password)
+
+	baseURL, err := discoverCalDAVURL(s.baseURL, s.username)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering caldav server: %v", err)
+	}
+	if baseURL != s.baseURL {
+		logDebug("Discovered CalDAV server %s for source %s", baseURL, s.name)
+	}
+
+	wDAV, err := webdav.NewClient(client, baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error creating webdav client: %v", err)
+	}
+	principal, err := wDAV.FindCurrentUserPrincipal(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error finding current user principal: %v", err)
+	}
+	cDAV, err := caldav.NewClient(client, baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error creating caldav client: %v", err)
+	}
+	calHome, err := cDAV.FindCalendarHomeSet(ctx, principal)
+	if err != nil {
+		return nil, fmt.Errorf("error finding calendar home set: %v", err)
+	}
+	cals, err := cDAV.FindCalendars(ctx, calHome)
+	if err != nil {
+		return nil, fmt.Errorf("error finding calendars: %v", err)
+	}
+
+	session := &calendarSession{
+		ctx:          ctx,
+		cDavClient:   cDAV,
+		calendars:    cals,
+		httpClient:   httpClient,
+		authClient:   client,
+		baseURL:      baseURL,
+		discoveredAt: time.Now(),
+	}
+	return session, nil
+}
+
+func getEventsFromCalQuery(events []caldav.CalendarObject, start, end time.Time) []CalendarEvent {
+	calEvents := []CalendarEvent{}
+	for _, event := range events {
+		for _, e := range getEventsFromCalendar(event.Data, start, end) {
+			e.ObjectPath = event.Path
+			calEvents = append(calEvents, e)
+		}
+	}
+
+	return calEvents
+}
+
+// MarkCompleted records on the server that the event was completed, with a
+// note appended to its description since VEVENTs have no completed status.
+// The object is only written if it didn't change since it was read, and read
+// again otherwise, not to overwrite an edit made meanwhile.
+func (s *calDAVSource) MarkCompleted(e CalendarEvent, at time.Time) error {
+	if e.ObjectPath == "" {
+		return fmt.Errorf("event %s has no calendar object path", e.ID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ss, err := s.currentSession()
+	if err != nil {
+		return fmt.Errorf("error finding calendars: %v", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = markCompleted(ss, e, at)
+		if !errors.Is(err, errObjectChanged) || attempt == calDAVCompleteAttempts {
+			return err
+		}
+		logDebug("Calendar object %s changed while marking it completed, trying again", e.ObjectPath)
+	}
+}
+
+// markCompleted appends the completion note to the event, if its calendar
+// object didn't change meanwhile.
+func markCompleted(ss *calendarSession, e CalendarEvent, at time.Time) error {
+	obj, err := ss.cDavClient.GetCalendarObject(ss.ctx, e.ObjectPath)
+	if err != nil {
+		return fmt.Errorf("failed to get calendar object %s: %v", e.ObjectPath, err)
+	}
+
+	comp := findEventComponent(obj.Data, e.ID)
+	if comp == nil {
+		// updating the master would mark every occurrence as completed
+		return fmt.Errorf("event %s is an occurrence of a recurring event, not updating it", e.ID)
+	}
+
+	notes, _ := comp.Props.Text(ical.PropDescription)
+	marker := fmt.Sprintf("Completed at %s (PiVoiceReminder)", at.Format("Jan 2, 3:04 PM"))
+	if notes != "" {
+		marker = notes + "\n\n" + marker
+	}
+	comp.Props.SetText(ical.PropDescription, marker)
+	comp.Props.SetDateTime(ical.PropLastModified, at.UTC())
+
+	if err := putIfUnchanged(ss, e.ObjectPath, obj.ETag, obj.Data); err != nil {
+		return fmt.Errorf("failed to update calendar object %s: %w", e.ObjectPath, err)
+	}
+
+	return nil
+}
+
+// putIfUnchanged writes the calendar object, only if its ETag on the server
+// is still etag. It returns errObjectChanged if it isn't.
+func putIfUnchanged(ss *calendarSession, objectPath, etag string, cal *ical.Calendar) error {
+	var body bytes.Buffer
+	if err := ical.NewEncoder(&body).Encode(cal); err != nil {
+		return fmt.Errorf("failed to encode calendar: %v", err)
+	}
+
+	base, err := url.Parse(ss.baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", ss.baseURL, err)
+	}
+	target := base.ResolveReference(&url.URL{Path: objectPath})
+
+	req, err := http.NewRequestWithContext(ss.ctx, http.MethodPut, target.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ical.MIMEType)
+	if etag != "" {
+		// the client hands out the ETags without their quotes
+		if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, "W/") {
+			etag = `"` + etag + `"`
+		}
+		req.Header.Set("If-Match", etag)
+	}
+
+	resp, err := ss.authClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errObjectChanged
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}
+
+// findEventComponent returns the VEVENT of the calendar with the given event
+// ID, as built by toCalendarEvent.
+func findEventComponent(cal *ical.Calendar, id string) *ical.Component {
+	for _, child := range cal.Children {
+		if child.Name != ical.CompEvent {
+			continue
+		}
+
+		uid := child.Props.Get(ical.PropUID)
+		if uid == nil {
+			continue
+		}
+
+		childID := uid.Value
+		if recurrenceID := child.Props.Get(ical.PropRecurrenceID); recurrenceID != nil {
+			childID = instanceID(uid.Value, eventTimeToTime(recurrenceID))
+		} else if isRecurringMaster(ical.Event{Component: child}) {
+			continue
+		}
+
+		if childID == id {
+			return child
+		}
+	}
+
+	return nil
+}
+
+// getEventsFromCalendar converts the VEVENTs of a calendar to CalendarEvents.
+// Recurring events the server did not expand are expanded locally to their
+// occurrences between start and end.
+func getEventsFromCalendar(cal *ical.Calendar, start, end time.Time) []CalendarEvent {
+	calEvents := []CalendarEvent{}
+	events := cal.Events()
+	registerTimeZones(cal)
+
+	// instances that were moved or changed are sent as separate VEVENTs with
+	// a RECURRENCE-ID, those replace the occurrence generated from the rule.
+	overridden := make(map[string]bool)
+	for _, ev := range events {
+		if e, ok := toCalendarEvent(ev); ok && ev.Props.Get(ical.PropRecurrenceID) != nil {
+			overridden[e.ID] = true
+		}
+	}
+
+	for _, ev := range events {
+		e, ok := toCalendarEvent(ev)
+		if !ok {
+			continue
+		}
+
+		if !isRecurringMaster(ev) {
+			calEvents = append(calEvents, e)
+			continue
+		}
+
+		duration := e.EndTime.Sub(e.StartTime)
+		occurrences, err := expandRecurrence(ev, e.StartTime, start.Add(-duration), end)
+		if err != nil {
+			logError("failed to expand recurring event %s: %v", e.ID, err)
+			continue
+		}
+
+		uid := e.ID
+		for _, occ := range occurrences {
+			instance := e
+			instance.ID = instanceID(uid, occ)
+			instance.StartTime = occ
+			instance.EndTime = occ.Add(duration)
+			instance.Alarms = shiftAlarms(e.Alarms, e.StartTime, occ)
+			if overridden[instance.ID] {
+				continue
+			}
+			calEvents = append(calEvents, instance)
+		}
+	}
+
+	return calEvents
+}
+
+// toCalendarEvent converts a single VEVENT to a CalendarEvent, it returns
+// false if the event lacks the properties needed to remind about it.
+func toCalendarEvent(ev ical.Event) (CalendarEvent, bool) {
+	dtStart := ev.Props.Get("DTSTART")
+	dtEnd := ev.Props.Get("DTEND")
+	uid := ev.Props.Get("UID")
+	if dtStart == nil || uid == nil {
+		return CalendarEvent{}, false
+	}
+
+	// the end may be given as a duration instead, all-day events may also
+	// leave it out, they last one day then
+	allDay := isDateValue(dtStart)
+	duration := ev.Props.Get(ical.PropDuration)
+	if dtEnd == nil && duration == nil && !allDay {
+		return CalendarEvent{}, false
+	}
+
+	summary := ""
+	if prop := ev.Props.Get("SUMMARY"); prop != nil {
+		summary = prop.Value
+	}
+
+	// missing or malformed location and notes are just left empty
+	location, _ := ev.Props.Text("LOCATION")
+	notes, _ := ev.Props.Text("DESCRIPTION")
+
+	id := uid.Value
+	if recurrenceID := ev.Props.Get(ical.PropRecurrenceID); recurrenceID != nil {
+		id = instanceID(uid.Value, eventTimeToTime(recurrenceID))
+	}
+
+	status := eventStatusConfirmed
+	if prop := ev.Props.Get(ical.PropStatus); prop != nil {
+		status = strings.ToUpper(prop.Value)
+	}
+	transparent := false
+	if prop := ev.Props.Get(ical.PropTransparency); prop != nil {
+		transparent = strings.EqualFold(prop.Value, "TRANSPARENT")
+	}
+
+	private := false
+	if prop := ev.Props.Get(ical.PropClass); prop != nil {
+		private = strings.EqualFold(prop.Value, "PRIVATE") || strings.EqualFold(prop.Value, "CONFIDENTIAL")
+	}
+
+	priority := 0
+	if prop := ev.Props.Get(ical.PropPriority); prop != nil {
+		if p, err := prop.Int(); err == nil && p >= 0 && p <= 9 {
+			priority = p
+		}
+	}
+
+	categories := []string{}
+	for _, prop := range ev.Props.Values(ical.PropCategories) {
+		values, err := prop.TextList()
+		if err != nil {
+			continue
+		}
+		for _, v := range values {
+			if v = strings.TrimSpace(v); v != "" {
+				categories = append(categories, v)
+			}
+		}
+	}
+
+	start := eventTimeToTime(dtStart)
+	end := start.AddDate(0, 0, 1)
+	if dtEnd != nil {
+		end = eventTimeToTime(dtEnd)
+	} else if duration != nil {
+		d, err := duration.Duration()
+		if err != nil {
+			logError("failed to parse duration %q of event %s: %v", duration.Value, uid.Value, err)
+			return CalendarEvent{}, false
+		}
+		end = start.Add(d)
+	}
+
+	// all-day events float, they happen on that date wherever we are
+	timeZone := getCalEventTimeZone(dtStart).String()
+	if allDay {
+		timeZone = ""
+	}
+
+	return CalendarEvent{
+		ID:          id,
+		StartTime:   start,
+		EndTime:     end,
+		TimeZone:    timeZone,
+		Description: summary,
+		Location:    location,
+		Notes:       notes,
+		AllDay:      allDay,
+		Status:      status,
+		Transparent: transparent,
+		Categories:  categories,
+		Private:     private,
+		Priority:    priority,
+		Alarms:      parseAlarms(ev, start, end),
+	}, true
+}
+
+// isDateValue returns true if the property holds a date rather than a date-time.
+func isDateValue(prop *ical.Prop) bool {
+	return prop.Params.Get(ical.ParamValue) == "DATE" || len(prop.Value) == len("20060102")
+}
+
+func eventTimeToTime(eventTime *ical.Prop) time.Time {
+	// dates, as used by all-day events, start at local midnight
+	if isDateValue(eventTime) {
+		t, err := time.ParseInLocation("20060102", eventTime.Value, time.Local)
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	}
+
+	// UTC times, as used by RECURRENCE-ID and server-expanded instances
+	if strings.HasSuffix(eventTime.Value, "Z") {
+		t, err := time.Parse("20060102T150405Z", eventTime.Value)
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	}
+
+	t, err := time.ParseInLocation("20060102T150405", eventTime.Value, getCalEventTimeZone(eventTime))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func getCalEventTimeZone(val *ical.Prop) *time.Location {
+	if val == nil {
+		return time.Local
+	}
+
+	loc, err := lookupTimeZone(val.Params.Get("TZID"))
+	if err != nil {
+		logError("failed to load timezone %s: %v", val.Params.Get("TZID"), err)
+		return time.Local
+	}
+
+	return loc
+}
@@ -1,13 +1,10 @@
 package main
 
 import (
-	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/emersion/go-ical"
-	webdav "github.com/jonyTF/go-webdav"
-	"github.com/jonyTF/go-webdav/caldav"
 )
 
 type CalendarEvent struct {
@@ -16,12 +13,84 @@ type CalendarEvent struct {
 	EndTime     time.Time
 	TimeZone    string
 	Description string
+	Location    string
+	Notes       string
+	AllDay      bool
+	Status      string // CONFIRMED, TENTATIVE or CANCELLED
+	Transparent bool   // Doesn't block time, e.g. free/busy set to free
+	Categories  []string
+	Private     bool // CLASS is PRIVATE or CONFIDENTIAL
+	Priority    int  // PRIORITY, 1 is the highest, 9 the lowest, 0 undefined
+	Alarms      []time.Time
+	Source      string // Name of the calendar source the event came from
+	Calendar    string // Name of the calendar within the source, the source name if it has only one
+	ObjectPath  string // Path of the event on the calendar server, if any
 }
 
-type calendarSession struct {
-	ctx        context.Context
-	cDavClient *caldav.Client
-	calendars  []caldav.Calendar
+// Event status values, as defined by the STATUS property
+const (
+	eventStatusConfirmed = "CONFIRMED"
+	eventStatusTentative = "TENTATIVE"
+	eventStatusCancelled = "CANCELLED"
+)
+
+// CalendarSource is a provider of calendar events, like a CalDAV server.
+type CalendarSource interface {
+	// Name returns the name of the source, as used in logs.
+	Name() string
+	// FetchEvents returns the events between start and end.
+	FetchEvents(start, end time.Time) ([]CalendarEvent, error)
+}
+
+// CompletionWriter is implemented by calendar sources that can record on
+// the calendar itself that an event was completed.
+type CompletionWriter interface {
+	MarkCompleted(e CalendarEvent, at time.Time) error
+}
+
+var (
+	calendarSources []CalendarSource
+	sourcesMutex    sync.RWMutex
+)
+
+// setupCalendarSources creates the calendar sources from the secrets.
+func setupCalendarSources() {
+	// reminders created on the device itself, and the doses of medications
+	sources := []CalendarSource{&localSource{}, &medicationSource{}}
+
+	// the iCloud account is kept as the default source for existing setups
+	if SysSecrets.IcloudConfig.Username != "" {
+		sources = append(sources, newCalDAVSource("icloud",
+			SysSecrets.IcloudConfig.CalDAVBaseUrl,
+			SysSecrets.IcloudConfig.Username,
+			SysSecrets.IcloudConfig.AppSpecificPassword))
+	}
+
+	for i, cfg := range SysSecrets.CalendarSources {
+		name := cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("source-%d", i+1)
+		}
+
+		switch strings.ToLower(cfg.Type) {
+		case "", "caldav":
+			src := newCalDAVSource(name, cfg.URL, cfg.Username, cfg.Password)
+			src.push = strings.ToLower(cfg.Push)
+			src.pushURL = cfg.PushURL
+			src.pushToken = cfg.PushToken
+			sources = append(sources, src)
+		case "ics", "webcal":
+			sources = append(sources, newICSSource(name, cfg.URL, cfg.Path))
+		default:
+			logError("unknown type %q for calendar source %s, ignoring it", cfg.Type, name)
+		}
+	}
+
+	sourcesMutex.Lock()
+	calendarSources = sources
+	sourcesMutex.Unlock()
+
+	startPushWatchers(sources)
 }
 
 // Event represents the structure of the event details.
@@ -30,6 +99,10 @@ func (e CalendarEvent) toString() string {
 		return fmt.Sprintf("Task '%s' is scheduled for today", e.Description)
 	}
 
+	if e.AllDay {
+		return fmt.Sprintf("Task '%s' is scheduled for the whole day", e.Description)
+	}
+
 	if e.EndTime.IsZero() {
 		return fmt.Sprintf("Task '%s' is scheduled for whole day, today at %s",
 			e.Description,
@@ -41,7 +114,7 @@ func (e CalendarEvent) toString() string {
 	durationStr := formatDuration(duration)
 
 	isOrWas := "is"
-	if e.EndTime.Before(time.Now()) {
+	if e.EndTime.Before(clockNow()) {
 		isOrWas = "was"
 	}
 
@@ -54,143 +127,125 @@ func (e CalendarEvent) toString() string {
 		e.EndTime.Format("3:04 PM"))
 }
 
-func getTodayCalEvents() []CalendarEvent {
-	now := time.Now()
-	start := startOfDay(now)
-	end := endOfDay(now)
-	return getCalEvents(start, end)
+// inCalendar returns true if the event comes from the named calendar, or
+// from the named calendar source.
+func inCalendar(e *CalendarEvent, name string) bool {
+	return strings.EqualFold(name, e.Calendar) || strings.EqualFold(name, e.Source)
 }
 
-func getCalendarSession() (*calendarSession, error) {
-	ctx := context.Background()
-	client := webdav.HTTPClientWithBasicAuth(nil,
-		SysSecrets.IcloudConfig.Username,
-		SysSecrets.IcloudConfig.AppSpecificPassword)
+// findCalendarSource returns the calendar source with the given name.
+func findCalendarSource(name string) (CalendarSource, bool) {
+	sourcesMutex.RLock()
+	defer sourcesMutex.RUnlock()
 
-	wDAV, err := webdav.NewClient(client, SysSecrets.IcloudConfig.CalDAVBaseUrl)
-	if err != nil {
-		return nil, fmt.Errorf("error creating webdav client: %v", err)
-	}
-	principal, err := wDAV.FindCurrentUserPrincipal(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error finding current user principal: %v", err)
+	for _, src := range calendarSources {
+		if src.Name() == name {
+			return src, true
+		}
 	}
-	cDAV, err := caldav.NewClient(client, SysSecrets.IcloudConfig.CalDAVBaseUrl)
-	if err != nil {
-		return nil, fmt.Errorf("error creating caldav client: %v", err)
+	return nil, false
+}
+
+// writeCompletion records the completion of the event on its calendar, if
+// enabled and supported by the event's source.
+func writeCompletion(e CalendarEvent, at time.Time) error {
+	if !SysConfig.WriteCompletionToCalendar {
+		return nil
 	}
-	calHome, err := cDAV.FindCalendarHomeSet(ctx, principal)
-	if err != nil {
-		return nil, fmt.Errorf("error finding calendar home set: %v", err)
+
+	src, ok := findCalendarSource(e.Source)
+	if !ok {
+		return fmt.Errorf("calendar source %q of event %s not found", e.Source, e.ID)
 	}
-	cals, err := cDAV.FindCalendars(ctx, calHome)
-	if err != nil {
-		return nil, fmt.Errorf("error finding calendars: %v", err)
+	writer, ok := src.(CompletionWriter)
+	if !ok {
+		logDebug("Calendar source %s can't record completions", src.Name())
+		return nil
 	}
 
-	session := &calendarSession{
-		ctx:        ctx,
-		cDavClient: cDAV,
-		calendars:  cals,
-	}
-	return session, nil
+	return writer.MarkCompleted(e, at)
 }
 
-func getCalEvents(start, end time.Time) []CalendarEvent {
-	ss, err := getCalendarSession()
-	if err != nil {
-		logError("error finding calendars: %v", err)
-		return []CalendarEvent{}
-	}
+func getTodayCalEvents() ([]CalendarEvent, error) {
+	now := time.Now()
+	start := startOfDay(now)
+	end := endOfDay(now)
+	return getCalEvents(start, end)
+}
 
-	query := &caldav.CalendarQuery{
-		CompRequest: caldav.CalendarCompRequest{
-			Name: "VCALENDAR",
-			Comps: []caldav.CalendarCompRequest{{
-				Name: "VEVENT",
-				Props: []string{
-					"SUMMARY",
-					"UID",
-					"DTSTART",
-					"DTEND",
-					"DURATION",
-				},
-			}},
-			Expand: &caldav.CalendarExpandRequest{
-				Start: start,
-				End:   end,
-			},
-		},
-		CompFilter: caldav.CompFilter{
-			Name: "VCALENDAR",
-			Comps: []caldav.CompFilter{{
-				Name:  "VEVENT",
-				Start: start,
-				End:   end,
-			}},
-		},
+// getCalEvents fetches the events between start and end from all the
+// configured calendar sources concurrently and merges them. The events of
+// the sources that could be read are returned even if others failed, along
+// with an error naming the failed sources.
+func getCalEvents(start, end time.Time) ([]CalendarEvent, error) {
+	sourcesMutex.RLock()
+	sources := calendarSources
+	sourcesMutex.RUnlock()
+
+	var wg sync.WaitGroup
+	results := make([][]CalendarEvent, len(sources))
+	failed := make([]bool, len(sources))
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src CalendarSource) {
+			defer wg.Done()
+			events, err := fetchWithRetry(src, start, end)
+			recordCalendarSync(src.Name(), err)
+			if err != nil {
+				logError("failed to fetch events from calendar source %s: %v", src.Name(), err)
+				failed[i] = true
+				return
+			}
+			for j := range events {
+				events[j].Source = src.Name()
+				if events[j].Calendar == "" {
+					events[j].Calendar = src.Name()
+				}
+			}
+			results[i] = events
+		}(i, src)
 	}
+	wg.Wait()
 
+	// the same calendar can be reachable through more than one source,
+	// keep the first copy of every event.
+	seen := make(map[string]bool)
 	allEvents := []CalendarEvent{}
-	for _, cal := range ss.calendars {
-		calQuery, err := ss.cDavClient.QueryCalendar(ss.ctx, cal.Path, query)
-		if err != nil {
-			logError("failed to query events for cal: %s", cal.Path)
-			continue
-		}
-
-		events := getEventsFromCalQuery(calQuery)
-		allEvents = append(allEvents, events...)
-	}
-
-	return allEvents
-}
-
-func getEventsFromCalQuery(events []caldav.CalendarObject) []CalendarEvent {
-	calEvents := []CalendarEvent{}
-	for _, event := range events {
-		e := event.Data.Events()
-		for _, ev := range e {
-			dtStart := ev.Props.Get("DTSTART")
-			dtEnd := ev.Props.Get("DTEND")
-			if dtStart == nil || dtEnd == nil {
+	for _, events := range results {
+		for _, e := range events {
+			if seen[e.ID] {
 				continue
 			}
-
-			id := ev.Props.Get("UID").Value
-			start := eventTimeToTime(dtStart)
-			end := eventTimeToTime(dtEnd)
-			calEvents = append(calEvents, CalendarEvent{
-				ID:          id,
-				StartTime:   start,
-				EndTime:     end,
-				TimeZone:    getCalEventTimeZone(dtStart).String(),
-				Description: ev.Props.Get("SUMMARY").Value,
-			})
+			seen[e.ID] = true
+			allEvents = append(allEvents, e)
 		}
 	}
 
-	return calEvents
-}
-
-func eventTimeToTime(eventTime *ical.Prop) time.Time {
-	t, err := time.ParseInLocation("20060102T150405", eventTime.Value, getCalEventTimeZone(eventTime))
-	if err != nil {
-		return time.Time{}
+	failedNames := []string{}
+	for i, src := range sources {
+		if failed[i] {
+			failedNames = append(failedNames, src.Name())
+		}
+	}
+	if len(failedNames) > 0 {
+		return allEvents, fmt.Errorf("failed to fetch calendar sources: %s", strings.Join(failedNames, ", "))
 	}
-	return t
+
+	return allEvents, nil
 }
 
-func getCalEventTimeZone(val *ical.Prop) *time.Location {
-	if val == nil {
-		return time.Local
-	}
+// fetchWithRetry fetches the events of a source, retrying with exponential
+// backoff so a short server hiccup doesn't fail the whole refresh.
+func fetchWithRetry(src CalendarSource, start, end time.Time) ([]CalendarEvent, error) {
+	backoff := SysConfig.CalendarFetchBackoff
+	for attempt := 0; ; attempt++ {
+		events, err := src.FetchEvents(start, end)
+		if err == nil || attempt >= SysConfig.CalendarFetchRetries {
+			return events, err
+		}
 
-	loc, err := time.LoadLocation(val.Params.Get("TZID"))
-	if err != nil {
-		logError("failed to load timezone %s: %v", val.Params.Get("TZID"), err)
-		return time.Local
+		logWarn("failed to fetch events from calendar source %s, retrying in %s: %v", src.Name(), backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
 	}
-
-	return loc
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// startedAt is when the reminders started running, the events that started
+// before it were missed while the device was off.
+var startedAt = time.Now()
+
+// dueCatchUp returns true if the start of the event was missed while the
+// device was off and a catch-up message is due instead.
+func dueCatchUp(e *LocalEvent) bool {
+	if !SysConfig.CatchUp.Enabled || e.Event.AllDay || e.StartAnnounced || e.Acknowledged || !e.scheduledForToday() {
+		return false
+	}
+
+	// started on time, the start announcement is just a bit late
+	now := clockNow()
+	if !e.Event.StartTime.Before(startedAt) || !now.After(e.Event.StartTime.Add(SysConfig.CatchUp.Grace)) {
+		return false
+	}
+
+	// old news
+	if now.After(e.Event.EndTime) && now.Sub(e.Event.EndTime) > SysConfig.CatchUp.MaxAge {
+		return false
+	}
+
+	return true
+}
+
+// setCaughtUp marks the event as announced up to now, the reminders go on
+// from here rather than all at once.
+func (e *LocalEvent) setCaughtUp() error {
+	now := clockNow()
+	ended := now.After(e.Event.EndTime)
+	return e.update(func(l *LocalEvent) {
+		l.StartAnnounced = true
+		l.CheckStartsSent = len(SysConfig.CheckStartDelays)
+		l.LastTimeReminded = now
+		l.EndAnnounced = l.EndAnnounced || ended
+	})
+}
+
+// catchUpTemplateData is what the catch-up template can use.
+type catchUpTemplateData struct {
+	EventTemplateData
+	Ended bool // the event is already over
+}
+
+func renderCatchUpMessage(e *LocalEvent) string {
+	data := catchUpTemplateData{
+		EventTemplateData: newEventTemplateData(e),
+		Ended:             clockNow().After(e.Event.EndTime),
+	}
+
+	defaultMessage := fmt.Sprintf("While I was off, \"%s\" started at %s.", data.Event, data.StartTime.Format("3:04 PM"))
+	if !data.Ended {
+		defaultMessage += fmt.Sprintf(" You have %s left.", data.TimeLeft)
+	}
+	tmplText := SysConfig.CatchUp.MessageTemplate.pick()
+	if tmplText == "" {
+		return defaultMessage
+	}
+
+	tmpl, err := template.New("catch_up").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse catch-up template: %v", err)
+		return defaultMessage
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logError("failed to execute catch-up template: %v", err)
+		return defaultMessage
+	}
+
+	return buf.String()
+}
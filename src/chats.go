@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chat platforms
+const (
+	chatMatrix  = "matrix"
+	chatDiscord = "discord"
+	chatSlack   = "slack"
+)
+
+// chatTypes are the types the chats can have.
+var chatTypes = []string{chatMatrix, chatDiscord, chatSlack}
+
+const chatTimeout = 15 * time.Second
+
+// chatChannel posts the announcements to a Matrix room, or a Discord or
+// Slack channel, the failed ones too since nobody heard them.
+type chatChannel struct {
+	chat ChatConfig
+}
+
+func chatChannels() []NotificationChannel {
+	channels := make([]NotificationChannel, 0, len(SysConfig.Chats))
+	for _, chat := range SysConfig.Chats {
+		channels = append(channels, chatChannel{chat})
+	}
+	return channels
+}
+
+func (c chatChannel) Name() string { return "chat:" + c.chat.Name }
+
+func (c chatChannel) Wants(ev AnnouncementEvent) bool {
+	// the ones getting the written recap don't need the spoken one too
+	if c.chat.Recap && ev.Kind == announceKindRecap {
+		return false
+	}
+	return mirrorsKind(c.chat.Kinds, ev.Kind)
+}
+
+func (c chatChannel) Send(ev AnnouncementEvent) error {
+	text := announcementTitle(ev.Kind) + ": " + ev.Text
+	if !ev.Success {
+		text += "\n(could not be spoken: " + ev.Error + ")"
+	}
+	return sendChat(c.chat, text)
+}
+
+// postRecapToChats posts how the day went to the chats that want it.
+func postRecapToChats(recap Recap) {
+	if simulating {
+		return
+	}
+	text := recap.summary() + "\n\n" + strings.TrimSpace(recap.text())
+	for _, chat := range SysConfig.Chats {
+		if chat.Recap {
+			go postToChat(chat, text)
+		}
+	}
+}
+
+// postToChat posts the text to the chat, logging a failure.
+func postToChat(chat ChatConfig, text string) {
+	if err := sendChat(chat, text); err != nil {
+		logError("Failed to post to the %s chat %s: %v", chat.Type, chat.Name, err)
+	}
+}
+
+// sendChat posts the text to the chat, with the credentials of its name in
+// the secrets.
+func sendChat(chat ChatConfig, text string) error {
+	secret, ok := chatSecret(chat.Name)
+	if !ok {
+		return permanentError{fmt.Errorf("chats.%s is not set in the secrets", chat.Name)}
+	}
+
+	var req *http.Request
+	var err error
+	switch chat.Type {
+	case chatMatrix:
+		req, err = matrixRequest(secret, text)
+	case chatDiscord:
+		req, err = chatWebhookRequest(secret.WebhookURL, map[string]string{"content": text})
+	case chatSlack:
+		req, err = chatWebhookRequest(secret.WebhookURL, map[string]string{"text": text})
+	default:
+		err = fmt.Errorf("unknown chat type %q", chat.Type)
+	}
+	if err != nil {
+		return permanentError{err}
+	}
+
+	resp, err := (&http.Client{Timeout: chatTimeout}).Do(req)
+	if err != nil {
+		// the webhook URLs have a token in them
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// matrixRequest sends a text message to the Matrix room, as the user of the
+// access token.
+func matrixRequest(secret ChatSecret, text string) (*http.Request, error) {
+	if secret.Homeserver == "" || secret.AccessToken == "" || secret.RoomID == "" {
+		return nil, fmt.Errorf("homeserver, access_token and room_id are needed for Matrix")
+	}
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": text})
+	if err != nil {
+		return nil, err
+	}
+	// the transaction ID only has to be unique for the access token
+	txn := strconv.FormatInt(time.Now().UnixNano(), 10)
+	endpoint := strings.TrimRight(secret.Homeserver, "/") + "/_matrix/client/v3/rooms/" +
+		url.PathEscape(secret.RoomID) + "/send/m.room.message/" + txn
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+secret.AccessToken)
+	return req, nil
+}
+
+// chatWebhookRequest posts the message to an incoming webhook, of Discord or
+// Slack.
+func chatWebhookRequest(webhookURL string, message map[string]string) (*http.Request, error) {
+	if webhookURL == "" {
+		return nil, fmt.Errorf("webhook_url is needed")
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook_url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// chatSecret returns the credentials of the chat of that name.
+func chatSecret(name string) (ChatSecret, bool) {
+	for _, s := range SysSecrets.Chats {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return ChatSecret{}, false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// soundSampleRate is the rate the sounds other than WAV are decoded at.
+const soundSampleRate = 22050
+
+// chimeSound returns the chime to play before an announcement of the given
+// kind, or empty if there is none. The sound of the wake-up alarms is played
+// even with the chimes off.
+func chimeSound(kind string) string {
+	if kind == announceKindWakeUp && SysConfig.WakeUp.Sound != "" {
+		return SysConfig.WakeUp.Sound
+	}
+	if !SysConfig.Chime.Enabled || kind == "" {
+		return ""
+	}
+	// an empty sound turns the chime off for that kind
+	if sound, ok := SysConfig.Chime.Sounds[kind]; ok {
+		return sound
+	}
+	return SysConfig.Chime.Sound
+}
+
+// playChime plays the chime and waits a moment, a chime that can't be played
+// must not hold back the announcement.
+func playChime(sound string, volume float64) {
+	if err := playSoundFile(realPath(sound), volume); err != nil {
+		logError("Failed to play chime %s: %v", sound, err)
+		return
+	}
+	time.Sleep(SysConfig.Chime.Pause)
+}
+
+// playSoundFile plays a sound file at the given volume, the formats other
+// than WAV, like OGG, are decoded with ffmpeg.
+func playSoundFile(filename string, volume float64) error {
+	if strings.EqualFold(filepath.Ext(filename), ".wav") {
+		return playWavFile(filename, volume)
+	}
+
+	cmd := exec.Command("ffmpeg", "-loglevel", "error", "-i", filename,
+		"-f", "s16le", "-acodec", "pcm_s16le", "-ac", "1", "-ar", strconv.Itoa(soundSampleRate), "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	pcm, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v: %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return playClip(audioClip{PCM: pcm, SampleRate: soundSampleRate, Channels: 1}, volume)
+}
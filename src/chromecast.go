@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	chromecastPort = "8009"
+	// chromecastMediaApp is the default media receiver, it plays a URL.
+	chromecastMediaApp = "CC1AD845"
+	// chromecastMaxMessage bounds the size of a message from the device.
+	chromecastMaxMessage = 64 << 10
+)
+
+// Cast namespaces
+const (
+	castNamespaceConnection = "urn:x-cast:com.google.cast.tp.connection"
+	castNamespaceHeartbeat  = "urn:x-cast:com.google.cast.tp.heartbeat"
+	castNamespaceReceiver   = "urn:x-cast:com.google.cast.receiver"
+	castNamespaceMedia      = "urn:x-cast:com.google.cast.media"
+)
+
+const (
+	castSender   = "sender-0"
+	castReceiver = "receiver-0"
+)
+
+// castMessage is the CastMessage protobuf of the Cast protocol, only with
+// the fields of string payloads.
+type castMessage struct {
+	Source      string
+	Destination string
+	Namespace   string
+	Payload     string
+}
+
+// castPayload are the common fields of the JSON payloads.
+type castPayload struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// castConn is a connection to a Chromecast.
+type castConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// castPlay plays the media URL on a Chromecast or Google Home with the
+// default media receiver, and returns once it was played or the timeout is
+// reached.
+func castPlay(address, url string, timeout time.Duration) error {
+	dialer := &net.Dialer{Timeout: netSpeakerDialTimeout}
+	// the devices have self-signed certificates
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout + netSpeakerMargin))
+
+	c := &castConn{conn: conn, reader: bufio.NewReader(conn)}
+	if err := c.send(castReceiver, castNamespaceConnection, map[string]any{"type": "CONNECT"}); err != nil {
+		return err
+	}
+	if err := c.send(castReceiver, castNamespaceReceiver, map[string]any{"type": "LAUNCH", "appId": chromecastMediaApp, "requestId": 1}); err != nil {
+		return err
+	}
+
+	// the app reports its transport once launched
+	var transport string
+	for transport == "" {
+		msg, payload, err := c.receive()
+		if err != nil {
+			return fmt.Errorf("failed to launch the media receiver: %v", err)
+		}
+		if msg.Namespace != castNamespaceReceiver {
+			continue
+		}
+		if payload.Type == "LAUNCH_ERROR" {
+			return fmt.Errorf("failed to launch the media receiver: %s", payload.Reason)
+		}
+		var status struct {
+			Status struct {
+				Applications []struct {
+					AppID       string `json:"appId"`
+					TransportID string `json:"transportId"`
+				} `json:"applications"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal([]byte(msg.Payload), &status); err != nil {
+			continue
+		}
+		for _, app := range status.Status.Applications {
+			if app.AppID == chromecastMediaApp && app.TransportID != "" {
+				transport = app.TransportID
+			}
+		}
+	}
+
+	if err := c.send(transport, castNamespaceConnection, map[string]any{"type": "CONNECT"}); err != nil {
+		return err
+	}
+	load := map[string]any{
+		"type":      "LOAD",
+		"requestId": 2,
+		"autoplay":  true,
+		"media": map[string]any{
+			"contentId":   url,
+			"contentType": "audio/wav",
+			"streamType":  "BUFFERED",
+		},
+	}
+	if err := c.send(transport, castNamespaceMedia, load); err != nil {
+		return err
+	}
+
+	// played once the player goes idle after playing
+	deadline := time.Now().Add(timeout)
+	played := false
+	for time.Now().Before(deadline) {
+		msg, payload, err := c.receive()
+		if err != nil {
+			if errors.Is(err, io.EOF) || played {
+				return nil
+			}
+			return fmt.Errorf("failed to play on %s: %v", address, err)
+		}
+		if msg.Namespace != castNamespaceMedia {
+			continue
+		}
+
+		var status struct {
+			Status []struct {
+				PlayerState string `json:"playerState"`
+				IdleReason  string `json:"idleReason"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal([]byte(msg.Payload), &status); err != nil {
+			continue
+		}
+		switch payload.Type {
+		case "LOAD_FAILED", "LOAD_CANCELLED", "INVALID_REQUEST":
+			return fmt.Errorf("%s refused the speech: %s %s", address, payload.Type, payload.Reason)
+		case "MEDIA_STATUS":
+			for _, s := range status.Status {
+				switch {
+				case s.PlayerState == "PLAYING" || s.PlayerState == "BUFFERING":
+					played = true
+				case s.PlayerState == "IDLE" && s.IdleReason == "ERROR":
+					return fmt.Errorf("%s failed to play the speech", address)
+				case s.PlayerState == "IDLE" && s.IdleReason != "":
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// send sends a JSON payload to the destination.
+func (c *castConn) send(destination, namespace string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg := encodeCastMessage(castMessage{Source: castSender, Destination: destination, Namespace: namespace, Payload: string(data)})
+
+	frame := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	if _, err := c.conn.Write(frame); err != nil {
+		return fmt.Errorf("failed to send to the Chromecast: %v", err)
+	}
+	return nil
+}
+
+// receive reads the next message, answering the heartbeats on the way.
+func (c *castConn) receive() (castMessage, castPayload, error) {
+	for {
+		var size uint32
+		if err := binary.Read(c.reader, binary.BigEndian, &size); err != nil {
+			return castMessage{}, castPayload{}, err
+		}
+		if size > chromecastMaxMessage {
+			return castMessage{}, castPayload{}, fmt.Errorf("message too large: %d bytes", size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return castMessage{}, castPayload{}, err
+		}
+
+		msg, err := decodeCastMessage(data)
+		if err != nil {
+			return castMessage{}, castPayload{}, err
+		}
+		var payload castPayload
+		json.Unmarshal([]byte(msg.Payload), &payload)
+
+		if msg.Namespace == castNamespaceHeartbeat && payload.Type == "PING" {
+			if err := c.send(msg.Source, castNamespaceHeartbeat, map[string]any{"type": "PONG"}); err != nil {
+				return castMessage{}, castPayload{}, err
+			}
+			continue
+		}
+		return msg, payload, nil
+	}
+}
+
+// encodeCastMessage encodes the CastMessage protobuf: protocol version 0,
+// the ids, the namespace and a string payload.
+func encodeCastMessage(m castMessage) []byte {
+	buf := []byte{}
+	buf = protoVarint(buf, 1, 0) // CASTV2_1_0
+	buf = protoString(buf, 2, m.Source)
+	buf = protoString(buf, 3, m.Destination)
+	buf = protoString(buf, 4, m.Namespace)
+	buf = protoVarint(buf, 5, 0) // STRING
+	buf = protoString(buf, 6, m.Payload)
+	return buf
+}
+
+func protoVarint(buf []byte, field int, value uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field<<3))
+	return binary.AppendUvarint(buf, value)
+}
+
+func protoString(buf []byte, field int, value string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field<<3|2))
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// decodeCastMessage decodes the fields of a CastMessage used here, the
+// others are skipped.
+func decodeCastMessage(data []byte) (castMessage, error) {
+	var m castMessage
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return m, fmt.Errorf("invalid message")
+		}
+		data = data[n:]
+
+		field, wire := key>>3, key&7
+		switch wire {
+		case 0:
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return m, fmt.Errorf("invalid message")
+			}
+			data = data[n:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return m, fmt.Errorf("invalid message")
+			}
+			value := string(data[n : n+int(size)])
+			data = data[n+int(size):]
+			switch field {
+			case 2:
+				m.Source = value
+			case 3:
+				m.Destination = value
+			case 4:
+				m.Namespace = value
+			case 6:
+				m.Payload = value
+			}
+		default:
+			return m, fmt.Errorf("unsupported wire type %d", wire)
+		}
+	}
+	return m, nil
+}
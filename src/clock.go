@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// The reminder logic reads the time through clockNow, so a simulation can
+// run it on a fake clock that is moved forward instead of waiting.
+var (
+	clockMutex sync.RWMutex
+	fakeNow    time.Time
+)
+
+// clockNow returns the current time, or the simulated time in a simulation.
+func clockNow() time.Time {
+	clockMutex.RLock()
+	defer clockMutex.RUnlock()
+
+	if fakeNow.IsZero() {
+		return time.Now()
+	}
+	return fakeNow
+}
+
+// setFakeClock sets the simulated time, a zero time goes back to the real clock.
+func setFakeClock(t time.Time) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	fakeNow = t
+}
+
+// clockUntil returns the duration until t, on the clock of clockNow.
+func clockUntil(t time.Time) time.Duration {
+	return t.Sub(clockNow())
+}
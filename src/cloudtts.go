@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Cloud text-to-speech providers
+const (
+	cloudTtsGoogle     = "google"
+	cloudTtsAzure      = "azure"
+	cloudTtsElevenLabs = "elevenlabs"
+)
+
+const (
+	cloudTtsTimeout    = 30 * time.Second
+	cloudTtsSampleRate = 22050
+)
+
+// cloudTtsEnabled returns true if a cloud provider is configured along with its key.
+func cloudTtsEnabled() bool {
+	return SysConfig.CloudTts.Provider != "" && SysSecrets.CloudTtsApiKey != ""
+}
+
+// useCloudTts returns true if announcements of the given kind are spoken by
+// the cloud provider rather than the local model.
+func useCloudTts(kind string) bool {
+	return cloudTtsEnabled() && kind != "" && slices.Contains(SysConfig.CloudTts.Kinds, kind)
+}
+
+// cloudGenerate generates the speech with the configured cloud provider.
+func cloudGenerate(text string) (audioClip, error) {
+	var (
+		audio []byte
+		err   error
+	)
+	switch SysConfig.CloudTts.Provider {
+	case cloudTtsGoogle:
+		audio, err = googleSynthesize(text)
+	case cloudTtsAzure:
+		audio, err = azureSynthesize(text)
+	case cloudTtsElevenLabs:
+		audio, err = elevenLabsSynthesize(text)
+	default:
+		return audioClip{}, fmt.Errorf("unknown cloud TTS provider %q", SysConfig.CloudTts.Provider)
+	}
+	if err != nil {
+		return audioClip{}, fmt.Errorf("%s: %v", SysConfig.CloudTts.Provider, err)
+	}
+
+	clip, err := decodeWav(audio)
+	if err != nil {
+		return audioClip{}, fmt.Errorf("%s: %v", SysConfig.CloudTts.Provider, err)
+	}
+	return clip, nil
+}
+
+// googleSynthesize uses Google Cloud Text-to-Speech, LINEAR16 comes with a WAV header.
+func googleSynthesize(text string) ([]byte, error) {
+	cfg := SysConfig.CloudTts
+	body, err := json.Marshal(map[string]interface{}{
+		"input": map[string]string{"text": text},
+		"voice": map[string]string{"languageCode": cfg.Language, "name": cfg.Voice},
+		"audioConfig": map[string]interface{}{
+			"audioEncoding":   "LINEAR16",
+			"sampleRateHertz": cloudTtsSampleRate,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := "https://texttospeech.googleapis.com/v1/text:synthesize?key=" + url.QueryEscape(SysSecrets.CloudTtsApiKey)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	data, err := doCloudTtsRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		AudioContent string `json:"audioContent"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	return base64.StdEncoding.DecodeString(result.AudioContent)
+}
+
+// azureSynthesize uses Azure AI Speech, the riff formats are WAV files.
+func azureSynthesize(text string) ([]byte, error) {
+	cfg := SysConfig.CloudTts
+	ssml := fmt.Sprintf("<speak version='1.0' xml:lang='%s'><voice name='%s'>%s</voice></speak>",
+		html.EscapeString(cfg.Language), html.EscapeString(cfg.Voice), html.EscapeString(text))
+
+	endpoint := fmt.Sprintf("https://%s.tts.speech.microsoft.com/cognitiveservices/v1", url.PathEscape(cfg.Region))
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader([]byte(ssml)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", SysSecrets.CloudTtsApiKey)
+	req.Header.Set("Content-Type", "application/ssml+xml")
+	req.Header.Set("X-Microsoft-OutputFormat", "riff-22050hz-16bit-mono-pcm")
+	req.Header.Set("User-Agent", "simple-reminder")
+
+	return doCloudTtsRequest(req)
+}
+
+// elevenLabsSynthesize uses ElevenLabs, which returns raw PCM that gets a WAV header here.
+func elevenLabsSynthesize(text string) ([]byte, error) {
+	cfg := SysConfig.CloudTts
+	body, err := json.Marshal(map[string]string{
+		"text":     text,
+		"model_id": cfg.Model,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("https://api.elevenlabs.io/v1/text-to-speech/%s?output_format=pcm_%d", url.PathEscape(cfg.Voice), cloudTtsSampleRate)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("xi-api-key", SysSecrets.CloudTtsApiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	pcm, err := doCloudTtsRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return wavFromPCM(pcm, cloudTtsSampleRate, 1), nil
+}
+
+func doCloudTtsRequest(req *http.Request) ([]byte, error) {
+	client := &http.Client{Timeout: cloudTtsTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// the error details are in the body, keep it short for the logs
+		if len(data) > 200 {
+			data = data[:200]
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
+
+// wavFromPCM wraps 16-bit little-endian PCM samples in a WAV header.
+func wavFromPCM(pcm []byte, sampleRate, channels int) []byte {
+	var buf bytes.Buffer
+	buf.Grow(44 + len(pcm))
+
+	blockAlign := channels * 2
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+
+	return buf.Bytes()
+}
@@ -2,12 +2,98 @@ package main
 
 import (
 	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 const (
 	DefaultNotificationRepeats = 3
+	DefaultPanicRepeats        = 3
+	DefaultPanicMessage        = "Emergency! Someone here needs help right now!"
+	DefaultReviewAnswerTimeout = 30 * time.Second
+	DefaultCalDAVRediscovery   = 6 * time.Hour
+	DefaultCalendarRetries     = 3
+	DefaultCalendarBackoff     = 2 * time.Second
+	DefaultCalendarPoll        = 15 * time.Second
+	DefaultCalendarPushPoll    = 15 * time.Minute
+	DefaultAllDayMode          = "morning"
+	DefaultAllDayMorningTime   = "08:00"
+	DefaultTentativeMode       = "normal"
+	DefaultTransparentMode     = "normal"
+	DefaultPrivateTitle        = "a private appointment"
+	DefaultHistoryRetention    = 90
+	DefaultCatchUpGrace        = 2 * time.Minute
+	DefaultCatchUpMaxAge       = 2 * time.Hour
+	DefaultBatchWindow         = 5 * time.Minute
+	DefaultTtsCacheSizeMB      = 100
+	DefaultVolume              = 100
+	DefaultChimeSound          = "resources/sounds/chime.wav"
+	DefaultChimePause          = time.Second
+	DefaultLanguage            = "en"
+	DefaultTtsManifest         = "resources/models/tts/manifest.yml"
+	DefaultBluetoothCheck      = 30 * time.Second
+	DefaultWakeWordModel       = "resources/models/kws/sherpa-onnx-kws-zipformer-gigaspeech-3.3M-2024-01-01"
+	DefaultWakeWordWindow      = 8 * time.Second
+	DefaultWakeWordThreshold   = 0.25
+	DefaultWakeWordScore       = 1.0
+	DefaultSttModel            = "resources/models/asr/sherpa-onnx-whisper-tiny.en"
+	DefaultSttType             = "whisper"
+	DefaultVoiceSnooze         = 10 * time.Minute
+	DefaultVoiceAnswerWindow   = 5 * time.Second
+	DefaultACMEChallenge       = ":80"
+	DefaultWebPushSubject      = "mailto:reminder@example.com"
+	DefaultWebPushTTL          = time.Hour
+	DefaultTelegramSnooze      = 10 * time.Minute
+	DefaultNotifyAttempts      = 3
+	DefaultNotifyRetryDelay    = 2 * time.Second
+	DefaultNotifyQueueSize     = 50
+	DefaultScriptTimeout       = 30 * time.Second
+	DefaultLightsDuration      = 5 * time.Second
+	DefaultGPIOChip            = "/dev/gpiochip0"
+	DefaultButtonDebounce      = 30 * time.Millisecond
+	DefaultButtonLongPress     = time.Second
+	DefaultButtonSnooze        = 10 * time.Minute
+	DefaultStatusLEDSPI        = "/dev/spidev0.0"
+	DefaultStatusLEDBrightness = 20
+	DefaultVolumeKnobStep      = 5
+	DefaultVolumeKnobDetent    = 4
+	DefaultVolumeKnobSPI       = "/dev/spidev0.0"
+	DefaultMedicationWindow    = 30 * time.Minute
+	DefaultWakeUpSnooze        = 9 * time.Minute
+	DefaultWakeUpMaxSnoozes    = 3
+	DefaultNightModeInterval   = 30 * time.Second
+	DefaultNightModeDarkLux    = 5
+	DefaultNightModeLightLux   = 15
+	DefaultNightModeVolume     = 30
+	DefaultDisplayBus          = "/dev/i2c-1"
+	DefaultDisplayAddress      = 0x3c
+	DefaultDisplayHeight       = 64
+	DefaultPresenceRecent      = 10 * time.Minute
+	DefaultPresenceRetry       = 3 * time.Minute
+	DefaultPresenceMaxDefer    = 30 * time.Minute
+	DefaultTelegramPause       = time.Hour
+	DefaultNtfyServer          = "https://ntfy.sh"
+	DefaultSMSWindow           = 15 * time.Minute
+)
+
+var (
+	// DefaultPreStartLeads are the lead times of the pre-start reminders.
+	DefaultPreStartLeads = []time.Duration{15 * time.Minute, 5 * time.Minute}
+	// DefaultCheckStartDelays are the times after the start of the follow-ups.
+	DefaultCheckStartDelays = []time.Duration{time.Minute}
+	// DefaultSystemMessages are spoken when the config has no messages of that kind.
+	DefaultSystemMessages = SystemMessages{
+		SpeechErrorMessages: MessageTemplate{"I'm sorry, I couldn't make that announcement."},
+		SystemFailMessages:  MessageTemplate{"I'm sorry, my voice could not be loaded, please check the configuration."},
+		SystemErrorMessages: MessageTemplate{"I'm sorry, but I can't access the internet right now, I will try again later."},
+		SystemSleepMessages: MessageTemplate{"Goodbye, I'm shutting down."},
+		SystemWakeMessages:  MessageTemplate{"I'm back online."},
+		SystemInitMessages:  MessageTemplate{"Hello, I'm ready to help you."},
+	}
 )
 
 var (
@@ -22,37 +108,756 @@ type IcloudConfig struct {
 	CalDAVBaseUrl       string `yaml:"icloud_caldav_base_url"`       // iCloud CalDAV base URL
 }
 
+type CalendarSourceConfig struct {
+	Name     string `yaml:"name"`     // Name of the source, used in logs
+	Type     string `yaml:"type"`     // Source type: caldav or ics
+	URL      string `yaml:"url"`      // CalDAV base URL, or ICS/webcal subscription URL
+	Path     string `yaml:"path"`     // Path to a local .ics file, for the ics type
+	Username string `yaml:"username"` // Account username
+	Password string `yaml:"password"` // Account password or app-specific password
+
+	Push      string `yaml:"push"`       // Push notification service: nextcloud or jmap
+	PushURL   string `yaml:"push_url"`   // notify_push websocket or JMAP session URL, discovered if empty
+	PushToken string `yaml:"push_token"` // JMAP API token, the account credentials are used if empty
+}
+
+// WebUser is a user of the web interface.
+type WebUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"` // Bcrypt hash, from tools/hash-password.go
+	Role     string `yaml:"role"`     // admin or viewer
+}
+
 type Secrets struct {
-	WebServerPassword string       `yaml:"web_server_password"`
-	IcloudConfig      IcloudConfig `yaml:"icloud_config"`
+	WebServerPassword string                 `yaml:"web_server_password"` // Single admin password, if no users are set
+	Users             []WebUser              `yaml:"users"`
+	IcloudConfig      IcloudConfig           `yaml:"icloud_config"`
+	CalendarSources   []CalendarSourceConfig `yaml:"calendar_sources"`
+	CloudTtsApiKey    string                 `yaml:"cloud_tts_api_key"` // API key of the cloud TTS provider
+	Telegram          TelegramSecrets        `yaml:"telegram"`
+	Webhooks          []WebhookSecret        `yaml:"webhooks"`
+	SMTP              SMTPSecrets            `yaml:"smtp"`
+	Ntfy              NtfySecrets            `yaml:"ntfy"`
+	Pushover          PushoverSecrets        `yaml:"pushover"`
+	Twilio            TwilioSecrets          `yaml:"twilio"`
+	Chats             []ChatSecret           `yaml:"chats"`
+	Hue               HueSecrets             `yaml:"hue"`
+}
+
+type HueSecrets struct {
+	APIKey string `yaml:"api_key"` // The username of the bridge API, created by pressing its link button
+}
+
+type ChatSecret struct {
+	Name        string `yaml:"name"`        // Of the chat in config.yml
+	WebhookURL  string `yaml:"webhook_url"` // Discord and Slack incoming webhook
+	Homeserver  string `yaml:"homeserver"`  // Matrix, e.g. "https://matrix.org"
+	AccessToken string `yaml:"access_token"`
+	RoomID      string `yaml:"room_id"` // e.g. "!abcdef:matrix.org", the account must have joined it
+}
+
+type TwilioSecrets struct {
+	AccountSID string             `yaml:"account_sid"`
+	AuthToken  string             `yaml:"auth_token"`
+	From       string             `yaml:"from"` // Twilio number the texts are sent from, e.g. "+15017122661"
+	Caregivers []CaregiverContact `yaml:"caregivers"`
+}
+
+type CaregiverContact struct {
+	Name  string `yaml:"name"`  // For the logs
+	Phone string `yaml:"phone"` // In international format, e.g. "+14155550100"
+}
+
+type NtfySecrets struct {
+	Token string `yaml:"token"` // Access token, for a protected topic
+}
+
+type PushoverSecrets struct {
+	APIToken string `yaml:"api_token"` // Of the application created on pushover.net
+	UserKey  string `yaml:"user_key"`  // Of the user or the group
+}
+
+type SMTPSecrets struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`     // 587 by default, 465 for TLS
+	Security string `yaml:"security"` // starttls, tls or none, from the port if empty
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"` // e.g. "Reminder <reminder@example.com>", the username if empty
+}
+
+type WebhookSecret struct {
+	Name   string `yaml:"name"`   // Of the webhook in config.yml
+	Secret string `yaml:"secret"` // The payloads are signed with HMAC-SHA256 of it
+}
+
+type TelegramSecrets struct {
+	BotToken string  `yaml:"bot_token"` // From @BotFather
+	ChatIDs  []int64 `yaml:"chat_ids"`  // Chats the announcements are sent to and the commands are taken from
 }
 
 type SystemMessages struct {
-	// Error messages and system messages
-	SpeechErrorMessages []string `yaml:"speech_error_messages"` // List of speech error messages
-	SystemFailMessages  []string `yaml:"system_fail_messages"`  // List of system failure messages
-	SystemErrorMessages []string `yaml:"system_error_messages"` // List of system error messages
-	SystemSleepMessages []string `yaml:"system_sleep_messages"` // List of system sleep messages
-	SystemWakeMessages  []string `yaml:"system_wake_messages"`  // List of system wake messages
-	SystemInitMessages  []string `yaml:"system_init_messages"`  // List of system initialization messages
+	// Error messages and system messages, one of each list is picked at random
+	SpeechErrorMessages MessageTemplate `yaml:"speech_error_messages"` // When an announcement can't be spoken
+	SystemFailMessages  MessageTemplate `yaml:"system_fail_messages"`  // When the voice model can't be loaded
+	SystemErrorMessages MessageTemplate `yaml:"system_error_messages"` // When there is no internet at startup
+	SystemSleepMessages MessageTemplate `yaml:"system_sleep_messages"` // On shutdown
+	SystemWakeMessages  MessageTemplate `yaml:"system_wake_messages"`  // When the internet is back
+	SystemInitMessages  MessageTemplate `yaml:"system_init_messages"`  // Once started
 }
 
 type Config struct {
-	DebugLogEnabled     bool   `yaml:"debug_log_enabled"`
+	LogLevel            string `yaml:"log_level"`         // trace, debug, info, warning or error
+	DebugLogEnabled     bool   `yaml:"debug_log_enabled"` // Log at the debug level if log_level is not set
+	DryRun              bool   `yaml:"dry_run"`           // Log the announcements instead of speaking them
 	EventsPath          string `yaml:"events_path"`
 	NotificationRepeats int    `yaml:"notification_repeats"`
 
+	// Remind this often during the events, instead of notification_repeats
+	// times spread over their duration
+	ReminderInterval time.Duration `yaml:"reminder_interval"`
+
+	// Times after the start of the events at which to ask whether they
+	// started, until they are acknowledged
+	CheckStartDelays []time.Duration `yaml:"check_start_delays"`
+
+	// How often the CalDAV calendars are discovered again
+	CalDAVRediscoveryInterval time.Duration `yaml:"caldav_rediscovery_interval"`
+
+	// How many times a failed calendar fetch is retried, 0 for never and
+	// the default if unset or negative, and the delay before the first
+	// retry, doubled for every following one
+	CalendarFetchRetries int           `yaml:"calendar_fetch_retries"`
+	CalendarFetchBackoff time.Duration `yaml:"calendar_fetch_backoff"`
+
+	// How often the calendars are fetched, and how often while all the
+	// sources push their changes
+	CalendarPollInterval     time.Duration `yaml:"calendar_poll_interval"`
+	CalendarPushPollInterval time.Duration `yaml:"calendar_push_poll_interval"`
+
+	// How many days of announcement history are kept
+	HistoryRetentionDays int `yaml:"history_retention_days"`
+
+	// Record completed events on the calendar server
+	WriteCompletionToCalendar bool `yaml:"write_completion_to_calendar"`
+
 	// Message Templates
-	AnnounceMessageTemplate    string `yaml:"announce_message_template"`
-	AnnounceEndMessageTemplate string `yaml:"announce_end_message_template"`
-	CheckStartMessageTemplate  string `yaml:"check_start_message_template"`
-	RemindMessageTemplate      string `yaml:"remind_message_template"`
+	AnnounceMessageTemplate    MessageTemplate `yaml:"announce_message_template"`
+	AnnounceEndMessageTemplate MessageTemplate `yaml:"announce_end_message_template"`
+	CheckStartMessageTemplate  MessageTemplate `yaml:"check_start_message_template"`
+	RemindMessageTemplate      MessageTemplate `yaml:"remind_message_template"`
 
 	// TTS Configuration
 	TtsConfig TtsConfig `yaml:"tts_config"`
 
 	// AI Speech TTS Configuration
 	AiSpeechTtsConfig AiSpeechTtsConfig `yaml:"ai_speech_tts_config"`
+
+	// How words are spoken, e.g. family names the models get wrong
+	Pronunciations map[string]string `yaml:"pronunciations"`
+
+	// Messages about the device itself, like startup and shutdown
+	SystemMessages SystemMessages `yaml:"system_messages"`
+
+	// Other local TTS models, by name, usable like kokoro and glados
+	TtsModels map[string]TtsModelDefinition `yaml:"tts_models"`
+
+	// Voices that can be downloaded from the web interface, a file or a URL
+	TtsManifest string `yaml:"tts_manifest"`
+
+	// Voice of the announcement kinds, e.g. "remind" or "system"
+	Voices map[string]VoiceConfig `yaml:"voices"`
+
+	// Voice of the events in other languages
+	Languages LanguagesConfig `yaml:"languages"`
+
+	// Cloud TTS, for some announcement kinds or when the local model fails
+	CloudTts CloudTtsConfig `yaml:"cloud_tts"`
+
+	// Cache of the generated speech
+	TtsCache TtsCacheConfig `yaml:"tts_cache"`
+
+	// ALSA device to play on, e.g. "plughw:CARD=Device,DEV=0", the default device if empty
+	AudioDevice string `yaml:"audio_device"`
+
+	// Bluetooth speaker to play on
+	Bluetooth BluetoothConfig `yaml:"bluetooth"`
+
+	// Chromecast, Google Home or Sonos speaker to play on
+	NetworkSpeaker NetworkSpeakerConfig `yaml:"network_speaker"`
+
+	// Outputs to play on all at once, instead of the ones above
+	Sinks []AudioSinkConfig `yaml:"sinks"`
+
+	// Output volume, by time of day
+	Volume VolumeConfig `yaml:"volume"`
+
+	// ALSA device to record from, e.g. "plughw:CARD=Device,DEV=0", the default device if empty
+	Microphone string `yaml:"microphone"`
+
+	// Wake word that starts listening
+	WakeWord WakeWordConfig `yaml:"wake_word"`
+
+	// Speech recognition model, for what is said after the wake word
+	SpeechRecognition SpeechRecognitionConfig `yaml:"speech_recognition"`
+
+	// Commands said after the wake word
+	VoiceCommands VoiceCommandsConfig `yaml:"voice_commands"`
+
+	// Answers said to the start checks
+	VoiceAnswers VoiceAnswersConfig `yaml:"voice_answers"`
+
+	// Chime played before the announcements
+	Chime ChimeConfig `yaml:"chime"`
+
+	// Emergency announcement configuration
+	PanicConfig PanicConfig `yaml:"panic_config"`
+
+	// End-of-day review configuration
+	ReviewConfig ReviewConfig `yaml:"review_config"`
+
+	// End-of-day recap configuration
+	RecapConfig RecapConfig `yaml:"recap_config"`
+
+	// Reminders before the events start
+	PreStartReminders PreStartConfig `yaml:"pre_start_reminders"`
+
+	// Catch-up of the events missed while the device was off
+	CatchUp CatchUpConfig `yaml:"catch_up"`
+
+	// Combined reminders of the events going on at the same time
+	BatchReminders BatchRemindersConfig `yaml:"batch_reminders"`
+
+	// Limits on how much is announced
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	// Escalation of the reminders of events that are not acknowledged
+	Escalation EscalationConfig `yaml:"escalation"`
+
+	// Calendar alarms (VALARM) configuration
+	CalendarAlarms CalendarAlarmsConfig `yaml:"calendar_alarms"`
+
+	// All-day events configuration
+	AllDayEvents AllDayEventsConfig `yaml:"all_day_events"`
+
+	// Event status (STATUS and TRANSP) configuration
+	EventStatus EventStatusConfig `yaml:"event_status"`
+
+	// Holiday calendar, for the event rules that apply on holidays
+	Holidays HolidaysConfig `yaml:"holidays"`
+
+	// Rules to announce matching events differently, the first match applies
+	EventRules []EventRule `yaml:"event_rules"`
+
+	// Reminder profiles by event priority: high, normal or low
+	PriorityProfiles map[string]ReminderProfile `yaml:"priority_profiles"`
+
+	// Reminder profiles by calendar or calendar source name
+	CalendarProfiles map[string]ReminderProfile `yaml:"calendar_profiles"`
+
+	// Privacy mode configuration
+	Privacy PrivacyConfig `yaml:"privacy"`
+
+	// Web interface configuration
+	WebServer WebServerConfig `yaml:"web_server"`
+
+	// Notifications on the phones and browsers that asked for them in the web interface
+	WebPush WebPushConfig `yaml:"web_push"`
+
+	// How the announcements are sent to the channels besides the speaker
+	Notifications NotificationsConfig `yaml:"notifications"`
+
+	// Announcements mirrored to Telegram chats, and commands from them
+	Telegram TelegramConfig `yaml:"telegram"`
+
+	// URLs the announcements and the changes of the events are posted to
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+
+	// Commands run for the announcements
+	Scripts []ScriptConfig `yaml:"scripts"`
+
+	// Emails to the caregivers, through the SMTP server of the secrets
+	Email EmailConfig `yaml:"email"`
+
+	// Announcements mirrored to the ntfy and Pushover phone apps
+	Ntfy     NtfyConfig     `yaml:"ntfy"`
+	Pushover PushoverConfig `yaml:"pushover"`
+
+	// Texts to the caregivers about critical events nobody acknowledged
+	SMS SMSConfig `yaml:"sms"`
+
+	// Matrix rooms, Discord and Slack channels the announcements are posted to
+	Chats []ChatConfig `yaml:"chats"`
+
+	// Lights flashed along with the announcements
+	Lights LightsConfig `yaml:"lights"`
+
+	// A push button on the GPIO pins of the Pi
+	Button ButtonConfig `yaml:"button"`
+
+	// An LED showing the state of the reminder
+	StatusLED StatusLEDConfig `yaml:"status_led"`
+
+	// A PIR motion sensor, to announce only when someone is in the room
+	Presence PresenceConfig `yaml:"presence"`
+
+	// A small screen showing the next event
+	Display DisplayConfig `yaml:"display"`
+
+	// A knob on the GPIO pins to turn the volume
+	VolumeKnob VolumeKnobConfig `yaml:"volume_knob"`
+
+	// Quieter announcements while the room is dark
+	NightMode NightModeConfig `yaml:"night_mode"`
+
+	// Medications taken on a schedule, each dose confirmed
+	Medications MedicationsConfig `yaml:"medications"`
+
+	// Wake-up alarms, with a greeting and the day ahead
+	WakeUp WakeUpConfig `yaml:"wake_up"`
+}
+
+type WebServerConfig struct {
+	TLS          WebTLSConfig `yaml:"tls"`           // Serve the web interface over https
+	HealthPublic bool         `yaml:"health_public"` // Serve /healthz without a login to the local network
+
+	// Behind a reverse proxy
+	BasePath       string   `yaml:"base_path"`       // Path prefix the interface is served under, e.g. "/reminder"
+	TrustedProxies []string `yaml:"trusted_proxies"` // Addresses or networks of the proxies whose X-Forwarded-* headers are used
+	CORSOrigins    []string `yaml:"cors_origins"`    // Sites allowed to call the /api endpoints from a browser, e.g. "https://dash.example.com"
+}
+
+type WebPushConfig struct {
+	Enabled bool          `yaml:"enabled"` // Mirror each spoken announcement as a notification
+	Subject string        `yaml:"subject"` // Contact for the push services, a mailto: or https: URL
+	TTL     time.Duration `yaml:"ttl"`     // How long the push services keep a notification for a phone that is offline
+}
+
+type NotificationsConfig struct {
+	Attempts   int                                  `yaml:"attempts"`    // Tries of each announcement per channel
+	RetryDelay time.Duration                        `yaml:"retry_delay"` // Before the second try, doubled for the next ones
+	QueueSize  int                                  `yaml:"queue_size"`  // Announcements waiting per channel, the next ones are dropped
+	Channels   map[string]NotificationChannelConfig `yaml:"channels"`    // By channel name, e.g. "telegram:12345678" or "webhook:node-red"
+}
+
+type NotificationChannelConfig struct {
+	Enabled *bool    `yaml:"enabled"` // Sent to unless false
+	Kinds   []string `yaml:"kinds"`   // Announcement kinds sent, all if empty
+}
+
+type TelegramConfig struct {
+	Enabled bool          `yaml:"enabled"` // The bot token and the chats are in the secrets
+	Snooze  time.Duration `yaml:"snooze"`  // How long /snooze snoozes without a duration
+	Pause   time.Duration `yaml:"pause"`   // How long /pause pauses without a duration
+}
+
+type WebhookConfig struct {
+	Name   string   `yaml:"name"`   // For the logs and the secret in secrets.yml
+	URL    string   `yaml:"url"`    // Posted to with the JSON payload
+	Events []string `yaml:"events"` // start, reminder, end, acknowledged, snoozed, completed or announcement, all if empty
+}
+
+type ScriptConfig struct {
+	Name    string        `yaml:"name"`    // For the logs and notifications.channels, as script:<name>
+	Command []string      `yaml:"command"` // The program and its arguments, run from the application directory
+	Kinds   []string      `yaml:"kinds"`   // Announcement kinds it is run for, all if empty
+	Timeout time.Duration `yaml:"timeout"` // It is killed after this long
+}
+
+type EmailConfig struct {
+	Enabled  bool     `yaml:"enabled"`
+	To       []string `yaml:"to"`       // Recipients
+	Triggers []string `yaml:"triggers"` // unacknowledged, recap or panic, all if empty
+}
+
+type ButtonConfig struct {
+	Enabled    bool          `yaml:"enabled"`     // A press acknowledges the last event announced, holding it snoozes it
+	Chip       string        `yaml:"chip"`        // GPIO character device
+	Pin        int           `yaml:"pin"`         // BCM number of the pin, e.g. 17 for the physical pin 11
+	ActiveHigh bool          `yaml:"active_high"` // The button connects the pin to 3.3V, instead of to ground
+	Debounce   time.Duration `yaml:"debounce"`    // Contacts bouncing for less than this are ignored
+	LongPress  time.Duration `yaml:"long_press"`  // Held this long, it snoozes instead
+	Snooze     time.Duration `yaml:"snooze"`      // How long a long press snoozes for
+	PanicPin   int           `yaml:"panic_pin"`   // BCM number of a separate emergency button, none if 0
+}
+
+type StatusLEDConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Type    string `yaml:"type"` // gpio or neopixel
+
+	// gpio: the BCM numbers of the pins of each color, 0 if not connected
+	Chip     string `yaml:"chip"`
+	RedPin   int    `yaml:"red_pin"`
+	GreenPin int    `yaml:"green_pin"`
+	BluePin  int    `yaml:"blue_pin"`
+
+	// neopixel: WS2812 LEDs on the MOSI pin, GPIO 10, with SPI enabled
+	SPI        string `yaml:"spi"`        // SPI device
+	Count      int    `yaml:"count"`      // Number of LEDs
+	Brightness int    `yaml:"brightness"` // Percent, they are very bright
+}
+
+type PresenceConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Chip     string        `yaml:"chip"`      // GPIO character device
+	Pin      int           `yaml:"pin"`       // BCM number of the pin the sensor output is on
+	Recent   time.Duration `yaml:"recent"`    // Someone seen this long ago is still in the room
+	Retry    time.Duration `yaml:"retry"`     // How often deferred announcements are tried again
+	MaxDefer time.Duration `yaml:"max_defer"` // They are announced anyway after being deferred this long
+}
+
+type MedicationsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Calendar string        `yaml:"calendar"` // A calendar, or calendar source, whose events are doses too
+	Window   time.Duration `yaml:"window"`   // How long a dose can be confirmed after its time, it is missed after that
+	Schedule []Medication  `yaml:"schedule"`
+}
+
+type Medication struct {
+	Name  string   `yaml:"name"`
+	Dose  string   `yaml:"dose"`  // e.g. "500 mg" or "2 tablets", spoken after the name
+	Times []string `yaml:"times"` // Times of day of the doses, e.g. "08:00"
+	Days  []string `yaml:"days"`  // mon to sun, every day if empty
+	Notes string   `yaml:"notes"` // e.g. "with food"
+}
+
+type WakeUpConfig struct {
+	Enabled    bool            `yaml:"enabled"`
+	Alarms     []WakeUpAlarm   `yaml:"alarms"`
+	Sound      string          `yaml:"sound"`       // Played before the greeting, e.g. "resources/sounds/alarm.wav", the chime if empty
+	Greeting   MessageTemplate `yaml:"greeting"`    // Spoken when it rings, a built-in greeting if empty
+	Briefing   bool            `yaml:"briefing"`    // Tells the events of the day after the greeting, the first time it rings
+	Volume     int             `yaml:"volume"`      // Volume in percent, whatever the time of day or the light, the usual volume if 0
+	Snooze     time.Duration   `yaml:"snooze"`      // How long a snooze lasts
+	MaxSnoozes int             `yaml:"max_snoozes"` // How many times in a row it can be snoozed
+}
+
+type WakeUpAlarm struct {
+	Time string   `yaml:"time"` // Time of day, e.g. "07:00"
+	Days []string `yaml:"days"` // mon to sun, every day if empty
+}
+
+type NightModeConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Sensor   string        `yaml:"sensor"`    // bh1750 or tsl2561, on the I2C bus
+	Bus      string        `yaml:"bus"`       // I2C device
+	Address  int           `yaml:"address"`   // I2C address of the sensor, its usual one if 0
+	Interval time.Duration `yaml:"interval"`  // How often the light is read
+	DarkLux  float64       `yaml:"dark_lux"`  // The room is dark below this
+	LightLux float64       `yaml:"light_lux"` // And lit again above this
+	Action   string        `yaml:"action"`    // quiet or silent
+	Volume   int           `yaml:"volume"`    // quiet: the volume at most, in percent
+}
+
+type VolumeKnobConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Type    string `yaml:"type"` // encoder or potentiometer
+
+	// encoder: the BCM numbers of its pins, the switch mutes, 0 if it has none
+	Chip           string `yaml:"chip"`
+	PinA           int    `yaml:"pin_a"`            // CLK on the common modules
+	PinB           int    `yaml:"pin_b"`            // DT
+	SwitchPin      int    `yaml:"switch_pin"`       // SW
+	Step           int    `yaml:"step"`             // Volume change per click, in percent
+	StepsPerDetent int    `yaml:"steps_per_detent"` // Pin changes per click, 4, or 2 on some encoders
+
+	// potentiometer: read through an MCP3008, with SPI enabled
+	SPI     string `yaml:"spi"`     // SPI device of the MCP3008
+	Channel int    `yaml:"channel"` // Input the wiper of the potentiometer is on, 0 to 7
+}
+
+type DisplayConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Type    string `yaml:"type"`    // ssd1306
+	Bus     string `yaml:"bus"`     // I2C device
+	Address int    `yaml:"address"` // I2C address of the display, usually 0x3c
+	Height  int    `yaml:"height"`  // 32 or 64 pixels
+	Rotate  bool   `yaml:"rotate"`  // Turned upside down
+}
+
+type LightsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Kinds    []string      `yaml:"kinds"`    // Announcement kinds flashed for, all if empty
+	Duration time.Duration `yaml:"duration"` // How long the lights flash
+	Hue      HueConfig     `yaml:"hue"`
+	WLED     []string      `yaml:"wled"` // Addresses of the WLED strips, e.g. "http://192.168.1.40"
+}
+
+type HueConfig struct {
+	Bridge string   `yaml:"bridge"` // Address of the bridge on the local network
+	Lights []string `yaml:"lights"` // IDs of the lights
+	Groups []string `yaml:"groups"` // IDs of the rooms or zones, "0" for all the lights
+}
+
+type ChatConfig struct {
+	Name  string   `yaml:"name"`  // For the logs and the credentials in secrets.yml
+	Type  string   `yaml:"type"`  // matrix, discord or slack
+	Kinds []string `yaml:"kinds"` // Announcement kinds posted, all if empty
+	Recap bool     `yaml:"recap"` // Post the written recap of the day instead of the spoken one
+}
+
+type SMSConfig struct {
+	Enabled    bool          `yaml:"enabled"`    // Twilio and the caregivers are in the secrets
+	Window     time.Duration `yaml:"window"`     // How long after the start a critical event may go unacknowledged
+	Categories []string      `yaml:"categories"` // Events with any of these categories are critical, besides the [critical] tag
+}
+
+type NtfyConfig struct {
+	Enabled    bool              `yaml:"enabled"`
+	Server     string            `yaml:"server"`     // https://ntfy.sh, or your own
+	Topic      string            `yaml:"topic"`      // Long and hard to guess on a public server
+	Kinds      []string          `yaml:"kinds"`      // Announcement kinds mirrored, all if empty
+	Priorities map[string]string `yaml:"priorities"` // By announcement kind: min, low, default, high or urgent
+}
+
+type PushoverConfig struct {
+	Enabled    bool           `yaml:"enabled"`
+	Device     string         `yaml:"device"`     // Only this device of the user, all if empty
+	Kinds      []string       `yaml:"kinds"`      // Announcement kinds mirrored, all if empty
+	Priorities map[string]int `yaml:"priorities"` // By announcement kind, from -2 to 2, 2 repeats until acknowledged
+}
+
+type WebTLSConfig struct {
+	Enabled bool   `yaml:"enabled"` // Serve on port 8443, port 8080 redirects to it
+	Cert    string `yaml:"cert"`    // Certificate file, a self-signed one is created if empty
+	Key     string `yaml:"key"`     // Private key file of the certificate
+
+	// Certificates from Let's Encrypt, instead of cert and key
+	ACME WebACMEConfig `yaml:"acme"`
+}
+
+type WebACMEConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	Domains          []string `yaml:"domains"`           // DNS names the web interface is reached with
+	Email            string   `yaml:"email"`             // Contact for the expiry notices, optional
+	ChallengeAddress string   `yaml:"challenge_address"` // Where the HTTP-01 challenges are answered
+}
+
+type CatchUpConfig struct {
+	Enabled         bool            `yaml:"enabled"`          // Tell about the events that started while the device was off
+	Grace           time.Duration   `yaml:"grace"`            // How late a start is still announced as usual
+	MaxAge          time.Duration   `yaml:"max_age"`          // Skip the events that ended longer ago than this
+	MessageTemplate MessageTemplate `yaml:"message_template"` // Catch-up message
+}
+
+type BatchRemindersConfig struct {
+	Enabled         bool            `yaml:"enabled"`          // Give the reminders of overlapping events in one announcement
+	Window          time.Duration   `yaml:"window"`           // Reminders due within this time are given along
+	MessageTemplate MessageTemplate `yaml:"message_template"` // Combined reminder message
+}
+
+type HolidaysConfig struct {
+	Country string `yaml:"country"` // ISO code of the country whose public holidays are looked up, e.g. "DE"
+	Region  string `yaml:"region"`  // Region of the country, for the regional holidays, e.g. "DE-BY"
+	URL     string `yaml:"url"`     // ICS or webcal subscription of a holiday calendar
+	Path    string `yaml:"path"`    // Local .ics file of a holiday calendar
+}
+
+type RateLimitConfig struct {
+	MaxPerHour         int `yaml:"max_per_hour"`           // Announcements per hour, 0 for no limit
+	MaxPerEventPerHour int `yaml:"max_per_event_per_hour"` // Announcements per hour about a single event, 0 for no limit
+}
+
+type TtsModelDefinition struct {
+	Type        string  `yaml:"type"`          // Model family: vits, matcha or kokoro
+	Model       string  `yaml:"model"`         // Path to the model, the acoustic model for matcha
+	Vocoder     string  `yaml:"vocoder"`       // Path to the vocoder, for matcha
+	Voices      string  `yaml:"voices"`        // Path to voices.bin, for kokoro
+	Tokens      string  `yaml:"tokens"`        // Path to tokens.txt
+	Lexicon     string  `yaml:"lexicon"`       // Path to the lexicon, if the model has one
+	DataDir     string  `yaml:"data_dir"`      // Path to espeak-ng-data, if the model uses it
+	DictDir     string  `yaml:"dict_dir"`      // Path to the jieba dictionary, for Chinese models
+	Lang        string  `yaml:"lang"`          // Language of a multi-lang kokoro model, e.g. "es"
+	NoiseScale  float32 `yaml:"noise_scale"`   // Defaults to 0.667, for vits and matcha
+	NoiseScaleW float32 `yaml:"noise_scale_w"` // Defaults to 0.8, for vits
+	LengthScale float32 `yaml:"length_scale"`  // Defaults to 1, small is faster and large is slower
+	Speaker     int     `yaml:"speaker"`       // Default speaker index
+	RuleFsts    string  `yaml:"rule_fsts"`     // Paths to text normalization rules, comma separated
+}
+
+type VoiceConfig struct {
+	Model   string `yaml:"model"`   // Local model, kokoro, glados or one of tts_models, tts_model if empty
+	Speaker *int   `yaml:"speaker"` // Speaker index, the default speaker of the model if unset
+}
+
+type LanguagesConfig struct {
+	Default string                 `yaml:"default"` // Language of the other voices, e.g. "en"
+	Detect  bool                   `yaml:"detect"`  // Guess the language of the events without a lang tag from their text
+	Voices  map[string]VoiceConfig `yaml:"voices"`  // Voice of each other language, e.g. "es"
+}
+
+type CloudTtsConfig struct {
+	Provider string   `yaml:"provider"` // google, azure or elevenlabs, empty to only speak locally
+	Voice    string   `yaml:"voice"`    // Voice name, or voice ID for ElevenLabs
+	Language string   `yaml:"language"` // Language code, e.g. "en-US", for Google and Azure
+	Region   string   `yaml:"region"`   // Azure region of the speech resource, e.g. "westeurope"
+	Model    string   `yaml:"model"`    // ElevenLabs model, e.g. "eleven_multilingual_v2"
+	Kinds    []string `yaml:"kinds"`    // Announcement kinds always spoken by the cloud provider
+	Fallback bool     `yaml:"fallback"` // Use the cloud provider when local generation fails
+}
+
+type TtsCacheConfig struct {
+	Enabled   bool  `yaml:"enabled"`     // Keep the generated speech to play it again
+	MaxSizeMB int64 `yaml:"max_size_mb"` // Size of the cache, the least recently used speech is dropped
+}
+
+type BluetoothConfig struct {
+	Address       string        `yaml:"address"`        // Address of the paired speaker, e.g. "AA:BB:CC:DD:EE:FF", empty to not use one
+	Device        string        `yaml:"device"`         // ALSA device of the speaker, the bluez-alsa device of the address if empty
+	CheckInterval time.Duration `yaml:"check_interval"` // How often the connection is checked, and restored
+	Wait          time.Duration `yaml:"wait"`           // How long an announcement waits for a disconnected speaker
+	Fallback      bool          `yaml:"fallback"`       // Then play on audio_device or the default output, instead of dropping it
+}
+
+type NetworkSpeakerConfig struct {
+	Type     string `yaml:"type"`      // "chromecast" or "sonos", empty to not use one
+	Address  string `yaml:"address"`   // Address of the speaker, with an optional port
+	MediaURL string `yaml:"media_url"` // URL of this web server as reached by the speaker, found from the route if empty
+}
+
+type AudioSinkConfig struct {
+	Name     string `yaml:"name"`      // Name shown in the web interface
+	Type     string `yaml:"type"`      // "local", "bluetooth", "chromecast" or "sonos"
+	Device   string `yaml:"device"`    // ALSA device of a local or Bluetooth sink, the default one if empty
+	Address  string `yaml:"address"`   // Address of a Bluetooth or network speaker
+	MediaURL string `yaml:"media_url"` // URL of this web server as reached by a network speaker
+	Disabled bool   `yaml:"disabled"`  // Off until switched on from the web interface
+}
+
+type WakeWordConfig struct {
+	Enabled   bool          `yaml:"enabled"`   // Listen to the microphone for the wake word
+	Model     string        `yaml:"model"`     // Directory of the keyword spotting model
+	Keywords  string        `yaml:"keywords"`  // Keywords file, keywords.txt of the model if empty
+	Threshold float32       `yaml:"threshold"` // Lower to hear the wake word more easily, with more false alarms
+	Score     float32       `yaml:"score"`     // Boost of the keywords, higher to hear them more easily
+	Window    time.Duration `yaml:"window"`    // How long it listens after the wake word
+	Sound     string        `yaml:"sound"`     // Played when the wake word is heard, empty for none
+}
+
+type SpeechRecognitionConfig struct {
+	Model    string `yaml:"model"`    // Directory of the sherpa-onnx model
+	Type     string `yaml:"type"`     // "whisper" or "transducer"
+	Language string `yaml:"language"` // Language of a multilingual Whisper model, e.g. "en"
+}
+
+type VoiceCommandsConfig struct {
+	Enabled bool          `yaml:"enabled"` // Run the commands said after the wake word
+	Snooze  time.Duration `yaml:"snooze"`  // Snooze when no time is said
+}
+
+type VoiceAnswersConfig struct {
+	Enabled bool          `yaml:"enabled"` // Listen for a yes or no after asking if an event started
+	Window  time.Duration `yaml:"window"`  // How long it listens for the answer
+}
+
+type VolumeConfig struct {
+	Level   int            `yaml:"level"`   // Volume in percent, outside of the windows
+	Windows []VolumeWindow `yaml:"windows"` // Volume by time of day, the first matching window applies
+}
+
+type VolumeWindow struct {
+	From  string `yaml:"from"`  // Start time of day, e.g. "21:00"
+	To    string `yaml:"to"`    // End time of day, e.g. "07:00", can be past midnight
+	Level int    `yaml:"level"` // Volume in percent
+}
+
+type ChimeConfig struct {
+	Enabled bool              `yaml:"enabled"` // Play a chime before the announcements
+	Sound   string            `yaml:"sound"`   // WAV or OGG file of the chime
+	Pause   time.Duration     `yaml:"pause"`   // Silence between the chime and the speech
+	Sounds  map[string]string `yaml:"sounds"`  // Chime by announcement kind, empty for none
+}
+
+type PrivacyConfig struct {
+	Enabled         bool            `yaml:"enabled"`          // Announce private events without their details
+	Calendars       []string        `yaml:"calendars"`        // Calendar sources whose events are all private
+	Title           string          `yaml:"title"`            // What private events are called, e.g. "a private appointment"
+	MessageTemplate MessageTemplate `yaml:"message_template"` // Start announcement of private events
+}
+
+type EventRule struct {
+	Name       string   `yaml:"name"`        // Name of the rule, used in logs
+	Categories []string `yaml:"categories"`  // Matches events with any of these categories
+	TitleRegex string   `yaml:"title_regex"` // Matches event titles against this regular expression
+	Calendar   string   `yaml:"calendar"`    // Matches events from this calendar source
+	Mode       string   `yaml:"mode"`        // How to announce matching events: normal, once or skip
+	Priority   string   `yaml:"priority"`    // Priority of matching events: high, normal or low
+	Holiday    bool     `yaml:"holiday"`     // Matches events on holidays only
+	Sound      string   `yaml:"sound"`       // Sound played before the announcements of matching events
+	SoundOnly  bool     `yaml:"sound_only"`  // Play the sound instead of speaking
+
+	titleRegex *regexp.Regexp
+}
+
+type ReminderProfile struct {
+	Mode                string        `yaml:"mode"`                 // How to announce the events: normal, once or skip
+	NotificationRepeats int           `yaml:"notification_repeats"` // Reminders during the event, notification_repeats if 0
+	ReminderInterval    time.Duration `yaml:"reminder_interval"`    // Remind this often instead, overrides notification_repeats
+	PreStart            bool          `yaml:"pre_start"`            // Announce before the start, even if pre_start_reminders is disabled
+	Escalation          bool          `yaml:"escalation"`           // Escalate, even if escalation is disabled
+	Speaker             *int          `yaml:"speaker"`              // Speaker index of the voice, the configured speaker if unset
+
+	// Message templates, the global ones if empty
+	AnnounceMessageTemplate    MessageTemplate `yaml:"announce_message_template"`
+	CheckStartMessageTemplate  MessageTemplate `yaml:"check_start_message_template"`
+	RemindMessageTemplate      MessageTemplate `yaml:"remind_message_template"`
+	AnnounceEndMessageTemplate MessageTemplate `yaml:"announce_end_message_template"`
+}
+
+type EventStatusConfig struct {
+	AnnounceCancelled bool   `yaml:"announce_cancelled"` // Keep announcing events that were cancelled
+	TentativeMode     string `yaml:"tentative_mode"`     // How to announce tentative events: normal, once or skip
+	TransparentMode   string `yaml:"transparent_mode"`   // How to announce events marked as free: normal, once or skip
+}
+
+type AllDayEventsConfig struct {
+	Mode            string          `yaml:"mode"`             // How to announce all-day events: morning, once or skip
+	MorningTime     string          `yaml:"morning_time"`     // Time of day for the morning mention, e.g. "08:00"
+	MessageTemplate MessageTemplate `yaml:"message_template"` // Message for all-day events
+}
+
+type PreStartConfig struct {
+	Enabled         bool            `yaml:"enabled"`          // Announce events before they start
+	Leads           []time.Duration `yaml:"leads"`            // How long before the start, e.g. [15m, 5m]
+	MessageTemplate MessageTemplate `yaml:"message_template"` // Message for the pre-start reminders
+}
+
+type EscalationConfig struct {
+	Enabled bool             `yaml:"enabled"` // Escalate the reminders of events that are not acknowledged
+	Steps   []EscalationStep `yaml:"steps"`   // The escalation ladder, the last step reached applies
+}
+
+type EscalationStep struct {
+	After           int             `yaml:"after"`            // Unanswered reminders before this step applies
+	Interval        time.Duration   `yaml:"interval"`         // Reminder interval from this step on, if shorter
+	MessageTemplate MessageTemplate `yaml:"message_template"` // Reminder message from this step on
+	Notify          bool            `yaml:"notify"`           // Raise an alert when this step is reached
+}
+
+type CalendarAlarmsConfig struct {
+	Enabled          bool            `yaml:"enabled"`           // Announce the alarms set on the calendar events
+	ReplaceReminders bool            `yaml:"replace_reminders"` // Skip the periodic reminders for events that have alarms
+	MessageTemplate  MessageTemplate `yaml:"message_template"`  // Message for alarms before the event starts
+}
+
+type RecapConfig struct {
+	Enabled         bool            `yaml:"enabled"`          // Announce how the day went at the configured time
+	Time            string          `yaml:"time"`             // Time of day for the recap, e.g. "20:30"
+	MessageTemplate MessageTemplate `yaml:"message_template"` // Recap message, a built-in summary if empty
+}
+
+type ReviewConfig struct {
+	Enabled          bool            `yaml:"enabled"`           // Walk through today's events at the configured time
+	Time             string          `yaml:"time"`              // Time of day for the review, e.g. "21:00"
+	QuestionTemplate MessageTemplate `yaml:"question_template"` // Question asked for every event
+	AnswerTimeout    time.Duration   `yaml:"answer_timeout"`    // How long to wait for an answer to each question
+}
+
+type PanicConfig struct {
+	Message    string `yaml:"message"`     // Message spoken when the panic trigger fires
+	Repeats    int    `yaml:"repeats"`     // Number of times the message is spoken
+	DeviceName string `yaml:"device_name"` // Name of this device, included in the alert
+	Location   string `yaml:"location"`    // Where this device is located, included in the alert
 }
 
 type VitsConfig struct {
@@ -105,14 +910,19 @@ func loadConfig() error {
 	file := openFile(realPath(defaultConfig))
 	defer file.Close()
 	decoder := yaml.NewDecoder(file)
+	SysConfig.CalendarFetchRetries = newConfig().CalendarFetchRetries
 	if err := decoder.Decode(&SysConfig); err != nil {
 		logError("Error decoding configuration file: %v", err)
 		return err
 	}
 
-	if SysConfig.NotificationRepeats <= 0 {
-		SysConfig.NotificationRepeats = DefaultNotificationRepeats
-	}
+	applyConfigDefaults(&SysConfig)
+	applyLogLevel()
+	SysMessages = SysConfig.SystemMessages.withDefaults(DefaultSystemMessages)
+	compileEventRules()
+	compileEscalation()
+	compilePronunciations()
+	compileSinks()
 
 	// Load secrets, with fallback to environment variables
 	secretsPath := realPath(defaultSecrets)
@@ -131,9 +941,291 @@ func loadConfig() error {
 	// Override with environment variables if they exist
 	overrideSecretsWithEnv()
 
+	// (re)create the calendar sources, credentials might have changed
+	setupCalendarSources()
+
+	// the announcement times depend on the config
+	reschedule()
+
 	return nil
 }
 
+// newConfig returns a Config to decode a file into, the options whose zero
+// value means something are marked as unset.
+func newConfig() Config {
+	return Config{CalendarFetchRetries: -1}
+}
+
+// applyConfigDefaults sets the options left out of the config to their
+// defaults.
+func applyConfigDefaults(c *Config) {
+	if c.NotificationRepeats <= 0 {
+		c.NotificationRepeats = DefaultNotificationRepeats
+	}
+	if c.PanicConfig.Message == "" {
+		c.PanicConfig.Message = DefaultPanicMessage
+	}
+	if c.PanicConfig.Repeats <= 0 {
+		c.PanicConfig.Repeats = DefaultPanicRepeats
+	}
+	if c.CalDAVRediscoveryInterval <= 0 {
+		c.CalDAVRediscoveryInterval = DefaultCalDAVRediscovery
+	}
+	if c.CalendarFetchRetries < 0 {
+		c.CalendarFetchRetries = DefaultCalendarRetries
+	}
+	if c.CalendarFetchBackoff <= 0 {
+		c.CalendarFetchBackoff = DefaultCalendarBackoff
+	}
+	if c.HistoryRetentionDays == 0 {
+		c.HistoryRetentionDays = DefaultHistoryRetention
+	}
+	if c.CalendarPollInterval <= 0 {
+		c.CalendarPollInterval = DefaultCalendarPoll
+	}
+	if c.CalendarPushPollInterval <= 0 {
+		c.CalendarPushPollInterval = DefaultCalendarPushPoll
+	}
+	if len(c.CheckStartDelays) == 0 {
+		c.CheckStartDelays = DefaultCheckStartDelays
+	}
+	sort.Slice(c.CheckStartDelays, func(i, j int) bool {
+		return c.CheckStartDelays[i] < c.CheckStartDelays[j]
+	})
+	if len(c.PreStartReminders.Leads) == 0 {
+		c.PreStartReminders.Leads = DefaultPreStartLeads
+	}
+	if c.AllDayEvents.Mode == "" {
+		c.AllDayEvents.Mode = DefaultAllDayMode
+	}
+	if c.AllDayEvents.MorningTime == "" {
+		c.AllDayEvents.MorningTime = DefaultAllDayMorningTime
+	}
+	if c.EventStatus.TentativeMode == "" {
+		c.EventStatus.TentativeMode = DefaultTentativeMode
+	}
+	if c.EventStatus.TransparentMode == "" {
+		c.EventStatus.TransparentMode = DefaultTransparentMode
+	}
+	if c.ReviewConfig.AnswerTimeout <= 0 {
+		c.ReviewConfig.AnswerTimeout = DefaultReviewAnswerTimeout
+	}
+	if c.CatchUp.Grace <= 0 {
+		c.CatchUp.Grace = DefaultCatchUpGrace
+	}
+	if c.CatchUp.MaxAge <= 0 {
+		c.CatchUp.MaxAge = DefaultCatchUpMaxAge
+	}
+	if c.BatchReminders.Window <= 0 {
+		c.BatchReminders.Window = DefaultBatchWindow
+	}
+	if c.TtsCache.MaxSizeMB <= 0 {
+		c.TtsCache.MaxSizeMB = DefaultTtsCacheSizeMB
+	}
+	if c.Bluetooth.CheckInterval <= 0 {
+		c.Bluetooth.CheckInterval = DefaultBluetoothCheck
+	}
+	if c.Bluetooth.Wait < 0 {
+		c.Bluetooth.Wait = 0
+	}
+	if c.WakeWord.Model == "" {
+		c.WakeWord.Model = DefaultWakeWordModel
+	}
+	if c.WakeWord.Window <= 0 {
+		c.WakeWord.Window = DefaultWakeWordWindow
+	}
+	if c.WakeWord.Threshold <= 0 {
+		c.WakeWord.Threshold = DefaultWakeWordThreshold
+	}
+	if c.WakeWord.Score <= 0 {
+		c.WakeWord.Score = DefaultWakeWordScore
+	}
+	if c.SpeechRecognition.Model == "" {
+		c.SpeechRecognition.Model = DefaultSttModel
+	}
+	if c.SpeechRecognition.Type == "" {
+		c.SpeechRecognition.Type = DefaultSttType
+	}
+	if c.VoiceCommands.Snooze <= 0 {
+		c.VoiceCommands.Snooze = DefaultVoiceSnooze
+	}
+	if c.VoiceAnswers.Window <= 0 {
+		c.VoiceAnswers.Window = DefaultVoiceAnswerWindow
+	}
+	if c.WebServer.TLS.ACME.ChallengeAddress == "" {
+		c.WebServer.TLS.ACME.ChallengeAddress = DefaultACMEChallenge
+	}
+	if c.WebPush.Subject == "" {
+		c.WebPush.Subject = DefaultWebPushSubject
+	}
+	if c.WebPush.TTL <= 0 {
+		c.WebPush.TTL = DefaultWebPushTTL
+	}
+	if c.Notifications.Attempts <= 0 {
+		c.Notifications.Attempts = DefaultNotifyAttempts
+	}
+	if c.Notifications.RetryDelay <= 0 {
+		c.Notifications.RetryDelay = DefaultNotifyRetryDelay
+	}
+	if c.Notifications.QueueSize <= 0 {
+		c.Notifications.QueueSize = DefaultNotifyQueueSize
+	}
+	for i := range c.Scripts {
+		if c.Scripts[i].Timeout <= 0 {
+			c.Scripts[i].Timeout = DefaultScriptTimeout
+		}
+	}
+	if c.Lights.Duration <= 0 {
+		c.Lights.Duration = DefaultLightsDuration
+	}
+	if c.Button.Chip == "" {
+		c.Button.Chip = DefaultGPIOChip
+	}
+	if c.Button.Debounce <= 0 {
+		c.Button.Debounce = DefaultButtonDebounce
+	}
+	if c.Button.LongPress <= 0 {
+		c.Button.LongPress = DefaultButtonLongPress
+	}
+	if c.Button.Snooze <= 0 {
+		c.Button.Snooze = DefaultButtonSnooze
+	}
+	if c.StatusLED.Type == "" {
+		c.StatusLED.Type = statusLEDGPIO
+	}
+	if c.StatusLED.Chip == "" {
+		c.StatusLED.Chip = DefaultGPIOChip
+	}
+	if c.StatusLED.SPI == "" {
+		c.StatusLED.SPI = DefaultStatusLEDSPI
+	}
+	if c.StatusLED.Count <= 0 {
+		c.StatusLED.Count = 1
+	}
+	if c.StatusLED.Brightness <= 0 {
+		c.StatusLED.Brightness = DefaultStatusLEDBrightness
+	}
+	if c.Medications.Window <= 0 {
+		c.Medications.Window = DefaultMedicationWindow
+	}
+	for i := range c.Medications.Schedule {
+		for j, day := range c.Medications.Schedule[i].Days {
+			c.Medications.Schedule[i].Days[j] = strings.ToLower(strings.TrimSpace(day))
+		}
+	}
+	if c.WakeUp.Snooze <= 0 {
+		c.WakeUp.Snooze = DefaultWakeUpSnooze
+	}
+	if c.WakeUp.MaxSnoozes <= 0 {
+		c.WakeUp.MaxSnoozes = DefaultWakeUpMaxSnoozes
+	}
+	for i := range c.WakeUp.Alarms {
+		for j, day := range c.WakeUp.Alarms[i].Days {
+			c.WakeUp.Alarms[i].Days[j] = strings.ToLower(strings.TrimSpace(day))
+		}
+	}
+	if c.NightMode.Sensor == "" {
+		c.NightMode.Sensor = lightSensorBH1750
+	}
+	if c.NightMode.Bus == "" {
+		c.NightMode.Bus = DefaultDisplayBus
+	}
+	if c.NightMode.Address <= 0 {
+		c.NightMode.Address = bh1750Address
+		if c.NightMode.Sensor == lightSensorTSL2561 {
+			c.NightMode.Address = tsl2561Address
+		}
+	}
+	if c.NightMode.Interval <= 0 {
+		c.NightMode.Interval = DefaultNightModeInterval
+	}
+	if c.NightMode.DarkLux <= 0 {
+		c.NightMode.DarkLux = DefaultNightModeDarkLux
+	}
+	if c.NightMode.LightLux <= 0 {
+		c.NightMode.LightLux = DefaultNightModeLightLux
+	}
+	if c.NightMode.Action == "" {
+		c.NightMode.Action = nightActionQuiet
+	}
+	if c.NightMode.Volume <= 0 {
+		c.NightMode.Volume = DefaultNightModeVolume
+	}
+	if c.VolumeKnob.Type == "" {
+		c.VolumeKnob.Type = volumeKnobEncoder
+	}
+	if c.VolumeKnob.Chip == "" {
+		c.VolumeKnob.Chip = DefaultGPIOChip
+	}
+	if c.VolumeKnob.Step <= 0 {
+		c.VolumeKnob.Step = DefaultVolumeKnobStep
+	}
+	if c.VolumeKnob.StepsPerDetent <= 0 {
+		c.VolumeKnob.StepsPerDetent = DefaultVolumeKnobDetent
+	}
+	if c.VolumeKnob.SPI == "" {
+		c.VolumeKnob.SPI = DefaultVolumeKnobSPI
+	}
+	if c.Display.Type == "" {
+		c.Display.Type = displaySSD1306
+	}
+	if c.Display.Bus == "" {
+		c.Display.Bus = DefaultDisplayBus
+	}
+	if c.Display.Address <= 0 {
+		c.Display.Address = DefaultDisplayAddress
+	}
+	if c.Display.Height <= 0 {
+		c.Display.Height = DefaultDisplayHeight
+	}
+	if c.Presence.Chip == "" {
+		c.Presence.Chip = DefaultGPIOChip
+	}
+	if c.Presence.Recent <= 0 {
+		c.Presence.Recent = DefaultPresenceRecent
+	}
+	if c.Presence.Retry <= 0 {
+		c.Presence.Retry = DefaultPresenceRetry
+	}
+	if c.Presence.MaxDefer <= 0 {
+		c.Presence.MaxDefer = DefaultPresenceMaxDefer
+	}
+	if c.Telegram.Snooze <= 0 {
+		c.Telegram.Snooze = DefaultTelegramSnooze
+	}
+	if c.Telegram.Pause <= 0 {
+		c.Telegram.Pause = DefaultTelegramPause
+	}
+	if c.SMS.Window <= 0 {
+		c.SMS.Window = DefaultSMSWindow
+	}
+	if c.Ntfy.Server == "" {
+		c.Ntfy.Server = DefaultNtfyServer
+	}
+	if c.Volume.Level <= 0 || c.Volume.Level > 100 {
+		c.Volume.Level = DefaultVolume
+	}
+	for i := range c.Volume.Windows {
+		c.Volume.Windows[i].Level = min(max(c.Volume.Windows[i].Level, 0), 100)
+	}
+	if c.Chime.Sound == "" {
+		c.Chime.Sound = DefaultChimeSound
+	}
+	if c.Chime.Pause <= 0 {
+		c.Chime.Pause = DefaultChimePause
+	}
+	if c.Privacy.Title == "" {
+		c.Privacy.Title = DefaultPrivateTitle
+	}
+	if c.TtsManifest == "" {
+		c.TtsManifest = DefaultTtsManifest
+	}
+	c.Languages.Default = strings.ToLower(c.Languages.Default)
+	if c.Languages.Default == "" {
+		c.Languages.Default = DefaultLanguage
+	}
+}
+
 // overrideSecretsWithEnv overrides secrets with environment variables if they exist
 func overrideSecretsWithEnv() {
 	if username := os.Getenv("ICLOUD_USERNAME"); username != "" {
@@ -148,4 +1240,19 @@ func overrideSecretsWithEnv() {
 	if password := os.Getenv("WEB_SERVER_PASSWORD"); password != "" {
 		SysSecrets.WebServerPassword = password
 	}
+	if key := os.Getenv("CLOUD_TTS_API_KEY"); key != "" {
+		SysSecrets.CloudTtsApiKey = key
+	}
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		SysSecrets.Telegram.BotToken = token
+	}
+	if password := os.Getenv("SMTP_PASSWORD"); password != "" {
+		SysSecrets.SMTP.Password = password
+	}
+	if token := os.Getenv("TWILIO_AUTH_TOKEN"); token != "" {
+		SysSecrets.Twilio.AuthToken = token
+	}
+	if token := os.Getenv("PUSHOVER_API_TOKEN"); token != "" {
+		SysSecrets.Pushover.APIToken = token
+	}
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestCalendarFetchRetries(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want int
+	}{
+		{"", DefaultCalendarRetries},
+		{"calendar_fetch_retries: -1", DefaultCalendarRetries},
+		{"calendar_fetch_retries: 0", 0},
+		{"calendar_fetch_retries: 5", 5},
+	}
+	for _, tt := range tests {
+		cfg := newConfig()
+		if err := yaml.Unmarshal([]byte(tt.yaml), &cfg); err != nil {
+			t.Fatalf("%q: %v", tt.yaml, err)
+		}
+		applyConfigDefaults(&cfg)
+		if cfg.CalendarFetchRetries != tt.want {
+			t.Errorf("%q: retries = %d, want %d", tt.yaml, cfg.CalendarFetchRetries, tt.want)
+		}
+	}
+
+	// the form edits it, 0 included
+	cfg := newConfig()
+	cfg.CalendarFetchRetries = 0
+	for _, field := range configFields(cfg, "") {
+		if field.Path == "calendar_fetch_retries" {
+			if field.Value != "0" || field.Default != "3" {
+				t.Errorf("form field = %+v, want value 0 and default 3", field)
+			}
+			return
+		}
+	}
+	t.Errorf("calendar_fetch_retries missing from the config form")
+}
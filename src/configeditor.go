@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of the fields of the config form
+const (
+	fieldBool     = "bool"
+	fieldInt      = "int"
+	fieldFloat    = "float"
+	fieldString   = "string"
+	fieldDuration = "duration"
+	fieldList     = "list"      // Comma separated strings
+	fieldDuraList = "durations" // Comma separated durations
+)
+
+// ConfigField is an option of the config form. The maps, the lists of
+// settings and the message templates are only edited as YAML.
+type ConfigField struct {
+	Path    string `json:"path"`    // Dotted YAML keys, e.g. "wake_word.window"
+	Section string `json:"section"` // First key of the nested options, empty at the top
+	Label   string `json:"label"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Default string `json:"default"`
+	Help    string `json:"help,omitempty"` // From the comments of the config file
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configFields returns the options of the config that the form can edit,
+// with their values in cfg and the help of the config file text.
+func configFields(cfg Config, text string) []ConfigField {
+	defaults := newConfig()
+	applyConfigDefaults(&defaults)
+
+	help := map[string]string{}
+	for _, k := range yamlKeyLines(text) {
+		if k.help != "" && (help[k.path] == "" || !k.commented) {
+			help[k.path] = k.help
+		}
+	}
+
+	fields := []ConfigField{}
+	var walk func(value, def reflect.Value, path []string)
+	walk = func(value, def reflect.Value, path []string) {
+		t := value.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			fieldPath := append(append([]string{}, path...), key)
+			v, d := value.Field(i), def.Field(i)
+
+			if v.Kind() == reflect.Struct {
+				walk(v, d, fieldPath)
+				continue
+			}
+			fieldType := configFieldType(v.Type())
+			if fieldType == "" {
+				continue
+			}
+
+			field := ConfigField{
+				Path:    strings.Join(fieldPath, "."),
+				Label:   configLabel(key),
+				Type:    fieldType,
+				Value:   formatConfigValue(v),
+				Default: formatConfigValue(d),
+			}
+			if len(path) > 0 {
+				field.Section = path[0]
+			}
+			field.Help = help[field.Path]
+			fields = append(fields, field)
+		}
+	}
+	walk(reflect.ValueOf(cfg), reflect.ValueOf(defaults), nil)
+	return fields
+}
+
+// configFieldType returns the form field type of a config option type,
+// empty if it is only edited as YAML.
+func configFieldType(t reflect.Type) string {
+	if t == durationType {
+		return fieldDuration
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return fieldBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fieldInt
+	case reflect.Float32, reflect.Float64:
+		return fieldFloat
+	case reflect.String:
+		return fieldString
+	case reflect.Slice:
+		// MessageTemplate is a list too, but multi-line
+		if t.Name() == "" && t.Elem() == durationType {
+			return fieldDuraList
+		}
+		if t.Name() == "" && t.Elem().Kind() == reflect.String {
+			return fieldList
+		}
+	}
+	return ""
+}
+
+// configLabel returns the label of a YAML key, e.g. "Reminder interval".
+func configLabel(key string) string {
+	label := strings.ReplaceAll(key, "_", " ")
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// formatConfigValue returns a config value as the form shows it.
+func formatConfigValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return formatConfigDuration(time.Duration(v.Int()))
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatConfigValue(v.Index(i))
+		}
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// formatConfigDuration returns the duration as it is written in the config,
+// e.g. "1h30m" rather than "1h30m0s".
+func formatConfigDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// configFieldYAML validates a value of the form and returns it as a YAML
+// value.
+func configFieldYAML(field ConfigField, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch field.Type {
+	case fieldBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("must be true or false")
+		}
+		return strconv.FormatBool(b), nil
+	case fieldInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("must be a whole number")
+		}
+		return value, nil
+	case fieldFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("must be a number")
+		}
+		return value, nil
+	case fieldDuration:
+		if value == "" {
+			return "0s", nil
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return "", fmt.Errorf("must be a duration, like 90s, 15m or 1h30m")
+		}
+		return value, nil
+	case fieldList, fieldDuraList:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if field.Type == fieldList {
+				items = append(items, strconv.Quote(item))
+				continue
+			}
+			if _, err := time.ParseDuration(item); err != nil {
+				return "", fmt.Errorf("%q is not a duration, like 90s, 15m or 1h30m", item)
+			}
+			items = append(items, item)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	}
+	return strconv.Quote(value), nil
+}
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// minPollInterval is the shortest calendar poll interval that doesn't
+	// hammer the servers.
+	minPollInterval = 5 * time.Second
+	// minReminderInterval is the shortest reminder interval that isn't
+	// announced all the time.
+	minReminderInterval = time.Minute
+)
+
+// ConfigProblem is something wrong with a proposed configuration.
+type ConfigProblem struct {
+	Path    string `json:"path"` // Dotted YAML keys of the option, empty for the whole file
+	Message string `json:"message"`
+	Warning bool   `json:"warning,omitempty"` // It works, but likely not as intended
+}
+
+// validateConfigYAML checks a proposed configuration beyond its syntax, the
+// calendar servers are only checked with checkNetwork.
+func validateConfigYAML(data []byte, checkNetwork bool) []ConfigProblem {
+	cfg := newConfig()
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return []ConfigProblem{{Message: fmt.Sprintf("invalid YAML: %v", err)}}
+	}
+
+	problems := []ConfigProblem{}
+	if err := yaml.UnmarshalStrict(data, &Config{}); err != nil {
+		// e.g. a misspelled option, which is silently ignored
+		problems = append(problems, ConfigProblem{Message: strings.TrimPrefix(err.Error(), "yaml: "), Warning: true})
+	}
+
+	applyConfigDefaults(&cfg)
+	problems = append(problems, validateTemplates(cfg)...)
+	problems = append(problems, validateFiles(cfg)...)
+	problems = append(problems, validateIntervals(cfg)...)
+	problems = append(problems, validateSettings(cfg)...)
+	if checkNetwork {
+		problems = append(problems, validateCalendarServers()...)
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems
+}
+
+// newConfigErrors returns the problems that are not warnings, and that the
+// current configuration doesn't have already, e.g. the models not yet
+// downloaded.
+func newConfigErrors(problems, current []ConfigProblem) []ConfigProblem {
+	known := map[ConfigProblem]bool{}
+	for _, p := range current {
+		known[p] = true
+	}
+	errs := []ConfigProblem{}
+	for _, p := range problems {
+		if !p.Warning && !known[p] {
+			errs = append(errs, p)
+		}
+	}
+	return errs
+}
+
+// messageTemplateCheck is a message template option, with data like the
+// one its messages are filled in with.
+type messageTemplateCheck struct {
+	path string
+	tmpl MessageTemplate
+	data interface{}
+}
+
+// validateTemplates checks that the message templates compile and only use
+// the fields their messages have.
+func validateTemplates(cfg Config) []ConfigProblem {
+	// filled in, so that the conditions hold and their fields are checked too
+	now := time.Now()
+	event := EventTemplateData{Event: "event", Location: "location", Notes: "notes", Calendar: "calendar",
+		StartTime: now, EndTime: now, Duration: "1 hour", TimeLeft: "1 hour", StartsIn: "5 minutes", Now: now}
+	templates := []messageTemplateCheck{
+		{"announce_message_template", cfg.AnnounceMessageTemplate, event},
+		{"announce_end_message_template", cfg.AnnounceEndMessageTemplate, event},
+		{"check_start_message_template", cfg.CheckStartMessageTemplate, event},
+		{"remind_message_template", cfg.RemindMessageTemplate, event},
+		{"catch_up.message_template", cfg.CatchUp.MessageTemplate, catchUpTemplateData{EventTemplateData: event}},
+		{"batch_reminders.message_template", cfg.BatchReminders.MessageTemplate, batchTemplateData{Count: 1, Events: "event", Items: []EventTemplateData{event}}},
+		{"privacy.message_template", cfg.Privacy.MessageTemplate, privateTemplateData{Event: "event", Time: "9:00 AM"}},
+		{"all_day_events.message_template", cfg.AllDayEvents.MessageTemplate, event},
+		{"pre_start_reminders.message_template", cfg.PreStartReminders.MessageTemplate, event},
+		{"calendar_alarms.message_template", cfg.CalendarAlarms.MessageTemplate, event},
+		{"recap_config.message_template", cfg.RecapConfig.MessageTemplate, recapTemplateData{Done: "event", Unconfirmed: "event", Missed: "event", DoneCount: 1, UnconfirmedCount: 1, MissedCount: 1}},
+		{"review_config.question_template", cfg.ReviewConfig.QuestionTemplate, event},
+		{"wake_up.greeting", cfg.WakeUp.Greeting, wakeUpTemplateData{Now: time.Now()}},
+	}
+	for i, step := range cfg.Escalation.Steps {
+		templates = append(templates, messageTemplateCheck{fmt.Sprintf("escalation.steps.%d.message_template", i), step.MessageTemplate, event})
+	}
+	profiles := map[string]map[string]ReminderProfile{
+		"priority_profiles": cfg.PriorityProfiles,
+		"calendar_profiles": cfg.CalendarProfiles,
+	}
+	for group, list := range profiles {
+		for name, p := range list {
+			prefix := group + "." + name + "."
+			for key, tmpl := range map[string]MessageTemplate{
+				"announce_message_template":     p.AnnounceMessageTemplate,
+				"check_start_message_template":  p.CheckStartMessageTemplate,
+				"remind_message_template":       p.RemindMessageTemplate,
+				"announce_end_message_template": p.AnnounceEndMessageTemplate,
+			} {
+				templates = append(templates, messageTemplateCheck{prefix + key, tmpl, event})
+			}
+		}
+	}
+
+	problems := []ConfigProblem{}
+	for _, t := range templates {
+		for _, text := range t.tmpl {
+			if err := checkTemplate(text, t.data); err != nil {
+				problems = append(problems, ConfigProblem{Path: t.path, Message: err.Error()})
+			}
+		}
+	}
+	return problems
+}
+
+// checkTemplate compiles the template and runs it on the data, which fails
+// on the fields the data doesn't have.
+func checkTemplate(text string, data interface{}) error {
+	tmpl, err := template.New("message").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("%q doesn't compile: %v", text, strings.TrimPrefix(err.Error(), "template: message:"))
+	}
+	if err := tmpl.Execute(io.Discard, data); err != nil {
+		// e.g. "can't evaluate field Title in type main.EventTemplateData"
+		msg := err.Error()
+		if i := strings.LastIndex(msg, ">: "); i >= 0 {
+			msg = msg[i+3:]
+		}
+		return fmt.Errorf("%q can't be filled in: %s", text, strings.ReplaceAll(msg, "main.", ""))
+	}
+	return nil
+}
+
+// validateFiles checks that the models and sounds the config refers to are
+// there.
+func validateFiles(cfg Config) []ConfigProblem {
+	problems := []ConfigProblem{}
+	missing := func(path, file string, dir bool) {
+		if file == "" {
+			return
+		}
+		info, err := os.Stat(realPath(file))
+		switch {
+		case err != nil:
+			problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf("%s not found", file)})
+		case dir && !info.IsDir():
+			problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf("%s is not a directory", file)})
+		}
+	}
+
+	// the models of the voices, a model chosen in the web interface was
+	// checked when it was installed
+	models := map[string]string{}
+	if model := strings.ToLower(cfg.AiSpeechTtsConfig.TtsModel); model != "" && activeTtsModel() == "" {
+		models[model] = "ai_speech_tts_config.tts_model"
+	}
+	for kind, v := range cfg.Voices {
+		if v.Model != "" {
+			models[strings.ToLower(v.Model)] = "voices." + kind + ".model"
+		}
+	}
+	for lang, v := range cfg.Languages.Voices {
+		if v.Model != "" {
+			models[strings.ToLower(v.Model)] = "languages.voices." + lang + ".model"
+		}
+	}
+	for model, path := range models {
+		if name, def, ok := configModelDefinition(cfg, model); ok {
+			prefix := "tts_models." + name + "."
+			missing(prefix+"model", def.Model, false)
+			missing(prefix+"tokens", def.Tokens, false)
+			missing(prefix+"vocoder", def.Vocoder, false)
+			missing(prefix+"voices", def.Voices, false)
+			missing(prefix+"data_dir", def.DataDir, true)
+			missing(prefix+"dict_dir", def.DictDir, true)
+			for _, file := range strings.Split(def.Lexicon, ",") {
+				missing(prefix+"lexicon", strings.TrimSpace(file), false)
+			}
+			continue
+		}
+		tts := cfg.AiSpeechTtsConfig
+		switch model {
+		case ttsModelKokoro:
+			missing("ai_speech_tts_config.kokoro_model", tts.KokoroModel, false)
+			missing("ai_speech_tts_config.kokoro_voices", tts.KokoroVoices, false)
+			missing("ai_speech_tts_config.kokoro_tokens", tts.KokoroTokens, false)
+			missing("ai_speech_tts_config.kokoro_data_dir", tts.KokoroDataDir, true)
+		case ttsModelGlados:
+			missing("ai_speech_tts_config.glados_model", tts.GladosModel, false)
+			missing("ai_speech_tts_config.glados_tokens", tts.GladosTokens, false)
+			missing("ai_speech_tts_config.glados_lexicon", tts.GladosLexicon, false)
+			missing("ai_speech_tts_config.glados_data_dir", tts.GladosDataDir, true)
+		default:
+			if _, ok := installedModel(model); !ok {
+				problems = append(problems, ConfigProblem{Path: path,
+					Message: fmt.Sprintf("unknown TTS model %q, expected kokoro, glados, one of tts_models or a downloaded voice", model)})
+			}
+		}
+	}
+
+	if cfg.WakeWord.Enabled {
+		missing("wake_word.model", cfg.WakeWord.Model, true)
+		missing("wake_word.keywords", cfg.WakeWord.Keywords, false)
+		missing("wake_word.sound", cfg.WakeWord.Sound, false)
+	}
+	if cfg.WakeWord.Enabled || cfg.VoiceAnswers.Enabled {
+		missing("speech_recognition.model", cfg.SpeechRecognition.Model, true)
+	}
+	if cfg.Chime.Enabled {
+		missing("chime.sound", cfg.Chime.Sound, false)
+		for kind, sound := range cfg.Chime.Sounds {
+			missing("chime.sounds."+kind, sound, false)
+		}
+	}
+	for i, rule := range cfg.EventRules {
+		missing(fmt.Sprintf("event_rules.%d.sound", i), rule.Sound, false)
+	}
+	if cfg.WakeUp.Enabled {
+		missing("wake_up.sound", cfg.WakeUp.Sound, false)
+	}
+	missing("holidays.path", cfg.Holidays.Path, false)
+	if cfg.WebServer.TLS.Enabled && !cfg.WebServer.TLS.ACME.Enabled {
+		missing("web_server.tls.cert", cfg.WebServer.TLS.Cert, false)
+		missing("web_server.tls.key", cfg.WebServer.TLS.Key, false)
+	}
+	return problems
+}
+
+// configModelDefinition returns the tts_models entry of the model.
+func configModelDefinition(cfg Config, model string) (string, TtsModelDefinition, bool) {
+	for name, def := range cfg.TtsModels {
+		if strings.EqualFold(name, model) {
+			return name, def, true
+		}
+	}
+	return "", TtsModelDefinition{}, false
+}
+
+// validateIntervals checks that no duration is negative and that the
+// polling and the reminders are not too frequent.
+func validateIntervals(cfg Config) []ConfigProblem {
+	problems := []ConfigProblem{}
+
+	var walk func(v reflect.Value, path string)
+	walk = func(v reflect.Value, path string) {
+		switch {
+		case v.Type() == durationType:
+			if v.Int() < 0 {
+				problems = append(problems, ConfigProblem{Path: path, Message: "can't be negative"})
+			}
+		case v.Kind() == reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				key := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+				if key == "" || key == "-" {
+					continue
+				}
+				walk(v.Field(i), strings.TrimPrefix(path+"."+key, "."))
+			}
+		case v.Kind() == reflect.Slice && v.Type() != reflect.TypeOf(MessageTemplate{}):
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i), fmt.Sprintf("%s.%d", path, i))
+			}
+		case v.Kind() == reflect.Map:
+			for _, key := range v.MapKeys() {
+				walk(v.MapIndex(key), fmt.Sprintf("%s.%v", path, key))
+			}
+		}
+	}
+	walk(reflect.ValueOf(cfg), "")
+
+	if cfg.CalendarPollInterval < minPollInterval {
+		problems = append(problems, ConfigProblem{Path: "calendar_poll_interval",
+			Message: fmt.Sprintf("polling more often than every %s puts a load on the calendar servers", formatConfigDuration(minPollInterval)), Warning: true})
+	}
+	if cfg.CalendarPushPollInterval < cfg.CalendarPollInterval {
+		problems = append(problems, ConfigProblem{Path: "calendar_push_poll_interval",
+			Message: "is shorter than calendar_poll_interval, pushing the changes saves nothing", Warning: true})
+	}
+	if cfg.ReminderInterval > 0 && cfg.ReminderInterval < minReminderInterval {
+		problems = append(problems, ConfigProblem{Path: "reminder_interval",
+			Message: fmt.Sprintf("reminding more often than every %s is hardly ever quiet", formatConfigDuration(minReminderInterval)), Warning: true})
+	}
+	for i, step := range cfg.Escalation.Steps {
+		if step.Interval > 0 && step.Interval < minReminderInterval {
+			problems = append(problems, ConfigProblem{Path: fmt.Sprintf("escalation.steps.%d.interval", i),
+				Message: fmt.Sprintf("reminding more often than every %s is hardly ever quiet", formatConfigDuration(minReminderInterval)), Warning: true})
+		}
+	}
+	for i, lead := range cfg.PreStartReminders.Leads {
+		if lead == 0 {
+			problems = append(problems, ConfigProblem{Path: fmt.Sprintf("pre_start_reminders.leads.%d", i),
+				Message: "a reminder 0s before the start is the start announcement", Warning: true})
+		}
+	}
+	return problems
+}
+
+// validateSettings checks the options that only take some values.
+func validateSettings(cfg Config) []ConfigProblem {
+	problems := []ConfigProblem{}
+	add := func(path, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if _, err := configLogLevel(cfg); err != nil {
+		add("log_level", "%v", err)
+	}
+
+	for i, proxy := range cfg.WebServer.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		if cidrErr != nil && net.ParseIP(proxy) == nil {
+			add(fmt.Sprintf("web_server.trusted_proxies.%d", i), "%q is not an address or a network, like 192.168.1.10 or 172.16.0.0/12", proxy)
+		}
+	}
+	for i, origin := range cfg.WebServer.CORSOrigins {
+		if !validCORSOrigin(origin) {
+			add(fmt.Sprintf("web_server.cors_origins.%d", i), "%q is not an origin, like https://dash.example.com, or *", origin)
+		}
+	}
+	names := map[string]bool{}
+	for i, hook := range cfg.Webhooks {
+		path := fmt.Sprintf("webhooks.%d", i)
+		if hook.Name == "" || names[hook.Name] {
+			add(path+".name", "every webhook needs a name of its own")
+		}
+		names[hook.Name] = true
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(path+".url", "%q is not an http or https URL", hook.URL)
+		}
+		for j, typ := range hook.Events {
+			if !slices.Contains(webhookTypes, typ) {
+				add(fmt.Sprintf("%s.events.%d", path, j), "unknown event %q, expected %s", typ, strings.Join(webhookTypes, ", "))
+			}
+		}
+	}
+	for name, channel := range cfg.Notifications.Channels {
+		for j, kind := range channel.Kinds {
+			if !slices.Contains(announceKinds, kind) {
+				add(fmt.Sprintf("notifications.channels.%s.kinds.%d", name, j), "unknown announcement kind %q, expected %s", kind, strings.Join(announceKinds, ", "))
+			}
+		}
+	}
+	names = map[string]bool{}
+	for i, script := range cfg.Scripts {
+		path := fmt.Sprintf("scripts.%d", i)
+		if script.Name == "" || names[script.Name] {
+			add(path+".name", "every script needs a name of its own")
+		}
+		names[script.Name] = true
+		if len(script.Command) == 0 || script.Command[0] == "" {
+			add(path+".command", "the program to run is missing")
+		}
+		for j, kind := range script.Kinds {
+			if !slices.Contains(announceKinds, kind) {
+				add(fmt.Sprintf("%s.kinds.%d", path, j), "unknown announcement kind %q, expected %s", kind, strings.Join(announceKinds, ", "))
+			}
+		}
+	}
+	if cfg.Button.Pin < 0 {
+		add("button.pin", "pin %d is not a GPIO pin", cfg.Button.Pin)
+	}
+	if cfg.Button.PanicPin < 0 {
+		add("button.panic_pin", "pin %d is not a GPIO pin", cfg.Button.PanicPin)
+	} else if cfg.Button.PanicPin > 0 && cfg.Button.PanicPin == cfg.Button.Pin {
+		add("button.panic_pin", "pin %d is already the button", cfg.Button.PanicPin)
+	}
+	if cfg.Button.Snooze > maxSnoozeMinutes*time.Minute {
+		add("button.snooze", "snoozes are %d minutes at most", maxSnoozeMinutes)
+	}
+	// the doses are told apart by the name of the medication and the time, on
+	// the days both are taken
+	type scheduledDose struct {
+		path string
+		days []string
+	}
+	doses := map[string][]scheduledDose{}
+	for i, med := range cfg.Medications.Schedule {
+		path := fmt.Sprintf("medications.schedule.%d", i)
+		if strings.TrimSpace(med.Name) == "" {
+			add(path+".name", "every medication needs a name")
+		}
+		if len(med.Times) == 0 {
+			add(path+".times", "no times set for the doses")
+		}
+		for j, at := range med.Times {
+			timePath := fmt.Sprintf("%s.times.%d", path, j)
+			if _, err := time.Parse("15:04", at); err != nil {
+				add(timePath, "invalid time %q, expected HH:MM", at)
+				continue
+			}
+			dose := medicationSlug(med.Name) + " " + at
+			for _, other := range doses[dose] {
+				if len(med.Days) == 0 || len(other.days) == 0 || slices.ContainsFunc(med.Days, func(d string) bool { return slices.Contains(other.days, d) }) {
+					add(timePath, "%s at %s is already scheduled in %s", med.Name, at, other.path)
+					break
+				}
+			}
+			doses[dose] = append(doses[dose], scheduledDose{timePath, med.Days})
+		}
+		for j, day := range med.Days {
+			if !slices.Contains(weekdays, day) {
+				add(fmt.Sprintf("%s.days.%d", path, j), "unknown day %q, expected %s", day, strings.Join(weekdays, ", "))
+			}
+		}
+	}
+	if cfg.WakeUp.Enabled && len(cfg.WakeUp.Alarms) == 0 {
+		add("wake_up.alarms", "no wake-up alarms set")
+	}
+	for i, alarm := range cfg.WakeUp.Alarms {
+		path := fmt.Sprintf("wake_up.alarms.%d", i)
+		if _, err := time.Parse("15:04", alarm.Time); err != nil {
+			add(path+".time", "invalid time %q, expected HH:MM", alarm.Time)
+		}
+		for j, day := range alarm.Days {
+			if !slices.Contains(weekdays, day) {
+				add(fmt.Sprintf("%s.days.%d", path, j), "unknown day %q, expected %s", day, strings.Join(weekdays, ", "))
+			}
+		}
+	}
+	if cfg.WakeUp.Volume > 100 {
+		add("wake_up.volume", "volume %d is over 100 percent", cfg.WakeUp.Volume)
+	}
+	night := cfg.NightMode
+	if night.Sensor != lightSensorBH1750 && night.Sensor != lightSensorTSL2561 {
+		add("night_mode.sensor", "unknown sensor %q, expected bh1750 or tsl2561", night.Sensor)
+	}
+	if night.Action != nightActionQuiet && night.Action != nightActionSilent {
+		add("night_mode.action", "unknown action %q, expected quiet or silent", night.Action)
+	}
+	if night.LightLux < night.DarkLux {
+		add("night_mode.light_lux", "%g lux is under dark_lux, %g", night.LightLux, night.DarkLux)
+	}
+	if night.Volume > 100 {
+		add("night_mode.volume", "volume %d is over 100 percent", night.Volume)
+	}
+	switch knob := cfg.VolumeKnob; knob.Type {
+	case volumeKnobEncoder:
+		if knob.Enabled && (knob.PinA <= 0 || knob.PinB <= 0 || knob.PinA == knob.PinB) {
+			add("volume_knob", "the encoder needs two pins of its own")
+		}
+		if knob.Step > 100 {
+			add("volume_knob.step", "step %d is over 100 percent", knob.Step)
+		}
+		if knob.StepsPerDetent != 1 && knob.StepsPerDetent != 2 && knob.StepsPerDetent != 4 {
+			add("volume_knob.steps_per_detent", "%d steps per click, expected 1, 2 or 4", knob.StepsPerDetent)
+		}
+	case volumeKnobPotentiometer:
+		if knob.Channel < 0 || knob.Channel > 7 {
+			add("volume_knob.channel", "channel %d is not one of the 0 to 7 of an MCP3008", knob.Channel)
+		}
+	default:
+		add("volume_knob.type", "unknown type %q, expected encoder or potentiometer", knob.Type)
+	}
+	if cfg.Display.Type != displaySSD1306 {
+		add("display.type", "unknown type %q, expected ssd1306", cfg.Display.Type)
+	}
+	if cfg.Display.Height != 32 && cfg.Display.Height != 64 {
+		add("display.height", "height %d is not 32 or 64 pixels", cfg.Display.Height)
+	}
+	// the others are reserved
+	if cfg.Display.Address < 0x08 || cfg.Display.Address > 0x77 {
+		add("display.address", "0x%02x is not an I2C address", cfg.Display.Address)
+	}
+	if cfg.Presence.Pin < 0 {
+		add("presence.pin", "pin %d is not a GPIO pin", cfg.Presence.Pin)
+	}
+	if cfg.Presence.Enabled && cfg.Button.Enabled && cfg.Presence.Chip == cfg.Button.Chip && cfg.Presence.Pin == cfg.Button.Pin {
+		add("presence.pin", "pin %d is already used by the button", cfg.Presence.Pin)
+	}
+	switch led := cfg.StatusLED; led.Type {
+	case statusLEDGPIO:
+		if led.Enabled && led.RedPin <= 0 && led.GreenPin <= 0 && led.BluePin <= 0 {
+			add("status_led", "no pins set for the LED")
+		}
+	case statusLEDNeoPixel:
+		if led.Brightness > 100 {
+			add("status_led.brightness", "brightness %d is over 100 percent", led.Brightness)
+		}
+		// spidev writes 4096 bytes at most, 9 per LED
+		if led.Count > maxNeoPixels {
+			add("status_led.count", "%d LEDs are more than the %d a write can drive", led.Count, maxNeoPixels)
+		}
+	default:
+		add("status_led.type", "unknown type %q, expected gpio or neopixel", led.Type)
+	}
+	for i, kind := range cfg.Lights.Kinds {
+		if !slices.Contains(announceKinds, kind) {
+			add(fmt.Sprintf("lights.kinds.%d", i), "unknown announcement kind %q, expected %s", kind, strings.Join(announceKinds, ", "))
+		}
+	}
+	if cfg.Lights.Hue.Bridge != "" && len(cfg.Lights.Hue.Lights)+len(cfg.Lights.Hue.Groups) == 0 {
+		add("lights.hue", "no lights or groups to flash")
+	}
+	for i, strip := range cfg.Lights.WLED {
+		if u, err := url.Parse(strip); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(fmt.Sprintf("lights.wled.%d", i), "%q is not an http or https URL", strip)
+		}
+	}
+	names = map[string]bool{}
+	for i, chat := range cfg.Chats {
+		path := fmt.Sprintf("chats.%d", i)
+		if chat.Name == "" || names[chat.Name] {
+			add(path+".name", "every chat needs a name of its own")
+		}
+		names[chat.Name] = true
+		if !slices.Contains(chatTypes, chat.Type) {
+			add(path+".type", "unknown chat type %q, expected %s", chat.Type, strings.Join(chatTypes, ", "))
+		}
+		for j, kind := range chat.Kinds {
+			if !slices.Contains(announceKinds, kind) {
+				add(fmt.Sprintf("%s.kinds.%d", path, j), "unknown announcement kind %q, expected %s", kind, strings.Join(announceKinds, ", "))
+			}
+		}
+	}
+	for i, to := range cfg.Email.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			add(fmt.Sprintf("email.to.%d", i), "%q is not an email address", to)
+		}
+	}
+	for i, trigger := range cfg.Email.Triggers {
+		if !slices.Contains(emailTriggers, trigger) {
+			add(fmt.Sprintf("email.triggers.%d", i), "unknown trigger %q, expected %s", trigger, strings.Join(emailTriggers, ", "))
+		}
+	}
+	if cfg.Ntfy.Enabled && cfg.Ntfy.Topic == "" {
+		add("ntfy.topic", "a topic is needed to publish to")
+	}
+	if u, err := url.Parse(cfg.Ntfy.Server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("ntfy.server", "%q is not an http or https URL", cfg.Ntfy.Server)
+	}
+	for kind, priority := range cfg.Ntfy.Priorities {
+		if !slices.Contains(ntfyPriorities, priority) {
+			add("ntfy.priorities."+kind, "unknown priority %q, expected %s", priority, strings.Join(ntfyPriorities, ", "))
+		}
+	}
+	for kind, priority := range cfg.Pushover.Priorities {
+		if priority < -2 || priority > 2 {
+			add("pushover.priorities."+kind, "priority %d is not between -2 and 2", priority)
+		}
+	}
+	for _, mirror := range []struct {
+		path  string
+		kinds []string
+		by    []string
+	}{
+		{"ntfy", cfg.Ntfy.Kinds, slices.Collect(maps.Keys(cfg.Ntfy.Priorities))},
+		{"pushover", cfg.Pushover.Kinds, slices.Collect(maps.Keys(cfg.Pushover.Priorities))},
+	} {
+		for i, kind := range mirror.kinds {
+			if !slices.Contains(announceKinds, kind) {
+				add(fmt.Sprintf("%s.kinds.%d", mirror.path, i), "unknown announcement kind %q, expected %s", kind, strings.Join(announceKinds, ", "))
+			}
+		}
+		for _, kind := range mirror.by {
+			if !slices.Contains(announceKinds, kind) {
+				add(mirror.path+".priorities."+kind, "unknown announcement kind %q, expected %s", kind, strings.Join(announceKinds, ", "))
+			}
+		}
+	}
+	if strings.ContainsAny(cfg.WebServer.BasePath, "?#") {
+		add("web_server.base_path", "%q is not a path, like /reminder", cfg.WebServer.BasePath)
+	}
+
+	clocks := map[string]string{
+		"all_day_events.morning_time": cfg.AllDayEvents.MorningTime,
+		"review_config.time":          cfg.ReviewConfig.Time,
+		"recap_config.time":           cfg.RecapConfig.Time,
+	}
+	for i, w := range cfg.Volume.Windows {
+		clocks[fmt.Sprintf("volume.windows.%d.from", i)] = w.From
+		clocks[fmt.Sprintf("volume.windows.%d.to", i)] = w.To
+	}
+	for path, clock := range clocks {
+		if _, err := time.Parse("15:04", clock); clock != "" && err != nil {
+			add(path, "%q is not a time of day, like 08:00 or 21:30", clock)
+		}
+	}
+
+	modes := map[string]string{
+		"event_status.tentative_mode":   cfg.EventStatus.TentativeMode,
+		"event_status.transparent_mode": cfg.EventStatus.TransparentMode,
+	}
+	for i, rule := range cfg.EventRules {
+		prefix := fmt.Sprintf("event_rules.%d.", i)
+		modes[prefix+"mode"] = rule.Mode
+		if rule.Priority != "" {
+			if _, ok := validPriority(rule.Priority); !ok {
+				add(prefix+"priority", "unknown priority %q, expected high, normal or low", rule.Priority)
+			}
+		}
+		if _, err := regexp.Compile(rule.TitleRegex); err != nil {
+			add(prefix+"title_regex", "invalid regular expression: %v", err)
+		}
+		if len(rule.Categories) == 0 && rule.TitleRegex == "" && rule.Calendar == "" && !rule.Holiday {
+			problems = append(problems, ConfigProblem{Path: prefix + "name", Message: "the rule has no conditions, it is ignored", Warning: true})
+		}
+	}
+	for group, list := range map[string]map[string]ReminderProfile{
+		"priority_profiles": cfg.PriorityProfiles,
+		"calendar_profiles": cfg.CalendarProfiles,
+	} {
+		for name, p := range list {
+			modes[group+"."+name+".mode"] = p.Mode
+		}
+	}
+	for path, mode := range modes {
+		switch strings.ToLower(mode) {
+		case "", announceModeNormal, announceModeOnce, announceModeSkip:
+		default:
+			add(path, "unknown mode %q, expected normal, once or skip", mode)
+		}
+	}
+	switch cfg.AllDayEvents.Mode {
+	case allDayModeMorning, announceModeOnce, announceModeSkip:
+	default:
+		add("all_day_events.mode", "unknown mode %q, expected morning, once or skip", cfg.AllDayEvents.Mode)
+	}
+
+	for i, step := range cfg.Escalation.Steps {
+		if step.After <= 0 {
+			add(fmt.Sprintf("escalation.steps.%d.after", i), "needs at least one unanswered reminder")
+		}
+	}
+	for i, sink := range cfg.Sinks {
+		prefix := fmt.Sprintf("sinks.%d.", i)
+		switch strings.ToLower(sink.Type) {
+		case sinkLocal:
+		case sinkBluetooth, netSpeakerChromecast, netSpeakerSonos:
+			if sink.Address == "" {
+				add(prefix+"address", "a %s sink needs an address", sink.Type)
+			}
+		default:
+			add(prefix+"type", "unknown type %q, expected local, bluetooth, chromecast or sonos", sink.Type)
+		}
+	}
+	if cfg.WebServer.TLS.ACME.Enabled && len(cfg.WebServer.TLS.ACME.Domains) == 0 {
+		add("web_server.tls.acme.domains", "needs the domains to get certificates for")
+	}
+	if (cfg.WebServer.TLS.Cert == "") != (cfg.WebServer.TLS.Key == "") {
+		add("web_server.tls", "needs both cert and key")
+	}
+	return problems
+}
+
+// validateCalendarServers checks that the CalDAV servers of the secrets
+// answer, which they do even without credentials.
+func validateCalendarServers() []ConfigProblem {
+	type server struct {
+		name, url, username string
+	}
+	servers := []server{}
+	if SysSecrets.IcloudConfig.Username != "" {
+		servers = append(servers, server{"icloud", SysSecrets.IcloudConfig.CalDAVBaseUrl, SysSecrets.IcloudConfig.Username})
+	}
+	for i, cfg := range SysSecrets.CalendarSources {
+		switch strings.ToLower(cfg.Type) {
+		case "", "caldav":
+			name := cfg.Name
+			if name == "" {
+				name = fmt.Sprintf("source-%d", i+1)
+			}
+			servers = append(servers, server{name, cfg.URL, cfg.Username})
+		}
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	problems := []ConfigProblem{}
+	client := &http.Client{Timeout: discoveryTimeout}
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s server) {
+			defer wg.Done()
+			err := checkCalendarServer(client, s.url, s.username)
+			if err == nil {
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			problems = append(problems, ConfigProblem{
+				Message: fmt.Sprintf("the calendar server of %s can't be reached: %v", s.name, err),
+				Warning: true,
+			})
+		}(s)
+	}
+	wg.Wait()
+	return problems
+}
+
+func checkCalendarServer(client *http.Client, rawURL, username string) error {
+	url, err := discoverCalDAVURL(rawURL, username)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodOptions, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files whose versions are kept
+const (
+	versionConfig  = "config"
+	versionSecrets = "secrets"
+)
+
+const (
+	// maxConfigVersions is how many versions of each file are kept.
+	maxConfigVersions = 50
+	// diffContext is how many unchanged lines are shown around the changes.
+	diffContext         = 3
+	configVersionsIndex = "versions.json"
+)
+
+// ConfigVersion is a saved version of the config or the secrets file.
+type ConfigVersion struct {
+	ID   string    `json:"id"`
+	File string    `json:"file"` // config or secrets
+	Time time.Time `json:"time"`
+	User string    `json:"user"`           // Who saved it, empty for the file as it was before the first save
+	Note string    `json:"note,omitempty"` // e.g. "Rolled back to ..."
+}
+
+// DiffLine is a line of a diff between two versions.
+type DiffLine struct {
+	Op   string `json:"op"` // " " unchanged, "-" removed, "+" added, "@" unchanged lines left out
+	Text string `json:"text"`
+}
+
+var configVersions sync.Mutex
+
+func readConfigVersions() ([]ConfigVersion, error) {
+	versions := []ConfigVersion{}
+	data, err := os.ReadFile(filepath.Join(realPath(configVersionsPath), configVersionsIndex))
+	if os.IsNotExist(err) {
+		return versions, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config versions: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("failed to parse config versions: %v", err)
+	}
+	return versions, nil
+}
+
+func writeConfigVersions(versions []ConfigVersion) error {
+	data, err := json.MarshalIndent(versions, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config versions: %v", err)
+	}
+	return writeFileAtomically(filepath.Join(realPath(configVersionsPath), configVersionsIndex), data, 0600)
+}
+
+// versionFilePath returns where the content of a version is kept.
+func versionFilePath(v ConfigVersion) string {
+	return filepath.Join(realPath(configVersionsPath), v.ID+".yml")
+}
+
+// loadConfigVersions returns the versions of the file, the newest first.
+func loadConfigVersions(file string) ([]ConfigVersion, error) {
+	configVersions.Lock()
+	defer configVersions.Unlock()
+
+	versions, err := readConfigVersions()
+	if err != nil {
+		return nil, err
+	}
+	list := []ConfigVersion{}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].File == file {
+			list = append(list, versions[i])
+		}
+	}
+	return list, nil
+}
+
+// recordConfigVersion keeps the content just saved to the file. The first
+// time, the content it had before is kept too, so it can be rolled back to.
+func recordConfigVersion(file string, previous, data []byte, user, note string) error {
+	configVersions.Lock()
+	defer configVersions.Unlock()
+
+	if err := os.MkdirAll(realPath(configVersionsPath), 0700); err != nil {
+		return fmt.Errorf("failed to create the config versions directory: %v", err)
+	}
+	versions, err := readConfigVersions()
+	if err != nil {
+		return err
+	}
+
+	add := func(at time.Time, content []byte, user, note string) error {
+		v := ConfigVersion{
+			ID:   file + "-" + at.UTC().Format("20060102-150405.000000000"),
+			File: file,
+			Time: at,
+			User: user,
+			Note: note,
+		}
+		if err := writeFileAtomically(versionFilePath(v), content, 0600); err != nil {
+			return fmt.Errorf("failed to save the config version: %v", err)
+		}
+		versions = append(versions, v)
+		return nil
+	}
+
+	count := 0
+	for _, v := range versions {
+		if v.File == file {
+			count++
+		}
+	}
+	now := time.Now()
+	if count == 0 && len(previous) > 0 {
+		if err := add(now.Add(-time.Nanosecond), previous, "", "Before the first save from the web interface"); err != nil {
+			return err
+		}
+	}
+	if err := add(now, data, user, note); err != nil {
+		return err
+	}
+
+	// drop the oldest versions of the file
+	kept := []ConfigVersion{}
+	extra := 0
+	for _, v := range versions {
+		if v.File == file {
+			extra++
+		}
+	}
+	extra -= maxConfigVersions
+	for _, v := range versions {
+		if v.File == file && extra > 0 {
+			extra--
+			if err := os.Remove(versionFilePath(v)); err != nil && !os.IsNotExist(err) {
+				logWarn("Failed to remove the config version %s: %v", v.ID, err)
+			}
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return writeConfigVersions(kept)
+}
+
+// configVersion returns a version with its content, and the content of the
+// version before it, empty for the first one.
+func configVersion(id string) (ConfigVersion, []byte, []byte, error) {
+	configVersions.Lock()
+	defer configVersions.Unlock()
+
+	versions, err := readConfigVersions()
+	if err != nil {
+		return ConfigVersion{}, nil, nil, err
+	}
+	for i, v := range versions {
+		if v.ID != id {
+			continue
+		}
+		data, err := os.ReadFile(versionFilePath(v))
+		if err != nil {
+			return ConfigVersion{}, nil, nil, fmt.Errorf("failed to read the config version: %v", err)
+		}
+		for j := i - 1; j >= 0; j-- {
+			if versions[j].File != v.File {
+				continue
+			}
+			before, err := os.ReadFile(versionFilePath(versions[j]))
+			if err != nil {
+				return ConfigVersion{}, nil, nil, fmt.Errorf("failed to read the config version: %v", err)
+			}
+			return v, data, before, nil
+		}
+		return v, data, nil, nil
+	}
+	return ConfigVersion{}, nil, nil, fmt.Errorf("version %s not found", id)
+}
+
+// diffLines returns the changes from a to b, with diffContext unchanged
+// lines around them.
+func diffLines(a, b string) []DiffLine {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// longest common subsequence, the files are small
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	all := []DiffLine{}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			all = append(all, DiffLine{Op: " ", Text: x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			all = append(all, DiffLine{Op: "-", Text: x[i]})
+			i++
+		default:
+			all = append(all, DiffLine{Op: "+", Text: y[j]})
+			j++
+		}
+	}
+
+	// only the changes and their context
+	near := make([]bool, len(all))
+	for n, line := range all {
+		if line.Op == " " {
+			continue
+		}
+		for k := max(n-diffContext, 0); k <= min(n+diffContext, len(all)-1); k++ {
+			near[k] = true
+		}
+	}
+	diff := []DiffLine{}
+	skipped := 0
+	for n, line := range all {
+		if !near[n] {
+			skipped++
+			continue
+		}
+		if skipped > 0 {
+			diff = append(diff, DiffLine{Op: "@", Text: fmt.Sprintf("%d unchanged lines", skipped)})
+			skipped = 0
+		}
+		diff = append(diff, line)
+	}
+	if skipped > 0 && len(diff) > 0 {
+		diff = append(diff, DiffLine{Op: "@", Text: fmt.Sprintf("%d unchanged lines", skipped)})
+	}
+	return diff
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discoveryTimeout bounds every DNS and HTTP request made during discovery.
+const discoveryTimeout = 15 * time.Second
+
+// discoverCalDAVURL finds the CalDAV context path of a server as described
+// in RFC 6764. The configured URL is used as is if it already has a path,
+// otherwise SRV records and the .well-known/caldav redirect of the host are
+// looked up. The host is taken from the username if no URL is configured.
+func discoverCalDAVURL(rawURL, username string) (string, error) {
+	if rawURL == "" {
+		at := strings.LastIndex(username, "@")
+		if at < 0 {
+			return "", fmt.Errorf("no URL configured and no domain in username %q", username)
+		}
+		rawURL = username[at+1:]
+	}
+
+	// a bare hostname, possibly with a port
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", rawURL, err)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return u.String(), nil
+	}
+
+	// SRV records point to the actual server, a TXT record may carry the path
+	base := u
+	if u.Scheme == "https" && u.Port() == "" {
+		if srv, err := lookupCalDAVSRV(u.Hostname()); err == nil {
+			base = srv
+		} else {
+			logDebug("No CalDAV SRV record for %s: %v", u.Hostname(), err)
+		}
+	}
+	if base.Path != "" && base.Path != "/" {
+		return base.String(), nil
+	}
+
+	contextURL, err := followWellKnown(base)
+	if err != nil {
+		// servers without the well-known redirect are assumed to serve at the root
+		logDebug("CalDAV well-known lookup on %s failed: %v", base.Host, err)
+		base.Path = "/"
+		return base.String(), nil
+	}
+
+	return contextURL, nil
+}
+
+// lookupCalDAVSRV returns the server announced by the _caldavs._tcp SRV
+// record of the domain, with the path from its TXT record if there is one.
+func lookupCalDAVSRV(domain string) (*url.URL, error) {
+	resolver := &net.Resolver{}
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	_, addrs, err := resolver.LookupSRV(ctx, "caldavs", "tcp", domain)
+	if err != nil {
+		return nil, err
+	}
+	// a single "." target means the service is explicitly not available
+	if len(addrs) == 0 || addrs[0].Target == "." {
+		return nil, fmt.Errorf("service not available")
+	}
+
+	target := strings.TrimSuffix(addrs[0].Target, ".")
+	u := &url.URL{Scheme: "https", Host: target}
+	if addrs[0].Port != 443 {
+		u.Host = net.JoinHostPort(target, strconv.Itoa(int(addrs[0].Port)))
+	}
+
+	txts, err := resolver.LookupTXT(ctx, "_caldavs._tcp."+domain)
+	if err == nil {
+		for _, txt := range txts {
+			if path, ok := strings.CutPrefix(txt, "path="); ok {
+				u.Path = path
+			}
+		}
+	}
+
+	return u, nil
+}
+
+// followWellKnown returns the URL the .well-known/caldav path of the server
+// redirects to.
+func followWellKnown(base *url.URL) (string, error) {
+	wellKnown := *base
+	wellKnown.Path = "/.well-known/caldav"
+
+	client := &http.Client{Timeout: discoveryTimeout}
+	resp, err := client.Get(wellKnown.String())
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	// the client follows the redirects, the final request is the context path
+	final := resp.Request.URL
+	if final.Path == wellKnown.Path {
+		return "", fmt.Errorf("no redirect, status %s", resp.Status)
+	}
+
+	final.RawQuery = ""
+	final.Fragment = ""
+	return final.String(), nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Display types
+const (
+	displaySSD1306 = "ssd1306" // a 128 pixel wide OLED on the I2C bus
+)
+
+const (
+	displayRetry   = time.Minute
+	displayWidth   = 128
+	i2cSlaveIoctl  = 0x0703
+	ssd1306Command = 0x00 // the control byte before the commands
+	ssd1306Data    = 0x40 // and before the pixels
+)
+
+// displayRefresh asks the display to show the schedule again, e.g. after the
+// scheduler ran.
+var displayRefresh = make(chan struct{}, 1)
+
+// displayPanel is the screen the schedule is shown on, the pixels are in
+// pages of 8 rows, a byte for each column of the page with the top pixel in
+// the low bit.
+type displayPanel interface {
+	show(pixels []byte) error
+	Close() error
+}
+
+// refreshDisplay shows the schedule again on the display, without waiting.
+func refreshDisplay() {
+	select {
+	case displayRefresh <- struct{}{}:
+	default:
+	}
+}
+
+// runDisplay shows the next event, how long until it starts and whether the
+// calendars are synced on a small screen, so the schedule can be checked
+// without asking for it.
+func runDisplay() {
+	warned := false
+	for {
+		cfg := SysConfig.Display
+		if !cfg.Enabled {
+			time.Sleep(displayRetry)
+			continue
+		}
+		if err := showSchedule(cfg); err != nil {
+			if !warned {
+				logError("Failed to drive the display, retrying every %s: %v", displayRetry, err)
+				warned = true
+			}
+			time.Sleep(displayRetry)
+			continue
+		}
+		warned = false
+	}
+}
+
+// showSchedule keeps the display up to date until the config changes.
+func showSchedule(cfg DisplayConfig) error {
+	panel, err := openDisplay(cfg)
+	if err != nil {
+		return err
+	}
+	defer panel.Close()
+	logInfo("Showing the schedule on the %s display", cfg.Type)
+
+	// the config is checked in between the minutes too
+	check := time.NewTicker(configCheckInterval)
+	defer check.Stop()
+	var shown []byte
+	for {
+		if SysConfig.Display != cfg {
+			logInfo("Display config changed, opening it again")
+			return nil
+		}
+
+		pixels := renderLines(scheduleLines(cfg.Height/8), cfg.Height)
+		if !bytes.Equal(pixels, shown) {
+			if err := panel.show(pixels); err != nil {
+				return err
+			}
+			shown = pixels
+		}
+
+		// the time left changes on the minute
+		now := clockNow()
+		minute := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-minute.C:
+		case <-check.C:
+		case <-displayRefresh:
+		}
+		minute.Stop()
+	}
+}
+
+// scheduleLines returns the text shown on a display of that many lines: the
+// next event, its time, the time left and the calendar sync.
+func scheduleLines(rows int) []string {
+	cols := displayWidth / fontWidth
+	now := clockNow()
+	status := fitLine(now.Format("15:04")+"  "+syncStatus(), cols)
+
+	events, err := loadTodayEvents()
+	if err != nil {
+		logError("failed to load events for the display: %v", err)
+		return []string{status, "No schedule"}
+	}
+	var next *LocalEvent
+	for i := range events {
+		e := &events[i]
+		if e.Completed || announceMode(e) == announceModeSkip || e.Event.AllDay {
+			continue
+		}
+		if e.Event.StartTime.After(now) || e.scheduledForNow() {
+			next = e
+			break
+		}
+	}
+	if next == nil {
+		return []string{status, "Nothing else today"}
+	}
+
+	var when, left string
+	if next.scheduledForNow() {
+		when = "Now, until " + next.Event.EndTime.Format("15:04")
+		left = formatTimeLeft(next.Event.EndTime.Sub(now)) + " left"
+	} else {
+		when = "At " + next.Event.StartTime.Format("15:04")
+		left = "In " + formatTimeLeft(next.Event.StartTime.Sub(now))
+	}
+
+	// the title gets the lines the others leave
+	title := wrapLine(next.spokenTitle(), cols, max(rows-3, 1))
+	lines := append([]string{status}, title...)
+	return append(lines, fitLine(when, cols), fitLine(left, cols))
+}
+
+// syncStatus returns when the calendars were last synced, or that one of
+// them failed.
+func syncStatus() string {
+	healthMutex.Lock()
+	syncs := make(map[string]syncLog, len(calendarSyncs))
+	for name, s := range calendarSyncs {
+		syncs[name] = s
+	}
+	healthMutex.Unlock()
+
+	var last time.Time
+	for _, c := range calendarHealth(syncs) {
+		if !c.Healthy {
+			return "Sync failed!"
+		}
+		if c.LastSuccess.After(last) {
+			last = c.LastSuccess
+		}
+	}
+	if last.IsZero() {
+		return "Not synced"
+	}
+	return "Synced " + last.Format("15:04")
+}
+
+// formatTimeLeft returns the duration in hours and minutes, e.g. "1h 05m",
+// rounded up as it is counted down.
+func formatTimeLeft(d time.Duration) string {
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	if minutes < 60 {
+		return fmt.Sprintf("%d min", minutes)
+	}
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
+
+// wrapLine splits the text at the spaces into at most rows lines of cols
+// characters, the last one cut short if it doesn't fit.
+func wrapLine(text string, cols, rows int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= cols:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	lines = append(lines, line)
+	if len(lines) > rows {
+		lines[rows-1] = strings.Join(lines[rows-1:], " ") + "..."
+		lines = lines[:rows]
+	}
+	for i := range lines {
+		lines[i] = fitLine(lines[i], cols)
+	}
+	return lines
+}
+
+// fitLine cuts the line to cols characters, with dots where it is cut.
+func fitLine(line string, cols int) string {
+	r := []rune(line)
+	if len(r) <= cols {
+		return line
+	}
+	return string(r[:cols-2]) + ".."
+}
+
+// renderLines draws the lines of text, one per page, the characters missing
+// from the font as question marks.
+func renderLines(lines []string, height int) []byte {
+	pixels := make([]byte, displayWidth*height/8)
+	for row, line := range lines {
+		if row >= height/8 {
+			break
+		}
+		x := row * displayWidth
+		for _, c := range line {
+			if x+fontWidth > (row+1)*displayWidth {
+				break
+			}
+			if c < fontFirst || c > fontLast {
+				c = '?'
+			}
+			copy(pixels[x:], font5x7[c-fontFirst][:])
+			x += fontWidth
+		}
+	}
+	return pixels
+}
+
+func openDisplay(cfg DisplayConfig) (displayPanel, error) {
+	switch cfg.Type {
+	case displaySSD1306:
+		return openSSD1306(cfg)
+	}
+	return nil, fmt.Errorf("unknown display type %q", cfg.Type)
+}
+
+// ssd1306 is an SSD1306 OLED on the I2C bus, 128 pixels wide and 32 or 64
+// high.
+type ssd1306 struct {
+	file  *os.File
+	pages int
+}
+
+func openSSD1306(cfg DisplayConfig) (*ssd1306, error) {
+	f, err := openI2C(cfg.Bus, cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &ssd1306{file: f, pages: cfg.Height / 8}
+	// the columns and the rows are scanned backwards to turn it around
+	segmentRemap, comScan := byte(0xa1), byte(0xc8)
+	if cfg.Rotate {
+		segmentRemap, comScan = 0xa0, 0xc0
+	}
+	comPins := byte(0x12)
+	if cfg.Height == 32 {
+		comPins = 0x02
+	}
+	err = d.command(
+		0xae,       // off
+		0xd5, 0x80, // clock
+		0xa8, byte(cfg.Height-1), // multiplex
+		0xd3, 0x00, // no offset
+		0x40,       // start line 0
+		0x8d, 0x14, // charge pump on
+		0x20, 0x00, // horizontal addressing
+		segmentRemap, comScan,
+		0xda, comPins,
+		0x81, 0xcf, // contrast
+		0xd9, 0xf1, // pre-charge
+		0xdb, 0x40, // vcomh
+		0xa4, // show the RAM
+		0xa6, // not inverted
+		0xaf, // on
+	)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to set up the display: %v", err)
+	}
+	return d, nil
+}
+
+func (d *ssd1306) show(pixels []byte) error {
+	if err := d.command(0x21, 0, displayWidth-1, 0x22, 0, byte(d.pages-1)); err != nil {
+		return err
+	}
+	_, err := d.file.Write(append([]byte{ssd1306Data}, pixels...))
+	return err
+}
+
+func (d *ssd1306) command(cmds ...byte) error {
+	_, err := d.file.Write(append([]byte{ssd1306Command}, cmds...))
+	return err
+}
+
+// Close turns the display off, an OLED left on burns in.
+func (d *ssd1306) Close() error {
+	d.command(0xae)
+	return d.file.Close()
+}
+
+// openI2C opens the I2C bus to talk to the device at that address.
+func openI2C(bus string, address int) (*os.File, error) {
+	f, err := os.OpenFile(bus, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlaveIoctl, uintptr(address)); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("failed to address 0x%02x on %s: %v", address, bus, errno)
+	}
+	return f, nil
+}
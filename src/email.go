@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email triggers
+const (
+	emailUnacknowledged = "unacknowledged" // an event ended without being acknowledged
+	emailRecap          = "recap"          // how the day went, at the recap time
+	emailPanic          = "panic"          // the emergency button
+)
+
+// emailTriggers are the triggers email.triggers can have.
+var emailTriggers = []string{emailUnacknowledged, emailRecap, emailPanic}
+
+// SMTP connection security
+const (
+	smtpStartTLS = "starttls"
+	smtpTLS      = "tls"  // TLS from the start, usually port 465
+	smtpNone     = "none" // only for a relay on the local network
+)
+
+const smtpTimeout = 30 * time.Second
+
+// emailWanted returns true if the emails of the trigger are enabled.
+func emailWanted(trigger string) bool {
+	cfg := SysConfig.Email
+	if simulating || SysConfig.DryRun || !cfg.Enabled || len(cfg.To) == 0 || SysSecrets.SMTP.Host == "" {
+		return false
+	}
+	return len(cfg.Triggers) == 0 || slices.Contains(cfg.Triggers, trigger)
+}
+
+// emailUnacknowledgedEvent tells that the event ended while its reminders
+// were never acknowledged.
+func emailUnacknowledgedEvent(e *LocalEvent) {
+	if !emailWanted(emailUnacknowledged) {
+		return
+	}
+	title := e.spokenTitle()
+	body := fmt.Sprintf("%s, from %s to %s, was announced but never acknowledged",
+		title, e.Event.StartTime.Format("15:04"), e.Event.EndTime.Format("15:04"))
+	if e.RemindersSent > 0 {
+		body += fmt.Sprintf(", after %d reminders", e.RemindersSent)
+	}
+	body += ".\n"
+	sendEmailAsync("Never acknowledged: "+title, body)
+}
+
+// emailRecapOf sends how the day went.
+func emailRecapOf(recap Recap) {
+	if !emailWanted(emailRecap) {
+		return
+	}
+	sendEmailAsync(recap.summary(), recap.text())
+}
+
+// emailPanicAlert tells about the emergency, where the device is.
+func emailPanicAlert(source string) {
+	if !emailWanted(emailPanic) {
+		return
+	}
+	cfg := SysConfig.PanicConfig
+	body := fmt.Sprintf("The emergency announcement was triggered by %s at %s.\n\nDevice: %s\nLocation: %s\n",
+		source, time.Now().Format("15:04 on Monday, January 2"), cfg.DeviceName, cfg.Location)
+	sendEmailAsync("Emergency on "+cfg.DeviceName, body)
+}
+
+// sendEmailAsync sends the email to email.to in the background, retried and
+// counted like the notification channels.
+func sendEmailAsync(subject, body string) {
+	deliverAsync(emailChannel, func() error {
+		return sendEmail(SysConfig.Email.To, subject, body)
+	}, func(err error) {
+		if err != nil {
+			logError("Failed to send the email %q: %v", subject, err)
+			return
+		}
+		logInfo("Email %q sent to %s", subject, strings.Join(SysConfig.Email.To, ", "))
+	})
+}
+
+// sendEmail sends a plain text email through the SMTP server of the
+// secrets.
+func sendEmail(to []string, subject, body string) error {
+	cfg := SysSecrets.SMTP
+	from := cfg.From
+	if from == "" {
+		from = cfg.Username
+	}
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("invalid smtp.from %q: %v", from, err)
+	}
+	recipients := make([]string, 0, len(to))
+	for _, t := range to {
+		address, err := mail.ParseAddress(t)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %v", t, err)
+		}
+		recipients = append(recipients, address.Address)
+	}
+
+	message, err := emailMessage(fromAddress, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialSMTP(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("failed to log in: %v", err)
+		}
+	}
+	if err := client.Mail(fromAddress.Address); err != nil {
+		return fmt.Errorf("sender refused: %v", err)
+	}
+	for _, r := range recipients {
+		if err := client.Rcpt(r); err != nil {
+			return fmt.Errorf("recipient %s refused: %v", r, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send the message: %v", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("failed to send the message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send the message: %v", err)
+	}
+	return client.Quit()
+}
+
+// dialSMTP connects to the server, over TLS or upgraded with STARTTLS.
+func dialSMTP(cfg SMTPSecrets) (*smtp.Client, error) {
+	security := smtpSecurity(cfg)
+	if !slices.Contains([]string{smtpStartTLS, smtpTLS, smtpNone}, security) {
+		return nil, fmt.Errorf("unknown smtp.security %q, expected starttls, tls or none", cfg.Security)
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+		if security == smtpTLS {
+			port = 465
+		}
+	}
+	address := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	if security == smtpTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to talk to %s: %v", address, err)
+	}
+	hostname, _ := os.Hostname()
+	if hostname != "" {
+		if err := client.Hello(hostname); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to greet %s: %v", address, err)
+		}
+	}
+	if security == smtpStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to start TLS with %s: %v", address, err)
+		}
+	}
+	return client, nil
+}
+
+// smtpSecurity returns how the connection is secured, TLS from the start
+// on port 465 unless told otherwise.
+func smtpSecurity(cfg SMTPSecrets) string {
+	if cfg.Security != "" {
+		return strings.ToLower(cfg.Security)
+	}
+	if cfg.Port == 465 {
+		return smtpTLS
+	}
+	return smtpStartTLS
+}
+
+// emailMessage returns the message with its headers, the text encoded as
+// quoted-printable.
+func emailMessage(from *mail.Address, to []string, subject, body string) ([]byte, error) {
+	var buf bytes.Buffer
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%d.reminder@%s>", time.Now().UnixNano(), emailDomain(from.Address)),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// emailDomain returns the domain of the address, for the message IDs.
+func emailDomain(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
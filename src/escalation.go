@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// compileEscalation prepares the escalation ladder, the steps are sorted by
+// the number of unanswered reminders they need.
+func compileEscalation() {
+	steps := []EscalationStep{}
+	for _, step := range SysConfig.Escalation.Steps {
+		if step.After <= 0 {
+			logError("escalation step needs at least one unanswered reminder, ignoring it")
+			continue
+		}
+		steps = append(steps, step)
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].After < steps[j].After })
+	SysConfig.Escalation.Steps = steps
+}
+
+// escalationStep returns the escalation step the event has reached, and its
+// level, counted from 1.
+func escalationStep(e *LocalEvent) (EscalationStep, int) {
+	enabled := SysConfig.Escalation.Enabled || reminderProfile(&e.Event).Escalation
+	if !enabled || e.Acknowledged {
+		return EscalationStep{}, 0
+	}
+
+	level := 0
+	for i, step := range SysConfig.Escalation.Steps {
+		if e.RemindersSent >= step.After {
+			level = i + 1
+		}
+	}
+	if level == 0 {
+		return EscalationStep{}, 0
+	}
+	return SysConfig.Escalation.Steps[level-1], level
+}
+
+// escalatedInterval returns the reminder interval, shortened by the
+// escalation step the event has reached.
+func escalatedInterval(e *LocalEvent, interval time.Duration) time.Duration {
+	step, level := escalationStep(e)
+	if level > 0 && step.Interval > 0 && step.Interval < interval {
+		return step.Interval
+	}
+	return interval
+}
+
+// setReminderSent records a periodic reminder, every one of them is
+// unanswered since acknowledged events are not reminded.
+func (e *LocalEvent) setReminderSent() error {
+	now := clockNow()
+	return e.update(func(l *LocalEvent) {
+		l.LastTimeReminded = now
+		l.RemindersSent++
+	})
+}
+
+// escalate raises the alert of the escalation step the event reached, once per step.
+func escalate(e *LocalEvent) {
+	step, level := escalationStep(e)
+	if level == 0 || !step.Notify || e.EscalationNotified >= level {
+		return
+	}
+
+	if err := e.update(func(l *LocalEvent) { l.EscalationNotified = level }); err != nil {
+		logError("failed to save escalation of event %s: %v", e.Event.ID, err)
+	}
+	notifyEscalation(e, level)
+}
+
+// notifyEscalation reports an event that keeps being ignored.
+func notifyEscalation(e *LocalEvent, level int) {
+	WithFields(map[string]interface{}{
+		"device":    SysConfig.PanicConfig.DeviceName,
+		"event":     e.Event.Description,
+		"level":     level,
+		"reminders": e.RemindersSent,
+		"time":      clockNow().Format(time.RFC3339),
+	}).Warn("Event not acknowledged, escalating")
+}
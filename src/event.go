@@ -1,23 +1,58 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// eventsQuarantineDir is where unreadable event files are moved, inside the events path.
+const eventsQuarantineDir = "quarantine"
+
 var syncEvent sync.Mutex
 
+// The local events are kept in memory and only written to disk when they
+// change, to spare the SD card. The disk copy is read once, at startup.
+var (
+	eventCache       = make(map[string]cachedEvent)
+	eventCacheLoaded bool
+)
+
+// cachedEvent is an event along with its content as last written to disk.
+type cachedEvent struct {
+	event LocalEvent
+	data  []byte
+}
+
 type LocalEvent struct {
-	Event               CalendarEvent
-	StartAnnounced      bool
-	CheckStartAnnounced bool
-	EndAnnounced        bool
-	LastTimeReminded    time.Time
+	SchemaVersion      int
+	Event              CalendarEvent
+	StartAnnounced     bool
+	CheckStartsSent    int
+	EndAnnounced       bool
+	LastTimeReminded   time.Time
+	RemindersSent      int
+	EscalationNotified int
+	ReviewAnswer       string
+	ReviewedAt         time.Time
+	AlarmsAnnounced    []time.Time
+	PreStartAnnounced  []time.Time
+	Completed          bool
+	CompletedAt        time.Time
+	Acknowledged       bool
+	AcknowledgedAt     time.Time
+	SnoozedUntil       time.Time
+	StartAnswer        string
+	StartAnsweredAt    time.Time
+	CaregiversTexted   bool
+	DoseRecorded       bool
+	HabitRecorded      bool
 }
 
 // saveEventLocally saves the event to the local storage.
@@ -25,75 +60,228 @@ func saveEventLocally(event CalendarEvent) error {
 	syncEvent.Lock()
 	defer syncEvent.Unlock()
 
-	eventPath := realPath(SysConfig.EventsPath)
-	if _, err := os.Stat(eventPath); os.IsNotExist(err) {
-		os.MkdirAll(eventPath, 0755)
+	if err := loadEventCache(); err != nil {
+		return err
 	}
 
-	e := &LocalEvent{
+	e := LocalEvent{
 		Event: event,
 	}
 
 	// if event exist, set some properties from the existing event
-	existingEvent, err := loadEvent(e.Event.ID + ".json")
-	if err == nil {
+	if cached, ok := eventCache[event.ID]; ok {
+		existingEvent := cached.event
 		e.StartAnnounced = existingEvent.StartAnnounced
-		e.CheckStartAnnounced = existingEvent.CheckStartAnnounced
+		e.CheckStartsSent = existingEvent.CheckStartsSent
 		e.EndAnnounced = existingEvent.EndAnnounced
 		e.LastTimeReminded = existingEvent.LastTimeReminded
+		e.RemindersSent = existingEvent.RemindersSent
+		e.EscalationNotified = existingEvent.EscalationNotified
+		e.ReviewAnswer = existingEvent.ReviewAnswer
+		e.ReviewedAt = existingEvent.ReviewedAt
+		e.AlarmsAnnounced = existingEvent.AlarmsAnnounced
+		e.PreStartAnnounced = existingEvent.PreStartAnnounced
+		e.Completed = existingEvent.Completed
+		e.CompletedAt = existingEvent.CompletedAt
+		e.Acknowledged = existingEvent.Acknowledged
+		e.AcknowledgedAt = existingEvent.AcknowledgedAt
+		e.SnoozedUntil = existingEvent.SnoozedUntil
+		e.StartAnswer = existingEvent.StartAnswer
+		e.StartAnsweredAt = existingEvent.StartAnsweredAt
+		e.CaregiversTexted = existingEvent.CaregiversTexted
+		e.DoseRecorded = existingEvent.DoseRecorded
+		e.HabitRecorded = existingEvent.HabitRecorded
+	}
+
+	return storeEvent(e)
+}
+
+// eventFileName returns the name of the file an event is stored in. The id
+// comes from the calendar, one that would name a file outside the events
+// path is refused.
+func eventFileName(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, "/\\\x00") || strings.Contains(id, "..") {
+		return "", fmt.Errorf("event id %q can't be used as a file name", id)
+	}
+	return id + ".json", nil
+}
+
+// storeEvent updates the cached event and writes it to disk, if it changed.
+// The caller must hold syncEvent.
+func storeEvent(e LocalEvent) error {
+	name, err := eventFileName(e.Event.ID)
+	if err != nil {
+		return err
 	}
 
+	e.SchemaVersion = eventSchemaVersion
 	eJson, err := json.MarshalIndent(e, "", " ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal event: %v", err)
 	}
 
-	filePath := path.Join(eventPath, e.Event.ID+".json")
-	return os.WriteFile(filePath, eJson, 0644)
+	if cached, ok := eventCache[e.Event.ID]; ok && bytes.Equal(cached.data, eJson) {
+		return nil
+	}
+
+	if simulating {
+		eventCache[e.Event.ID] = cachedEvent{event: e, data: eJson}
+		return nil
+	}
+
+	eventPath := realPath(SysConfig.EventsPath)
+	if _, err := os.Stat(eventPath); os.IsNotExist(err) {
+		os.MkdirAll(eventPath, 0755)
+	}
+
+	filePath := path.Join(eventPath, name)
+	if err := writeFileAtomically(filePath, eJson, 0644); err != nil {
+		return err
+	}
+
+	eventCache[e.Event.ID] = cachedEvent{event: e, data: eJson}
+	// new events or moved times change when the next announcement is due
+	reschedule()
+	return nil
 }
 
-func loadEvent(name string) (LocalEvent, error) {
-	var e LocalEvent
+// loadEventCache reads the local events from disk, once. The caller must
+// hold syncEvent.
+func loadEventCache() error {
+	if eventCacheLoaded {
+		return nil
+	}
+	// a simulation starts from an empty store
+	if simulating {
+		eventCacheLoaded = true
+		return nil
+	}
 
 	eventDir := realPath(SysConfig.EventsPath)
-	eJson, err := os.ReadFile(path.Join(eventDir, name))
-	if err != nil {
-		return LocalEvent{}, fmt.Errorf("failed to read event file %s: %v", name, err)
+	files, err := os.ReadDir(eventDir)
+	if os.IsNotExist(err) {
+		eventCacheLoaded = true
+		return nil
 	}
-	err = json.Unmarshal(eJson, &e)
 	if err != nil {
-		return LocalEvent{}, fmt.Errorf("failed to unmarshal event file %s: %v", name, err)
+		return fmt.Errorf("failed to read events directory: %v", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		// temporary files left behind by an interrupted write
+		if strings.Contains(file.Name(), ".json.tmp.") {
+			os.Remove(path.Join(eventDir, file.Name()))
+			continue
+		}
+
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		eJson, e, err := loadEvent(file.Name())
+		if err != nil {
+			// one broken file must not stop the reminders for all the others,
+			// the event is fetched again on the next refresh
+			logError("failed to load event %s, quarantining it: %v", file.Name(), err)
+			quarantineEventFile(eventDir, file.Name())
+			continue
+		}
+		eventCache[e.Event.ID] = cachedEvent{event: e, data: eJson}
 	}
 
-	return e, nil
+	logDebug("Loaded %d events from local storage", len(eventCache))
+	eventCacheLoaded = true
+	return nil
 }
 
-// loadTodayEvents loads today's events from the local storage.
-func loadTodayEvents() ([]LocalEvent, error) {
+// loadEventByID returns a single event from the local storage.
+func loadEventByID(id string) (LocalEvent, error) {
 	syncEvent.Lock()
 	defer syncEvent.Unlock()
 
+	if err := loadEventCache(); err != nil {
+		return LocalEvent{}, err
+	}
+
+	cached, ok := eventCache[id]
+	if !ok {
+		return LocalEvent{}, fmt.Errorf("event %q not found", id)
+	}
+	return cached.event, nil
+}
+
+// loadEvent reads a single event file from disk, migrating it to the
+// current schema version if it was written by an older build.
+func loadEvent(name string) ([]byte, LocalEvent, error) {
 	eventDir := realPath(SysConfig.EventsPath)
-	files, err := os.ReadDir(eventDir)
+	data, err := os.ReadFile(path.Join(eventDir, name))
+	if err != nil {
+		return nil, LocalEvent{}, fmt.Errorf("failed to read event file %s: %v", name, err)
+	}
+
+	eJson, e, migrated, err := decodeEvent(data)
+	if err != nil {
+		return nil, LocalEvent{}, fmt.Errorf("failed to decode event file %s: %v", name, err)
+	}
+	if _, err := eventFileName(e.Event.ID); err != nil {
+		return nil, LocalEvent{}, fmt.Errorf("invalid event file %s: %v", name, err)
+	}
+	if migrated {
+		logInfo("Migrated event %s to schema version %d", name, eventSchemaVersion)
+		if err := writeFileAtomically(path.Join(eventDir, name), eJson, 0644); err != nil {
+			// the migrated copy is in memory, the file is written again on the next change
+			logError("failed to write migrated event %s: %v", name, err)
+		}
+	}
+
+	return eJson, e, nil
+}
+
+// loadTodayEvents loads today's events that are not finished yet from the local storage.
+func loadTodayEvents() ([]LocalEvent, error) {
+	allEvents, err := loadAllTodayEvents()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read events directory: %v", err)
+		return nil, err
 	}
 
 	events := make([]LocalEvent, 0)
-	for _, file := range files {
-		e, err := loadEvent(file.Name())
-		if err != nil {
-			return nil, fmt.Errorf("failed to load event %s: %v", file.Name(), err)
+	for _, e := range allEvents {
+		// skip events that are already finished
+		if clockNow().After(e.Event.EndTime) {
+			continue
 		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// loadAllTodayEvents loads all of today's events from the local storage,
+// including the ones that are already finished.
+func loadAllTodayEvents() ([]LocalEvent, error) {
+	syncEvent.Lock()
+	defer syncEvent.Unlock()
 
-		// if event is not scheduled for today, or it's already finished
-		if !e.scheduledForToday() || time.Now().After(e.Event.EndTime) {
+	if err := loadEventCache(); err != nil {
+		return nil, err
+	}
+
+	events := make([]LocalEvent, 0)
+	for _, cached := range eventCache {
+		e := cached.event
+
+		// if event is not scheduled for today
+		if !e.scheduledForToday() {
 			continue
 		}
 
 		// fix the event time zone
 		if e.Event.TimeZone != "" {
-			loc, err := time.LoadLocation(e.Event.TimeZone)
+			loc, err := lookupTimeZone(e.Event.TimeZone)
 			if err != nil {
 				logError("failed to load timezone %s for event %s, using local timezone", e.Event.TimeZone, e.Event.ID)
 				loc = time.Local
@@ -101,27 +289,57 @@ func loadTodayEvents() ([]LocalEvent, error) {
 			e.Event.StartTime = e.Event.StartTime.In(loc)
 			e.Event.EndTime = e.Event.EndTime.In(loc)
 			e.LastTimeReminded = e.LastTimeReminded.In(loc)
-
-			if time.Now().After(e.Event.EndTime) {
-				continue // skip events that are already finished
-			}
 		}
 
 		events = append(events, e)
 	}
 
+	// announce in a stable order, earliest first
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Event.StartTime.Equal(events[j].Event.StartTime) {
+			return events[i].Event.StartTime.Before(events[j].Event.StartTime)
+		}
+		return events[i].Event.ID < events[j].Event.ID
+	})
+
 	return events, nil
 }
 
-// syncLocalEvents saves the events to the local storage.
-func syncLocalEvents(events []CalendarEvent) error {
+// quarantineEventFile moves an unreadable event file out of the way, it is
+// kept for inspection.
+func quarantineEventFile(eventDir, name string) {
+	quarantineDir := path.Join(eventDir, eventsQuarantineDir)
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		logError("failed to create quarantine directory: %v", err)
+		return
+	}
+
+	target := path.Join(quarantineDir, fmt.Sprintf("%s.%s", name, time.Now().Format("20060102T150405")))
+	if err := os.Rename(path.Join(eventDir, name), target); err != nil {
+		logError("failed to quarantine event file %s: %v", name, err)
+	}
+}
+
+// syncLocalEvents saves the events to the local storage. Local events that
+// are not in the calendar anymore are removed only if prune is set, which
+// it must not be if the events could not be fully fetched.
+func syncLocalEvents(events []CalendarEvent, prune bool) error {
 	for _, event := range events {
+		// one bad id must not stop the sync of the other events
+		if _, err := eventFileName(event.ID); err != nil {
+			logWarn("Skipping event %q: %v", event.Description, err)
+			continue
+		}
 		err := saveEventLocally(event)
 		if err != nil {
 			return err
 		}
 	}
 
+	if !prune {
+		return nil
+	}
+
 	// remove local events that are not in the calendar
 	err := removeLocalEventsNotInCalendar(events)
 	if err != nil {
@@ -135,69 +353,153 @@ func removeLocalEventsNotInCalendar(events []CalendarEvent) error {
 	syncEvent.Lock()
 	defer syncEvent.Unlock()
 
-	eventPath := realPath(SysConfig.EventsPath)
-	files, err := os.ReadDir(eventPath)
-	if err != nil {
-		return fmt.Errorf("failed to read events directory: %v", err)
+	inCalendar := make(map[string]bool, len(events))
+	for _, event := range events {
+		inCalendar[event.ID] = true
 	}
 
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			id := strings.TrimSuffix(file.Name(), ".json")
-			found := false
-			for _, event := range events {
-				if event.ID == id {
-					found = true
-					break
-				}
-			}
-			if !found {
-				err := os.Remove(path.Join(eventPath, file.Name()))
-				if err != nil {
-					return fmt.Errorf("failed to remove event %s: %v", id, err)
-				}
-			}
+	eventPath := realPath(SysConfig.EventsPath)
+	for id := range eventCache {
+		if inCalendar[id] {
+			continue
+		}
+
+		name, err := eventFileName(id)
+		if err != nil {
+			return err
 		}
+		err = os.Remove(path.Join(eventPath, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove event %s: %v", id, err)
+		}
+		delete(eventCache, id)
 	}
 	return nil
 }
 
 // setStartAnnounced sets the event start as announced.
 func (e *LocalEvent) setStartAnnounced() error {
-	e.StartAnnounced = true
-	return e.updateEvent()
+	return e.update(func(l *LocalEvent) { l.StartAnnounced = true })
 }
 
-// setStartChecked sets the event start checked.
-func (e *LocalEvent) setStartChecked() error {
-	e.CheckStartAnnounced = true
-	return e.updateEvent()
+// setStartChecked sets the given number of start follow-ups as sent.
+func (e *LocalEvent) setStartChecked(sent int) error {
+	return e.update(func(l *LocalEvent) { l.CheckStartsSent = sent })
 }
 
 // setEndAnnounced sets the event end as announced.
 func (e *LocalEvent) setEndAnnounced() error {
-	e.EndAnnounced = true
-	return e.updateEvent()
+	return e.update(func(l *LocalEvent) { l.EndAnnounced = true })
 }
 
 // setReminded sets the event as reminded.
 func (e *LocalEvent) setReminded() error {
-	e.LastTimeReminded = time.Now()
-	return e.updateEvent()
+	now := clockNow()
+	return e.update(func(l *LocalEvent) { l.LastTimeReminded = now })
+}
+
+// setAlarmAnnounced marks the calendar alarm as announced.
+func (e *LocalEvent) setAlarmAnnounced(alarm time.Time) error {
+	return e.update(func(l *LocalEvent) { l.AlarmsAnnounced = append(l.AlarmsAnnounced, alarm) })
+}
+
+// alarmAnnounced returns true if the calendar alarm was already announced.
+func (e *LocalEvent) alarmAnnounced(alarm time.Time) bool {
+	for _, a := range e.AlarmsAnnounced {
+		if a.Equal(alarm) {
+			return true
+		}
+	}
+	return false
+}
+
+// setAcknowledged marks the event as acknowledged, it is not reminded
+// anymore but its end is still announced.
+func (e *LocalEvent) setAcknowledged() error {
+	now := clockNow()
+	err := e.update(func(l *LocalEvent) {
+		l.Acknowledged = true
+		l.AcknowledgedAt = now
+	})
+	if err == nil {
+		eventWebhook(webhookAcknowledged, e)
+	}
+	return err
+}
+
+// setStartAnswer records the answer to the start check, a yes acknowledges
+// the event.
+func (e *LocalEvent) setStartAnswer(answer string) error {
+	now := clockNow()
+	acknowledged := e.Acknowledged
+	err := e.update(func(l *LocalEvent) {
+		l.StartAnswer = answer
+		l.StartAnsweredAt = now
+		if answer == reviewAnswerYes && !l.Acknowledged {
+			l.Acknowledged = true
+			l.AcknowledgedAt = now
+		}
+	})
+	if err == nil && !acknowledged && e.Acknowledged {
+		eventWebhook(webhookAcknowledged, e)
+	}
+	return err
+}
+
+// setSnoozed holds the reminders of the event for the given time, one is
+// given when it ends.
+func (e *LocalEvent) setSnoozed(d time.Duration) error {
+	until := clockNow().Add(d)
+	err := e.update(func(l *LocalEvent) { l.SnoozedUntil = until })
+	if err == nil {
+		eventWebhook(webhookSnoozed, e)
+	}
+	return err
+}
+
+// setCompleted marks the event as completed, no more announcements are made for it.
+func (e *LocalEvent) setCompleted() error {
+	now := clockNow()
+	err := e.update(func(l *LocalEvent) {
+		l.Completed = true
+		l.CompletedAt = now
+	})
+	if err == nil {
+		eventWebhook(webhookCompleted, e)
+	}
+	return err
 }
 
-// updateEvent updates the event's information in the local storage.
-func (e *LocalEvent) updateEvent() error {
+// setReviewAnswer records the answer given for the event in the end-of-day review.
+func (e *LocalEvent) setReviewAnswer(answer string) error {
+	now := clockNow()
+	return e.update(func(l *LocalEvent) {
+		l.ReviewAnswer = answer
+		l.ReviewedAt = now
+	})
+}
+
+// update applies the change to the event and to its stored copy. The
+// change is applied to the stored copy rather than saving e as a whole,
+// since e may be stale, e.g. acknowledged from the web interface while the
+// reminder loop was announcing it.
+func (e *LocalEvent) update(change func(l *LocalEvent)) error {
+	change(e)
+
 	syncEvent.Lock()
 	defer syncEvent.Unlock()
 
-	eJson, err := json.MarshalIndent(e, "", " ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %v", err)
+	// the event may have been removed from the calendar in the meantime
+	cached, ok := eventCache[e.Event.ID]
+	if !ok {
+		return fmt.Errorf("event %s not found", e.Event.ID)
 	}
 
-	eventPath := realPath(SysConfig.EventsPath)
-	return os.WriteFile(path.Join(eventPath, e.Event.ID+".json"), eJson, 0644)
+	stored := cached.event
+	stored.AlarmsAnnounced = append([]time.Time(nil), stored.AlarmsAnnounced...)
+	stored.PreStartAnnounced = append([]time.Time(nil), stored.PreStartAnnounced...)
+	change(&stored)
+	return storeEvent(stored)
 }
 
 // scheduledForNow returns true if now is within the event's start and end times.
@@ -206,7 +508,7 @@ func (e *LocalEvent) scheduledForNow() bool {
 		return false
 	}
 
-	now := time.Now()
+	now := clockNow()
 	if now.After(e.Event.StartTime) && now.Before(e.Event.EndTime) {
 		return true
 	}
@@ -220,7 +522,7 @@ func (e *LocalEvent) scheduledNearEnd() bool {
 		return false
 	}
 
-	now := time.Now()
+	now := clockNow()
 	if now.After(e.Event.EndTime.Add(-time.Minute)) && now.Before(e.Event.EndTime.Add(time.Minute)) {
 		return true
 	}
@@ -230,6 +532,6 @@ func (e *LocalEvent) scheduledNearEnd() bool {
 
 // scheduledForToday returns true if the event is scheduled for today.
 func (e *LocalEvent) scheduledForToday() bool {
-	// Truncate both times to midnight to compare only the date part
-	return e.Event.StartTime.Truncate(24 * time.Hour).Equal(time.Now().Truncate(24 * time.Hour))
+	// compare the local dates, all-day events start at local midnight
+	return startOfDay(e.Event.StartTime.Local()).Equal(startOfDay(clockNow()))
 }
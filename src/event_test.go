@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventFileName(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "abc-123@google.com", want: "abc-123@google.com.json"},
+		{id: "uid-20250102T080000Z", want: "uid-20250102T080000Z.json"},
+		{id: "", wantErr: true},
+		{id: "../configs/tokens", wantErr: true},
+		{id: "..", wantErr: true},
+		{id: "a/b", wantErr: true},
+		{id: `a\b`, wantErr: true},
+		{id: "a\x00b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := eventFileName(tt.id)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("eventFileName(%q) = %q, %v, want %q, error %v", tt.id, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestSyncHostileEventID checks an id from a calendar feed can't write or
+// remove the files next to the events.
+func TestSyncHostileEventID(t *testing.T) {
+	root, events := SysRootDir, SysConfig.EventsPath
+	defer func() {
+		SysRootDir, SysConfig.EventsPath = root, events
+		eventCache, eventCacheLoaded = make(map[string]cachedEvent), false
+	}()
+	SysRootDir = t.TempDir()
+	SysConfig.EventsPath = "resources/events"
+	eventCache, eventCacheLoaded = make(map[string]cachedEvent), false
+
+	tokens := filepath.Join(SysRootDir, "resources", "configs", "tokens.json")
+	if err := os.MkdirAll(filepath.Dir(tokens), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tokens, []byte("[]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(time.Hour)
+	feed := []CalendarEvent{
+		{ID: "../configs/tokens", Description: "Hostile", StartTime: start, EndTime: start.Add(time.Hour)},
+		{ID: "good", Description: "Good", StartTime: start, EndTime: start.Add(time.Hour)},
+	}
+	if err := syncLocalEvents(feed, true); err != nil {
+		t.Fatalf("syncLocalEvents() error = %v", err)
+	}
+	if _, ok := eventCache["../configs/tokens"]; ok {
+		t.Errorf("hostile event stored")
+	}
+	if _, err := os.Stat(filepath.Join(SysRootDir, "resources", "events", "good.json")); err != nil {
+		t.Errorf("good event not stored: %v", err)
+	}
+	if data, err := os.ReadFile(tokens); err != nil || string(data) != "[]" {
+		t.Errorf("tokens file = %q, %v, want it untouched", data, err)
+	}
+
+	// an event file with a hostile id is quarantined, not removed by its id
+	hostile := filepath.Join(SysRootDir, "resources", "events", "hostile.json")
+	if err := os.WriteFile(hostile, []byte(`{"SchemaVersion":2,"Event":{"ID":"../configs/tokens"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	eventCache, eventCacheLoaded = make(map[string]cachedEvent), false
+	if err := syncLocalEvents(feed[1:], true); err != nil {
+		t.Fatalf("syncLocalEvents() error = %v", err)
+	}
+	if _, err := os.Stat(tokens); err != nil {
+		t.Errorf("tokens file removed: %v", err)
+	}
+	if _, err := os.Stat(hostile); !os.IsNotExist(err) {
+		t.Errorf("hostile event file not quarantined: %v", err)
+	}
+}
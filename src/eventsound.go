@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// eventSound returns the sound played before the announcements of the
+// event, from its sound tag or else its rule, and true if it is played
+// instead of speaking.
+func eventSound(e *LocalEvent) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	// calendars can be shared, a tag only picks from the sounds directory
+	if sound := eventTags(&e.Event).Sound; sound != "" {
+		return filepath.Join(soundsPath, filepath.Base(sound)), false
+	}
+	if rule, ok := matchEventRule(&e.Event); ok && rule.Sound != "" {
+		return soundPath(rule.Sound), rule.SoundOnly
+	}
+	return "", false
+}
+
+// soundPath returns the path of a sound, a bare file name is in the sounds directory.
+func soundPath(sound string) string {
+	if filepath.Base(sound) == sound {
+		return filepath.Join(soundsPath, sound)
+	}
+	return sound
+}
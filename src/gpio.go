@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The Linux GPIO character device, version 2 of its interface, see
+// include/uapi/linux/gpio.h. The pins are the line offsets of the chip,
+// which are the BCM numbers on gpiochip0 of a Raspberry Pi.
+const (
+	gpioGetLineIoctl      = 0xc250b407 // _IOWR(0xB4, 0x07, struct gpio_v2_line_request)
+	gpioGetValuesIoctl    = 0xc010b40e // _IOWR(0xB4, 0x0E, struct gpio_v2_line_values)
+	gpioSetValuesIoctl    = 0xc010b40f // _IOWR(0xB4, 0x0F, struct gpio_v2_line_values)
+	gpioLineRequestSize   = 592
+	gpioLineEventSize     = 48
+	gpioConsumerOffset    = 256
+	gpioConfigOffset      = 288
+	gpioNumLinesOffset    = 560
+	gpioRequestFdOffset   = 588
+	gpioAttrDebounce      = 3
+	gpioFlagActiveLow     = 1 << 1
+	gpioFlagInput         = 1 << 2
+	gpioFlagOutput        = 1 << 3
+	gpioFlagEdgeRising    = 1 << 4
+	gpioFlagEdgeFalling   = 1 << 5
+	gpioFlagBiasPullUp    = 1 << 8
+	gpioFlagBiasPullDown  = 1 << 9
+	gpioEventRisingEdge   = 1
+	gpioConsumer          = "rbpi-reminder"
+	gpioMaxLinesInRequest = 64
+)
+
+// gpioLines are lines of a GPIO chip requested together, as inputs that
+// report their edges or as outputs.
+type gpioLines struct {
+	file *os.File
+}
+
+// gpioEvent is an edge of an input line.
+type gpioEvent struct {
+	Pin    int
+	Rising bool // To active, a press for a button
+	Time   time.Duration
+}
+
+// requestGPIOInputs requests the pins of the chip as inputs reporting both
+// edges. Active low lines are pulled up and active when shorted to ground,
+// the others are pulled down. The kernel drops the edges of bounces shorter
+// than debounce.
+func requestGPIOInputs(chip string, pins []int, activeLow bool, debounce time.Duration) (*gpioLines, error) {
+	flags := uint64(gpioFlagInput | gpioFlagEdgeRising | gpioFlagEdgeFalling | gpioFlagBiasPullDown)
+	if activeLow {
+		flags = gpioFlagInput | gpioFlagEdgeRising | gpioFlagEdgeFalling | gpioFlagActiveLow | gpioFlagBiasPullUp
+	}
+	return requestGPIOLines(chip, pins, flags, debounce)
+}
+
+// requestGPIOOutputs requests the pins of the chip as outputs, off.
+func requestGPIOOutputs(chip string, pins []int) (*gpioLines, error) {
+	return requestGPIOLines(chip, pins, gpioFlagOutput, 0)
+}
+
+func requestGPIOLines(chip string, pins []int, flags uint64, debounce time.Duration) (*gpioLines, error) {
+	if len(pins) == 0 || len(pins) > gpioMaxLinesInRequest {
+		return nil, fmt.Errorf("invalid number of pins %d", len(pins))
+	}
+	f, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	req := make([]byte, gpioLineRequestSize)
+	le := binary.LittleEndian
+	for i, pin := range pins {
+		le.PutUint32(req[i*4:], uint32(pin))
+	}
+	copy(req[gpioConsumerOffset:gpioConsumerOffset+31], gpioConsumer)
+	// gpio_v2_line_config: flags, num_attrs, padding, then the attributes
+	le.PutUint64(req[gpioConfigOffset:], flags)
+	if debounce > 0 {
+		le.PutUint32(req[gpioConfigOffset+8:], 1)
+		attr := gpioConfigOffset + 32
+		le.PutUint32(req[attr:], gpioAttrDebounce)
+		le.PutUint32(req[attr+8:], uint32(debounce.Microseconds()))
+		le.PutUint64(req[attr+16:], 1<<len(pins)-1)
+	}
+	le.PutUint32(req[gpioNumLinesOffset:], uint32(len(pins)))
+
+	if err := gpioIoctl(f.Fd(), gpioGetLineIoctl, req); err != nil {
+		return nil, fmt.Errorf("failed to request pins %v of %s: %v", pins, chip, err)
+	}
+	fd := int(int32(le.Uint32(req[gpioRequestFdOffset:])))
+	// non-blocking, the reads go through the poller and end when it is closed
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &gpioLines{file: os.NewFile(uintptr(fd), chip)}, nil
+}
+
+// readEvent waits for the next edge of an input line.
+func (l *gpioLines) readEvent() (gpioEvent, error) {
+	buf := make([]byte, gpioLineEventSize)
+	if _, err := l.file.Read(buf); err != nil {
+		return gpioEvent{}, err
+	}
+	le := binary.LittleEndian
+	// gpio_v2_line_event: timestamp_ns, id, offset, seqno, line_seqno
+	return gpioEvent{
+		Pin:    int(le.Uint32(buf[12:])),
+		Rising: le.Uint32(buf[8:]) == gpioEventRisingEdge,
+		Time:   time.Duration(le.Uint64(buf[0:])),
+	}, nil
+}
+
+// values returns the lines that are active, bit i for the pin i of the
+// request.
+func (l *gpioLines) values() (uint64, error) {
+	values := make([]byte, 16)
+	binary.LittleEndian.PutUint64(values[8:], 1<<gpioMaxLinesInRequest-1)
+	if err := gpioIoctl(l.file.Fd(), gpioGetValuesIoctl, values); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(values[0:]), nil
+}
+
+// setValues sets the output lines, bit i for the pin i of the request.
+func (l *gpioLines) setValues(bits, mask uint64) error {
+	values := make([]byte, 16)
+	binary.LittleEndian.PutUint64(values[0:], bits)
+	binary.LittleEndian.PutUint64(values[8:], mask)
+	return gpioIoctl(l.file.Fd(), gpioSetValuesIoctl, values)
+}
+
+// Close releases the lines, outputs go back to inputs.
+func (l *gpioLines) Close() error {
+	return l.file.Close()
+}
+
+func gpioIoctl(fd uintptr, request uintptr, arg []byte) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(&arg[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
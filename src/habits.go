@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+const (
+	// habitCategory is the category of the occurrences of the habits, for
+	// the event rules and sms.categories.
+	habitCategory = "habit"
+	// habitCheckInterval is how often the habits are checked for being done,
+	// or for being over without it.
+	habitCheckInterval = time.Minute
+)
+
+// HabitRecord is a single occurrence of a habit, done or not.
+type HabitRecord struct {
+	ReminderID string    `json:"reminder_id"`
+	EventID    string    `json:"event_id"`
+	Habit      string    `json:"habit"`
+	Scheduled  time.Time `json:"scheduled"`
+	Done       bool      `json:"done"`
+	DoneAt     time.Time `json:"done_at,omitempty"`
+}
+
+var habitLog sync.Mutex
+
+// isHabit returns true if the event is an occurrence of a local reminder
+// kept as a habit.
+func isHabit(e *CalendarEvent) bool {
+	return e.Source == localSourceName && hasAnyCategory(e, []string{habitCategory})
+}
+
+// habitReminderID returns the ID of the local reminder an occurrence is of,
+// the occurrences add their start time to it.
+func habitReminderID(e *CalendarEvent) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(e.ID, "local-"), "-")
+	return "local-" + id
+}
+
+// runHabitChecks records the habits once they are done, or over.
+func runHabitChecks() {
+	ticker := time.NewTicker(habitCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkHabits()
+	}
+}
+
+// checkHabits records the habits of today that were acknowledged or
+// completed, and the ones that ended without it.
+func checkHabits() {
+	if simulating {
+		return
+	}
+	events, err := loadAllTodayEvents()
+	if err != nil {
+		logError("failed to load events for the habits: %v", err)
+		return
+	}
+
+	now := clockNow()
+	for i := range events {
+		e := &events[i]
+		if e.HabitRecorded || !isHabit(&e.Event) {
+			continue
+		}
+		done := e.Acknowledged || e.Completed
+		if !done && now.Before(e.Event.EndTime) {
+			continue
+		}
+
+		// marked first, an occurrence is not counted twice
+		if err := e.update(func(l *LocalEvent) { l.HabitRecorded = true }); err != nil {
+			logError("failed to save the habit of event %s: %v", e.Event.ID, err)
+			continue
+		}
+		record := HabitRecord{
+			ReminderID: habitReminderID(&e.Event),
+			EventID:    e.Event.ID,
+			Habit:      stripEventTags(e.Event.Description),
+			Scheduled:  e.Event.StartTime,
+			Done:       done,
+		}
+		if done {
+			record.DoneAt = e.CompletedAt
+			if record.DoneAt.IsZero() || (!e.AcknowledgedAt.IsZero() && e.AcknowledgedAt.Before(record.DoneAt)) {
+				record.DoneAt = e.AcknowledgedAt
+			}
+		}
+		if err := appendHabitRecord(record); err != nil {
+			logError("failed to record the habit of event %s: %v", e.Event.ID, err)
+			continue
+		}
+		if done {
+			logInfo("Habit %s at %s done", record.Habit, e.Event.StartTime.Format("15:04"))
+		} else {
+			logInfo("Habit %s at %s missed", record.Habit, e.Event.StartTime.Format("15:04"))
+		}
+	}
+}
+
+// appendHabitRecord adds the occurrence to the habits history.
+func appendHabitRecord(record HabitRecord) error {
+	habitLog.Lock()
+	defer habitLog.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal habit: %v", err)
+	}
+
+	path := realPath(habitsPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open habits history: %v", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// habitRecords returns the recorded occurrences of each habit by the ID of
+// its reminder, the last one first.
+func habitRecords() (map[string][]HabitRecord, error) {
+	records := map[string][]HabitRecord{}
+
+	habitLog.Lock()
+	defer habitLog.Unlock()
+	f, err := os.Open(realPath(habitsPath))
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return records, fmt.Errorf("failed to open habits history: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record HabitRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a line cut short by a power loss, skip it
+			continue
+		}
+		records[record.ReminderID] = append(records[record.ReminderID], record)
+	}
+	if err := scanner.Err(); err != nil {
+		return records, fmt.Errorf("failed to read habits history: %v", err)
+	}
+
+	for _, r := range records {
+		sort.SliceStable(r, func(i, j int) bool { return r[i].Scheduled.After(r[j].Scheduled) })
+	}
+	return records, nil
+}
+
+// habitStreak returns how many of the last occurrences in a row were done.
+func habitStreak(records []HabitRecord) int {
+	streak := 0
+	for _, record := range records {
+		if !record.Done {
+			break
+		}
+		streak++
+	}
+	return streak
+}
+
+// streakUnit returns what the occurrences of a repeat rule are counted in,
+// e.g. "days" for a daily habit, or "times" when they are irregular.
+func streakUnit(repeat string) string {
+	roption, err := rrule.StrToROption(repeat)
+	if err != nil || roption.Interval > 1 || len(roption.Byhour) > 1 || len(roption.Byminute) > 1 {
+		return "times"
+	}
+	switch roption.Freq {
+	case rrule.DAILY:
+		if len(roption.Byweekday) == 0 && len(roption.Bymonthday) == 0 && len(roption.Bymonth) == 0 {
+			return "days"
+		}
+	case rrule.WEEKLY:
+		if len(roption.Byweekday) <= 1 {
+			return "weeks"
+		}
+	case rrule.MONTHLY:
+		if len(roption.Bymonthday) <= 1 && len(roption.Byweekday) == 0 {
+			return "months"
+		}
+	case rrule.YEARLY:
+		return "years"
+	}
+	return "times"
+}
+
+// habitEncouragement returns what is said about the streak of a habit when
+// it is announced, a nudge without blame when the last one was missed.
+func habitEncouragement(e *LocalEvent) string {
+	if !isHabit(&e.Event) {
+		return ""
+	}
+	id := habitReminderID(&e.Event)
+	all, err := habitRecords()
+	if err != nil {
+		logError("failed to load the habits: %v", err)
+		return ""
+	}
+	records := all[id]
+	// this one is recorded once it's done, or over
+	if len(records) > 0 && records[0].EventID == e.Event.ID {
+		records = records[1:]
+	}
+	if len(records) == 0 {
+		return ""
+	}
+
+	streak := habitStreak(records)
+	switch {
+	case streak >= 2:
+		unit := "times"
+		if reminder, ok := findLocalReminder(id); ok {
+			unit = streakUnit(reminder.Repeat)
+		}
+		return fmt.Sprintf("You've done it %d %s in a row, keep it going!", streak, unit)
+	case streak == 0:
+		return "No worries about last time, this is a fresh start."
+	}
+	return ""
+}
+
+// findLocalReminder returns the local reminder with that ID.
+func findLocalReminder(id string) (LocalReminder, bool) {
+	reminders, err := loadLocalReminders()
+	if err != nil {
+		logError("failed to load local reminders: %v", err)
+		return LocalReminder{}, false
+	}
+	for _, r := range reminders {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return LocalReminder{}, false
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// minFreeDiskSpace is the free space under which the disk is unhealthy, the
+// logs, the history and the speech cache stop being written.
+const minFreeDiskSpace = 100 * 1024 * 1024
+
+// Health is the state of the parts the announcements depend on.
+type Health struct {
+	Status   string           `json:"status"` // ok, or degraded if any part is not healthy
+	Time     time.Time        `json:"time"`
+	Calendar []CalendarHealth `json:"calendar"`
+	TTS      TTSHealth        `json:"tts"`
+	Audio    AudioHealth      `json:"audio"`
+	Disk     DiskHealth       `json:"disk"`
+	Channels []ChannelHealth  `json:"notification_channels"` // Not part of the status, the speaker is what matters
+	Problems []string         `json:"problems,omitempty"`
+}
+
+type CalendarHealth struct {
+	Source        string    `json:"source"`
+	Healthy       bool      `json:"healthy"`
+	LastSuccess   time.Time `json:"last_success,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+}
+
+type TTSHealth struct {
+	Engine string `json:"engine"` // The local model
+	Loaded bool   `json:"loaded"` // The model is in memory, it's loaded on first use
+	Ready  bool   `json:"ready"`
+	Error  string `json:"error,omitempty"`
+}
+
+type AudioHealth struct {
+	Output        string    `json:"output"` // default, the audio_device, bluetooth or dry run
+	Available     bool      `json:"available"`
+	LastPlayed    time.Time `json:"last_played,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+}
+
+type DiskHealth struct {
+	Path      string `json:"path"`
+	FreeBytes uint64 `json:"free_bytes"`
+	Total     uint64 `json:"total_bytes"`
+	Healthy   bool   `json:"healthy"`
+}
+
+// syncLog is the outcome of the last fetches of a calendar source.
+type syncLog struct {
+	success   time.Time
+	err       string
+	errorTime time.Time
+}
+
+var (
+	healthMutex   sync.Mutex
+	calendarSyncs = make(map[string]syncLog)
+	playback      syncLog
+)
+
+// recordCalendarSync keeps the outcome of a fetch of the calendar source.
+func recordCalendarSync(source string, err error) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	s := calendarSyncs[source]
+	if err != nil {
+		s.err = err.Error()
+		s.errorTime = time.Now()
+	} else {
+		s.success = time.Now()
+	}
+	calendarSyncs[source] = s
+}
+
+// recordPlayback keeps the outcome of playing a clip.
+func recordPlayback(err error) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	if err != nil {
+		playback.err = err.Error()
+		playback.errorTime = time.Now()
+	} else {
+		playback.success = time.Now()
+	}
+}
+
+// checkHealth returns the state of the calendar sync, the TTS engine, the
+// audio output and the disk.
+func checkHealth() Health {
+	h := Health{Status: "ok", Time: time.Now()}
+	healthMutex.Lock()
+	syncs := make(map[string]syncLog, len(calendarSyncs))
+	for name, s := range calendarSyncs {
+		syncs[name] = s
+	}
+	played := playback
+	healthMutex.Unlock()
+
+	h.Calendar = calendarHealth(syncs)
+	for _, c := range h.Calendar {
+		if !c.Healthy {
+			h.Problems = append(h.Problems, fmt.Sprintf("calendar %s: %s", c.Source, c.LastError))
+		}
+	}
+
+	h.TTS = ttsHealth()
+	if !h.TTS.Ready {
+		h.Problems = append(h.Problems, "tts: "+h.TTS.Error)
+	}
+
+	h.Audio = audioHealth(played)
+	if !h.Audio.Available {
+		h.Problems = append(h.Problems, "audio: "+h.Audio.LastError)
+	}
+
+	h.Disk = diskHealth()
+	if !h.Disk.Healthy {
+		h.Problems = append(h.Problems, fmt.Sprintf("disk: %d MB free", h.Disk.FreeBytes/1024/1024))
+	}
+
+	h.Channels = channelHealth()
+
+	if len(h.Problems) > 0 {
+		h.Status = "degraded"
+	}
+	return h
+}
+
+// calendarHealth returns the state of each calendar source. A source is
+// unhealthy if its last fetch failed, or if it was never fetched though
+// another was.
+func calendarHealth(syncs map[string]syncLog) []CalendarHealth {
+	sourcesMutex.RLock()
+	sources := calendarSources
+	sourcesMutex.RUnlock()
+
+	list := []CalendarHealth{}
+	for _, src := range sources {
+		s, ok := syncs[src.Name()]
+		c := CalendarHealth{
+			Source:        src.Name(),
+			LastSuccess:   s.success,
+			LastError:     s.err,
+			LastErrorTime: s.errorTime,
+		}
+		if ok {
+			c.Healthy = !s.success.Before(s.errorTime)
+		} else {
+			// not fetched yet, only a problem once the others were
+			c.Healthy = len(syncs) == 0
+			if !c.Healthy {
+				c.LastError = "never fetched"
+			}
+		}
+		list = append(list, c)
+	}
+	return list
+}
+
+// ttsHealth returns the state of the TTS engine of the announcements. A
+// local model that isn't loaded yet is ready if its files are there.
+func ttsHealth() TTSHealth {
+	model := defaultTtsModel()
+	h := TTSHealth{Engine: model}
+	ttsModelsMutex.Lock()
+	_, h.Loaded = ttsModels[model]
+	loadErr := ttsLoadErrors[model]
+	ttsModelsMutex.Unlock()
+
+	switch {
+	case h.Loaded:
+		h.Ready = true
+	case loadErr != nil:
+		h.Error = loadErr.Error()
+	default:
+		h.Ready = true
+		for _, p := range validateFiles(SysConfig) {
+			if strings.HasPrefix(p.Path, "tts_models.") || strings.HasPrefix(p.Path, "ai_speech_tts_config.") {
+				h.Ready = false
+				h.Error = p.Path + ": " + p.Message
+				break
+			}
+		}
+	}
+	return h
+}
+
+// audioHealth returns the state of the audio output, as playClip picks it.
+// The last playback failing makes it unavailable until a clip plays again.
+func audioHealth(played syncLog) AudioHealth {
+	h := AudioHealth{
+		Available:     true,
+		LastPlayed:    played.success,
+		LastError:     played.err,
+		LastErrorTime: played.errorTime,
+	}
+
+	switch {
+	case SysConfig.DryRun:
+		h.Output = "dry run"
+		return h
+	case bluetoothEnabled():
+		h.Output = "bluetooth"
+		bluetoothMutex.Lock()
+		connected := false
+		for _, address := range bluetoothAddresses() {
+			connected = connected || bluetoothConnected[address]
+		}
+		bluetoothMutex.Unlock()
+		if !connected {
+			h.Available = false
+			h.LastError = "no bluetooth speaker connected"
+		}
+	case SysConfig.AudioDevice != "":
+		h.Output = SysConfig.AudioDevice
+		devices, err := listAudioDevices()
+		if err != nil {
+			h.Available = false
+			h.LastError = err.Error()
+			break
+		}
+		found := false
+		for _, d := range devices {
+			found = found || d.Name == SysConfig.AudioDevice
+		}
+		if !found {
+			h.Available = false
+			h.LastError = fmt.Sprintf("audio device %s not found", SysConfig.AudioDevice)
+		}
+	default:
+		h.Output = "default"
+		// the mutex is held while a clip plays, the output works then
+		if audioMutex.TryLock() {
+			if audioContext != nil {
+				if err := audioContext.Err(); err != nil {
+					h.Available = false
+					h.LastError = err.Error()
+				}
+			}
+			audioMutex.Unlock()
+		}
+	}
+
+	if h.Available && played.success.Before(played.errorTime) {
+		h.Available = false
+	}
+	return h
+}
+
+// diskHealth returns the free space where the state and the logs are kept.
+func diskHealth() DiskHealth {
+	h := DiskHealth{Path: realPath(".")}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(h.Path, &stat); err != nil {
+		logWarn("Failed to check the disk space: %v", err)
+		return h
+	}
+	h.FreeBytes = stat.Bavail * uint64(stat.Bsize)
+	h.Total = stat.Blocks * uint64(stat.Bsize)
+	h.Healthy = h.FreeBytes >= minFreeDiskSpace
+	return h
+}
+
+// isLocalAddress reports whether the request comes from this device or the
+// local network.
+func isLocalAddress(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast())
+}
+
+// handleHealth returns the health as JSON, with 503 if it is degraded so
+// monitoring tools can check the status code alone.
+func (ws *webServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	h := checkHealth()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if h.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// requireHealthAccess serves the health without a login to the local
+// network if web_server.health_public is set.
+func (ws *webServer) requireHealthAccess(next http.HandlerFunc) http.HandlerFunc {
+	protected := ws.requireAuth(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if SysConfig.WebServer.HealthPublic && isLocalAddress(r.RemoteAddr) {
+			next(w, r)
+			return
+		}
+		protected(w, r)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of announcements
+const (
+	announceKindStart      = "start"
+	announceKindCatchUp    = "catch_up"
+	announceKindAllDay     = "all_day"
+	announceKindAlarm      = "alarm"
+	announceKindPreStart   = "pre_start"
+	announceKindCheckStart = "check_start"
+	announceKindRemind     = "remind"
+	announceKindEnd        = "end"
+	announceKindReview     = "review"
+	announceKindRecap      = "recap"
+	announceKindPanic      = "panic"
+	announceKindMissedDose = "missed_dose"
+	announceKindWakeUp     = "wake_up"
+)
+
+// announceKinds are the kinds the config can refer to.
+var announceKinds = []string{
+	announceKindStart, announceKindCatchUp, announceKindAllDay, announceKindAlarm, announceKindPreStart,
+	announceKindCheckStart, announceKindRemind, announceKindEnd, announceKindReview, announceKindRecap, announceKindPanic,
+	announceKindMissedDose, announceKindWakeUp,
+}
+
+// maxHistoryDays limits how many days a single history query can span.
+const maxHistoryDays = 31
+
+// Announcement is a single entry of the announcement history.
+type Announcement struct {
+	Time    time.Time `json:"time"`
+	EventID string    `json:"event_id,omitempty"`
+	Kind    string    `json:"kind"`
+	Text    string    `json:"text"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+var historyLog sync.Mutex
+
+// historyFile returns the path of the history file of the given day.
+func historyFile(day time.Time) string {
+	return filepath.Join(realPath(historyPath), "announcements-"+day.Format("2006-01-02")+".jsonl")
+}
+
+// recordAnnouncement adds an announcement to the history, failures are only
+// logged, the history must never stop an announcement. It is then sent to the
+// notification channels. Nothing is recorded in a dry run, nothing was said.
+func recordAnnouncement(e *LocalEvent, kind, text string, speakErr error) {
+	if simulating || SysConfig.DryRun {
+		return
+	}
+	entry := recordSpoken(e, kind, text, speakErr)
+	queueForChannels(e, entry)
+}
+
+// recordSpoken adds an announcement to the history and counts it for the
+// speaker, without sending it to the notification channels. In a dry run it
+// is only returned.
+func recordSpoken(e *LocalEvent, kind, text string, speakErr error) Announcement {
+	entry := Announcement{
+		Time:    time.Now(),
+		Kind:    kind,
+		Text:    text,
+		Success: speakErr == nil,
+	}
+	if e != nil {
+		entry.EventID = e.Event.ID
+	}
+	if speakErr != nil {
+		entry.Error = speakErr.Error()
+	}
+	if SysConfig.DryRun {
+		return entry
+	}
+
+	if err := appendAnnouncement(entry); err != nil {
+		logError("failed to record announcement: %v", err)
+	}
+	publishLive(liveTypeAnnouncement, entry)
+	recordChannelResult(speechChannel, speakErr, 0)
+	return entry
+}
+
+func appendAnnouncement(entry Announcement) error {
+	historyLog.Lock()
+	defer historyLog.Unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal announcement: %v", err)
+	}
+
+	if err := os.MkdirAll(realPath(historyPath), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	path := historyFile(entry.Time)
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %v", err)
+	}
+	defer f.Close()
+
+	// first entry of the day, a good time to drop old history
+	if os.IsNotExist(statErr) {
+		pruneHistory()
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// pruneHistory removes the history files older than the retention period.
+func pruneHistory() {
+	if SysConfig.HistoryRetentionDays <= 0 {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join(realPath(historyPath), "announcements-*.jsonl"))
+	if err != nil {
+		return
+	}
+
+	cutoff := startOfDay(time.Now()).AddDate(0, 0, -SysConfig.HistoryRetentionDays)
+	for _, file := range files {
+		date := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "announcements-"), ".jsonl")
+		day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil || !day.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			logError("failed to remove old history file %s: %v", file, err)
+		}
+	}
+}
+
+// loadHistory returns the announcements made between the from and to days, inclusive.
+func loadHistory(from, to time.Time) ([]Announcement, error) {
+	historyLog.Lock()
+	defer historyLog.Unlock()
+
+	entries := []Announcement{}
+	for day := startOfDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		f, err := os.Open(historyFile(day))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open history file: %v", err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry Announcement
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				// a line cut short by a power loss, skip it
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history file: %v", err)
+		}
+	}
+
+	return entries, nil
+}
+
+// loadEventHistory returns the announcements made about the event, from
+// the day before its first alarm to the day after it ended, at most
+// maxHistoryDays back from then.
+func loadEventHistory(e *LocalEvent) ([]Announcement, error) {
+	from, to := e.Event.StartTime, e.Event.EndTime
+	for _, t := range append(append([]time.Time{}, e.Event.Alarms...), e.PreStartAnnounced...) {
+		if t.Before(from) {
+			from = t
+		}
+	}
+	for _, t := range []time.Time{e.ReviewedAt, e.CompletedAt, e.AcknowledgedAt} {
+		if t.After(to) {
+			to = t
+		}
+	}
+	from, to = startOfDay(from).AddDate(0, 0, -1), startOfDay(to).AddDate(0, 0, 1)
+	if now := startOfDay(time.Now()); to.After(now) {
+		to = now
+	}
+	if earliest := to.AddDate(0, 0, -maxHistoryDays); from.Before(earliest) {
+		from = earliest
+	}
+
+	entries, err := loadHistory(from, to)
+	if err != nil {
+		return nil, err
+	}
+	history := []Announcement{}
+	for _, entry := range entries {
+		if entry.EventID == e.Event.ID {
+			history = append(history, entry)
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// holidayAPI looks up the public holidays of a country by its ISO code
+	holidayAPI      = "https://date.nager.at/api/v3/PublicHolidays/%d/%s"
+	holidayTimeout  = 30 * time.Second
+	holidayRefresh  = 24 * time.Hour
+	holidayRetry    = time.Hour
+	holidayDateForm = "2006-01-02"
+)
+
+var (
+	holidaysMutex  sync.RWMutex
+	holidays       = map[string]string{} // holiday names by date
+	holidaysSource string
+)
+
+// isHoliday returns true if the day of t is a holiday.
+func isHoliday(t time.Time) bool {
+	holidaysMutex.RLock()
+	defer holidaysMutex.RUnlock()
+	_, ok := holidays[t.Local().Format(holidayDateForm)]
+	return ok
+}
+
+// holidaysEnabled returns true if a holiday calendar is configured.
+func holidaysEnabled() bool {
+	h := SysConfig.Holidays
+	return h.Country != "" || h.URL != "" || h.Path != ""
+}
+
+// refreshHolidays keeps the holidays of this year and the next up to date,
+// reading them again every day or as soon as the configuration changes.
+func refreshHolidays() {
+	next := time.Time{}
+	for {
+		changes := scheduleChanges()
+
+		h := SysConfig.Holidays
+		source := strings.Join([]string{h.Country, h.Region, h.URL, h.Path}, "|")
+		holidaysMutex.RLock()
+		changed := source != holidaysSource
+		holidaysMutex.RUnlock()
+
+		if changed || !time.Now().Before(next) {
+			next = time.Now().Add(holidayRefresh)
+			if err := loadHolidays(); err != nil {
+				logError("failed to load holidays: %v", err)
+				next = time.Now().Add(holidayRetry)
+			}
+		}
+
+		sleepUntil(next, changes)
+	}
+}
+
+// loadHolidays reads the holidays of this year and the next from the
+// configured holiday calendar and country.
+func loadHolidays() error {
+	h := SysConfig.Holidays
+	source := strings.Join([]string{h.Country, h.Region, h.URL, h.Path}, "|")
+	loaded := map[string]string{}
+
+	year := clockNow().Year()
+	if h.Country != "" {
+		for _, y := range []int{year, year + 1} {
+			if err := fetchPublicHolidays(y, h.Country, h.Region, loaded); err != nil {
+				return err
+			}
+		}
+	}
+
+	if h.URL != "" || h.Path != "" {
+		src := newICSSource("holidays", h.URL, h.Path)
+		start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+		events, err := src.FetchEvents(start, start.AddDate(2, 0, 0))
+		if err != nil {
+			return fmt.Errorf("failed to read holiday calendar: %v", err)
+		}
+		for _, e := range events {
+			// a holiday can span several days, the end is exclusive
+			first := startOfDay(e.StartTime.Local())
+			loaded[first.Format(holidayDateForm)] = e.Description
+			for day := first.AddDate(0, 0, 1); day.Before(e.EndTime); day = day.AddDate(0, 0, 1) {
+				loaded[day.Format(holidayDateForm)] = e.Description
+			}
+		}
+	}
+
+	holidaysMutex.Lock()
+	holidays = loaded
+	holidaysSource = source
+	holidaysMutex.Unlock()
+
+	if holidaysEnabled() {
+		logDebug("Loaded %d holidays", len(loaded))
+	}
+	return nil
+}
+
+// fetchPublicHolidays adds the public holidays of the country in the given
+// year, with the regional ones of the region if set, e.g. "DE-BY".
+func fetchPublicHolidays(year int, country, region string, into map[string]string) error {
+	client := &http.Client{Timeout: holidayTimeout}
+	resp, err := client.Get(fmt.Sprintf(holidayAPI, year, url.PathEscape(strings.ToUpper(country))))
+	if err != nil {
+		return fmt.Errorf("failed to fetch public holidays: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch public holidays of %s: %s", country, resp.Status)
+	}
+
+	var list []struct {
+		Date     string   `json:"date"`
+		Name     string   `json:"name"`
+		Global   bool     `json:"global"`
+		Counties []string `json:"counties"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("failed to parse public holidays: %v", err)
+	}
+
+	for _, holiday := range list {
+		if !holiday.Global && !containsFold(holiday.Counties, region) {
+			continue
+		}
+		into[holiday.Date] = holiday.Name
+	}
+	return nil
+}
+
+// containsFold returns true if the list has the value, ignoring case.
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if value != "" && strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+const icsFetchTimeout = 30 * time.Second
+
+// icsSource is a CalendarSource backed by an iCalendar file, either a local
+// .ics file or a webcal/https subscription URL.
+type icsSource struct {
+	name string
+	url  string
+	path string
+
+	// remote calendars are only downloaded again when they changed
+	mutex        sync.Mutex
+	etag         string
+	lastModified string
+	data         []byte
+}
+
+func newICSSource(name, url, path string) *icsSource {
+	// webcal is just a hint for calendar apps, the feed itself is served over http(s)
+	if strings.HasPrefix(url, "webcal://") {
+		url = "https://" + strings.TrimPrefix(url, "webcal://")
+	}
+	if path != "" && !filepath.IsAbs(path) {
+		path = realPath(path)
+	}
+
+	return &icsSource{
+		name: name,
+		url:  url,
+		path: path,
+	}
+}
+
+// Name returns the name of the source.
+func (s *icsSource) Name() string {
+	return s.name
+}
+
+// FetchEvents returns the events of the calendar that overlap start and end.
+func (s *icsSource) FetchEvents(start, end time.Time) ([]CalendarEvent, error) {
+	data, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []CalendarEvent{}
+	decoder := ical.NewDecoder(bytes.NewReader(data))
+	for {
+		cal, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse calendar: %v", err)
+		}
+
+		// subscriptions usually carry the name of the calendar
+		name := ""
+		if prop := cal.Props.Get("X-WR-CALNAME"); prop != nil {
+			name = prop.Value
+		}
+		for _, e := range getEventsFromCalendar(cal, start, end) {
+			if e.StartTime.Before(end) && e.EndTime.After(start) {
+				e.Calendar = name
+				events = append(events, e)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// read returns the raw calendar data.
+func (s *icsSource) read() ([]byte, error) {
+	if s.path != "" {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read calendar file: %v", err)
+		}
+		return data, nil
+	}
+
+	return s.download()
+}
+
+// download fetches the subscription, reusing the previous copy if the server
+// reports it has not changed.
+func (s *icsSource) download() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if s.data != nil {
+		if s.etag != "" {
+			req.Header.Set("If-None-Match", s.etag)
+		}
+		if s.lastModified != "" {
+			req.Header.Set("If-Modified-Since", s.lastModified)
+		}
+	}
+
+	client := &http.Client{Timeout: icsFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download calendar: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return s.data, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("failed to download calendar: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read calendar: %v", err)
+	}
+
+	s.data = data
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	return data, nil
+}
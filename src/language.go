@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// languageWords are common short words of the languages that can be told
+// apart, enough to guess the language of an event title.
+var languageWords = map[string][]string{
+	"en": {"the", "a", "an", "and", "with", "for", "to", "of", "at", "in", "on", "my", "your", "meeting", "call"},
+	"es": {"el", "la", "los", "las", "un", "una", "y", "con", "para", "de", "del", "al", "en", "mi", "cita", "reunión"},
+	"fr": {"le", "la", "les", "un", "une", "et", "avec", "pour", "de", "du", "des", "au", "chez", "mon", "ma", "rendez-vous", "réunion"},
+	"de": {"der", "die", "das", "ein", "eine", "und", "mit", "für", "von", "zum", "zur", "im", "beim", "mein", "termin", "treffen"},
+	"it": {"il", "lo", "la", "gli", "le", "un", "una", "e", "con", "per", "di", "del", "della", "al", "alla", "mio", "appuntamento", "riunione"},
+	"pt": {"o", "a", "os", "as", "um", "uma", "e", "com", "para", "de", "do", "da", "no", "na", "meu", "consulta", "reunião"},
+	"nl": {"de", "het", "een", "en", "met", "voor", "van", "bij", "naar", "mijn", "afspraak", "vergadering"},
+}
+
+// languageLetters are letters only found in some of the languages.
+var languageLetters = map[string]string{
+	"es": "ñ¿¡",
+	"fr": "çœàèêëîïôûù",
+	"de": "ßäöü",
+	"it": "ìò",
+	"pt": "ãõç",
+}
+
+// eventLanguage returns the language of the event, from its lang tag, or
+// guessed from its text if enabled, the default language otherwise.
+func eventLanguage(e *CalendarEvent) string {
+	if lang := eventTags(e).Language; lang != "" {
+		return lang
+	}
+	if SysConfig.Languages.Detect && len(SysConfig.Languages.Voices) > 0 {
+		others := []string{}
+		for lang := range SysConfig.Languages.Voices {
+			others = append(others, strings.ToLower(lang))
+		}
+		sort.Strings(others)
+		candidates := append([]string{SysConfig.Languages.Default}, others...)
+		return detectLanguage(stripEventTags(e.Description+" "+e.Notes), candidates)
+	}
+	return SysConfig.Languages.Default
+}
+
+// detectLanguage returns which of the candidate languages the text is most
+// likely written in, the first candidate if nothing tells them apart.
+func detectLanguage(text string, candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '-'
+	})
+	scores := make(map[string]int)
+	for _, lang := range candidates {
+		for _, word := range words {
+			if slices.Contains(languageWords[lang], word) {
+				scores[lang]++
+			}
+			if letters := languageLetters[lang]; letters != "" && strings.ContainsAny(word, letters) {
+				scores[lang] += 2
+			}
+		}
+	}
+
+	// ties go to the earlier candidate, the default language first
+	best := candidates[0]
+	ranked := append([]string{}, candidates...)
+	sort.SliceStable(ranked, func(i, j int) bool { return scores[ranked[i]] > scores[ranked[j]] })
+	if scores[ranked[0]] > scores[best] {
+		best = ranked[0]
+	}
+	return best
+}
+
+// languageVoice returns the voice of the language, if it has one of its own.
+func languageVoice(lang string) (VoiceConfig, bool) {
+	if lang == SysConfig.Languages.Default {
+		return VoiceConfig{}, false
+	}
+	for name, v := range SysConfig.Languages.Voices {
+		if strings.EqualFold(name, lang) {
+			return v, true
+		}
+	}
+	return VoiceConfig{}, false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const lightsTimeout = 5 * time.Second
+
+// wledBlink is the Blink effect of WLED, between the colors of the segment.
+const wledBlink = 1
+
+// flashing is held while the lights flash, a flash for the next
+// announcement is skipped rather than saving the state of a flashing light.
+var flashing sync.Mutex
+
+// flashLights flashes the Hue lights and the WLED strips along with an
+// announcement of that kind, in the background.
+func flashLights(kind string) {
+	cfg := SysConfig.Lights
+	if !cfg.Enabled || !mirrorsKind(cfg.Kinds, kind) {
+		return
+	}
+	if !flashing.TryLock() {
+		return
+	}
+
+	go func() {
+		defer flashing.Unlock()
+
+		var wg sync.WaitGroup
+		if cfg.Hue.Bridge != "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := flashHue(cfg.Hue, cfg.Duration); err != nil {
+					logError("Failed to flash the Hue lights: %v", err)
+				}
+			}()
+		}
+		for _, strip := range cfg.WLED {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := flashWLED(strip, cfg.Duration); err != nil {
+					logError("Failed to flash the WLED lights at %s: %v", strip, err)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+}
+
+// flashHue starts the alert of the Hue lights and rooms, and stops it after
+// the duration. The lights go back to how they were by themselves.
+func flashHue(cfg HueConfig, duration time.Duration) error {
+	key := SysSecrets.Hue.APIKey
+	if key == "" {
+		return fmt.Errorf("hue.api_key is not set in the secrets")
+	}
+	base := "http://" + cfg.Bridge + "/api/" + url.PathEscape(key)
+	var paths []string
+	for _, light := range cfg.Lights {
+		paths = append(paths, "/lights/"+url.PathEscape(light)+"/state")
+	}
+	for _, group := range cfg.Groups {
+		paths = append(paths, "/groups/"+url.PathEscape(group)+"/action")
+	}
+
+	// lselect flashes for 15 seconds, unless stopped
+	var errs []error
+	for _, path := range paths {
+		errs = append(errs, hueRequest(base+path, `{"alert":"lselect"}`))
+	}
+	time.Sleep(duration)
+	for _, path := range paths {
+		errs = append(errs, hueRequest(base+path, `{"alert":"none"}`))
+	}
+	return errors.Join(errs...)
+}
+
+// hueRequest changes the state of a Hue light or room. The bridge answers
+// 200 to errors too, with the error in the body.
+func hueRequest(endpoint, body string) error {
+	req, err := http.NewRequest(http.MethodPut, endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := lightsRequest(req)
+	if err != nil {
+		return err
+	}
+
+	var answer []struct {
+		Error *struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(resp, &answer) == nil {
+		for _, a := range answer {
+			if a.Error != nil {
+				return fmt.Errorf("%s", a.Error.Description)
+			}
+		}
+	}
+	return nil
+}
+
+// flashWLED blinks the WLED strip at full brightness for the duration, then
+// puts it back the way it was.
+func flashWLED(strip string, duration time.Duration) error {
+	endpoint := strings.TrimRight(strip, "/") + "/json/state"
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	saved, err := lightsRequest(req)
+	if err != nil {
+		return fmt.Errorf("failed to read the state: %v", err)
+	}
+
+	flash := fmt.Sprintf(`{"on":true,"bri":255,"seg":{"fx":%d,"sx":240}}`, wledBlink)
+	if err := wledState(endpoint, []byte(flash)); err != nil {
+		return err
+	}
+	time.Sleep(duration)
+	if err := wledState(endpoint, saved); err != nil {
+		return fmt.Errorf("failed to restore the state: %v", err)
+	}
+	return nil
+}
+
+// wledState sets the state of a WLED strip.
+func wledState(endpoint string, state []byte) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(state))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = lightsRequest(req)
+	return err
+}
+
+// lightsRequest sends a request to a light on the local network and returns
+// the body of the answer.
+func lightsRequest(req *http.Request) ([]byte, error) {
+	resp, err := (&http.Client{Timeout: lightsTimeout}).Do(req)
+	if err != nil {
+		// the Hue URLs have the key in them
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("light answered %s", resp.Status)
+	}
+	return body, nil
+}
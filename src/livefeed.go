@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Kinds of live feed messages
+const (
+	liveTypeLog          = "log"
+	liveTypeAnnouncement = "announcement"
+)
+
+const (
+	// liveBuffer is how many messages a slow browser can fall behind before
+	// it misses some.
+	liveBuffer = 256
+	// liveKeepAlive is how often the connection is pinged, and the session
+	// checked.
+	liveKeepAlive = 30 * time.Second
+)
+
+// liveMessage is pushed to the browsers following the live feed.
+type liveMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+var (
+	liveMutex       sync.Mutex
+	liveSubscribers = map[chan liveMessage]struct{}{}
+)
+
+// subscribeLive returns a channel receiving the live feed, until it is
+// given to unsubscribeLive.
+func subscribeLive() chan liveMessage {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+
+	feed := make(chan liveMessage, liveBuffer)
+	liveSubscribers[feed] = struct{}{}
+	return feed
+}
+
+func unsubscribeLive(feed chan liveMessage) {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+	delete(liveSubscribers, feed)
+}
+
+// publishLive sends a message to every subscriber, it never blocks and must
+// not log, as the log lines are published too.
+func publishLive(kind string, data interface{}) {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+
+	msg := liveMessage{Type: kind, Data: data}
+	for feed := range liveSubscribers {
+		select {
+		case feed <- msg:
+		default:
+		}
+	}
+}
+
+// liveLogWriter publishes the log lines written to it.
+type liveLogWriter struct{}
+
+func (liveLogWriter) Write(p []byte) (int, error) {
+	publishLive(liveTypeLog, string(p))
+	return len(p), nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+const (
+	localSourceName         = "local"
+	defaultReminderDuration = 15 // minutes
+)
+
+// Shortcuts for the common repeat rules, anything else is taken as a cron
+// expression or an RRULE.
+var repeatPresets = map[string]string{
+	"daily":    "FREQ=DAILY",
+	"weekdays": "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR",
+	"weekly":   "FREQ=WEEKLY",
+	"monthly":  "FREQ=MONTHLY",
+	"yearly":   "FREQ=YEARLY",
+}
+
+// cronFields are the fields of a cron expression, as RRULE parts, with the
+// values they take.
+var cronFields = []struct {
+	part     string
+	min, max int
+}{
+	{"BYMINUTE", 0, 59},
+	{"BYHOUR", 0, 23},
+	{"BYMONTHDAY", 1, 31},
+	{"BYMONTH", 1, 12},
+	{"BYDAY", 0, 7},
+}
+
+// cronDays are the days of the week of cron, from Sunday, which is 7 too.
+var cronDays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// LocalReminder is a reminder created on the device, not tied to a calendar.
+type LocalReminder struct {
+	ID              string    `json:"id"`
+	Title           string    `json:"title"`
+	Start           time.Time `json:"start"`
+	DurationMinutes int       `json:"duration_minutes"`
+	Repeat          string    `json:"repeat,omitempty"` // RRULE, e.g. FREQ=WEEKLY;BYDAY=TU
+	Notes           string    `json:"notes,omitempty"`
+	Habit           bool      `json:"habit,omitempty"` // Keeps a streak of the repeats done
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// localSource is a CalendarSource for the local reminders.
+type localSource struct{}
+
+var localReminders sync.Mutex
+
+// Name returns the name of the source.
+func (s *localSource) Name() string {
+	return localSourceName
+}
+
+// FetchEvents returns the occurrences of the local reminders between start and end.
+func (s *localSource) FetchEvents(start, end time.Time) ([]CalendarEvent, error) {
+	reminders, err := loadLocalReminders()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []CalendarEvent{}
+	for _, r := range reminders {
+		duration := time.Duration(r.DurationMinutes) * time.Minute
+		event := CalendarEvent{
+			ID:          r.ID,
+			StartTime:   r.Start,
+			EndTime:     r.Start.Add(duration),
+			TimeZone:    time.Local.String(),
+			Description: r.Title,
+			Notes:       r.Notes,
+			Status:      eventStatusConfirmed,
+		}
+		if r.Habit {
+			event.Categories = []string{habitCategory}
+		}
+
+		if r.Repeat == "" {
+			if event.StartTime.Before(end) && event.EndTime.After(start) {
+				events = append(events, event)
+			}
+			continue
+		}
+
+		occurrences, err := expandReminder(r, start.Add(-duration), end)
+		if err != nil {
+			logError("failed to expand local reminder %s: %v", r.ID, err)
+			continue
+		}
+		for _, occ := range occurrences {
+			instance := event
+			instance.ID = instanceID(r.ID, occ)
+			instance.StartTime = occ
+			instance.EndTime = occ.Add(duration)
+			events = append(events, instance)
+		}
+	}
+
+	return events, nil
+}
+
+// expandReminder returns the start times of a repeating reminder between after and before.
+func expandReminder(r LocalReminder, after, before time.Time) ([]time.Time, error) {
+	roption, err := rrule.StrToROptionInLocation(r.Repeat, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repeat rule %q: %v", r.Repeat, err)
+	}
+	roption.Dtstart = r.Start
+
+	rule, err := rrule.NewRRule(*roption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build repeat rule %q: %v", r.Repeat, err)
+	}
+	return rule.Between(after, before, true), nil
+}
+
+// loadLocalReminders reads the local reminders from disk.
+func loadLocalReminders() ([]LocalReminder, error) {
+	localReminders.Lock()
+	defer localReminders.Unlock()
+	return readLocalReminders()
+}
+
+func readLocalReminders() ([]LocalReminder, error) {
+	reminders := []LocalReminder{}
+	data, err := os.ReadFile(realPath(remindersPath))
+	if os.IsNotExist(err) {
+		return reminders, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local reminders: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &reminders); err != nil {
+		return nil, fmt.Errorf("failed to parse local reminders: %v", err)
+	}
+	return reminders, nil
+}
+
+func writeLocalReminders(reminders []LocalReminder) error {
+	data, err := json.MarshalIndent(reminders, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal local reminders: %v", err)
+	}
+
+	path := realPath(remindersPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create reminders directory: %v", err)
+	}
+	return writeFileAtomically(path, data, 0644)
+}
+
+// addLocalReminder validates and stores a new local reminder.
+func addLocalReminder(r LocalReminder) (LocalReminder, error) {
+	r.Title = strings.TrimSpace(r.Title)
+	if r.Title == "" {
+		return r, fmt.Errorf("title is required")
+	}
+	if r.Start.IsZero() {
+		return r, fmt.Errorf("start time is required")
+	}
+	if r.DurationMinutes <= 0 {
+		r.DurationMinutes = defaultReminderDuration
+	}
+
+	r.Repeat = strings.TrimSpace(r.Repeat)
+	if preset, ok := repeatPresets[strings.ToLower(r.Repeat)]; ok {
+		r.Repeat = preset
+	}
+	r.Repeat = strings.TrimPrefix(r.Repeat, "RRULE:")
+	if rule, ok, err := cronToRRule(r.Repeat); err != nil {
+		return r, err
+	} else if ok {
+		r.Repeat = rule
+	}
+	if r.Repeat != "" {
+		if _, err := expandReminder(r, r.Start, r.Start); err != nil {
+			return r, err
+		}
+	}
+	if r.Habit && r.Repeat == "" {
+		return r, fmt.Errorf("a habit has to repeat")
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return r, fmt.Errorf("failed to generate reminder id: %v", err)
+	}
+	r.ID = "local-" + hex.EncodeToString(id)
+	r.CreatedAt = time.Now()
+
+	localReminders.Lock()
+	defer localReminders.Unlock()
+
+	reminders, err := readLocalReminders()
+	if err != nil {
+		return r, err
+	}
+	if err := writeLocalReminders(append(reminders, r)); err != nil {
+		return r, err
+	}
+
+	logInfo("Added local reminder %s: %s", r.ID, r.Title)
+	requestRefresh()
+	return r, nil
+}
+
+// deleteLocalReminder removes a local reminder, with all its occurrences.
+func deleteLocalReminder(id string) error {
+	localReminders.Lock()
+	defer localReminders.Unlock()
+
+	reminders, err := readLocalReminders()
+	if err != nil {
+		return err
+	}
+
+	kept := []LocalReminder{}
+	for _, r := range reminders {
+		if r.ID != id {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(reminders) {
+		return fmt.Errorf("reminder %q not found", id)
+	}
+
+	if err := writeLocalReminders(kept); err != nil {
+		return err
+	}
+
+	logInfo("Deleted local reminder %s", id)
+	requestRefresh()
+	return nil
+}
+
+// cronToRRule returns the RRULE of a cron expression, e.g. "30 7 * * 1-5" for
+// 7:30 on the weekdays, ok is false if it isn't one. The minutes and the
+// hours have to be set, and the days of the month and of the week can't both
+// be: cron takes a day matching either, which a single RRULE can't express.
+func cronToRRule(expr string) (rule string, ok bool, err error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) || strings.Contains(expr, "=") {
+		return "", false, nil
+	}
+	if fields[0] == "*" || fields[1] == "*" {
+		return "", true, fmt.Errorf("cron expression %q repeats too often, set the minutes and the hours", expr)
+	}
+	if fields[2] != "*" && fields[4] != "*" {
+		return "", true, fmt.Errorf("cron expression %q sets both the days of the month and of the week, set only one", expr)
+	}
+
+	parts := []string{"FREQ=DAILY", "BYSECOND=0"}
+	for i, field := range fields {
+		if field == "*" {
+			continue
+		}
+		values, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return "", true, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		list := make([]string, 0, len(values))
+		for _, v := range values {
+			if cronFields[i].part == "BYDAY" {
+				if !slices.Contains(list, cronDays[v%7]) {
+					list = append(list, cronDays[v%7])
+				}
+			} else {
+				list = append(list, strconv.Itoa(v))
+			}
+		}
+		parts = append(parts, cronFields[i].part+"="+strings.Join(list, ","))
+	}
+	return strings.Join(parts, ";"), true, nil
+}
+
+// parseCronField returns the values of a cron field, a list of values,
+// ranges like 1-5 and steps like */2 or 8-18/2.
+func parseCronField(field string, low, high int) ([]int, error) {
+	var values []int
+	seen := map[int]bool{}
+	for _, item := range strings.Split(field, ",") {
+		span, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		from, to := low, high
+		if span != "*" {
+			first, last, isRange := strings.Cut(span, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("invalid value %q", first)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				to = high
+			}
+		}
+		if from < low || to > high || from > to {
+			return nil, fmt.Errorf("%q is out of %d-%d", span, low, high)
+		}
+
+		for v := from; v <= to; v += step {
+			if !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	return values, nil
+}
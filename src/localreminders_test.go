@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCronToRRule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		rule    string
+		ok      bool
+		wantErr bool
+	}{
+		{"30 7 * * *", "FREQ=DAILY;BYSECOND=0;BYMINUTE=30;BYHOUR=7", true, false},
+		{"30 7 * * 1-5", "FREQ=DAILY;BYSECOND=0;BYMINUTE=30;BYHOUR=7;BYDAY=MO,TU,WE,TH,FR", true, false},
+		{"0 8,20 * * *", "FREQ=DAILY;BYSECOND=0;BYMINUTE=0;BYHOUR=8,20", true, false},
+		{"0 8-18/2 * * *", "FREQ=DAILY;BYSECOND=0;BYMINUTE=0;BYHOUR=8,10,12,14,16,18", true, false},
+		{"15 9 1,15 * *", "FREQ=DAILY;BYSECOND=0;BYMINUTE=15;BYHOUR=9;BYMONTHDAY=1,15", true, false},
+		{"0 12 25 12 *", "FREQ=DAILY;BYSECOND=0;BYMINUTE=0;BYHOUR=12;BYMONTHDAY=25;BYMONTH=12", true, false},
+		// Sunday is both 0 and 7
+		{"0 10 * * 0,7", "FREQ=DAILY;BYSECOND=0;BYMINUTE=0;BYHOUR=10;BYDAY=SU", true, false},
+		{"0 10 * * 6-7", "FREQ=DAILY;BYSECOND=0;BYMINUTE=0;BYHOUR=10;BYDAY=SA,SU", true, false},
+
+		// not cron, left to the RRULE parser
+		{"FREQ=DAILY", "", false, false},
+		{"FREQ=WEEKLY;BYDAY=MO TU WE TH FR", "", false, false},
+		{"30 7 * *", "", false, false},
+		{"", "", false, false},
+
+		{"* 7 * * *", "", true, true},
+		{"30 * * * *", "", true, true},
+		{"30 7 1 * 1", "", true, true},
+		{"30 7 1-7 * 1-5", "", true, true},
+		{"60 7 * * *", "", true, true},
+		{"30 24 * * *", "", true, true},
+		{"30 7 0 * *", "", true, true},
+		{"30 7 * 13 *", "", true, true},
+		{"30 7 * * 8", "", true, true},
+		{"30 7 * * mon", "", true, true},
+	}
+	for _, tt := range tests {
+		rule, ok, err := cronToRRule(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("cronToRRule(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+			continue
+		}
+		if ok != tt.ok || rule != tt.rule {
+			t.Errorf("cronToRRule(%q) = %q, %v, want %q, %v", tt.expr, rule, ok, tt.rule, tt.ok)
+		}
+	}
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field     string
+		low, high int
+		want      []int
+		wantErr   bool
+	}{
+		{"5", 0, 59, []int{5}, false},
+		{"1,3,5", 0, 59, []int{1, 3, 5}, false},
+		{"1-4", 0, 59, []int{1, 2, 3, 4}, false},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}, false},
+		{"10-20/5", 0, 59, []int{10, 15, 20}, false},
+		{"20/10", 0, 59, []int{20, 30, 40, 50}, false},
+		{"*", 1, 12, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, false},
+		// the values are listed once, in the order they come
+		{"5,1-3,2", 0, 59, []int{5, 1, 2, 3}, false},
+		{"0", 0, 23, []int{0}, false},
+		{"23", 0, 23, []int{23}, false},
+
+		{"24", 0, 23, nil, true},
+		{"0", 1, 31, nil, true},
+		{"5-1", 0, 59, nil, true},
+		{"*/0", 0, 59, nil, true},
+		{"*/x", 0, 59, nil, true},
+		{"a", 0, 59, nil, true},
+		{"1-b", 0, 59, nil, true},
+		{"", 0, 59, nil, true},
+		{"1,", 0, 59, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.low, tt.high)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCronField(%q, %d, %d) error = %v, want error %v", tt.field, tt.low, tt.high, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("parseCronField(%q, %d, %d) = %v, want %v", tt.field, tt.low, tt.high, got, tt.want)
+		}
+	}
+}
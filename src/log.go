@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -111,6 +112,9 @@ func (rw *RotatingWriter) Close() error {
 	return nil
 }
 
+// logWriter is the log file, closed on exit.
+var logWriter *RotatingWriter
+
 // setupLogging configures logrus to write logs to both console and file with rotation
 func setupLogging() {
 	const maxLogSize = 10 * 1024 * 1024 // 10MB
@@ -120,9 +124,10 @@ func setupLogging() {
 	if err != nil {
 		logrus.Fatal("Failed to create rotating log writer: ", err)
 	}
+	logWriter = rotatingWriter
 
-	// Set logrus to log to both stdout and the rotating log file
-	multiWriter := io.MultiWriter(os.Stdout, rotatingWriter)
+	// Set logrus to log to stdout, the rotating log file and the live feed
+	multiWriter := io.MultiWriter(os.Stdout, rotatingWriter, liveLogWriter{})
 	logrus.SetOutput(multiWriter)
 	logrus.SetLevel(logLevel)
 	logrus.SetFormatter(&logrus.TextFormatter{
@@ -134,6 +139,44 @@ func setupLogging() {
 	logrus.SetReportCaller(true)
 }
 
+// logLevelNames are the levels log_level can be set to, the most verbose
+// first. Fatal and panic would hide the errors.
+var logLevelNames = []string{"trace", "debug", "info", "warning", "error"}
+
+// configLogLevel returns the log level of the config. Without log_level,
+// debug_log_enabled picks debug or info.
+func configLogLevel(cfg Config) (logrus.Level, error) {
+	if cfg.LogLevel == "" {
+		if cfg.DebugLogEnabled {
+			return logrus.DebugLevel, nil
+		}
+		return logrus.InfoLevel, nil
+	}
+	level, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil || level < logrus.ErrorLevel {
+		return logrus.InfoLevel, fmt.Errorf("unknown log level %q, expected %s", cfg.LogLevel, strings.Join(logLevelNames, ", "))
+	}
+	return level, nil
+}
+
+// applyLogLevel sets the level of the console and file logs to the one of
+// the config, it takes effect on the next line logged.
+func applyLogLevel() {
+	level, err := configLogLevel(SysConfig)
+	if err != nil {
+		logWarn("%v, logging at the info level", err)
+	}
+	if level != logrus.GetLevel() {
+		logrus.SetLevel(level)
+		logInfo("Log level set to %s", level)
+	}
+}
+
+// currentLogLevel returns the name of the level the logs are written at.
+func currentLogLevel() string {
+	return logrus.GetLevel().String()
+}
+
 // logError is a convenience function for error logging
 func logError(format string, args ...interface{}) {
 	logrus.Errorf(format, args...)
@@ -151,9 +194,7 @@ func logInfo(format string, args ...interface{}) {
 
 // logDebug is a convenience function for debug logging
 func logDebug(format string, args ...interface{}) {
-	if SysConfig.DebugLogEnabled {
-		logrus.Debugf(format, args...)
-	}
+	logrus.Debugf(format, args...)
 }
 
 // logFatal is a convenience function for fatal logging
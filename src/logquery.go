@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultLogTail and maxLogTail are how many lines a page of the logs
+	// has, unless asked, and at most.
+	defaultLogTail = 500
+	maxLogTail     = 10000
+	// logChunkSize is how much of the log file is read at a time, from the end.
+	logChunkSize = 64 * 1024
+)
+
+// Log files, the current one and the one rotated out
+const (
+	logFileCurrent = "current"
+	logFileOld     = "old"
+)
+
+// logLineTime and logLineLevel are the fields logrus writes at the start of
+// the lines, e.g. time="2025-01-02T08:00:00+01:00" level=info msg="...".
+var (
+	logLineTime  = regexp.MustCompile(`^time="([^"]+)"`)
+	logLineLevel = regexp.MustCompile(` level=(\w+)`)
+)
+
+// LogQuery is what lines of the logs to return.
+type LogQuery struct {
+	Tail   int          // How many lines
+	Level  logrus.Level // Only this level and the more severe ones
+	Filter bool         // Whether Level is set
+	Search string       // Only the lines with this text, in any case
+	Since  time.Time    // Only the lines logged at or after this time
+	Until  time.Time    // Only the lines logged before this time
+	Before string       // Only the lines before this cursor, from the previous page
+}
+
+// LogPage is a page of the matching lines, the oldest first.
+type LogPage struct {
+	Lines  []string `json:"lines"`
+	Before string   `json:"before,omitempty"` // Cursor of the older lines, empty if there are none
+}
+
+// parseLogQuery reads the query of the logs from the request parameters:
+// tail, level, search, since, until and before.
+func parseLogQuery(get func(string) string) (LogQuery, error) {
+	q := LogQuery{Tail: defaultLogTail, Search: strings.TrimSpace(get("search")), Before: get("before")}
+	if tail := get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("invalid tail %q", tail)
+		}
+		q.Tail = min(n, maxLogTail)
+	}
+	if level := get("level"); level != "" {
+		l, err := logrus.ParseLevel(level)
+		if err != nil {
+			return q, fmt.Errorf("invalid level %q", level)
+		}
+		q.Level, q.Filter = l, true
+	}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		value := get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseLogTime(value)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s %q, expected a time like 2025-01-02T15:04", name, value)
+		}
+		*t = parsed
+	}
+	return q, nil
+}
+
+// parseLogTime parses a time as RFC 3339, or as the local time of a
+// datetime-local input.
+func parseLogTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// queryLogs returns the last lines of the logs that match the query, from
+// the current log file and then the rotated one. The files are read from
+// the end, only as far back as needed.
+func queryLogs(q LogQuery) (LogPage, error) {
+	file, end := logFileCurrent, int64(-1)
+	if q.Before != "" {
+		var err error
+		if file, end, err = parseLogCursor(q.Before); err != nil {
+			return LogPage{}, err
+		}
+	}
+
+	page := LogPage{Lines: []string{}}
+	paths := map[string]string{
+		logFileCurrent: realPath(logPath),
+		logFileOld:     realPath(logPath) + ".old",
+	}
+	for {
+		done := false
+		err := scanLinesBackward(paths[file], end, func(line string, offset int64) bool {
+			if len(page.Lines) == q.Tail {
+				page.Before = fmt.Sprintf("%s:%d", file, offset+int64(len(line))+1)
+				return false
+			}
+			match, older := q.matches(line)
+			if older {
+				done = true
+				return false
+			}
+			if match {
+				page.Lines = append(page.Lines, line)
+			}
+			return true
+		})
+		if err != nil {
+			return LogPage{}, err
+		}
+		if done || page.Before != "" || file == logFileOld {
+			break
+		}
+		file, end = logFileOld, -1
+	}
+
+	// oldest first
+	for i, j := 0, len(page.Lines)-1; i < j; i, j = i+1, j-1 {
+		page.Lines[i], page.Lines[j] = page.Lines[j], page.Lines[i]
+	}
+	return page, nil
+}
+
+// matches reports whether the line matches the query, and whether it was
+// logged before Since, so that no older line can match either.
+func (q LogQuery) matches(line string) (bool, bool) {
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		// the lines of a multi-line message have no time, they go with it
+		if m := logLineTime.FindStringSubmatch(line); m != nil {
+			if t, err := time.Parse(time.RFC3339, m[1]); err == nil {
+				if !q.Since.IsZero() && t.Before(q.Since) {
+					return false, true
+				}
+				if !q.Until.IsZero() && !t.Before(q.Until) {
+					return false, false
+				}
+			}
+		}
+	}
+	if q.Filter {
+		m := logLineLevel.FindStringSubmatch(line)
+		if m == nil {
+			return false, false
+		}
+		level, err := logrus.ParseLevel(m[1])
+		if err != nil || level > q.Level {
+			return false, false
+		}
+	}
+	if q.Search != "" && !strings.Contains(strings.ToLower(line), strings.ToLower(q.Search)) {
+		return false, false
+	}
+	return true, false
+}
+
+// parseLogCursor returns the file and the offset a cursor of a page points at.
+func parseLogCursor(cursor string) (string, int64, error) {
+	file, offset, ok := strings.Cut(cursor, ":")
+	end, err := strconv.ParseInt(offset, 10, 64)
+	if !ok || err != nil || end < 0 || (file != logFileCurrent && file != logFileOld) {
+		return "", 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return file, end, nil
+}
+
+// scanLinesBackward calls fn with the lines of the file that end before the
+// offset end, or the end of the file if negative, the last one first, until
+// fn returns false. The offset of each line is where it starts.
+func scanLinesBackward(path string, end int64, fn func(line string, offset int64) bool) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get log file info: %v", err)
+	}
+	if end < 0 || end > info.Size() {
+		end = info.Size()
+	}
+
+	// pending is the text from pos up to the lines already given, its
+	// first line may start in the chunk before
+	pos := end
+	pending := []byte{}
+	chunk := make([]byte, logChunkSize)
+	for pos > 0 {
+		n := min(int64(len(chunk)), pos)
+		pos -= n
+		if _, err := file.ReadAt(chunk[:n], pos); err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read log file: %v", err)
+		}
+		pending = append(append([]byte{}, chunk[:n]...), pending...)
+
+		for {
+			i := bytes.LastIndexByte(pending, '\n')
+			if i < 0 {
+				break
+			}
+			line := pending[i+1:]
+			pending = pending[:i]
+			if len(line) > 0 && !fn(string(line), pos+int64(i)+1) {
+				return nil
+			}
+		}
+	}
+	if len(pending) > 0 {
+		fn(string(pending), 0)
+	}
+	return nil
+}
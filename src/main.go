@@ -1,23 +1,41 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	logLevel       = logrus.DebugLevel
-	logPath        = "resources/app.log"
-	defaultConfig  = "resources/configs/config.yml"
-	defaultSecrets = "resources/configs/secrets.yml"
+	logLevel            = logrus.DebugLevel // Until the config is loaded
+	logPath             = "resources/app.log"
+	defaultConfig       = "resources/configs/config.yml"
+	defaultSecrets      = "resources/configs/secrets.yml"
+	reviewsPath         = "resources/history/reviews.jsonl"
+	historyPath         = "resources/history"
+	remindersPath       = "resources/reminders.json"
+	adherencePath       = "resources/history/medications.jsonl"
+	habitsPath          = "resources/history/habits.jsonl"
+	pausePath           = "resources/pause.json"
+	speechCachePath     = "resources/cache/tts"
+	ttsModelsPath       = "resources/models/tts"
+	soundsPath          = "resources/sounds"
+	installedModelsPath = "resources/models/tts/installed.yml"
+	tlsPath             = "resources/tls"
+	apiTokensPath       = "resources/configs/tokens.json"
+	configVersionsPath  = "resources/configs/versions"
+	webPushPath         = "resources/configs/webpush.json"
 )
 
 var (
 	SysRootDir string
 )
 
-func init() {
+// setup finds the root directory, starts the logging and loads the
+// configuration, the tests do what they need themselves.
+func setup() {
 	// Set the system root directory
 	var err error
 	SysRootDir, err = getAppRootDir()
@@ -35,6 +53,24 @@ func init() {
 }
 
 func main() {
+	setup()
+
+	// maintenance commands run instead of the reminders
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "events":
+			os.Exit(runEventsCommand(os.Args[2:]))
+		case "simulate":
+			os.Exit(runSimulateCommand(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n", os.Args[1])
+			os.Exit(2)
+		}
+	}
+
+	// the places the announcements are sent to besides the speaker
+	registerNotificationChannels()
+
 	// Setup web server for configuration management
 	setupWebServer()
 
@@ -43,38 +79,107 @@ func main() {
 		logrus.Fatal("Failed to initialize TTS system:", err)
 	}
 
+	// the audio output is opened again when playing, if it isn't there yet
+	if err := initAudio(); err != nil {
+		logError("Failed to open the audio output: %v", err)
+	}
+
+	// the cloud voice or the cached speech may still tell about it
+	if _, err := ttsModel(defaultTtsModel()); err != nil {
+		speakSystemMessage(SysMessages.SystemFailMessages)
+	}
+
+	// say goodbye when stopped
+	go handleShutdown()
+
 	// check internet connection
+	offline := false
 	for {
 		if !checkInternetConnection() {
-			aiSpeak("I'm sorry, but I can't access the internet right now, I will try again later.")
+			speakSystemMessage(SysMessages.SystemErrorMessages)
+			offline = true
 			time.Sleep(15 * time.Second)
 			continue
 		}
 
 		logDebug("Internet connection is available.")
-		aiSpeak("Hello, I'm ready to help you.")
+		if offline {
+			speakSystemMessage(SysMessages.SystemWakeMessages)
+		}
+		speakSystemMessage(SysMessages.SystemInitMessages)
 		break
 	}
 
 	// refresh tasks periodically in background
 	go refreshTasks()
 
-	// remind pending tasks periodocally
-	for {
-		remindCurrentEvents()
-		time.Sleep(10 * time.Second)
-	}
+	// and the holidays the event rules can depend on
+	go refreshHolidays()
+
+	// keep the Bluetooth speaker connected
+	go monitorBluetooth()
+
+	// and the big button acknowledging the reminders
+	go runButton()
+
+	// show how it is doing on the LED
+	go runStatusLED()
+
+	// and keep track of who is in the room to hear the announcements
+	go runPresenceSensor()
+
+	// show what is next on the screen
+	go runDisplay()
+
+	// and turn the volume with the knob
+	go runVolumeKnob()
+
+	// and keep it down while the room is dark
+	go runNightMode()
+
+	// keep track of the doses taken, and missed
+	go runDoseChecks()
+
+	// and keep the streaks of the habits
+	go runHabitChecks()
+
+	// wake up in the morning
+	go runWakeUpAlarms()
+
+	// mirror the announcements to Telegram, and take commands from there
+	go runTelegramBot()
+
+	// and listen for the wake word, and the commands said after it
+	onWake(handleVoiceCommand)
+	go listenForWakeWord()
+
+	// walk through the day's events every evening
+	go runDaily("review", reviewTime, reviewDay)
+
+	// and tell how it went
+	go runDaily("recap", recapTime, recapDay)
+
+	// remind pending tasks when they are due
+	runScheduler()
 }
 
 func refreshTasks() {
 	for {
-		todayEvents := getTodayCalEvents()
-		err := syncLocalEvents(todayEvents)
+		todayEvents, err := getTodayCalEvents()
+		if err != nil {
+			// keep what we have, an empty result here would delete every cached event
+			logError("Calendar refresh incomplete, keeping cached events: %v", err)
+		}
+		err = syncLocalEvents(todayEvents, err == nil)
 		if err != nil {
 			logrus.Error("Failed to save events:", err)
 		}
 
 		logDebug("Refreshed tasks from calendar, for today there is %d tasks.\n", len(todayEvents))
-		time.Sleep(15 * time.Second)
+
+		select {
+		case <-time.After(calendarPollInterval()):
+		case <-refreshRequests:
+		}
 	}
 }
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	medicationSourceName = "medications"
+	// medicationCategory is the category of the doses, for the event rules
+	// and sms.categories.
+	medicationCategory = "medication"
+	// doseCheckInterval is how often the doses are checked for a
+	// confirmation, or for being missed.
+	doseCheckInterval = time.Minute
+	// maxAdherenceDays limits how far back a single adherence report goes.
+	maxAdherenceDays = 366
+)
+
+// weekdays are the days of medications.schedule.days, as time.Weekday.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// DoseRecord is a single dose of the adherence history, taken or missed.
+type DoseRecord struct {
+	EventID     string    `json:"event_id"`
+	Medication  string    `json:"medication"`
+	Scheduled   time.Time `json:"scheduled"`
+	Taken       bool      `json:"taken"`
+	ConfirmedAt time.Time `json:"confirmed_at,omitempty"`
+}
+
+// MedicationAdherence counts the doses of a medication over a report.
+type MedicationAdherence struct {
+	Medication string  `json:"medication"`
+	Taken      int     `json:"taken"`
+	Missed     int     `json:"missed"`
+	Adherence  float64 `json:"adherence"` // Share of the doses taken, 0 to 1
+}
+
+// AdherenceReport is the adherence of each medication since a day, with the
+// doses, the last one first.
+type AdherenceReport struct {
+	From        time.Time             `json:"from"`
+	Medications []MedicationAdherence `json:"medications"`
+	Doses       []DoseRecord          `json:"doses"`
+}
+
+var adherenceLog sync.Mutex
+
+// doseProfile are the messages of the doses, the calendar profile of
+// "medications" or of medications.calendar can change them.
+var doseProfile = ReminderProfile{
+	AnnounceMessageTemplate:    MessageTemplate{"It's time to take your {{.Event}}. Please confirm once you have."},
+	RemindMessageTemplate:      MessageTemplate{"Did you take your {{.Event}}? Please confirm it."},
+	AnnounceEndMessageTemplate: MessageTemplate{"Last call for your {{.Event}}, it will be counted as missed."},
+}
+
+// medicationSource is a CalendarSource for the doses of medications.schedule,
+// an event per dose lasting for medications.window.
+type medicationSource struct{}
+
+// Name returns the name of the source.
+func (s *medicationSource) Name() string {
+	return medicationSourceName
+}
+
+// FetchEvents returns the doses between start and end.
+func (s *medicationSource) FetchEvents(start, end time.Time) ([]CalendarEvent, error) {
+	cfg := SysConfig.Medications
+	events := []CalendarEvent{}
+	if !cfg.Enabled {
+		return events, nil
+	}
+
+	for day := startOfDay(start.Add(-cfg.Window)); day.Before(end); day = day.AddDate(0, 0, 1) {
+		for _, med := range cfg.Schedule {
+			if len(med.Days) > 0 && !slices.Contains(med.Days, weekdays[day.Weekday()]) {
+				continue
+			}
+			for _, at := range med.Times {
+				clock, err := time.Parse("15:04", at)
+				if err != nil {
+					logError("invalid time %q of medication %s: %v", at, med.Name, err)
+					continue
+				}
+				doseTime := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
+				event := CalendarEvent{
+					// by name, the doses keep their ID when the schedule is reordered
+					ID:          fmt.Sprintf("medication-%s-%s", medicationSlug(med.Name), doseTime.Format("200601021504")),
+					StartTime:   doseTime,
+					EndTime:     doseTime.Add(cfg.Window),
+					TimeZone:    time.Local.String(),
+					Description: medicationTitle(med),
+					Notes:       med.Notes,
+					Status:      eventStatusConfirmed,
+					Categories:  []string{medicationCategory},
+				}
+				if event.StartTime.Before(end) && event.EndTime.After(start) {
+					events = append(events, event)
+				}
+			}
+		}
+	}
+	return events, nil
+}
+
+// medicationSlug returns the name of the medication for the IDs of its doses,
+// in lower case with dashes, e.g. "vitamin-d" for "Vitamin D".
+func medicationSlug(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return slug.String()
+}
+
+// medicationTitle returns what a dose of the medication is announced as,
+// e.g. "Metformin, 500 mg".
+func medicationTitle(med Medication) string {
+	if med.Dose == "" {
+		return med.Name
+	}
+	return med.Name + ", " + med.Dose
+}
+
+// isDose returns true if the event is a dose of a medication, from
+// medications.schedule or from the medications.calendar.
+func isDose(e *CalendarEvent) bool {
+	cfg := SysConfig.Medications
+	if !cfg.Enabled || e.AllDay {
+		return false
+	}
+	return e.Source == medicationSourceName || (cfg.Calendar != "" && inCalendar(e, cfg.Calendar))
+}
+
+// runDoseChecks records the doses once they are confirmed, or missed.
+func runDoseChecks() {
+	ticker := time.NewTicker(doseCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkDoses()
+	}
+}
+
+// checkDoses records the doses of today that were confirmed, acknowledged or
+// completed from the voice, the button, the web interface or a chat, and
+// the ones that were not within medications.window of their time.
+func checkDoses() {
+	if simulating || !SysConfig.Medications.Enabled {
+		return
+	}
+	events, err := loadAllTodayEvents()
+	if err != nil {
+		logError("failed to load events for the doses: %v", err)
+		return
+	}
+
+	now := clockNow()
+	for i := range events {
+		e := &events[i]
+		if e.DoseRecorded || !isDose(&e.Event) {
+			continue
+		}
+		taken := e.Acknowledged || e.Completed
+		if !taken && now.Before(e.Event.StartTime.Add(SysConfig.Medications.Window)) {
+			continue
+		}
+
+		// marked first, a dose is not recorded twice
+		if err := e.update(func(l *LocalEvent) { l.DoseRecorded = true }); err != nil {
+			logError("failed to save the dose of event %s: %v", e.Event.ID, err)
+			continue
+		}
+		record := DoseRecord{
+			EventID:    e.Event.ID,
+			Medication: stripEventTags(e.Event.Description),
+			Scheduled:  e.Event.StartTime,
+			Taken:      taken,
+		}
+		if taken {
+			record.ConfirmedAt = e.AcknowledgedAt
+			if record.ConfirmedAt.IsZero() || (!e.CompletedAt.IsZero() && e.CompletedAt.Before(record.ConfirmedAt)) {
+				record.ConfirmedAt = e.CompletedAt
+			}
+		}
+		if err := appendDoseRecord(record); err != nil {
+			logError("failed to record the dose of event %s: %v", e.Event.ID, err)
+		}
+
+		if taken {
+			logInfo("Dose of %s at %s taken", record.Medication, e.Event.StartTime.Format("15:04"))
+			continue
+		}
+		missedDose(e)
+	}
+}
+
+// missedDose tells about a dose that was not confirmed, out loud and to the
+// channels that want missed_dose, and texts the caregivers unless in a dry
+// run.
+func missedDose(e *LocalEvent) {
+	logWarn("Dose of %s at %s missed", e.Event.Description, e.Event.StartTime.Format("15:04"))
+	text := fmt.Sprintf("The %s dose of %s was not confirmed.", e.Event.StartTime.Format("15:04"), e.spokenTitle())
+	// the caregivers are still told while paused
+	if !isPaused() {
+		announceTask(e, announceKindMissedDose, text)
+	}
+
+	if SysConfig.DryRun || !smsEnabled() || e.CaregiversTexted {
+		return
+	}
+	if err := e.update(func(l *LocalEvent) { l.CaregiversTexted = true }); err != nil {
+		logError("failed to save the text about event %s: %v", e.Event.ID, err)
+		return
+	}
+	textCaregivers(e, text)
+}
+
+// appendDoseRecord adds the dose to the adherence history.
+func appendDoseRecord(record DoseRecord) error {
+	adherenceLog.Lock()
+	defer adherenceLog.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dose: %v", err)
+	}
+
+	path := realPath(adherencePath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open adherence history: %v", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// adherenceReport returns the adherence to each medication of the doses
+// scheduled since from.
+func adherenceReport(from time.Time) (AdherenceReport, error) {
+	report := AdherenceReport{From: from, Medications: []MedicationAdherence{}, Doses: []DoseRecord{}}
+
+	adherenceLog.Lock()
+	defer adherenceLog.Unlock()
+	f, err := os.Open(realPath(adherencePath))
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return report, fmt.Errorf("failed to open adherence history: %v", err)
+	}
+	defer f.Close()
+
+	counts := map[string]*MedicationAdherence{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record DoseRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a line cut short by a power loss, skip it
+			continue
+		}
+		if record.Scheduled.Before(from) {
+			continue
+		}
+		report.Doses = append(report.Doses, record)
+
+		c, ok := counts[record.Medication]
+		if !ok {
+			c = &MedicationAdherence{Medication: record.Medication}
+			counts[record.Medication] = c
+		}
+		if record.Taken {
+			c.Taken++
+		} else {
+			c.Missed++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return report, fmt.Errorf("failed to read adherence history: %v", err)
+	}
+
+	for _, c := range counts {
+		c.Adherence = float64(c.Taken) / float64(c.Taken+c.Missed)
+		report.Medications = append(report.Medications, *c)
+	}
+	sort.Slice(report.Medications, func(i, j int) bool {
+		return strings.ToLower(report.Medications[i].Medication) < strings.ToLower(report.Medications[j].Medication)
+	})
+	sort.SliceStable(report.Doses, func(i, j int) bool { return report.Doses[i].Scheduled.After(report.Doses[j].Scheduled) })
+	return report, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	// micSampleRate is the rate the speech models expect.
+	micSampleRate = 16000
+	// micChunk is how much audio is read at once.
+	micChunk = 100 * time.Millisecond
+	// speechLevel is the sound level taken as someone speaking.
+	speechLevel = 0.02
+	// speechPause ends the recording once someone spoke.
+	speechPause = 1500 * time.Millisecond
+	// micHandover is how long a recording waits for the wake word listener
+	// to hand over the microphone, before opening it itself.
+	micHandover = 500 * time.Millisecond
+)
+
+// micRequest asks the wake word listener, which keeps the microphone open,
+// for a recording.
+type micRequest struct {
+	window time.Duration
+	result chan micResult
+}
+
+type micResult struct {
+	samples []float32
+	err     error
+}
+
+var micRequests = make(chan micRequest)
+
+// microphone is a running capture of an ALSA input device with arecord,
+// mono 16-bit at micSampleRate.
+type microphone struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	buf    []byte
+}
+
+// openMicrophone starts capturing the device, the default one if empty.
+func openMicrophone(device string) (*microphone, error) {
+	if device == "" {
+		device = "default"
+	}
+
+	cmd := exec.Command("arecord", "-q", "-D", device, "-t", "raw", "-f", "S16_LE",
+		"-r", strconv.Itoa(micSampleRate), "-c", "1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to record from %s: %v", device, err)
+	}
+
+	samples := int(micChunk.Seconds() * micSampleRate)
+	return &microphone{cmd: cmd, stdout: stdout, buf: make([]byte, samples*2)}, nil
+}
+
+// read returns the next chunk of samples, from -1 to 1.
+func (m *microphone) read() ([]float32, error) {
+	if _, err := io.ReadFull(m.stdout, m.buf); err != nil {
+		return nil, fmt.Errorf("failed to read the microphone: %v", err)
+	}
+
+	samples := make([]float32, len(m.buf)/2)
+	for i := range samples {
+		samples[i] = float32(int16(binary.LittleEndian.Uint16(m.buf[i*2:]))) / 32768
+	}
+	return samples, nil
+}
+
+// close stops the capture.
+func (m *microphone) close() {
+	m.cmd.Process.Kill()
+	m.cmd.Wait()
+}
+
+// soundLevel returns the RMS level of the samples, from 0 to 1.
+func soundLevel(samples []float32) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// recordSpeech records what is said, until a pause or the end of the
+// window. The samples read before played is closed are dropped, if it is
+// given. It returns nothing if nobody spoke.
+func recordSpeech(mic *microphone, window time.Duration, played <-chan struct{}) ([]float32, error) {
+	deadline := time.Now().Add(window)
+	speech := []float32{}
+	heard := false
+	var quiet time.Duration
+	for time.Now().Before(deadline) {
+		samples, err := mic.read()
+		if err != nil {
+			return nil, err
+		}
+		if played != nil {
+			select {
+			case <-played:
+			default:
+				continue
+			}
+		}
+
+		speech = append(speech, samples...)
+		if soundLevel(samples) >= speechLevel {
+			heard = true
+			quiet = 0
+			continue
+		}
+		quiet += micChunk
+		if heard && quiet >= speechPause {
+			break
+		}
+	}
+
+	if !heard {
+		return nil, nil
+	}
+	return speech, nil
+}
+
+// listen records what is said next, on the microphone of the wake word
+// listener if it is running.
+func listen(window time.Duration) ([]float32, error) {
+	req := micRequest{window: window, result: make(chan micResult, 1)}
+	select {
+	case micRequests <- req:
+		res := <-req.result
+		return res.samples, res.err
+	case <-time.After(micHandover):
+	}
+
+	mic, err := openMicrophone(SysConfig.Microphone)
+	if err != nil {
+		return nil, err
+	}
+	defer mic.close()
+	return recordSpeech(mic, window, nil)
+}
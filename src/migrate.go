@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// eventSchemaVersion is the version of the LocalEvent format written by this
+// build. Bump it and add a migration whenever a change to LocalEvent needs the
+// stored files to be converted.
+const eventSchemaVersion = 2
+
+// eventMigrations upgrade a stored event one version at a time, the migration
+// at index n converts version n to n+1. Files written before the version was
+// introduced are version 0.
+var eventMigrations = []func(raw map[string]json.RawMessage) error{
+	// 0 -> 1: the version field is introduced, every later field defaults to
+	// its zero value, so there is nothing else to convert
+	func(raw map[string]json.RawMessage) error { return nil },
+	// 1 -> 2: the single start check became a number of follow-ups
+	func(raw map[string]json.RawMessage) error {
+		var checked bool
+		if v, ok := raw["CheckStartAnnounced"]; ok {
+			if err := json.Unmarshal(v, &checked); err != nil {
+				return fmt.Errorf("invalid CheckStartAnnounced: %v", err)
+			}
+		}
+		delete(raw, "CheckStartAnnounced")
+		if checked {
+			raw["CheckStartsSent"] = json.RawMessage("1")
+		}
+		return nil
+	},
+}
+
+// migrateEvent upgrades the stored form of an event to the current schema
+// version. It returns the upgraded data and whether anything changed, events
+// written by a newer build are refused rather than read without their state.
+func migrateEvent(data []byte) ([]byte, bool, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, false, err
+	}
+
+	version := 0
+	if v, ok := raw["SchemaVersion"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, false, fmt.Errorf("invalid schema version: %v", err)
+		}
+	}
+	if version > eventSchemaVersion {
+		return nil, false, fmt.Errorf("schema version %d is newer than the supported %d", version, eventSchemaVersion)
+	}
+	if version == eventSchemaVersion {
+		return data, false, nil
+	}
+
+	for ; version < eventSchemaVersion; version++ {
+		if err := eventMigrations[version](raw); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate from schema version %d: %v", version, err)
+		}
+	}
+	raw["SchemaVersion"] = json.RawMessage(fmt.Sprint(eventSchemaVersion))
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	return migrated, true, nil
+}
+
+// decodeEvent parses a stored event, upgrading it to the current schema
+// version. The returned data is the event as it is written by this build.
+func decodeEvent(data []byte) ([]byte, LocalEvent, bool, error) {
+	var e LocalEvent
+
+	migrated, changed, err := migrateEvent(data)
+	if err != nil {
+		return nil, LocalEvent{}, false, err
+	}
+	if err := json.Unmarshal(migrated, &e); err != nil {
+		return nil, LocalEvent{}, false, err
+	}
+	if e.Event.ID == "" {
+		return nil, LocalEvent{}, false, fmt.Errorf("event has no id")
+	}
+
+	if !changed {
+		return data, e, false, nil
+	}
+	eJson, err := json.MarshalIndent(e, "", " ")
+	if err != nil {
+		return nil, LocalEvent{}, false, err
+	}
+	return eJson, e, true, nil
+}
+
+// runEventsCommand handles the "events" command line, which checks or
+// migrates the event store without starting the reminders:
+//
+//	simple-reminder events check
+//	simple-reminder events migrate
+//
+// It returns the process exit code.
+func runEventsCommand(args []string) int {
+	if len(args) != 1 || (args[0] != "check" && args[0] != "migrate") {
+		fmt.Fprintf(os.Stderr, "Usage: %s events check|migrate\n", os.Args[0])
+		return 2
+	}
+	migrate := args[0] == "migrate"
+
+	eventDir := realPath(SysConfig.EventsPath)
+	files, err := os.ReadDir(eventDir)
+	if os.IsNotExist(err) {
+		fmt.Printf("No events in %s\n", eventDir)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read events directory: %v\n", err)
+		return 1
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+
+	outdated, broken := 0, 0
+	for _, name := range names {
+		data, err := os.ReadFile(path.Join(eventDir, name))
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			broken++
+			continue
+		}
+
+		eJson, e, changed, err := decodeEvent(data)
+		if err == nil {
+			var fileName string
+			if fileName, err = eventFileName(e.Event.ID); err == nil && fileName != name {
+				err = fmt.Errorf("file name does not match event id %q", e.Event.ID)
+			}
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			broken++
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		outdated++
+		if !migrate {
+			fmt.Printf("%s: needs migration\n", name)
+			continue
+		}
+		if err := writeFileAtomically(path.Join(eventDir, name), eJson, 0644); err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			broken++
+			continue
+		}
+		fmt.Printf("%s: migrated\n", name)
+	}
+
+	fmt.Printf("%d events, %d outdated, %d invalid, schema version %d\n", len(names), outdated, broken, eventSchemaVersion)
+	if broken > 0 || (!migrate && outdated > 0) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEventMigrationsCoverSchema(t *testing.T) {
+	if len(eventMigrations) != eventSchemaVersion {
+		t.Errorf("%d migrations for schema version %d, one is needed per version", len(eventMigrations), eventSchemaVersion)
+	}
+}
+
+func TestMigrateEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		changed bool
+		wantErr bool
+		// fields of the migrated event, nil for absent
+		want map[string]any
+	}{
+		{
+			name: "version 0, start checked", data: `{"Event":{"ID":"a"},"CheckStartAnnounced":true}`, changed: true,
+			want: map[string]any{"SchemaVersion": 2.0, "CheckStartsSent": 1.0, "CheckStartAnnounced": nil},
+		},
+		{
+			name: "version 0, start not checked", data: `{"Event":{"ID":"a"},"CheckStartAnnounced":false}`, changed: true,
+			want: map[string]any{"SchemaVersion": 2.0, "CheckStartsSent": nil, "CheckStartAnnounced": nil},
+		},
+		{
+			name: "version 1", data: `{"SchemaVersion":1,"Event":{"ID":"a"},"CheckStartAnnounced":true,"Acknowledged":true}`, changed: true,
+			want: map[string]any{"SchemaVersion": 2.0, "CheckStartsSent": 1.0, "Acknowledged": true},
+		},
+		{
+			name: "current version", data: `{"SchemaVersion":2,"Event":{"ID":"a"},"CheckStartsSent":3}`,
+			want: map[string]any{"SchemaVersion": 2.0, "CheckStartsSent": 3.0},
+		},
+		{name: "newer version", data: `{"SchemaVersion":3,"Event":{"ID":"a"}}`, wantErr: true},
+		{name: "invalid version", data: `{"SchemaVersion":"two"}`, wantErr: true},
+		{name: "invalid start check", data: `{"CheckStartAnnounced":"yes"}`, wantErr: true},
+		{name: "not json", data: `{"Event":`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		migrated, changed, err := migrateEvent([]byte(tt.data))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: migrateEvent() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if changed != tt.changed {
+			t.Errorf("%s: migrateEvent() changed = %v, want %v", tt.name, changed, tt.changed)
+		}
+		if !changed && string(migrated) != tt.data {
+			t.Errorf("%s: unchanged event rewritten as %s", tt.name, migrated)
+		}
+
+		var fields map[string]any
+		if err := json.Unmarshal(migrated, &fields); err != nil {
+			t.Errorf("%s: migrated event is not JSON: %v", tt.name, err)
+			continue
+		}
+		for field, want := range tt.want {
+			got, ok := fields[field]
+			if want == nil {
+				if ok {
+					t.Errorf("%s: %s = %v, want it removed", tt.name, field, got)
+				}
+				continue
+			}
+			if got != want {
+				t.Errorf("%s: %s = %v, want %v", tt.name, field, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	data, e, changed, err := decodeEvent([]byte(`{"Event":{"ID":"evt-1"},"CheckStartAnnounced":true}`))
+	if err != nil {
+		t.Fatalf("decodeEvent() error = %v", err)
+	}
+	if !changed || e.Event.ID != "evt-1" || e.CheckStartsSent != 1 || e.SchemaVersion != eventSchemaVersion {
+		t.Errorf("decodeEvent() = %+v changed %v", e, changed)
+	}
+
+	// what is written back decodes as is
+	if _, again, changed, err := decodeEvent(data); err != nil || changed || again.CheckStartsSent != 1 {
+		t.Errorf("decodeEvent() of the migrated event = %+v changed %v error %v", again, changed, err)
+	}
+
+	if _, _, _, err := decodeEvent([]byte(`{"SchemaVersion":2}`)); err == nil {
+		t.Errorf("decodeEvent() of an event without ID succeeded")
+	}
+}
+
+func TestRunEventsCommand(t *testing.T) {
+	root, events := SysRootDir, SysConfig.EventsPath
+	defer func() { SysRootDir, SysConfig.EventsPath = root, events }()
+	SysRootDir = t.TempDir()
+	SysConfig.EventsPath = "events"
+
+	if code := runEventsCommand([]string{"check"}); code != 0 {
+		t.Errorf("check without events exit code = %d, want 0", code)
+	}
+	if code := runEventsCommand([]string{"fix"}); code != 2 {
+		t.Errorf("unknown command exit code = %d, want 2", code)
+	}
+
+	dir := filepath.Join(SysRootDir, "events")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("old.json", `{"Event":{"ID":"old"},"CheckStartAnnounced":true}`)
+	write("new.json", `{"SchemaVersion":2,"Event":{"ID":"new"}}`)
+	write("notes.txt", "not an event")
+
+	if code := runEventsCommand([]string{"check"}); code != 1 {
+		t.Errorf("check with an outdated event exit code = %d, want 1", code)
+	}
+	if code := runEventsCommand([]string{"migrate"}); code != 0 {
+		t.Errorf("migrate exit code = %d, want 0", code)
+	}
+	if code := runEventsCommand([]string{"check"}); code != 0 {
+		t.Errorf("check after the migration exit code = %d, want 0", code)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "old.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e LocalEvent
+	if err := json.Unmarshal(data, &e); err != nil || e.SchemaVersion != eventSchemaVersion || e.CheckStartsSent != 1 {
+		t.Errorf("migrated file = %s, error %v", data, err)
+	}
+
+	// a file not named after its event is invalid, migrating doesn't fix it
+	write("renamed.json", `{"SchemaVersion":2,"Event":{"ID":"other"}}`)
+	if code := runEventsCommand([]string{"migrate"}); code != 1 {
+		t.Errorf("migrate with an invalid event exit code = %d, want 1", code)
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	modelDownloadTimeout = 30 * time.Minute
+	modelManifestTimeout = 30 * time.Second
+)
+
+// States of a model of the manifest
+const (
+	modelStateAvailable   = "available"
+	modelStateDownloading = "downloading"
+	modelStateInstalled   = "installed"
+	modelStateFailed      = "failed"
+)
+
+// ModelManifest lists the voices that can be downloaded.
+type ModelManifest struct {
+	Models []ManifestModel `yaml:"models"`
+}
+
+// ManifestModel is a voice of the manifest, its definition paths are
+// relative to the models directory.
+type ManifestModel struct {
+	Name        string             `yaml:"name"`
+	Description string             `yaml:"description"`
+	Language    string             `yaml:"language"`
+	Files       []ManifestFile     `yaml:"files"`
+	Definition  TtsModelDefinition `yaml:"definition"`
+}
+
+// ManifestFile is a file to download for a model.
+type ManifestFile struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"` // Checked when given
+	Path   string `yaml:"path"`   // Where the file goes in the models directory, archives are unpacked there instead
+}
+
+// installedModels are the downloaded models and the model chosen at runtime,
+// kept on disk next to the models.
+type installedModels struct {
+	Active string                        `yaml:"active"`
+	Models map[string]TtsModelDefinition `yaml:"models"`
+}
+
+// ModelStatus is a model as listed by the API.
+type ModelStatus struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Language    string `json:"language"`
+	State       string `json:"state"`
+	Error       string `json:"error,omitempty"`
+	Downloaded  int64  `json:"downloaded,omitempty"` // Bytes, while downloading
+	Active      bool   `json:"active"`
+}
+
+var (
+	modelsMutex     sync.Mutex
+	installed       installedModels
+	installedLoaded bool
+	// downloads are the models being downloaded or that failed to
+	downloads = make(map[string]*ModelStatus)
+)
+
+// loadInstalledModels reads the installed models from disk, once. The caller
+// must hold modelsMutex.
+func loadInstalledModels() {
+	if installedLoaded {
+		return
+	}
+	installedLoaded = true
+
+	data, err := os.ReadFile(realPath(installedModelsPath))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logError("failed to read installed models: %v", err)
+		return
+	}
+	if err := yaml.Unmarshal(data, &installed); err != nil {
+		logError("failed to parse installed models: %v", err)
+	}
+}
+
+// saveInstalledModels writes the installed models. The caller must hold modelsMutex.
+func saveInstalledModels() error {
+	data, err := yaml.Marshal(installed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal installed models: %v", err)
+	}
+	if err := writeFileAtomically(realPath(installedModelsPath), data, 0644); err != nil {
+		return fmt.Errorf("failed to save installed models: %v", err)
+	}
+	return nil
+}
+
+// installedModel returns the definition of a downloaded model.
+func installedModel(name string) (TtsModelDefinition, bool) {
+	modelsMutex.Lock()
+	defer modelsMutex.Unlock()
+
+	loadInstalledModels()
+	def, ok := installed.Models[name]
+	return def, ok
+}
+
+// activeTtsModel returns the model chosen at runtime, if any.
+func activeTtsModel() string {
+	modelsMutex.Lock()
+	defer modelsMutex.Unlock()
+
+	loadInstalledModels()
+	return installed.Active
+}
+
+// loadModelManifest reads the manifest, from a file or a URL.
+func loadModelManifest() (ModelManifest, error) {
+	var manifest ModelManifest
+	source := SysConfig.TtsManifest
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{Timeout: modelManifestTimeout}
+		var resp *http.Response
+		if resp, err = client.Get(source); err != nil {
+			return manifest, fmt.Errorf("failed to fetch model manifest: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return manifest, fmt.Errorf("failed to fetch model manifest: %s", resp.Status)
+		}
+		data, err = io.ReadAll(resp.Body)
+	} else {
+		data, err = os.ReadFile(realPath(source))
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read model manifest: %v", err)
+	}
+
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse model manifest: %v", err)
+	}
+	return manifest, nil
+}
+
+// listModels returns the models of the manifest and the configured ones.
+func listModels() ([]ModelStatus, error) {
+	manifest, err := loadModelManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	active := defaultTtsModel()
+	modelsMutex.Lock()
+	defer modelsMutex.Unlock()
+	loadInstalledModels()
+
+	list := []ModelStatus{}
+	seen := make(map[string]bool)
+	for _, m := range manifest.Models {
+		status := ModelStatus{Name: m.Name, Description: m.Description, Language: m.Language, State: modelStateAvailable}
+		if _, ok := installed.Models[m.Name]; ok {
+			status.State = modelStateInstalled
+		}
+		if d, ok := downloads[m.Name]; ok {
+			status.State, status.Error, status.Downloaded = d.State, d.Error, d.Downloaded
+		}
+		status.Active = m.Name == active
+		list = append(list, status)
+		seen[m.Name] = true
+	}
+
+	// the models of the config can be switched to as well
+	configured := []string{ttsModelKokoro, ttsModelGlados}
+	for name := range SysConfig.TtsModels {
+		configured = append(configured, strings.ToLower(name))
+	}
+	for name := range installed.Models {
+		configured = append(configured, name)
+	}
+	sort.Strings(configured)
+	for _, name := range configured {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		list = append(list, ModelStatus{Name: name, Description: "From the configuration", State: modelStateInstalled, Active: name == active})
+	}
+	return list, nil
+}
+
+// installModel starts downloading a model of the manifest, in the background.
+func installModel(name string) error {
+	manifest, err := loadModelManifest()
+	if err != nil {
+		return err
+	}
+	var model *ManifestModel
+	for i := range manifest.Models {
+		if manifest.Models[i].Name == name {
+			model = &manifest.Models[i]
+		}
+	}
+	if model == nil {
+		return fmt.Errorf("unknown model %q", name)
+	}
+	if len(model.Files) == 0 {
+		return fmt.Errorf("model %q has no files", name)
+	}
+
+	modelsMutex.Lock()
+	defer modelsMutex.Unlock()
+	if d, ok := downloads[name]; ok && d.State == modelStateDownloading {
+		return fmt.Errorf("model %q is already being downloaded", name)
+	}
+	downloads[name] = &ModelStatus{Name: name, State: modelStateDownloading}
+
+	go func() {
+		err := downloadModel(*model)
+		modelsMutex.Lock()
+		defer modelsMutex.Unlock()
+		if err != nil {
+			logError("Failed to install the %s TTS model: %v", name, err)
+			downloads[name] = &ModelStatus{Name: name, State: modelStateFailed, Error: err.Error()}
+			return
+		}
+		delete(downloads, name)
+		logInfo("Installed the %s TTS model", name)
+	}()
+	return nil
+}
+
+// downloadModel downloads and verifies the files of the model, then adds it
+// to the installed models.
+func downloadModel(model ManifestModel) error {
+	dir := realPath(ttsModelsPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create models directory: %v", err)
+	}
+
+	for _, file := range model.Files {
+		logInfo("Downloading %s for the %s TTS model", file.URL, model.Name)
+		if err := downloadModelFile(model.Name, file, dir); err != nil {
+			return err
+		}
+	}
+
+	// the paths of the manifest are in the models directory
+	def := model.Definition
+	for _, p := range []*string{&def.Model, &def.Vocoder, &def.Voices, &def.Tokens, &def.Lexicon, &def.DataDir, &def.DictDir, &def.RuleFsts} {
+		list := []string{}
+		for _, part := range strings.Split(*p, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, path.Join(ttsModelsPath, part))
+			}
+		}
+		*p = strings.Join(list, ",")
+	}
+
+	// a model missing one of its files would only fail when it is used
+	for _, p := range []string{def.Model, def.Vocoder, def.Voices, def.Tokens, def.DataDir} {
+		if p == "" {
+			continue
+		}
+		if _, err := os.Stat(realPath(p)); err != nil {
+			return fmt.Errorf("model file %s is missing after the download", p)
+		}
+	}
+	if _, err := definedModelConfig(sherpa.OfflineTtsConfig{}, def); err != nil {
+		return fmt.Errorf("invalid model definition: %v", err)
+	}
+
+	modelsMutex.Lock()
+	defer modelsMutex.Unlock()
+	loadInstalledModels()
+	if installed.Models == nil {
+		installed.Models = make(map[string]TtsModelDefinition)
+	}
+	installed.Models[model.Name] = def
+	return saveInstalledModels()
+}
+
+// downloadModelFile downloads a file into the models directory, checking its
+// checksum, archives are unpacked.
+func downloadModelFile(name string, file ManifestFile, dir string) error {
+	client := &http.Client{Timeout: modelDownloadTimeout}
+	resp, err := client.Get(file.URL)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", file.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", file.URL, resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	progress := &downloadProgress{name: name}
+	if _, err := io.Copy(io.MultiWriter(tmp, hash, progress), resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %v", file.URL, err)
+	}
+	if file.SHA256 != "" {
+		if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, file.SHA256) {
+			return fmt.Errorf("checksum mismatch for %s: got %s, expected %s", file.URL, sum, file.SHA256)
+		}
+	}
+
+	if archiveKind(file.URL) != "" {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return extractArchive(tmp, archiveKind(file.URL), dir)
+	}
+
+	if file.Path == "" || !filepath.IsLocal(file.Path) {
+		return fmt.Errorf("invalid path %q for %s", file.Path, file.URL)
+	}
+	target := filepath.Join(dir, file.Path)
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// downloadProgress counts the downloaded bytes of a model, for the API.
+type downloadProgress struct {
+	name string
+}
+
+func (p *downloadProgress) Write(data []byte) (int, error) {
+	modelsMutex.Lock()
+	defer modelsMutex.Unlock()
+	if d, ok := downloads[p.name]; ok {
+		d.Downloaded += int64(len(data))
+	}
+	return len(data), nil
+}
+
+// archiveKind returns the compression of an archive URL, empty if it isn't one.
+func archiveKind(url string) string {
+	switch {
+	case strings.HasSuffix(url, ".tar.bz2"):
+		return "bzip2"
+	case strings.HasSuffix(url, ".tar.gz"), strings.HasSuffix(url, ".tgz"):
+		return "gzip"
+	}
+	return ""
+}
+
+// extractArchive unpacks a compressed tar archive into dir.
+func extractArchive(r io.Reader, kind, dir string) error {
+	switch kind {
+	case "bzip2":
+		r = bzip2.NewReader(r)
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("invalid archive: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %v", err)
+		}
+
+		// never write outside of the models directory
+		name := filepath.Clean(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path %q in archive", header.Name)
+		}
+		target := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %v", header.Name, err)
+			}
+		default:
+			logDebug("Skipping %s in model archive", header.Name)
+		}
+	}
+}
+
+// activateModel makes the model the default one, it is loaded first so a
+// broken model doesn't silence the announcements. The model that was used
+// before is freed unless a voice still uses it.
+func activateModel(name string) error {
+	if _, err := ttsModelConfig(name); err != nil {
+		return err
+	}
+	if _, err := ttsModel(name); err != nil {
+		return err
+	}
+
+	modelsMutex.Lock()
+	loadInstalledModels()
+	installed.Active = name
+	err := saveInstalledModels()
+	modelsMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	logInfo("Switched the TTS model to %s", name)
+	unloadUnusedModels()
+	return nil
+}
+
+// unloadUnusedModels frees the loaded models no voice uses anymore.
+func unloadUnusedModels() {
+	used := map[string]bool{defaultTtsModel(): true}
+	for _, v := range SysConfig.Voices {
+		used[strings.ToLower(v.Model)] = true
+	}
+	for _, v := range SysConfig.Languages.Voices {
+		used[strings.ToLower(v.Model)] = true
+	}
+
+	// not while a model is generating speech
+	speaking.Lock()
+	defer speaking.Unlock()
+	ttsModelsMutex.Lock()
+	defer ttsModelsMutex.Unlock()
+
+	for name, tts := range ttsModels {
+		if used[name] {
+			continue
+		}
+		logInfo("Unloading the %s TTS model", name)
+		sherpa.DeleteOfflineTts(tts)
+		delete(ttsModels, name)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Network speaker types
+const (
+	netSpeakerChromecast = "chromecast"
+	netSpeakerSonos      = "sonos"
+)
+
+const (
+	// mediaTTL is how long the speech stays available to the speaker, it
+	// fetches it right after being told to play it.
+	mediaTTL = 5 * time.Minute
+	// netSpeakerMargin is added to the length of the speech when waiting
+	// for the speaker, it buffers and starts late.
+	netSpeakerMargin      = 30 * time.Second
+	netSpeakerDialTimeout = 5 * time.Second
+)
+
+// mediaFile is speech served to the network speakers.
+type mediaFile struct {
+	data    []byte
+	expires time.Time
+}
+
+var (
+	mediaMutex sync.Mutex
+	mediaFiles = make(map[string]mediaFile)
+)
+
+// netSpeakerEnabled returns true if the announcements go to a network speaker.
+func netSpeakerEnabled() bool {
+	return SysConfig.NetworkSpeaker.Type != "" && SysConfig.NetworkSpeaker.Address != ""
+}
+
+// netSpeakerHost returns the address of the speaker with its default port.
+func netSpeakerHost(address, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, defaultPort)
+}
+
+// playOnNetSpeaker plays the clip on a network speaker and returns once it
+// has been played.
+func playOnNetSpeaker(speaker NetworkSpeakerConfig, clip audioClip) error {
+	url, done, err := serveMedia(speaker, clip)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	timeout := clip.duration() + netSpeakerMargin
+	switch strings.ToLower(speaker.Type) {
+	case netSpeakerChromecast:
+		return castPlay(netSpeakerHost(speaker.Address, chromecastPort), url, timeout)
+	case netSpeakerSonos:
+		return sonosPlay(netSpeakerHost(speaker.Address, sonosPort), url, timeout)
+	}
+	return fmt.Errorf("unknown network speaker type %q, expected chromecast or sonos", speaker.Type)
+}
+
+// serveMedia makes the clip available as a WAV file on the web server, under
+// a random name, and returns its URL and a function removing it.
+func serveMedia(speaker NetworkSpeakerConfig, clip audioClip) (string, func(), error) {
+	base, err := mediaBaseURL(speaker)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", nil, fmt.Errorf("failed to generate media name: %v", err)
+	}
+	name := hex.EncodeToString(token)
+
+	mediaMutex.Lock()
+	defer mediaMutex.Unlock()
+	now := time.Now()
+	for n, f := range mediaFiles {
+		if now.After(f.expires) {
+			delete(mediaFiles, n)
+		}
+	}
+	mediaFiles[name] = mediaFile{
+		data:    wavFromPCM(clip.PCM, clip.SampleRate, clip.Channels),
+		expires: now.Add(mediaTTL),
+	}
+
+	done := func() {
+		mediaMutex.Lock()
+		defer mediaMutex.Unlock()
+		delete(mediaFiles, name)
+	}
+	return fmt.Sprintf("%s/media/%s.wav", base, name), done, nil
+}
+
+// mediaBaseURL returns the URL of the web server as seen by the speaker, the
+// address of this device on the speaker's network unless configured.
+func mediaBaseURL(speaker NetworkSpeakerConfig) (string, error) {
+	if speaker.MediaURL != "" {
+		return strings.TrimSuffix(speaker.MediaURL, "/"), nil
+	}
+
+	// no packet is sent, this only picks the local address of the route
+	conn, err := net.DialTimeout("udp", netSpeakerHost(speaker.Address, "80"), netSpeakerDialTimeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the local address: %v", err)
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+	return fmt.Sprintf("http://%s", net.JoinHostPort(local.IP.String(), webServerPort)), nil
+}
+
+// handleMedia serves the speech to the network speakers, which can't log in,
+// the random names are what keeps it private.
+func (ws *webServer) handleMedia(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(r.PathValue("name"), ".wav")
+
+	mediaMutex.Lock()
+	f, ok := mediaFiles[name]
+	mediaMutex.Unlock()
+	if !ok || time.Now().After(f.expires) {
+		http.NotFound(w, r)
+		return
+	}
+
+	logDebug("Serving speech to %s", r.RemoteAddr)
+	w.Header().Set("Content-Type", "audio/wav")
+	http.ServeContent(w, r, name+".wav", time.Time{}, bytes.NewReader(f.data))
+}
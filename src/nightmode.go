@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Light sensor types
+const (
+	lightSensorBH1750  = "bh1750"
+	lightSensorTSL2561 = "tsl2561"
+)
+
+// Night mode actions
+const (
+	nightActionQuiet  = "quiet"  // spoken at night_mode.volume at most
+	nightActionSilent = "silent" // not spoken, only the lights, the LED and the other channels
+)
+
+const (
+	nightModeRetry = time.Minute
+	// silentBlink is how long the status LED blinks for an announcement that
+	// wasn't spoken.
+	silentBlink = time.Minute
+
+	bh1750Address           = 0x23 // with ADDR low
+	tsl2561Address          = 0x39 // with ADDR floating
+	bh1750ContinuousHighRes = 0x10
+	tsl2561Command          = 0x80
+	tsl2561Word             = 0x20
+	tsl2561Control          = 0x00
+	tsl2561Timing           = 0x01
+	tsl2561Data0            = 0x0c
+	tsl2561Data1            = 0x0e
+	tsl2561PowerOn          = 0x03
+	tsl2561Gain16x402ms     = 0x12
+)
+
+var (
+	// roomDark is set while the light sensor reads the room as dark
+	roomDark atomic.Bool
+	// silentUntil is when the status LED stops showing an announcement that
+	// was not spoken, in Unix nanoseconds
+	silentUntil atomic.Int64
+)
+
+// lightSensor reads the light in the room, in lux.
+type lightSensor interface {
+	lux() (float64, error)
+	Close() error
+}
+
+// runNightMode follows the light in the room, for the announcements to be
+// quieter, or not spoken, while it is dark, whatever the time.
+func runNightMode() {
+	warned := false
+	for {
+		cfg := SysConfig.NightMode
+		if !cfg.Enabled {
+			roomDark.Store(false)
+			time.Sleep(nightModeRetry)
+			continue
+		}
+		if err := watchLight(cfg); err != nil {
+			// spoken as usual when the room can't be seen
+			roomDark.Store(false)
+			if !warned {
+				logError("Failed to read the light sensor, retrying every %s: %v", nightModeRetry, err)
+				warned = true
+			}
+			time.Sleep(nightModeRetry)
+			continue
+		}
+		warned = false
+	}
+}
+
+// watchLight reads the sensor until the config changes.
+func watchLight(cfg NightModeConfig) error {
+	sensor, err := openLightSensor(cfg)
+	if err != nil {
+		return err
+	}
+	defer sensor.Close()
+	logInfo("Reading the light with the %s sensor", cfg.Sensor)
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		if SysConfig.NightMode != cfg {
+			logInfo("Night mode config changed, opening the light sensor again")
+			return nil
+		}
+		lux, err := sensor.lux()
+		if err != nil {
+			return err
+		}
+
+		// in between the two, it stays as it was
+		switch {
+		case lux < cfg.DarkLux && !roomDark.Load():
+			logInfo("Room is dark (%.1f lux), night mode on", lux)
+			roomDark.Store(true)
+		case lux > cfg.LightLux && roomDark.Load():
+			logInfo("Room is lit (%.1f lux), night mode off", lux)
+			roomDark.Store(false)
+		}
+	}
+}
+
+// nightMode returns the action of the night mode for an announcement of that
+// kind, empty if it is spoken as usual. Emergencies, the wake-up alarms and
+// the answers to someone who is obviously awake are always spoken.
+func nightMode(kind string) string {
+	cfg := SysConfig.NightMode
+	if !cfg.Enabled || !roomDark.Load() || kind == "" || kind == announceKindPanic || kind == announceKindWakeUp {
+		return ""
+	}
+	return cfg.Action
+}
+
+// showSilentAnnouncement blinks the status LED for an announcement that was
+// not spoken.
+func showSilentAnnouncement() {
+	silentUntil.Store(time.Now().Add(silentBlink).UnixNano())
+}
+
+// silentAnnouncementShown returns true while the status LED shows an
+// announcement that was not spoken.
+func silentAnnouncementShown() bool {
+	return time.Now().UnixNano() < silentUntil.Load()
+}
+
+func openLightSensor(cfg NightModeConfig) (lightSensor, error) {
+	f, err := openI2C(cfg.Bus, cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	var sensor lightSensor
+	switch cfg.Sensor {
+	case lightSensorBH1750:
+		sensor, err = openBH1750(f)
+	case lightSensorTSL2561:
+		sensor, err = openTSL2561(f)
+	default:
+		err = fmt.Errorf("unknown light sensor %q", cfg.Sensor)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return sensor, nil
+}
+
+// bh1750 measures continuously, a reading is the lux times 1.2.
+type bh1750 struct {
+	file *os.File
+}
+
+func openBH1750(f *os.File) (*bh1750, error) {
+	if _, err := f.Write([]byte{bh1750ContinuousHighRes}); err != nil {
+		return nil, fmt.Errorf("failed to start the BH1750: %v", err)
+	}
+	return &bh1750{file: f}, nil
+}
+
+func (s *bh1750) lux() (float64, error) {
+	buf := make([]byte, 2)
+	if _, err := s.file.Read(buf); err != nil {
+		return 0, err
+	}
+	return float64(binary.BigEndian.Uint16(buf)) / 1.2, nil
+}
+
+func (s *bh1750) Close() error {
+	return s.file.Close()
+}
+
+// tsl2561 measures the full spectrum and the infrared, the lux are computed
+// from both as in its datasheet, for the T package. It is set to its longest
+// integration and its high gain, to tell a dark room from a dim one.
+type tsl2561 struct {
+	file *os.File
+}
+
+func openTSL2561(f *os.File) (*tsl2561, error) {
+	s := &tsl2561{file: f}
+	if err := s.write(tsl2561Control, tsl2561PowerOn); err != nil {
+		return nil, fmt.Errorf("failed to power on the TSL2561: %v", err)
+	}
+	if err := s.write(tsl2561Timing, tsl2561Gain16x402ms); err != nil {
+		return nil, fmt.Errorf("failed to set up the TSL2561: %v", err)
+	}
+	return s, nil
+}
+
+func (s *tsl2561) lux() (float64, error) {
+	ch0, err := s.read(tsl2561Data0)
+	if err != nil {
+		return 0, err
+	}
+	ch1, err := s.read(tsl2561Data1)
+	if err != nil {
+		return 0, err
+	}
+	if ch0 == 0 {
+		return 0, nil
+	}
+	// saturated, so far from dark
+	if ch0 == math.MaxUint16 || ch1 == math.MaxUint16 {
+		return math.Inf(1), nil
+	}
+
+	// the coefficients are for the 1x gain
+	c0, c1 := float64(ch0)/16, float64(ch1)/16
+	ratio := c1 / c0
+	switch {
+	case ratio <= 0.5:
+		return 0.0304*c0 - 0.062*c0*math.Pow(ratio, 1.4), nil
+	case ratio <= 0.61:
+		return 0.0224*c0 - 0.031*c1, nil
+	case ratio <= 0.80:
+		return 0.0128*c0 - 0.0153*c1, nil
+	case ratio <= 1.30:
+		return 0.00146*c0 - 0.00112*c1, nil
+	}
+	return 0, nil
+}
+
+func (s *tsl2561) write(register, value byte) error {
+	_, err := s.file.Write([]byte{tsl2561Command | register, value})
+	return err
+}
+
+func (s *tsl2561) read(register byte) (uint16, error) {
+	if _, err := s.file.Write([]byte{tsl2561Command | tsl2561Word | register}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 2)
+	if _, err := s.file.Read(buf); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(buf), nil
+}
+
+func (s *tsl2561) Close() error {
+	s.write(tsl2561Control, 0)
+	return s.file.Close()
+}
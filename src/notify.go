@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// speechChannel is the name the speaker is counted under. The announcements
+// are spoken before they reach the other channels, which are told whether
+// they were heard. The emails and the texts are counted under emailChannel
+// and smsChannel, they are not notification channels as they are only sent
+// for their own triggers (an unacknowledged or critical event, the recap, the
+// emergency) rather than for every announcement, but they are retried the
+// same way.
+const (
+	speechChannel = "speech"
+	emailChannel  = "email"
+	smsChannel    = "sms"
+)
+
+// channelDrainTimeout bounds how long the deliveries still queued are
+// waited for when the application stops.
+const channelDrainTimeout = 20 * time.Second
+
+// AnnouncementEvent is what the notification channels are sent: an
+// announcement, and the event it is about if any.
+type AnnouncementEvent struct {
+	Announcement
+	Event *LocalEvent
+}
+
+// NotificationChannel is somewhere the announcements are sent besides the
+// speaker. Send is called from the queue of the channel, a failure is tried
+// again unless it is a permanentError.
+type NotificationChannel interface {
+	Name() string
+	Send(AnnouncementEvent) error
+}
+
+// channelFilter is implemented by the channels that only want some of the
+// announcements, e.g. those they are enabled for.
+type channelFilter interface {
+	Wants(AnnouncementEvent) bool
+}
+
+// channelSource returns the channels of a sink from the current config,
+// one per chat or URL for those that have several.
+type channelSource func() []NotificationChannel
+
+// permanentError is a failure trying again would not fix, e.g. a rejected
+// token.
+type permanentError struct {
+	error
+}
+
+// statusError returns the error of an answer that isn't a success, the
+// client errors other than a rate limit are permanent.
+func statusError(resp *http.Response) error {
+	err := fmt.Errorf("server answered %s", resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// ChannelHealth counts what a channel was sent since the start.
+type ChannelHealth struct {
+	Name          string    `json:"name"`
+	Sent          int       `json:"sent"`
+	Failed        int       `json:"failed"`  // Given up on, after the retries
+	Retried       int       `json:"retried"` // Attempts after the first one
+	Dropped       int       `json:"dropped"` // The queue was full
+	Queued        int       `json:"queued"`
+	LastSent      time.Time `json:"last_sent,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+}
+
+// channelQueue delivers the announcements of a channel one after the
+// other, so a slow or failing channel holds up no other.
+type channelQueue struct {
+	jobs  chan AnnouncementEvent
+	stats ChannelHealth
+}
+
+var (
+	channelsMutex  sync.Mutex
+	channelSources []channelSource
+	channelQueues  = make(map[string]*channelQueue)
+	// pendingDeliveries counts the announcements, emails and texts not sent
+	// or given up on yet
+	pendingDeliveries int
+)
+
+// registerNotificationChannels registers the built-in sinks, a new sink adds
+// its source here.
+func registerNotificationChannels() {
+	registerChannels(webPushChannels)
+	registerChannels(telegramChannels)
+	registerChannels(ntfyChannels)
+	registerChannels(pushoverChannels)
+	registerChannels(chatChannels)
+	registerChannels(webhookChannels)
+	registerChannels(scriptChannels)
+}
+
+// registerChannels adds a source of notification channels.
+func registerChannels(source channelSource) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+	channelSources = append(channelSources, source)
+}
+
+// queueForChannels queues the announcement for the channels that want it,
+// the speaker having already said it or failed to, or being about to for an
+// alert.
+func queueForChannels(e *LocalEvent, entry Announcement) {
+	if SysConfig.DryRun {
+		logDebug("Dry run, not sending the %s announcement to the channels", entry.Kind)
+		return
+	}
+	ev := AnnouncementEvent{Announcement: entry}
+	if e != nil {
+		// the caller keeps changing its copy
+		event := *e
+		ev.Event = &event
+	}
+
+	channelsMutex.Lock()
+	sources := channelSources
+	channelsMutex.Unlock()
+	for _, source := range sources {
+		for _, ch := range source() {
+			if !channelWants(ch, ev) {
+				continue
+			}
+			queueAnnouncement(ch, ev)
+		}
+	}
+}
+
+// channelWants returns true if the channel is enabled in notifications.channels
+// for the kind of the announcement, and wants it itself.
+func channelWants(ch NotificationChannel, ev AnnouncementEvent) bool {
+	cfg := SysConfig.Notifications.Channels[ch.Name()]
+	if cfg.Enabled != nil && !*cfg.Enabled {
+		return false
+	}
+	if !mirrorsKind(cfg.Kinds, ev.Kind) {
+		return false
+	}
+	if f, ok := ch.(channelFilter); ok {
+		return f.Wants(ev)
+	}
+	return true
+}
+
+// queueAnnouncement adds the announcement to the queue of the channel,
+// starting it on first use. It is dropped if the queue is full.
+func queueAnnouncement(ch NotificationChannel, ev AnnouncementEvent) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+
+	q, ok := channelQueues[ch.Name()]
+	if !ok {
+		q = &channelQueue{
+			jobs:  make(chan AnnouncementEvent, SysConfig.Notifications.QueueSize),
+			stats: ChannelHealth{Name: ch.Name()},
+		}
+		channelQueues[ch.Name()] = q
+		go q.run(ch)
+	}
+	select {
+	case q.jobs <- ev:
+		q.stats.Queued++
+		pendingDeliveries++
+	default:
+		q.stats.Dropped++
+		logWarn("Notification queue of %s is full, dropping the %s announcement", ch.Name(), ev.Kind)
+	}
+}
+
+// run sends the queued announcements to the channel, one after the other.
+func (q *channelQueue) run(ch NotificationChannel) {
+	for ev := range q.jobs {
+		if err := sendWithRetries(ch.Name(), func() error { return ch.Send(ev) }); err != nil {
+			logError("Failed to send the %s announcement to %s: %v", ev.Kind, ch.Name(), err)
+		}
+
+		channelsMutex.Lock()
+		q.stats.Queued--
+		pendingDeliveries--
+		channelsMutex.Unlock()
+	}
+}
+
+// sendWithRetries sends through the channel of that name, trying up to
+// notifications.attempts times with a growing delay in between, and counts
+// the result.
+func sendWithRetries(name string, send func() error) error {
+	var err error
+	retries := 0
+	delay := SysConfig.Notifications.RetryDelay
+	for attempt := 1; attempt <= SysConfig.Notifications.Attempts; attempt++ {
+		if attempt > 1 {
+			logWarn("Sending to %s failed, retrying in %s: %v", name, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+			retries++
+		}
+		if err = send(); err == nil {
+			break
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			break
+		}
+	}
+	recordChannelResult(name, err, retries)
+	return err
+}
+
+// deliverAsync sends through the channel of that name in the background,
+// with the retries, for the emails and the texts which have no queue.
+func deliverAsync(name string, send func() error, done func(error)) {
+	channelsMutex.Lock()
+	pendingDeliveries++
+	channelsMutex.Unlock()
+
+	go func() {
+		done(sendWithRetries(name, send))
+
+		channelsMutex.Lock()
+		pendingDeliveries--
+		channelsMutex.Unlock()
+	}()
+}
+
+// drainChannels waits for the deliveries still queued, for at most timeout.
+func drainChannels(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		channelsMutex.Lock()
+		pending := pendingDeliveries
+		channelsMutex.Unlock()
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			logWarn("%d notifications still not sent after %s, dropping them", pending, timeout)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// recordChannelResult counts a delivery to the channel, and the retries it
+// took.
+func recordChannelResult(name string, err error, retries int) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+
+	q, ok := channelQueues[name]
+	if !ok {
+		// the speaker, the emails and the texts have no queue, only their counts
+		q = &channelQueue{stats: ChannelHealth{Name: name}}
+		channelQueues[name] = q
+	}
+	q.stats.Retried += retries
+	if err != nil {
+		q.stats.Failed++
+		q.stats.LastError = err.Error()
+		q.stats.LastErrorTime = time.Now()
+		return
+	}
+	q.stats.Sent++
+	q.stats.LastSent = time.Now()
+}
+
+// channelHealth returns the counts of the channels used since the start,
+// the speaker first.
+func channelHealth() []ChannelHealth {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+
+	list := make([]ChannelHealth, 0, len(channelQueues))
+	for _, q := range channelQueues {
+		list = append(list, q.stats)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Name == speechChannel) != (list[j].Name == speechChannel) {
+			return list[i].Name == speechChannel
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var panicking sync.Mutex
+
+// triggerPanic speaks the configured emergency message and raises an alert
+// with the device information. source describes what fired the trigger, it
+// ends up in the alert so it is clear whether it was a person or a fault.
+func triggerPanic(source string) {
+	// ignore repeated triggers while an emergency announcement is running
+	if !panicking.TryLock() {
+		logWarn("Panic already in progress, ignoring trigger from %s", source)
+		return
+	}
+	defer panicking.Unlock()
+
+	notifyPanic(source)
+	emailPanicAlert(source)
+	alertPanic(source)
+
+	// emergencies are played at full scale whatever the volume, see speechVolume
+	for i := 0; i < SysConfig.PanicConfig.Repeats; i++ {
+		err := aiSpeakAs(announceKindPanic, nil, SysConfig.PanicConfig.Message)
+		if err != nil {
+			logError("failed to speak panic message: %v", err)
+		}
+		// the channels already got the alert
+		recordSpoken(nil, announceKindPanic, SysConfig.PanicConfig.Message, err)
+	}
+}
+
+// alertPanic sends a single alert with the device and its location to the
+// notification channels and texts the caregivers, before anything is
+// spoken so they don't wait for the repeats.
+func alertPanic(source string) {
+	if simulating {
+		return
+	}
+	cfg := SysConfig.PanicConfig
+	now := time.Now()
+	text := fmt.Sprintf("Emergency on %s, triggered by %s at %s.", cfg.DeviceName, source, now.Format("15:04"))
+	alert := text
+	if cfg.Location != "" {
+		alert += " Location: " + cfg.Location + "."
+	}
+	queueForChannels(nil, Announcement{Time: now, Kind: announceKindPanic, Text: alert, Success: true})
+
+	if !SysConfig.DryRun && smsEnabled() {
+		// textCaregivers adds the location
+		textCaregivers(nil, text)
+	}
+}
+
+// notifyPanic reports the emergency along with where the device is.
+func notifyPanic(source string) {
+	WithFields(map[string]interface{}{
+		"device":   SysConfig.PanicConfig.DeviceName,
+		"location": SysConfig.PanicConfig.Location,
+		"source":   source,
+		"time":     time.Now().Format(time.RFC3339),
+	}).Error("PANIC triggered")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PauseState is the global pause of the announcements, kept on disk so a
+// reboot while away doesn't resume them.
+type PauseState struct {
+	Until time.Time `json:"until"`
+	Since time.Time `json:"since"`
+}
+
+var (
+	pauseMutex  sync.Mutex
+	pauseState  PauseState
+	pauseLoaded bool
+)
+
+// loadPause reads the pause state from disk, once. The caller must hold pauseMutex.
+func loadPause() {
+	if pauseLoaded {
+		return
+	}
+	pauseLoaded = true
+
+	data, err := os.ReadFile(realPath(pausePath))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logError("failed to read pause state: %v", err)
+		return
+	}
+	if err := json.Unmarshal(data, &pauseState); err != nil {
+		logError("failed to parse pause state: %v", err)
+		return
+	}
+	if pauseState.Until.After(time.Now()) {
+		logInfo("Announcements are paused until %s", pauseState.Until.Format(time.RFC1123))
+	}
+}
+
+// getPause returns the pause state.
+func getPause() PauseState {
+	// a simulation shows what would be announced
+	if simulating {
+		return PauseState{}
+	}
+
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+
+	loadPause()
+	return pauseState
+}
+
+// isPaused returns true if the announcements are paused right now.
+func isPaused() bool {
+	return clockNow().Before(getPause().Until)
+}
+
+// setPause pauses the announcements until the given time, a zero time resumes them.
+func setPause(until time.Time) error {
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+
+	loadPause()
+	state := PauseState{}
+	if !until.IsZero() {
+		if !until.After(time.Now()) {
+			return fmt.Errorf("pause end must be in the future")
+		}
+		state = PauseState{Until: until, Since: time.Now()}
+	}
+
+	data, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal pause state: %v", err)
+	}
+	path := realPath(pausePath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create pause state directory: %v", err)
+	}
+	if err := writeFileAtomically(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save pause state: %v", err)
+	}
+
+	pauseState = state
+	reschedule()
+	if until.IsZero() {
+		logInfo("Announcements resumed")
+	} else {
+		logInfo("Announcements paused until %s", until.Format(time.RFC1123))
+	}
+	return nil
+}
+
+// resetPause drops the loaded pause state, it is read again on next use.
+func resetPause() {
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+	pauseLoaded = false
+	pauseState = PauseState{}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pushoverMessagesURL = "https://api.pushover.net/1/messages.json"
+	phonePushTimeout    = 15 * time.Second
+
+	// pushoverEmergency notifications are repeated every pushoverRetry
+	// until acknowledged on the phone, for pushoverExpire at most.
+	pushoverEmergency = 2
+	pushoverRetry     = time.Minute
+	pushoverExpire    = time.Hour
+)
+
+// ntfyPriorities are the priorities ntfy.priorities can have, the lowest
+// first.
+var ntfyPriorities = []string{"min", "low", "default", "high", "urgent"}
+
+// mirrorsKind returns true if the announcements of the kind are mirrored,
+// all of them if no kinds are set.
+func mirrorsKind(kinds []string, kind string) bool {
+	return len(kinds) == 0 || slices.Contains(kinds, kind)
+}
+
+// ntfyChannel mirrors the spoken announcements of its kinds to the ntfy
+// topic.
+type ntfyChannel struct{}
+
+func ntfyChannels() []NotificationChannel {
+	return []NotificationChannel{ntfyChannel{}}
+}
+
+func (ntfyChannel) Name() string { return "ntfy" }
+
+func (ntfyChannel) Wants(ev AnnouncementEvent) bool {
+	cfg := SysConfig.Ntfy
+	return cfg.Enabled && cfg.Topic != "" && ev.Success && mirrorsKind(cfg.Kinds, ev.Kind)
+}
+
+func (ntfyChannel) Send(ev AnnouncementEvent) error {
+	return sendNtfy(announcementTitle(ev.Kind), ev.Text, SysConfig.Ntfy.Priorities[ev.Kind], ev.Kind)
+}
+
+// pushoverChannel mirrors the spoken announcements of its kinds to the
+// Pushover user.
+type pushoverChannel struct{}
+
+func pushoverChannels() []NotificationChannel {
+	return []NotificationChannel{pushoverChannel{}}
+}
+
+func (pushoverChannel) Name() string { return "pushover" }
+
+func (pushoverChannel) Wants(ev AnnouncementEvent) bool {
+	cfg := SysConfig.Pushover
+	return cfg.Enabled && ev.Success && mirrorsKind(cfg.Kinds, ev.Kind)
+}
+
+func (pushoverChannel) Send(ev AnnouncementEvent) error {
+	return sendPushover(announcementTitle(ev.Kind), ev.Text, SysConfig.Pushover.Priorities[ev.Kind], ev.Time)
+}
+
+// sendNtfy publishes the message to the ntfy topic, with the priority, the
+// default one if empty.
+func sendNtfy(title, message, priority, tag string) error {
+	server := strings.TrimRight(SysConfig.Ntfy.Server, "/")
+	req, err := http.NewRequest(http.MethodPost, server+"/"+url.PathEscape(SysConfig.Ntfy.Topic), strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Tags", tag)
+	if priority != "" {
+		req.Header.Set("Priority", priority)
+	}
+	if token := SysSecrets.Ntfy.Token; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return postPhonePush(req)
+}
+
+// sendPushover sends the message to the Pushover user of the secrets.
+func sendPushover(title, message string, priority int, at time.Time) error {
+	secrets := SysSecrets.Pushover
+	if secrets.APIToken == "" || secrets.UserKey == "" {
+		return permanentError{fmt.Errorf("pushover.api_token and pushover.user_key are not set in the secrets")}
+	}
+	form := url.Values{
+		"token":     {secrets.APIToken},
+		"user":      {secrets.UserKey},
+		"title":     {title},
+		"message":   {message},
+		"priority":  {strconv.Itoa(priority)},
+		"timestamp": {strconv.FormatInt(at.Unix(), 10)},
+	}
+	if SysConfig.Pushover.Device != "" {
+		form.Set("device", SysConfig.Pushover.Device)
+	}
+	if priority == pushoverEmergency {
+		form.Set("retry", strconv.Itoa(int(pushoverRetry.Seconds())))
+		form.Set("expire", strconv.Itoa(int(pushoverExpire.Seconds())))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, pushoverMessagesURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return postPhonePush(req)
+}
+
+// postPhonePush sends the request, the error tells what the service
+// answered and is permanent if the request was refused.
+func postPhonePush(req *http.Request) error {
+	resp, err := (&http.Client{Timeout: phonePushTimeout}).Do(req)
+	if err != nil {
+		// the URL may have a token in it
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	err = statusError(resp)
+	var answer struct {
+		Error  string   `json:"error"`  // ntfy
+		Errors []string `json:"errors"` // Pushover
+	}
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	if json.Unmarshal(body.Bytes(), &answer) == nil {
+		detail := answer.Error
+		if len(answer.Errors) > 0 {
+			detail = strings.Join(answer.Errors, ", ")
+		}
+		if detail != "" {
+			err = fmt.Errorf("%w: %s", err, detail)
+		}
+	}
+	return err
+}
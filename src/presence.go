@@ -0,0 +1,150 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// presenceRetry is how long to wait before opening the sensor again after
+// it failed.
+const presenceRetry = time.Minute
+
+var (
+	// lastMotion is when the PIR sensor last saw someone, in Unix
+	// nanoseconds, and sensorWatched is set while the sensor is read
+	lastMotion    atomic.Int64
+	sensorWatched atomic.Bool
+
+	presenceMutex sync.Mutex
+	// deferringSince is when the announcements started waiting for someone
+	// to come in, zero if they are not waiting
+	deferringSince time.Time
+)
+
+// runPresenceSensor keeps track of the motion seen by the PIR sensor.
+func runPresenceSensor() {
+	warned := false
+	for {
+		cfg := SysConfig.Presence
+		if !cfg.Enabled {
+			time.Sleep(presenceRetry)
+			continue
+		}
+		if err := watchPresenceSensor(cfg); err != nil {
+			sensorWatched.Store(false)
+			if !warned {
+				logError("Failed to read the PIR sensor on pin %d of %s, announcing whoever is there: %v", cfg.Pin, cfg.Chip, err)
+				warned = true
+			}
+			time.Sleep(presenceRetry)
+			continue
+		}
+		sensorWatched.Store(false)
+		warned = false
+	}
+}
+
+// watchPresenceSensor records the motion seen by the sensor until the config
+// changes.
+func watchPresenceSensor(cfg PresenceConfig) error {
+	// the sensors drive their output high while they see motion
+	lines, err := requestGPIOInputs(cfg.Chip, []int{cfg.Pin}, false, 0)
+	if err != nil {
+		return err
+	}
+	defer lines.Close()
+	logInfo("Watching the PIR sensor on pin %d of %s", cfg.Pin, cfg.Chip)
+	sensorWatched.Store(true)
+
+	// closing the pin ends the wait for motion
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(configCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if SysConfig.Presence != cfg {
+					lines.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		event, err := lines.readEvent()
+		if err != nil {
+			if SysConfig.Presence != cfg {
+				logInfo("PIR sensor config changed, opening it again")
+				return nil
+			}
+			return err
+		}
+		// still there until the output goes low
+		lastMotion.Store(time.Now().UnixNano())
+		if event.Rising {
+			motionSeen()
+		}
+	}
+}
+
+// motionSeen announces what waited for someone to come in.
+func motionSeen() {
+	presenceMutex.Lock()
+	waiting := !deferringSince.IsZero()
+	deferringSince = time.Time{}
+	presenceMutex.Unlock()
+
+	if waiting {
+		logInfo("Someone came in, announcing what was deferred")
+		reschedule()
+	}
+}
+
+// deferForPresence returns true if the announcements wait for someone to be
+// in the room, talking to an empty room trains people to ignore it. They
+// wait for presence.max_defer at most, and not at all if the sensor can't
+// be read.
+func deferForPresence() bool {
+	cfg := SysConfig.Presence
+	if simulating || !cfg.Enabled || !sensorWatched.Load() {
+		return false
+	}
+
+	now := time.Now()
+	presenceMutex.Lock()
+	defer presenceMutex.Unlock()
+	if now.Sub(time.Unix(0, lastMotion.Load())) <= cfg.Recent {
+		deferringSince = time.Time{}
+		return false
+	}
+	if deferringSince.IsZero() {
+		logInfo("Nobody seen for %s, deferring the announcements", formatDuration(cfg.Recent))
+		deferringSince = now
+	}
+	if now.Sub(deferringSince) >= cfg.MaxDefer {
+		logInfo("Nobody seen since the announcements were deferred %s ago, announcing anyway", formatDuration(cfg.MaxDefer))
+		return false
+	}
+	return true
+}
+
+// presenceRetryTime returns when the deferred announcements are tried again,
+// false if they are not deferred.
+func presenceRetryTime() (time.Time, bool) {
+	presenceMutex.Lock()
+	defer presenceMutex.Unlock()
+	if deferringSince.IsZero() {
+		return time.Time{}, false
+	}
+	retry := time.Now().Add(SysConfig.Presence.Retry)
+	if limit := deferringSince.Add(SysConfig.Presence.MaxDefer); limit.Before(retry) {
+		retry = limit
+	}
+	return retry, true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+	"time"
+)
+
+// duePreStart returns the lead times of the event that are due now, if the
+// closest one was not announced yet. Leads that were missed, for example
+// because the device started late, are returned along with it so they are
+// not announced after a closer one.
+func duePreStart(e *LocalEvent) ([]time.Duration, bool) {
+	enabled := SysConfig.PreStartReminders.Enabled || reminderProfile(&e.Event).PreStart
+	if !enabled || e.Event.AllDay || e.StartAnnounced || e.Acknowledged {
+		return nil, false
+	}
+
+	// the calendar alarms already give a heads up
+	if hasCalendarAlarms(e) {
+		return nil, false
+	}
+
+	now := clockNow()
+	if !now.Before(e.Event.StartTime) {
+		return nil, false
+	}
+
+	due := []time.Duration{}
+	for _, lead := range SysConfig.PreStartReminders.Leads {
+		if !now.Before(e.Event.StartTime.Add(-lead)) {
+			due = append(due, lead)
+		}
+	}
+	if len(due) == 0 {
+		return nil, false
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+	if e.preStartAnnounced(e.Event.StartTime.Add(-due[0])) {
+		return nil, false
+	}
+	return due, true
+}
+
+// setPreStartAnnounced marks the lead times of the event as announced.
+func (e *LocalEvent) setPreStartAnnounced(leads []time.Duration) error {
+	start := e.Event.StartTime
+	return e.update(func(l *LocalEvent) {
+		for _, lead := range leads {
+			l.PreStartAnnounced = append(l.PreStartAnnounced, start.Add(-lead))
+		}
+	})
+}
+
+// preStartAnnounced returns true if the heads up at the given time was
+// already announced. The times are stored rather than the leads, so a moved
+// event is announced again.
+func (e *LocalEvent) preStartAnnounced(at time.Time) bool {
+	for _, a := range e.PreStartAnnounced {
+		if a.Equal(at) {
+			return true
+		}
+	}
+	return false
+}
+
+func renderPreStartMessage(e *LocalEvent) string {
+	data := newEventTemplateData(e)
+	defaultMessage := fmt.Sprintf("Get ready! \"%s\" starts in %s.", data.Event, data.StartsIn)
+	tmplText := SysConfig.PreStartReminders.MessageTemplate.pick()
+	if tmplText == "" {
+		tmplText = "Get ready! \"{{.Event}}\" starts in {{.StartsIn}}."
+	}
+
+	tmpl, err := template.New("pre_start").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse pre-start template: %v", err)
+		return defaultMessage
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logError("failed to execute pre-start template: %v", err)
+		return defaultMessage
+	}
+
+	return buf.String()
+}
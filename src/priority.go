@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+)
+
+// Event priorities, each one can have its own reminder profile
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+// eventPriority returns the priority of the event, from the event rules if
+// one sets it, from the PRIORITY of the calendar event otherwise.
+func eventPriority(e *CalendarEvent) string {
+	if rule, ok := matchEventRule(e); ok && rule.Priority != "" {
+		return rule.Priority
+	}
+
+	// RFC 5545: 1-4 is high, 5 medium, 6-9 low and 0 undefined
+	switch {
+	case e.Priority >= 1 && e.Priority <= 4:
+		return priorityHigh
+	case e.Priority >= 6 && e.Priority <= 9:
+		return priorityLow
+	default:
+		return priorityNormal
+	}
+}
+
+// reminderProfile returns the reminder profile of the event, the profile of
+// its calendar over the one of its priority, and over the messages of the
+// doses for a dose. An empty profile changes nothing.
+func reminderProfile(e *CalendarEvent) ReminderProfile {
+	profile := SysConfig.PriorityProfiles[eventPriority(e)]
+	if isDose(e) {
+		profile = profile.overlay(doseProfile)
+	}
+	if calendar, ok := calendarProfile(e); ok {
+		profile = profile.overlay(calendar)
+	}
+	return profile
+}
+
+// calendarProfile returns the profile of the calendar of the event, a
+// profile of the calendar itself wins over one of its source.
+func calendarProfile(e *CalendarEvent) (ReminderProfile, bool) {
+	for _, name := range []string{e.Calendar, e.Source} {
+		for key, profile := range SysConfig.CalendarProfiles {
+			if name != "" && strings.EqualFold(key, name) {
+				return profile, true
+			}
+		}
+	}
+	return ReminderProfile{}, false
+}
+
+// overlay returns the profile with the settings of o where o sets them.
+func (p ReminderProfile) overlay(o ReminderProfile) ReminderProfile {
+	if o.Mode != "" {
+		p.Mode = o.Mode
+	}
+	if o.NotificationRepeats > 0 {
+		p.NotificationRepeats = o.NotificationRepeats
+	}
+	if o.ReminderInterval > 0 {
+		p.ReminderInterval = o.ReminderInterval
+	}
+	p.PreStart = p.PreStart || o.PreStart
+	p.Escalation = p.Escalation || o.Escalation
+	if o.Speaker != nil {
+		p.Speaker = o.Speaker
+	}
+	if len(o.AnnounceMessageTemplate) > 0 {
+		p.AnnounceMessageTemplate = o.AnnounceMessageTemplate
+	}
+	if len(o.CheckStartMessageTemplate) > 0 {
+		p.CheckStartMessageTemplate = o.CheckStartMessageTemplate
+	}
+	if len(o.RemindMessageTemplate) > 0 {
+		p.RemindMessageTemplate = o.RemindMessageTemplate
+	}
+	if len(o.AnnounceEndMessageTemplate) > 0 {
+		p.AnnounceEndMessageTemplate = o.AnnounceEndMessageTemplate
+	}
+	return p
+}
+
+// validPriority returns the priority in its canonical form, and false if it is unknown.
+func validPriority(priority string) (string, bool) {
+	priority = strings.ToLower(strings.TrimSpace(priority))
+	switch priority {
+	case priorityHigh, priorityNormal, priorityLow:
+		return priority, true
+	}
+	return priority, false
+}
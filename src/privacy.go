@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// isPrivate returns true if the event should not be announced in detail.
+func isPrivate(e *CalendarEvent) bool {
+	if !SysConfig.Privacy.Enabled {
+		return false
+	}
+
+	if e.Private {
+		return true
+	}
+	for _, cal := range SysConfig.Privacy.Calendars {
+		if inCalendar(e, cal) {
+			return true
+		}
+	}
+	return false
+}
+
+// spokenTitle returns the title of the event as it should be spoken aloud.
+func (e *LocalEvent) spokenTitle() string {
+	if isPrivate(&e.Event) {
+		return SysConfig.Privacy.Title
+	}
+	return stripEventTags(e.Event.Description)
+}
+
+// spokenLocation returns the location of the event as it should be spoken aloud.
+func (e *LocalEvent) spokenLocation() string {
+	if isPrivate(&e.Event) {
+		return ""
+	}
+	return e.Event.Location
+}
+
+// spokenNotes returns the notes of the event as they should be spoken aloud.
+func (e *LocalEvent) spokenNotes() string {
+	if isPrivate(&e.Event) {
+		return ""
+	}
+	return stripEventTags(e.Event.Notes)
+}
+
+// privateTemplateData is what the private event template can use.
+type privateTemplateData struct {
+	Event string
+	Time  string
+}
+
+func renderPrivateStartMessage(e *LocalEvent) string {
+	startTime := e.Event.StartTime.Format("3:04 PM")
+	defaultMessage := fmt.Sprintf("You have %s at %s.", SysConfig.Privacy.Title, startTime)
+	tmplText := SysConfig.Privacy.MessageTemplate.pick()
+	if tmplText == "" {
+		tmplText = "You have {{.Event}} at {{.Time}}."
+	}
+
+	tmpl, err := template.New("private_start").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse private event template: %v", err)
+		return defaultMessage
+	}
+
+	data := privateTemplateData{
+		Event: SysConfig.Privacy.Title,
+		Time:  startTime,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logError("failed to execute private event template: %v", err)
+		return defaultMessage
+	}
+
+	return buf.String()
+}
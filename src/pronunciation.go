@@ -0,0 +1,57 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pronunciation replaces a word with how it is spoken.
+type pronunciation struct {
+	re     *regexp.Regexp
+	spoken string
+}
+
+var pronunciations []pronunciation
+
+// compilePronunciations prepares the pronunciations of the config, the
+// longest words first so "Anna Maria" wins over "Anna".
+func compilePronunciations() {
+	words := make([]string, 0, len(SysConfig.Pronunciations))
+	for word := range SysConfig.Pronunciations {
+		if strings.TrimSpace(word) != "" {
+			words = append(words, word)
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if len(words[i]) != len(words[j]) {
+			return len(words[i]) > len(words[j])
+		}
+		return words[i] < words[j]
+	})
+
+	list := []pronunciation{}
+	for _, word := range words {
+		// whole words only, a name must not change inside another word
+		re, err := regexp.Compile(`(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(strings.TrimSpace(word)) + `($|[^\pL\pN])`)
+		if err != nil {
+			logError("invalid pronunciation word %q, ignoring it: %v", word, err)
+			continue
+		}
+		list = append(list, pronunciation{re: re, spoken: SysConfig.Pronunciations[word]})
+	}
+	pronunciations = list
+}
+
+// applyPronunciations replaces the words of the text that have a configured
+// pronunciation, before the text is spoken.
+func applyPronunciations(text string) string {
+	for _, p := range pronunciations {
+		spoken := strings.ReplaceAll(p.spoken, "$", "$$")
+		// matches sharing a separator, like "Bo Bo", need a second pass
+		for range 2 {
+			text = p.re.ReplaceAllString(text, "${1}"+spoken+"${2}")
+		}
+	}
+	return text
+}
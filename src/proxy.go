@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// corsMaxAge is how long the browsers keep the answer to a preflight, in
+// seconds.
+const corsMaxAge = "600"
+
+// proxyRequestKey is the context key of what a reverse proxy said about the
+// request.
+type proxyRequestKey struct{}
+
+// proxyRequest is how the request reached the reverse proxy in front.
+type proxyRequest struct {
+	prefix string // Path prefix the interface is served under, e.g. "/reminder"
+	secure bool   // https, to the proxy or to us
+}
+
+// normalizeBasePath returns the path prefix as "/reminder", empty for the
+// root.
+func normalizeBasePath(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// trustedProxy reports whether the request comes from one of the
+// web_server.trusted_proxies, whose X-Forwarded-* headers are used.
+func trustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range SysConfig.WebServer.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient returns the address of the client from X-Forwarded-For,
+// the last one that is not a trusted proxy.
+func forwardedClient(header string) string {
+	hops := strings.Split(header, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			return ""
+		}
+		if !trustedProxy(hop) || i == 0 {
+			return hop
+		}
+	}
+	return ""
+}
+
+// proxyHandler serves the interface behind a reverse proxy: it takes the
+// client address, scheme, host and path prefix from the X-Forwarded-*
+// headers of the trusted proxies, removes web_server.base_path from the
+// paths, and answers the CORS requests of the allowed origins.
+func proxyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := proxyRequest{secure: r.TLS != nil}
+		if trustedProxy(r.RemoteAddr) {
+			if client := forwardedClient(r.Header.Get("X-Forwarded-For")); client != "" {
+				r.RemoteAddr = net.JoinHostPort(client, "0")
+			}
+			if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+				r.Host = strings.TrimSpace(strings.Split(host, ",")[0])
+			}
+			if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+				req.secure = strings.EqualFold(strings.TrimSpace(strings.Split(proto, ",")[0]), "https")
+			}
+			// a proxy that removes the prefix tells what it was
+			req.prefix = normalizeBasePath(r.Header.Get("X-Forwarded-Prefix"))
+		}
+
+		if base := normalizeBasePath(SysConfig.WebServer.BasePath); base != "" {
+			switch {
+			case r.URL.Path == base:
+				http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+				return
+			case strings.HasPrefix(r.URL.Path, base+"/"):
+				r.URL.Path = strings.TrimPrefix(r.URL.Path, base)
+				r.URL.RawPath = ""
+				req.prefix = base
+			}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), proxyRequestKey{}, req))
+
+		if (strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/healthz") && handleCORS(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleCORS sets the CORS headers for the allowed origins, and reports
+// whether the request was a preflight it answered.
+func handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !corsAllowed(origin) {
+		return false
+	}
+
+	// no credentials, the other sites call the API with a token
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// corsAllowed reports whether the origin is one of web_server.cors_origins.
+func corsAllowed(origin string) bool {
+	return slices.ContainsFunc(SysConfig.WebServer.CORSOrigins, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(strings.TrimRight(allowed, "/"), origin)
+	})
+}
+
+// basePath returns the path prefix the interface is served under for the
+// request, empty at the root.
+func basePath(r *http.Request) string {
+	req, _ := r.Context().Value(proxyRequestKey{}).(proxyRequest)
+	return req.prefix
+}
+
+// secureRequest reports whether the request came over https, to us or to
+// the proxy in front.
+func secureRequest(r *http.Request) bool {
+	if req, ok := r.Context().Value(proxyRequestKey{}).(proxyRequest); ok {
+		return req.secure
+	}
+	return r.TLS != nil
+}
+
+// validCORSOrigin reports whether the origin is "*" or a scheme and a host.
+func validCORSOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && strings.Trim(u.Path, "/") == ""
+}
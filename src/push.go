@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Push notification services
+const (
+	pushNextcloud = "nextcloud" // Nextcloud notify_push websocket
+	pushJMAP      = "jmap"      // JMAP event source, like Fastmail
+
+	defaultJMAPSessionURL = "https://api.fastmail.com/jmap/session"
+	pushMaxBackoff        = 5 * time.Minute
+
+	// nextcloudPingInterval is how often the notify_push connection is
+	// pinged, and jmapPingInterval how often the JMAP server is asked to
+	// ping. A connection silent for twice as long is taken as dead, e.g.
+	// dropped by a router without being closed, and reconnected.
+	nextcloudPingInterval = time.Minute
+	jmapPingInterval      = 5 * time.Minute
+)
+
+// PushSource is implemented by calendar sources that can be told about
+// changes by the server, instead of waiting for the next poll.
+type PushSource interface {
+	PushEnabled() bool
+	// WatchChanges calls changed on every change notification, it blocks
+	// until the connection drops or the context is canceled.
+	WatchChanges(ctx context.Context, connected, changed func()) error
+}
+
+var (
+	// refreshRequests wakes up the calendar refresh loop
+	refreshRequests = make(chan struct{}, 1)
+
+	pushMutex     sync.Mutex
+	pushCancel    context.CancelFunc
+	pushWatchers  int
+	pushConnected atomic.Int32
+)
+
+// requestRefresh asks for the calendars to be fetched again right away.
+func requestRefresh() {
+	select {
+	case refreshRequests <- struct{}{}:
+	default:
+	}
+}
+
+// calendarPollInterval returns how long to wait between calendar refreshes,
+// polling slows down while every source gets pushed its changes.
+func calendarPollInterval() time.Duration {
+	pushMutex.Lock()
+	watchers := pushWatchers
+	pushMutex.Unlock()
+
+	// local reminders refresh the calendars themselves when they change
+	sourcesMutex.RLock()
+	polled := 0
+	for _, src := range calendarSources {
+		if _, ok := src.(*localSource); !ok {
+			polled++
+		}
+	}
+	sourcesMutex.RUnlock()
+	allPushed := watchers > 0 && watchers == polled
+
+	if allPushed && int(pushConnected.Load()) == watchers {
+		return SysConfig.CalendarPushPollInterval
+	}
+	return SysConfig.CalendarPollInterval
+}
+
+// startPushWatchers (re)starts listening for changes on the sources that
+// support it, the previous watchers are stopped.
+func startPushWatchers(sources []CalendarSource) {
+	pushMutex.Lock()
+	defer pushMutex.Unlock()
+
+	if pushCancel != nil {
+		pushCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pushCancel = cancel
+	pushWatchers = 0
+
+	for _, src := range sources {
+		ps, ok := src.(PushSource)
+		if !ok || !ps.PushEnabled() {
+			continue
+		}
+		pushWatchers++
+		go watchSource(ctx, src.Name(), ps)
+	}
+}
+
+// watchSource keeps the push connection of a source open, reconnecting with
+// backoff when it drops.
+func watchSource(ctx context.Context, name string, ps PushSource) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		isConnected := false
+		startedAt := time.Now()
+		err := ps.WatchChanges(ctx,
+			func() {
+				isConnected = true
+				pushConnected.Add(1)
+				logInfo("Receiving push notifications for calendar source %s", name)
+				// changes may have been missed while disconnected
+				requestRefresh()
+			},
+			func() {
+				logDebug("Calendar source %s reported a change", name)
+				requestRefresh()
+			})
+		if isConnected {
+			pushConnected.Add(-1)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// a connection that lasted a while is not a failing one
+		if time.Since(startedAt) > pushMaxBackoff {
+			backoff = time.Second
+		}
+		logWarn("Push notifications for calendar source %s stopped, reconnecting in %s: %v", name, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, pushMaxBackoff)
+	}
+}
+
+// PushEnabled returns true if a push service is configured for the source.
+func (s *calDAVSource) PushEnabled() bool {
+	return s.push != ""
+}
+
+// WatchChanges implements PushSource.
+func (s *calDAVSource) WatchChanges(ctx context.Context, connected, changed func()) error {
+	switch s.push {
+	case pushNextcloud:
+		return s.watchNextcloud(ctx, connected, changed)
+	case pushJMAP:
+		return s.watchJMAP(ctx, connected, changed)
+	default:
+		return fmt.Errorf("unknown push service %q", s.push)
+	}
+}
+
+// watchNextcloud listens to the notify_push app of a Nextcloud server.
+func (s *calDAVSource) watchNextcloud(ctx context.Context, connected, changed func()) error {
+	endpoint := s.pushURL
+	if endpoint == "" {
+		var err error
+		if endpoint, err = s.nextcloudPushEndpoint(ctx); err != nil {
+			return err
+		}
+	}
+
+	conn, err := dialWebSocket(ctx, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// the pongs reset it, along with any other frame
+	conn.readTimeout = 2 * nextcloudPingInterval
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(nextcloudPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteMessage(wsOpPing, nil); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	// notify_push expects the credentials as the first two messages
+	if err := conn.WriteMessage(wsOpText, []byte(s.username)); err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(wsOpText, []byte(s.password)); err != nil {
+		return err
+	}
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		text := strings.TrimSpace(string(msg))
+		switch {
+		case text == "authenticated":
+			connected()
+		case strings.HasPrefix(text, "err:"):
+			return fmt.Errorf("notify_push: %s", text)
+		case strings.HasPrefix(text, "notify_"):
+			// calendar changes come as activity, but any change is cheap to check
+			changed()
+		}
+	}
+}
+
+// nextcloudPushEndpoint returns the notify_push websocket URL announced in
+// the server capabilities.
+func (s *calDAVSource) nextcloudPushEndpoint(ctx context.Context) (string, error) {
+	baseURL, err := discoverCalDAVURL(s.baseURL, s.username)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", baseURL, err)
+	}
+	// Nextcloud may be installed in a sub-directory, the DAV endpoint is below it
+	if i := strings.Index(u.Path, "/remote.php"); i >= 0 {
+		u.Path = u.Path[:i]
+	} else {
+		u.Path = ""
+	}
+	u.Path += "/ocs/v2.php/cloud/capabilities"
+	u.RawQuery = "format=json"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(s.username, s.password)
+	req.Header.Set("OCS-APIRequest", "true")
+
+	resp, err := (&http.Client{Timeout: discoveryTimeout}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get capabilities: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get capabilities: %s", resp.Status)
+	}
+
+	var capabilities struct {
+		OCS struct {
+			Data struct {
+				Capabilities struct {
+					NotifyPush struct {
+						Endpoints struct {
+							Websocket string `json:"websocket"`
+						} `json:"endpoints"`
+					} `json:"notify_push"`
+				} `json:"capabilities"`
+			} `json:"data"`
+		} `json:"ocs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&capabilities); err != nil {
+		return "", fmt.Errorf("failed to decode capabilities: %v", err)
+	}
+
+	endpoint := capabilities.OCS.Data.Capabilities.NotifyPush.Endpoints.Websocket
+	if endpoint == "" {
+		return "", fmt.Errorf("the notify_push app is not installed on the server")
+	}
+	return endpoint, nil
+}
+
+// watchJMAP listens to the event source of a JMAP server (RFC 8620).
+func (s *calDAVSource) watchJMAP(ctx context.Context, connected, changed func()) error {
+	sessionURL := s.pushURL
+	if sessionURL == "" {
+		sessionURL = defaultJMAPSessionURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sessionURL, nil)
+	if err != nil {
+		return err
+	}
+	s.setJMAPAuth(req)
+
+	resp, err := (&http.Client{Timeout: discoveryTimeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get JMAP session: %v", err)
+	}
+	var session struct {
+		EventSourceURL string `json:"eventSourceUrl"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&session)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get JMAP session: %s", resp.Status)
+	}
+	if err != nil || session.EventSourceURL == "" {
+		return fmt.Errorf("JMAP session has no event source: %v", err)
+	}
+
+	ping := strconv.Itoa(int(jmapPingInterval.Seconds()))
+	eventURL := strings.NewReplacer("{types}", "*", "{closeafter}", "no", "{ping}", ping).Replace(session.EventSourceURL)
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err = http.NewRequestWithContext(streamCtx, http.MethodGet, eventURL, nil)
+	if err != nil {
+		return err
+	}
+	s.setJMAPAuth(req)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// no timeout, the stream stays open, the pings keep it alive
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to open JMAP event source: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to open JMAP event source: %s", resp.Status)
+	}
+	connected()
+
+	// a stream without even the pings is dead, every line resets it
+	idle := time.AfterFunc(2*jmapPingInterval, cancel)
+	defer idle.Stop()
+
+	event := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		idle.Reset(2 * jmapPingInterval)
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case line == "":
+			// a blank line ends the event, state events mean something changed
+			if event == "state" {
+				changed()
+			}
+			event = ""
+		}
+	}
+	if ctx.Err() == nil && streamCtx.Err() != nil {
+		return fmt.Errorf("no ping from the JMAP event source for %s", 2*jmapPingInterval)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("JMAP event source closed")
+}
+
+// setJMAPAuth authenticates a JMAP request with the API token if there is
+// one, the account credentials otherwise.
+func (s *calDAVSource) setJMAPAuth(req *http.Request) {
+	if s.pushToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.pushToken)
+		return
+	}
+	req.SetBasicAuth(s.username, s.password)
+}
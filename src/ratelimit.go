@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateWindow is the window of the announcement limits.
+const rateWindow = time.Hour
+
+// spokenAnnouncement is an announcement counted against the limits.
+type spokenAnnouncement struct {
+	at      time.Time
+	eventID string
+}
+
+var (
+	rateMutex sync.Mutex
+	spokenLog []spokenAnnouncement
+)
+
+// droppableKind returns true if announcements of the kind can be dropped to
+// stay within the limits. The others, like the starts, are always spoken but
+// still count.
+func droppableKind(kind string) bool {
+	switch kind {
+	case announceKindRemind, announceKindCheckStart, announceKindPreStart:
+		return true
+	}
+	return false
+}
+
+// takeSpeechSlot counts an announcement of the given kind about the event,
+// if any, against the hourly limits. It returns an error instead if the
+// announcement can be dropped and would go over a limit.
+func takeSpeechSlot(e *LocalEvent, kind string) error {
+	rateMutex.Lock()
+	defer rateMutex.Unlock()
+
+	now := clockNow()
+	kept := spokenLog[:0]
+	for _, s := range spokenLog {
+		if now.Sub(s.at) < rateWindow {
+			kept = append(kept, s)
+		}
+	}
+	spokenLog = kept
+
+	eventID := ""
+	if e != nil {
+		eventID = e.Event.ID
+	}
+
+	if droppableKind(kind) {
+		limit := SysConfig.RateLimit
+		if limit.MaxPerHour > 0 && len(spokenLog) >= limit.MaxPerHour {
+			return fmt.Errorf("rate limited, %d announcements in the last hour", len(spokenLog))
+		}
+		if limit.MaxPerEventPerHour > 0 && eventID != "" {
+			count := 0
+			for _, s := range spokenLog {
+				if s.eventID == eventID {
+					count++
+				}
+			}
+			if count >= limit.MaxPerEventPerHour {
+				return fmt.Errorf("rate limited, %d announcements of the event in the last hour", count)
+			}
+		}
+	}
+
+	spokenLog = append(spokenLog, spokenAnnouncement{at: now, eventID: eventID})
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Recap sorts the events of a day by how they went.
+type Recap struct {
+	Done        []string `json:"done"`        // completed or acknowledged, also by saying it started
+	Unconfirmed []string `json:"unconfirmed"` // announced, but never confirmed as started
+	Missed      []string `json:"missed"`      // never announced, e.g. the device was off
+}
+
+// summary counts the events of the recap, in a line.
+func (r Recap) summary() string {
+	return fmt.Sprintf("Today: %d done, %d not confirmed, %d missed", len(r.Done), len(r.Unconfirmed), len(r.Missed))
+}
+
+// text lists the events of the recap, under a heading for each state.
+func (r Recap) text() string {
+	var text strings.Builder
+	for _, section := range []struct {
+		title  string
+		events []string
+	}{
+		{"Done", r.Done},
+		{"Never confirmed as started", r.Unconfirmed},
+		{"Missed", r.Missed},
+	} {
+		if len(section.events) == 0 {
+			continue
+		}
+		text.WriteString(section.title + ":\n")
+		for _, title := range section.events {
+			text.WriteString("- " + title + "\n")
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+// recapTime returns the configured recap time, or empty if the recap is disabled.
+func recapTime() string {
+	if !SysConfig.RecapConfig.Enabled {
+		return ""
+	}
+	return SysConfig.RecapConfig.Time
+}
+
+// buildRecap sorts today's events that already started. Events that are not
+// announced on purpose, like skipped or all-day ones, are left out.
+func buildRecap() (Recap, error) {
+	recap := Recap{Done: []string{}, Unconfirmed: []string{}, Missed: []string{}}
+
+	events, err := loadAllTodayEvents()
+	if err != nil {
+		return recap, err
+	}
+
+	now := clockNow()
+	for i := range events {
+		e := &events[i]
+		if e.Event.AllDay || now.Before(e.Event.StartTime) {
+			continue
+		}
+		// completed events are not announced anymore, but they are still done
+		if !e.Completed && announceMode(e) == announceModeSkip {
+			continue
+		}
+
+		title := e.spokenTitle()
+		switch {
+		case e.Completed || e.Acknowledged || e.ReviewAnswer == reviewAnswerYes:
+			recap.Done = append(recap.Done, title)
+		case e.StartAnnounced:
+			recap.Unconfirmed = append(recap.Unconfirmed, title)
+		default:
+			recap.Missed = append(recap.Missed, title)
+		}
+	}
+
+	return recap, nil
+}
+
+// recapDay announces how the day went, and emails and posts it.
+func recapDay() {
+	recap, err := buildRecap()
+	if err != nil {
+		logError("failed to load events for recap: %v", err)
+		return
+	}
+	if len(recap.Done)+len(recap.Unconfirmed)+len(recap.Missed) == 0 {
+		logInfo("Nothing to recap today")
+		return
+	}
+
+	// the email and the chats get it even while paused, they are read elsewhere
+	emailRecapOf(recap)
+	postRecapToChats(recap)
+	if isPaused() {
+		logInfo("Announcements are paused, skipping the recap")
+		return
+	}
+
+	WithFields(map[string]interface{}{
+		"done":        recap.Done,
+		"unconfirmed": recap.Unconfirmed,
+		"missed":      recap.Missed,
+	}).Info("Day recap")
+
+	announceTask(nil, announceKindRecap, renderRecapMessage(recap))
+}
+
+// joinSpoken joins the items as they would be read out, "a, b and c".
+func joinSpoken(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+// recapTemplateData is what the recap template can use.
+type recapTemplateData struct {
+	Done             string
+	Unconfirmed      string
+	Missed           string
+	DoneCount        int
+	UnconfirmedCount int
+	MissedCount      int
+}
+
+func renderRecapMessage(recap Recap) string {
+	parts := []string{"Here is how today went."}
+	if len(recap.Done) > 0 {
+		parts = append(parts, fmt.Sprintf("Done: %s.", joinSpoken(recap.Done)))
+	}
+	if len(recap.Unconfirmed) > 0 {
+		parts = append(parts, fmt.Sprintf("Never confirmed as started: %s.", joinSpoken(recap.Unconfirmed)))
+	}
+	if len(recap.Missed) > 0 {
+		parts = append(parts, fmt.Sprintf("Missed: %s.", joinSpoken(recap.Missed)))
+	}
+	defaultMessage := strings.Join(parts, " ")
+
+	tmplText := SysConfig.RecapConfig.MessageTemplate.pick()
+	if tmplText == "" {
+		return defaultMessage
+	}
+
+	tmpl, err := template.New("recap").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse recap template: %v", err)
+		return defaultMessage
+	}
+
+	data := recapTemplateData{
+		Done:             joinSpoken(recap.Done),
+		Unconfirmed:      joinSpoken(recap.Unconfirmed),
+		Missed:           joinSpoken(recap.Missed),
+		DoneCount:        len(recap.Done),
+		UnconfirmedCount: len(recap.Unconfirmed),
+		MissedCount:      len(recap.Missed),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logError("failed to execute recap template: %v", err)
+		return defaultMessage
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// isRecurringMaster returns true if the event defines a recurrence rather
+// than being a single (possibly server-expanded) instance.
+func isRecurringMaster(ev ical.Event) bool {
+	return ev.Props.Get(ical.PropRecurrenceRule) != nil || ev.Props.Get(ical.PropRecurrenceDates) != nil
+}
+
+// instanceID returns the ID of a single occurrence of a recurring event,
+// based on its original start time, so server-expanded and locally expanded
+// instances end up with the same ID.
+func instanceID(uid string, recurrenceID time.Time) string {
+	return uid + "-" + recurrenceID.UTC().Format("20060102T150405Z")
+}
+
+// expandRecurrence returns the start times of the occurrences of a recurring
+// event that start between after and before, honoring RRULE, RDATE and EXDATE.
+func expandRecurrence(ev ical.Event, dtStart time.Time, after, before time.Time) ([]time.Time, error) {
+	loc := dtStart.Location()
+	set := rrule.Set{}
+	set.DTStart(dtStart)
+
+	if prop := ev.Props.Get(ical.PropRecurrenceRule); prop != nil {
+		roption, err := rrule.StrToROptionInLocation(prop.Value, loc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rrule %q: %v", prop.Value, err)
+		}
+		roption.Dtstart = dtStart
+
+		rule, err := rrule.NewRRule(*roption)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build rrule %q: %v", prop.Value, err)
+		}
+		set.RRule(rule)
+	} else {
+		// with only RDATEs the first occurrence is DTSTART itself
+		set.RDate(dtStart)
+	}
+
+	rdates, err := propDateTimes(ev.Props.Values(ical.PropRecurrenceDates), loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rdate: %v", err)
+	}
+	for _, t := range rdates {
+		set.RDate(t)
+	}
+
+	exdates, err := propDateTimes(ev.Props.Values(ical.PropExceptionDates), loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse exdate: %v", err)
+	}
+	for _, t := range exdates {
+		set.ExDate(t)
+	}
+
+	return set.Between(after, before, true), nil
+}
+
+// propDateTimes parses the date-times of list properties like EXDATE and
+// RDATE, every property can hold several comma separated values.
+func propDateTimes(props []ical.Prop, loc *time.Location) ([]time.Time, error) {
+	times := []time.Time{}
+	for _, prop := range props {
+		// periods are not supported, those are very rare in practice
+		if prop.Params.Get(ical.ParamValue) == "PERIOD" {
+			continue
+		}
+
+		for _, value := range strings.Split(prop.Value, ",") {
+			p := prop
+			p.Value = strings.TrimSpace(value)
+			t, err := p.DateTime(loc)
+			if err != nil {
+				return nil, err
+			}
+			times = append(times, t)
+		}
+	}
+	return times, nil
+}
@@ -10,7 +10,33 @@ import (
 
 var reminding sync.Mutex
 
-func remindCurrentEvents() {
+// How an event is announced
+const (
+	announceModeNormal = "normal" // start, check, reminders and end
+	announceModeOnce   = "once"   // a single mention, no repeats
+	announceModeSkip   = "skip"   // not announced at all
+)
+
+// All-day event modes
+const (
+	allDayModeMorning = "morning" // a single mention at the configured morning time
+	allDayModeOnce    = "once"    // a single mention as soon as the day starts or the event is added
+	allDayModeSkip    = "skip"    // not announced
+)
+
+// remindCurrentEvents announces what is due for today's events, at most one
+// announcement per event. It returns true if anything was announced.
+func remindCurrentEvents() bool {
+	// nothing is marked as announced while paused, what is still running
+	// when the pause ends is announced then
+	if isPaused() {
+		return false
+	}
+	// nor while nobody is in the room to hear it
+	if deferForPresence() {
+		return false
+	}
+
 	events, err := loadTodayEvents()
 	if err != nil {
 		logError("failed to load events: %v", err)
@@ -20,7 +46,39 @@ func remindCurrentEvents() {
 	reminding.Lock()
 	defer reminding.Unlock()
 
+	announced := false
+	var reminders, upcoming []LocalEvent
 	for _, e := range events {
+		mode := announceMode(&e)
+		if mode == announceModeSkip {
+			continue
+		}
+		// the caregivers are texted whatever is announced this round
+		textMissedCriticalEvent(&e)
+		if alarm, ok := dueAlarm(&e); ok {
+			logDebug("Announcing calendar alarm")
+			e.setAlarmAnnounced(alarm)
+			text := renderAlarmMessage(&e)
+			announceTask(&e, announceKindAlarm, text)
+			announced = true
+			continue
+		}
+		if leads, ok := duePreStart(&e); ok && mode == announceModeNormal {
+			logDebug("Announcing event pre-start")
+			e.setPreStartAnnounced(leads)
+			text := renderPreStartMessage(&e)
+			announceTask(&e, announceKindPreStart, text)
+			announced = true
+			continue
+		}
+		if dueCatchUp(&e) {
+			logDebug("Catching up on event missed while off")
+			e.setCaughtUp()
+			text := renderCatchUpMessage(&e)
+			announceTask(&e, announceKindCatchUp, text)
+			announced = true
+			continue
+		}
 		if shouldAnnounceEventStart(&e) {
 			logDebug("Announcing event start")
 			// Set event announced and reminded, otherwise last reminded
@@ -28,61 +86,158 @@ func remindCurrentEvents() {
 			e.setStartAnnounced()
 			e.setReminded()
 			// Announce it
-			text := renderAnnounceStartMessage(&e)
-			announceTask(text)
+			kind, text := announceKindStart, renderAnnounceStartMessage(&e)
+			if e.Event.AllDay {
+				kind, text = announceKindAllDay, renderAllDayMessage(&e)
+			} else if isPrivate(&e.Event) {
+				text = renderPrivateStartMessage(&e)
+			}
+			announceTask(&e, kind, text)
+			announced = true
 			// if we just announced the start, don't check for reminders
 			continue
 		}
-		if shouldCheckEventStarted(&e) {
+		if mode == announceModeOnce {
+			continue
+		}
+		if sent, ok := dueStartCheck(&e); ok {
 			logDebug("Checking event started")
 			// Set event start checked
-			e.setStartChecked()
+			e.setStartChecked(sent)
 			// Announce event start
 			text := renderCheckStartMessage(&e)
-			announceTask(text)
+			announceTask(&e, announceKindCheckStart, text)
+			// the question is answered by voice, without holding up the others
+			go listenForStartAnswer(e)
+			announced = true
 			// if we checked for start, don't check for other conditions
 			continue
 		}
 		if shouldRemindEvent(&e) {
 			logDebug("Reminding event")
-			// Set reminded time to now
-			e.setReminded()
-			// Remind it
-			text := renderRemindMessage(&e)
-			announceTask(text)
+			// the reminders are given together after the loop
+			reminders = append(reminders, e)
 			// if we just reminded, don't check for end
 			continue
 		}
+		if SysConfig.BatchReminders.Enabled && reminderDueWithin(&e, SysConfig.BatchReminders.Window) {
+			upcoming = append(upcoming, e)
+		}
 		if shouldAnnounceEventEnd(&e) {
 			logDebug("Announcing event end")
 			// Set event end announced
 			e.setEndAnnounced()
 			// Announce event end
 			text := renderAnnounceEndMessage(&e)
-			announceTask(text)
+			announceTask(&e, announceKindEnd, text)
+			announced = true
+			// tell the caregivers if nobody answered the reminders
+			if e.StartAnnounced && !e.Acknowledged && !e.Completed {
+				emailUnacknowledgedEvent(&e)
+			}
 		}
 	}
+
+	// reminders due soon are given along with the due ones, rather than
+	// one right after the other
+	if len(reminders) > 0 {
+		remindEvents(append(reminders, upcoming...))
+		announced = true
+	}
+
+	return announced
 }
 
-func shouldAnnounceEventStart(e *LocalEvent) bool {
-	if !e.StartAnnounced && e.scheduledForToday() && e.scheduledForNow() {
-		return true
+// announceMode returns how the event should be announced.
+func announceMode(e *LocalEvent) string {
+	// nothing left to remind about
+	if e.Completed {
+		return announceModeSkip
 	}
 
-	return false
+	if e.Event.Status == eventStatusCancelled && !SysConfig.EventStatus.AnnounceCancelled {
+		return announceModeSkip
+	}
+
+	mode := announceModeNormal
+	if e.Event.AllDay {
+		mode = announceModeOnce
+		if SysConfig.AllDayEvents.Mode == allDayModeSkip {
+			mode = announceModeSkip
+		}
+	}
+	if rule, ok := matchEventRule(&e.Event); ok && rule.Mode != "" {
+		mode = quieterMode(mode, rule.Mode)
+	}
+	if profile := reminderProfile(&e.Event); profile.Mode != "" {
+		mode = quieterMode(mode, profile.Mode)
+	}
+	if e.Event.Status == eventStatusTentative {
+		mode = quieterMode(mode, SysConfig.EventStatus.TentativeMode)
+	}
+	if e.Event.Transparent {
+		mode = quieterMode(mode, SysConfig.EventStatus.TransparentMode)
+	}
+
+	tags := eventTags(&e.Event)
+	if tags.Silent {
+		mode = announceModeSkip
+	} else if tags.Once {
+		mode = quieterMode(mode, announceModeOnce)
+	}
+
+	return mode
 }
 
-func shouldCheckEventStarted(e *LocalEvent) bool {
-	if !e.scheduledForToday() || !e.StartAnnounced || e.CheckStartAnnounced {
+// quieterMode returns the announce mode that announces less of the two.
+func quieterMode(a, b string) string {
+	rank := map[string]int{announceModeNormal: 0, announceModeOnce: 1, announceModeSkip: 2}
+	if _, ok := rank[b]; !ok {
+		logWarn("invalid announce mode %q, using %q", b, announceModeNormal)
+		return a
+	}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func shouldAnnounceEventStart(e *LocalEvent) bool {
+	if e.StartAnnounced || e.Acknowledged || !e.scheduledForToday() || !e.scheduledForNow() {
 		return false
 	}
 
-	// If we one minute is passed since the event started, check if it has started
-	if time.Now().After(e.Event.StartTime.Add(time.Minute)) {
-		return true
+	// all-day events start at midnight, wait for the morning to mention them
+	if e.Event.AllDay && SysConfig.AllDayEvents.Mode != allDayModeOnce {
+		morning, err := todayAt(SysConfig.AllDayEvents.MorningTime)
+		if err != nil {
+			logError("invalid all-day morning time: %v", err)
+			return false
+		}
+		return clockNow().After(morning)
 	}
 
-	return false
+	return true
+}
+
+// dueStartCheck returns true if a follow-up asking whether the event started
+// is due, along with the number of follow-ups sent once it is given. The ones
+// that were missed, for example while paused, are skipped.
+func dueStartCheck(e *LocalEvent) (int, bool) {
+	if !e.scheduledForToday() || !e.StartAnnounced || e.Acknowledged {
+		return 0, false
+	}
+
+	now := clockNow()
+	if now.After(e.Event.EndTime) || now.Before(e.SnoozedUntil) {
+		return 0, false
+	}
+
+	sent := e.CheckStartsSent
+	for sent < len(SysConfig.CheckStartDelays) && now.After(e.Event.StartTime.Add(SysConfig.CheckStartDelays[sent])) {
+		sent++
+	}
+	return sent, sent > e.CheckStartsSent
 }
 
 func shouldAnnounceEventEnd(e *LocalEvent) bool {
@@ -94,43 +249,79 @@ func shouldAnnounceEventEnd(e *LocalEvent) bool {
 }
 
 func shouldRemindEvent(e *LocalEvent) bool {
-	now := time.Now()
-	if e.EndAnnounced || e.Event.EndTime.IsZero() || now.Before(e.Event.StartTime) || now.After(e.Event.EndTime) {
+	now := clockNow()
+	if e.EndAnnounced || e.Acknowledged || e.Event.EndTime.IsZero() || now.Before(e.Event.StartTime) || now.After(e.Event.EndTime) {
 		return false
 	}
 
-	// check if we are in remiding period, which is every (totalDuration / NotificationRepeats) times
-	reminderInterval := e.Event.EndTime.Sub(e.Event.StartTime) / time.Duration(SysConfig.NotificationRepeats)
-	if now.After(e.LastTimeReminded.Add(reminderInterval)) {
+	// the calendar alarms replace the periodic reminders if configured so
+	if hasCalendarAlarms(e) && SysConfig.CalendarAlarms.ReplaceReminders {
+		return false
+	}
+
+	// a snooze ends with a reminder
+	if now.Before(e.SnoozedUntil) {
+		return false
+	}
+	if e.LastTimeReminded.Before(e.SnoozedUntil) {
+		return true
+	}
+
+	// check if we are in remiding period, which is every (totalDuration / NotificationRepeats) times,
+	// unless a fixed reminder interval is set or the event text has its own interval or repeats
+	if now.After(e.LastTimeReminded.Add(reminderInterval(e))) {
 		return true
 	}
 
 	return false
 }
 
+// reminderInterval returns the time between two reminders of the event.
+func reminderInterval(e *LocalEvent) time.Duration {
+	tags := eventTags(&e.Event)
+	if tags.RemindInterval > 0 {
+		return escalatedInterval(e, tags.RemindInterval)
+	}
+
+	// the most specific setting wins, a fixed interval over the repeats at the same level
+	duration := e.Event.EndTime.Sub(e.Event.StartTime)
+	profile := reminderProfile(&e.Event)
+	switch {
+	case tags.Repeats > 0:
+		return escalatedInterval(e, duration/time.Duration(tags.Repeats))
+	case profile.ReminderInterval > 0:
+		return escalatedInterval(e, profile.ReminderInterval)
+	case profile.NotificationRepeats > 0:
+		return escalatedInterval(e, duration/time.Duration(profile.NotificationRepeats))
+	case SysConfig.ReminderInterval > 0:
+		return escalatedInterval(e, SysConfig.ReminderInterval)
+	}
+	return escalatedInterval(e, duration/time.Duration(SysConfig.NotificationRepeats))
+}
+
 func timeLeftString(e *LocalEvent) string {
-	left := time.Until(e.Event.EndTime)
+	left := clockUntil(e.Event.EndTime)
 	return fmt.Sprintf("%d minutes", int(left.Minutes()))
 }
 
 func renderAnnounceStartMessage(e *LocalEvent) string {
-	defaultConfig := fmt.Sprintf("Hey! Time to tackle \"%s\"! You have \"%s\" scheduled for now.", e.Event.Description, e.Event.Description)
-	tmplText := SysConfig.AnnounceMessageTemplate
+	defaultConfig := fmt.Sprintf("Hey! Time to tackle \"%s\"! You have \"%s\" scheduled for now.", e.spokenTitle(), e.spokenTitle())
+	variants := SysConfig.AnnounceMessageTemplate
+	if profile := reminderProfile(&e.Event); len(profile.AnnounceMessageTemplate) > 0 {
+		variants = profile.AnnounceMessageTemplate
+	}
+	tmplText := variants.pick()
 	if tmplText == "" {
 		tmplText = "Hey! Time to tackle \"{{.Event}}\"! You have \"{{.Event}}\" scheduled for now."
 	}
 
-	tmpl, err := template.New("announce_start").Parse(tmplText)
+	tmpl, err := template.New("announce_start").Funcs(templateFuncs).Parse(tmplText)
 	if err != nil {
 		logError("failed to parse announce template: %v", err)
 		return defaultConfig
 	}
 
-	data := struct {
-		Event string
-	}{
-		Event: e.Event.Description,
-	}
+	data := newEventTemplateData(e)
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
@@ -138,27 +329,54 @@ func renderAnnounceStartMessage(e *LocalEvent) string {
 		return defaultConfig
 	}
 
+	if streak := habitEncouragement(e); streak != "" {
+		return buf.String() + " " + streak
+	}
+	return buf.String()
+}
+
+func renderAllDayMessage(e *LocalEvent) string {
+	defaultMessage := fmt.Sprintf("Good morning! Today you have \"%s\".", e.spokenTitle())
+	tmplText := SysConfig.AllDayEvents.MessageTemplate.pick()
+	if tmplText == "" {
+		tmplText = "Good morning! Today you have \"{{.Event}}\"."
+	}
+
+	tmpl, err := template.New("all_day").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse all-day template: %v", err)
+		return defaultMessage
+	}
+
+	data := newEventTemplateData(e)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logError("failed to execute all-day template: %v", err)
+		return defaultMessage
+	}
+
 	return buf.String()
 }
 
 func renderAnnounceEndMessage(e *LocalEvent) string {
-	defaultConfig := fmt.Sprintf("Hey! The \"%s\" is over now!", e.Event.Description)
-	tmplText := SysConfig.AnnounceEndMessageTemplate
+	defaultConfig := fmt.Sprintf("Hey! The \"%s\" is over now!", e.spokenTitle())
+	variants := SysConfig.AnnounceEndMessageTemplate
+	if profile := reminderProfile(&e.Event); len(profile.AnnounceEndMessageTemplate) > 0 {
+		variants = profile.AnnounceEndMessageTemplate
+	}
+	tmplText := variants.pick()
 	if tmplText == "" {
 		tmplText = "Hey! The \"{{.Event}}\" is over now!"
 	}
 
-	tmpl, err := template.New("announce_end").Parse(tmplText)
+	tmpl, err := template.New("announce_end").Funcs(templateFuncs).Parse(tmplText)
 	if err != nil {
 		logError("failed to parse announce template: %v", err)
 		return defaultConfig
 	}
 
-	data := struct {
-		Event string
-	}{
-		Event: e.Event.Description,
-	}
+	data := newEventTemplateData(e)
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
@@ -170,23 +388,23 @@ func renderAnnounceEndMessage(e *LocalEvent) string {
 }
 
 func renderCheckStartMessage(e *LocalEvent) string {
-	defaultConfig := fmt.Sprintf("Hey! Did you start \"%s\"?", e.Event.Description)
-	tmplText := SysConfig.CheckStartMessageTemplate
+	defaultConfig := fmt.Sprintf("Hey! Did you start \"%s\"?", e.spokenTitle())
+	variants := SysConfig.CheckStartMessageTemplate
+	if profile := reminderProfile(&e.Event); len(profile.CheckStartMessageTemplate) > 0 {
+		variants = profile.CheckStartMessageTemplate
+	}
+	tmplText := variants.pick()
 	if tmplText == "" {
 		tmplText = "Hey! Did you start \"{{.Event}}\"?"
 	}
 
-	tmpl, err := template.New("checkstart").Parse(tmplText)
+	tmpl, err := template.New("checkstart").Funcs(templateFuncs).Parse(tmplText)
 	if err != nil {
 		logError("failed to parse checkstart template: %v", err)
 		return defaultConfig
 	}
 
-	data := struct {
-		Event string
-	}{
-		Event: e.Event.Description,
-	}
+	data := newEventTemplateData(e)
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
@@ -198,25 +416,26 @@ func renderCheckStartMessage(e *LocalEvent) string {
 }
 
 func renderRemindMessage(e *LocalEvent) string {
-	defaultMessage := fmt.Sprintf("You have %s left for %s", timeLeftString(e), e.Event.Description)
-	tmplText := SysConfig.RemindMessageTemplate
+	defaultMessage := fmt.Sprintf("You have %s left for %s", timeLeftString(e), e.spokenTitle())
+	variants := SysConfig.RemindMessageTemplate
+	if profile := reminderProfile(&e.Event); len(profile.RemindMessageTemplate) > 0 {
+		variants = profile.RemindMessageTemplate
+	}
+	if step, level := escalationStep(e); level > 0 && len(step.MessageTemplate) > 0 {
+		variants = step.MessageTemplate
+	}
+	tmplText := variants.pick()
 	if tmplText == "" {
 		tmplText = "You have {{.TimeLeft}} left for {{.Event}}"
 	}
 
-	tmpl, err := template.New("remind").Parse(tmplText)
+	tmpl, err := template.New("remind").Funcs(templateFuncs).Parse(tmplText)
 	if err != nil {
 		logError("failed to parse remind template: %v", err)
 		return defaultMessage
 	}
 
-	data := struct {
-		Event    string
-		TimeLeft string
-	}{
-		Event:    e.Event.Description,
-		TimeLeft: timeLeftString(e),
-	}
+	data := newEventTemplateData(e)
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
@@ -227,9 +446,54 @@ func renderRemindMessage(e *LocalEvent) string {
 	return buf.String()
 }
 
-func announceTask(speech string) {
-	err := aiSpeak(speech)
+// currentAnnouncementKind returns the kind of announcement that fits the
+// event now, the reminder once it started, else its start.
+func currentAnnouncementKind(e *LocalEvent) string {
+	if e.StartAnnounced && e.scheduledForNow() {
+		return announceKindRemind
+	}
+	return announceKindStart
+}
+
+// renderAnnouncement renders the announcement of the given kind for the event.
+func renderAnnouncement(e *LocalEvent, kind string) (string, error) {
+	switch kind {
+	case announceKindStart:
+		if e.Event.AllDay {
+			return renderAllDayMessage(e), nil
+		}
+		if isPrivate(&e.Event) {
+			return renderPrivateStartMessage(e), nil
+		}
+		return renderAnnounceStartMessage(e), nil
+	case announceKindAllDay:
+		return renderAllDayMessage(e), nil
+	case announceKindPreStart:
+		return renderPreStartMessage(e), nil
+	case announceKindAlarm:
+		return renderAlarmMessage(e), nil
+	case announceKindCatchUp:
+		return renderCatchUpMessage(e), nil
+	case announceKindCheckStart:
+		return renderCheckStartMessage(e), nil
+	case announceKindRemind:
+		return renderRemindMessage(e), nil
+	case announceKindEnd:
+		return renderAnnounceEndMessage(e), nil
+	}
+	return "", fmt.Errorf("invalid announcement kind %q", kind)
+}
+
+func announceTask(e *LocalEvent, kind, speech string) {
+	if err := takeSpeechSlot(e, kind); err != nil {
+		logWarn("Dropping %s announcement \"%s\": %v", kind, speech, err)
+		recordAnnouncement(e, kind, speech, err)
+		return
+	}
+
+	err := aiSpeakAs(kind, e, speech)
 	if err != nil {
 		logError("failed to announce task: %v", err)
 	}
+	recordAnnouncement(e, kind, speech, err)
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+const (
+	reviewAnswerYes  = "yes"
+	reviewAnswerNo   = "no"
+	reviewAnswerNone = "none"
+)
+
+var (
+	reviewActive  atomic.Bool
+	reviewAnswers = make(chan string, 1)
+	reviewLog     sync.Mutex
+)
+
+// ReviewResult is a single answer from an end-of-day review.
+type ReviewResult struct {
+	Date       string    `json:"date"`
+	EventID    string    `json:"event_id"`
+	Event      string    `json:"event"`
+	Answer     string    `json:"answer"`
+	AnsweredAt time.Time `json:"answered_at"`
+}
+
+// reviewTime returns the configured review time, or empty if the review is disabled.
+func reviewTime() string {
+	if !SysConfig.ReviewConfig.Enabled {
+		return ""
+	}
+	return SysConfig.ReviewConfig.Time
+}
+
+// reviewDay asks about every event of today that was not reviewed yet and
+// records the answers.
+func reviewDay() {
+	if isPaused() {
+		logInfo("Announcements are paused, skipping the review")
+		return
+	}
+
+	events, err := loadAllTodayEvents()
+	if err != nil {
+		logError("failed to load events for review: %v", err)
+		return
+	}
+
+	pending := make([]LocalEvent, 0)
+	for _, e := range events {
+		if e.ReviewAnswer == "" && clockNow().After(e.Event.StartTime) && !e.Acknowledged && !e.Completed && announceMode(&e) != announceModeSkip {
+			pending = append(pending, e)
+		}
+	}
+	if len(pending) == 0 {
+		logInfo("Nothing to review today")
+		return
+	}
+
+	reviewActive.Store(true)
+	defer reviewActive.Store(false)
+
+	// drop an answer that arrived while no question was asked
+	select {
+	case <-reviewAnswers:
+	default:
+	}
+
+	announceTask(nil, announceKindReview, fmt.Sprintf("Let's go over today. I have %d things to ask you about.", len(pending)))
+	for i := range pending {
+		e := &pending[i]
+		announceTask(e, announceKindReview, renderReviewQuestion(e))
+		// answered by voice, the button or the web interface, the first wins
+		go listenForReviewAnswer(e)
+
+		answer := reviewAnswerNone
+		select {
+		case answer = <-reviewAnswers:
+		case <-time.After(SysConfig.ReviewConfig.AnswerTimeout):
+			logInfo("No review answer for %s", e.Event.Description)
+		}
+
+		if answer != reviewAnswerNone {
+			if err := e.setReviewAnswer(answer); err != nil {
+				logError("failed to save review answer for %s: %v", e.Event.ID, err)
+			}
+		}
+		if err := appendReviewResult(e, answer); err != nil {
+			logError("failed to record review result for %s: %v", e.Event.ID, err)
+		}
+	}
+	announceTask(nil, announceKindReview, "Thanks, that's all for today.")
+}
+
+// listenForReviewAnswer listens for the answer to the review question about
+// the event and passes it on.
+func listenForReviewAnswer(e *LocalEvent) {
+	if !SysConfig.VoiceAnswers.Enabled || simulating || SysConfig.DryRun {
+		return
+	}
+
+	samples, err := listen(SysConfig.VoiceAnswers.Window)
+	if err != nil {
+		logError("Failed to listen for the review answer: %v", err)
+		return
+	}
+	if len(samples) == 0 {
+		return
+	}
+
+	text, err := transcribe(samples)
+	if err != nil {
+		logError("Failed to recognize the review answer: %v", err)
+		return
+	}
+	answer := parseYesNo(text)
+	if answer == "" {
+		logInfo("Review answer %q about %s not understood", text, e.Event.Description)
+		return
+	}
+	if err := answerReview(answer); err != nil {
+		logDebug("Review answer %s by voice not taken: %v", answer, err)
+		return
+	}
+	logInfo("Review of %s answered %s by voice", e.Event.ID, answer)
+}
+
+// answerReview passes an answer to the question the review is waiting on.
+func answerReview(answer string) error {
+	if answer != reviewAnswerYes && answer != reviewAnswerNo {
+		return fmt.Errorf("invalid answer %q", answer)
+	}
+	if !reviewActive.Load() {
+		return fmt.Errorf("no review in progress")
+	}
+
+	select {
+	case reviewAnswers <- answer:
+	default:
+		return fmt.Errorf("an answer is already pending")
+	}
+	return nil
+}
+
+// appendReviewResult adds the review result to the review history.
+func appendReviewResult(e *LocalEvent, answer string) error {
+	reviewLog.Lock()
+	defer reviewLog.Unlock()
+
+	result := ReviewResult{
+		Date:       e.Event.StartTime.Format("2006-01-02"),
+		EventID:    e.Event.ID,
+		Event:      e.Event.Description,
+		Answer:     answer,
+		AnsweredAt: time.Now(),
+	}
+	line, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal review result: %v", err)
+	}
+
+	historyPath := realPath(reviewsPath)
+	if err := os.MkdirAll(filepath.Dir(historyPath), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	f, err := os.OpenFile(historyPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open review history: %v", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func renderReviewQuestion(e *LocalEvent) string {
+	defaultMessage := fmt.Sprintf("Did you do \"%s\"?", e.spokenTitle())
+	tmplText := SysConfig.ReviewConfig.QuestionTemplate.pick()
+	if tmplText == "" {
+		tmplText = "Did you do \"{{.Event}}\"?"
+	}
+
+	tmpl, err := template.New("review").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse review template: %v", err)
+		return defaultMessage
+	}
+
+	data := newEventTemplateData(e)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logError("failed to execute review template: %v", err)
+		return defaultMessage
+	}
+
+	return buf.String()
+}
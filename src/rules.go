@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileEventRules prepares the configured event rules, rules that can't
+// match anything are dropped.
+func compileEventRules() {
+	rules := []EventRule{}
+	for i, rule := range SysConfig.EventRules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		if len(rule.Categories) == 0 && rule.TitleRegex == "" && rule.Calendar == "" && !rule.Holiday {
+			logError("event rule %s has no conditions, ignoring it", rule.Name)
+			continue
+		}
+
+		if rule.Priority != "" {
+			priority, ok := validPriority(rule.Priority)
+			if !ok {
+				logError("invalid priority %q in event rule %s, ignoring it", rule.Priority, rule.Name)
+				continue
+			}
+			rule.Priority = priority
+		}
+
+		// rules are mostly used to silence events, unless they set a priority
+		// or a sound
+		if rule.Mode == "" && rule.Priority == "" && rule.Sound == "" {
+			rule.Mode = announceModeSkip
+		}
+
+		if rule.TitleRegex != "" {
+			re, err := regexp.Compile(rule.TitleRegex)
+			if err != nil {
+				logError("invalid title regex in event rule %s, ignoring it: %v", rule.Name, err)
+				continue
+			}
+			rule.titleRegex = re
+		}
+
+		if rule.SoundOnly && rule.Sound == "" {
+			logError("event rule %s has sound_only without a sound, ignoring sound_only", rule.Name)
+			rule.SoundOnly = false
+		}
+
+		rules = append(rules, rule)
+	}
+	SysConfig.EventRules = rules
+
+	profiles := map[string]ReminderProfile{}
+	for name, profile := range SysConfig.PriorityProfiles {
+		priority, ok := validPriority(name)
+		if !ok {
+			logError("invalid priority profile %q, expected high, normal or low", name)
+			continue
+		}
+		profiles[priority] = profile
+	}
+	SysConfig.PriorityProfiles = profiles
+}
+
+// matchEventRule returns the first rule matching the event, all the
+// conditions set on a rule must match.
+func matchEventRule(e *CalendarEvent) (EventRule, bool) {
+	for _, rule := range SysConfig.EventRules {
+		if rule.Calendar != "" && !inCalendar(e, rule.Calendar) {
+			continue
+		}
+		if rule.titleRegex != nil && !rule.titleRegex.MatchString(e.Description) {
+			continue
+		}
+		if len(rule.Categories) > 0 && !hasAnyCategory(e, rule.Categories) {
+			continue
+		}
+		if rule.Holiday && !isHoliday(e.StartTime) {
+			continue
+		}
+		return rule, true
+	}
+
+	return EventRule{}, false
+}
+
+// hasAnyCategory returns true if the event has any of the categories.
+func hasAnyCategory(e *CalendarEvent, categories []string) bool {
+	for _, want := range categories {
+		for _, have := range e.Categories {
+			if strings.EqualFold(strings.TrimSpace(want), have) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// schedulerMaxSleep bounds how long the scheduler sleeps, the wake up
+	// times are computed from the wall clock which can jump, e.g. when a Pi
+	// without a real-time clock gets its time over the network.
+	schedulerMaxSleep = 5 * time.Minute
+	// schedulerSlack is added to the wake up times, the announcements are
+	// due once the time is strictly after them.
+	schedulerSlack = 100 * time.Millisecond
+	// schedulerMaxRounds bounds the rounds run back to back, in case an
+	// announcement can't be marked as done and keeps being due.
+	schedulerMaxRounds = 10
+)
+
+var (
+	scheduleMutex  sync.Mutex
+	scheduleChange = make(chan struct{})
+)
+
+// scheduleChanges returns a channel that is closed on the next reschedule.
+func scheduleChanges() <-chan struct{} {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	return scheduleChange
+}
+
+// reschedule wakes up the schedulers, for them to compute their next wake
+// up time again after the events, the config or the pause changed.
+func reschedule() {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	close(scheduleChange)
+	scheduleChange = make(chan struct{})
+}
+
+// sleepUntil waits until the given time, or until the next reschedule.
+func sleepUntil(at time.Time, changes <-chan struct{}) {
+	timer := time.NewTimer(min(time.Until(at), schedulerMaxSleep))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-changes:
+	}
+}
+
+// runScheduler announces the events when they are due, sleeping in between
+// until the next announcement time.
+func runScheduler() {
+	for {
+		changes := scheduleChanges()
+
+		// an event announces one thing per round, run until nothing is due
+		for i := 0; i < schedulerMaxRounds; i++ {
+			if !remindCurrentEvents() {
+				break
+			}
+		}
+
+		// what is next may have changed
+		refreshDisplay()
+
+		next := nextAnnouncementTime()
+		logDebug("Next announcement check at %s", next.Format(time.TimeOnly))
+		sleepUntil(next, changes)
+	}
+}
+
+// nextAnnouncementTime returns the earliest time something may have to be
+// announced.
+func nextAnnouncementTime() time.Time {
+	now := clockNow()
+	next := now.Add(schedulerMaxSleep)
+
+	// all-day events and the day's events change at midnight
+	if midnight := startOfDay(now).AddDate(0, 0, 1); midnight.Before(next) {
+		next = midnight
+	}
+
+	if pause := getPause(); now.Before(pause.Until) {
+		if pause.Until.Before(next) {
+			next = pause.Until
+		}
+		return next.Add(schedulerSlack)
+	}
+	// the deferred announcements are tried again, or made when someone
+	// comes in
+	if retry, ok := presenceRetryTime(); ok && retry.Before(next) {
+		next = retry
+	}
+
+	events, err := loadTodayEvents()
+	if err != nil {
+		logError("failed to load events for scheduling: %v", err)
+		return next
+	}
+
+	for i := range events {
+		for _, t := range dueTimes(&events[i]) {
+			if t.After(now) && t.Before(next) {
+				next = t
+			}
+		}
+	}
+
+	return next.Add(schedulerSlack)
+}
+
+// nextEventAnnouncement returns when something is planned to be announced
+// about the event, zero if nothing is. Like dueTimes, it can be early.
+func nextEventAnnouncement(e *LocalEvent) time.Time {
+	if e.EndAnnounced || announceMode(e) == announceModeSkip {
+		return time.Time{}
+	}
+
+	now := clockNow()
+	end := e.Event.EndTime.Add(-time.Minute)
+	var next time.Time
+	for _, t := range dueTimes(e) {
+		if !t.After(now) {
+			continue
+		}
+		// only the end is still announced for acknowledged and snoozed events
+		if !t.Equal(end) && (e.Acknowledged || t.Before(e.SnoozedUntil)) {
+			continue
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// dueTimes returns the times at which something about the event may become
+// due. It can return more than what is actually announced, waking up for
+// nothing is cheap, missing a time is not.
+func dueTimes(e *LocalEvent) []time.Time {
+	ev := &e.Event
+	times := []time.Time{
+		ev.StartTime,
+		ev.EndTime.Add(-time.Minute), // end announcement
+	}
+
+	for _, delay := range SysConfig.CheckStartDelays {
+		times = append(times, ev.StartTime.Add(delay))
+	}
+
+	if ev.AllDay {
+		if morning, err := todayAt(SysConfig.AllDayEvents.MorningTime); err == nil {
+			times = append(times, morning)
+		}
+	}
+
+	if SysConfig.CalendarAlarms.Enabled {
+		times = append(times, ev.Alarms...)
+	}
+
+	if SysConfig.PreStartReminders.Enabled || reminderProfile(ev).PreStart {
+		for _, lead := range SysConfig.PreStartReminders.Leads {
+			times = append(times, ev.StartTime.Add(-lead))
+		}
+	}
+
+	if e.StartAnnounced && !e.LastTimeReminded.IsZero() {
+		times = append(times, e.LastTimeReminded.Add(reminderInterval(e)))
+	}
+
+	if !e.SnoozedUntil.IsZero() {
+		times = append(times, e.SnoozedUntil)
+	}
+
+	return times
+}
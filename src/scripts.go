@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scriptChannel runs a command for the announcements of its kinds, with the
+// announcement in REMINDER_* environment variables and as the JSON of the
+// webhooks on stdin.
+type scriptChannel struct {
+	script ScriptConfig
+}
+
+func scriptChannels() []NotificationChannel {
+	channels := make([]NotificationChannel, 0, len(SysConfig.Scripts))
+	for _, script := range SysConfig.Scripts {
+		channels = append(channels, scriptChannel{script})
+	}
+	return channels
+}
+
+func (c scriptChannel) Name() string { return "script:" + c.script.Name }
+
+func (c scriptChannel) Wants(ev AnnouncementEvent) bool {
+	return len(c.script.Command) > 0 && mirrorsKind(c.script.Kinds, ev.Kind)
+}
+
+func (c scriptChannel) Send(ev AnnouncementEvent) error {
+	spoken := ev.Success
+	input, err := json.Marshal(WebhookPayload{
+		Type:   webhookType(ev.Kind),
+		Time:   ev.Time,
+		Event:  webhookEvent(ev.Event),
+		Kind:   ev.Kind,
+		Text:   ev.Text,
+		Spoken: &spoken,
+		Error:  ev.Error,
+	})
+	if err != nil {
+		return permanentError{err}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.script.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.script.Command[0], c.script.Command[1:]...)
+	cmd.Dir = SysRootDir
+	cmd.Env = append(os.Environ(), scriptEnv(ev)...)
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		// the script may have done part of its work, it is not run twice
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", c.script.Timeout)
+		}
+		return permanentError{fmt.Errorf("%s: %v: %s", c.script.Command[0], err, strings.TrimSpace(string(out)))}
+	}
+	if len(out) > 0 {
+		logDebug("Script %s: %s", c.script.Name, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// scriptEnv returns the environment variables the scripts are told about
+// the announcement with.
+func scriptEnv(ev AnnouncementEvent) []string {
+	env := []string{
+		"REMINDER_TYPE=" + webhookType(ev.Kind),
+		"REMINDER_KIND=" + ev.Kind,
+		"REMINDER_TEXT=" + ev.Text,
+		"REMINDER_SPOKEN=" + strconv.FormatBool(ev.Success),
+		"REMINDER_ERROR=" + ev.Error,
+		"REMINDER_TIME=" + ev.Time.Format(time.RFC3339),
+	}
+	if e := webhookEvent(ev.Event); e != nil {
+		env = append(env,
+			"REMINDER_EVENT_ID="+e.ID,
+			"REMINDER_EVENT_TITLE="+e.Title,
+			"REMINDER_EVENT_START="+e.Start.Format(time.RFC3339),
+			"REMINDER_EVENT_END="+e.End.Format(time.RFC3339),
+			"REMINDER_EVENT_ALL_DAY="+strconv.FormatBool(e.AllDay),
+			"REMINDER_EVENT_CALENDAR="+e.Calendar,
+			"REMINDER_EVENT_PRIORITY="+e.Priority,
+		)
+	}
+	return env
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// secretMask stands for a secret value in the web interface, saving it back
+// keeps the value of the file.
+const secretMask = "********"
+
+// SecretField is a value of the secrets file, as the secrets form shows it.
+type SecretField struct {
+	Path   string `json:"path"` // Dotted YAML keys, e.g. "calendar_sources.0.password"
+	Label  string `json:"label"`
+	Secret bool   `json:"secret"`          // The value is masked
+	Hashed bool   `json:"hashed"`          // A password of the web interface, saved hashed
+	Set    bool   `json:"set"`             // Whether it has a value
+	Value  string `json:"value,omitempty"` // Empty for the secret ones
+	Help   string `json:"help,omitempty"`
+}
+
+// isSecretKey returns whether the values of the key are credentials.
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"password", "token", "secret", "api_key"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHashedPassword returns whether the key is a password of the web
+// interface, which is kept as a bcrypt hash.
+func isHashedPassword(path string) bool {
+	parts := strings.Split(path, ".")
+	if len(parts) == 1 {
+		return path == "web_server_password"
+	}
+	return len(parts) == 3 && parts[0] == "users" && parts[2] == "password"
+}
+
+// isScalarLine returns whether the key holds a value of its own, rather than
+// a mapping, a list or a block.
+func isScalarLine(k yamlKeyLine) bool {
+	return !k.commented && k.value != "" && !yamlBlockScalarMark.MatchString(k.value) &&
+		!strings.HasPrefix(k.value, "[") && !strings.HasPrefix(k.value, "{")
+}
+
+// secretFields returns the values of the secrets file text.
+func secretFields(text string) []SecretField {
+	fields := []SecretField{}
+	for _, k := range yamlKeyLines(text) {
+		if !isScalarLine(k) {
+			continue
+		}
+		value := yamlScalar(k.value)
+		field := SecretField{
+			Path:   k.path,
+			Label:  configLabel(k.key),
+			Secret: isSecretKey(k.key),
+			Hashed: isHashedPassword(k.path),
+			Set:    value != "",
+			Help:   k.comment,
+		}
+		if !field.Secret {
+			field.Value = value
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// maskSecrets returns the secrets file text with the secret values replaced
+// by secretMask.
+func maskSecrets(text string) string {
+	return maskSecretsChanged(text, text)
+}
+
+// maskSecretsChanged masks the secrets file text like maskSecrets, the
+// values that differ in the previous text are marked, so that a diff of the
+// masked texts still shows them.
+func maskSecretsChanged(text, previous string) string {
+	old := map[string]string{}
+	for _, k := range yamlKeyLines(previous) {
+		if isScalarLine(k) {
+			old[k.path] = k.value
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	for _, k := range yamlKeyLines(text) {
+		if !isScalarLine(k) || !isSecretKey(k.key) || yamlScalar(k.value) == "" {
+			continue
+		}
+		mask := secretMask
+		if value, ok := old[k.path]; ok && value != k.value {
+			mask += " (changed)"
+		}
+		lines[k.line] = yamlKeyLineWith(lines[k.line], k, strconv.Quote(mask))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// unmaskSecrets returns the secrets file text with the masked values put
+// back from the current file text.
+func unmaskSecrets(text, current string) (string, error) {
+	saved := map[string]yamlKeyLine{}
+	for _, k := range yamlKeyLines(current) {
+		if isScalarLine(k) {
+			saved[k.path] = k
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	for _, k := range yamlKeyLines(text) {
+		if !isScalarLine(k) || yamlScalar(k.value) != secretMask {
+			continue
+		}
+		old, ok := saved[k.path]
+		if !ok {
+			return "", fmt.Errorf("%s has no saved value to keep, enter it", k.path)
+		}
+		lines[k.line] = yamlKeyLineWith(lines[k.line], k, old.value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// secretFieldYAML returns a value of the secrets form as a YAML value, the
+// passwords of the web interface hashed.
+func secretFieldYAML(field SecretField, value string) (string, error) {
+	if !field.Hashed {
+		return strconv.Quote(value), nil
+	}
+	if value == "" {
+		return "", fmt.Errorf("the password can't be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(value), 12)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash the password: %v", err)
+	}
+	return strconv.Quote(string(hash)), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownTimeout bounds how long the announcement being spoken and the
+// writes of the event state are waited for before exiting anyway.
+const shutdownTimeout = 30 * time.Second
+
+var stopping sync.Mutex
+
+// handleShutdown says goodbye when the service is stopped, then exits.
+func handleShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	stopApplication(sig.String(), false)
+}
+
+// stopApplication exits, or restarts the application in the same process
+// so a service manager keeps tracking it. The announcement being spoken is
+// finished first and the event state written, then nothing else is said or
+// saved until the exit, and the notifications still queued are sent.
+// reason tells who asked, for the logs.
+func stopApplication(reason string, restart bool) {
+	// the first request wins, the others wait for the exit
+	stopping.Lock()
+
+	action := "shutting down"
+	if restart {
+		action = "restarting"
+	}
+	logInfo("Received %s, %s", reason, action)
+	if !restart {
+		speakSystemMessage(SysMessages.SystemSleepMessages)
+	}
+
+	// the locks are kept until the exit: a round of announcements is
+	// finished, with what it marked as announced, and nothing starts after
+	done := make(chan struct{})
+	go func() {
+		reminding.Lock()
+		speaking.Lock()
+		syncEvent.Lock()
+		historyLog.Lock()
+		reviewLog.Lock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		logWarn("Still speaking or saving after %s, %s anyway", shutdownTimeout, action)
+	}
+	// the announcements, emails and texts already on their way are sent
+	drainChannels(channelDrainTimeout)
+
+	closeLogging()
+	if !restart {
+		os.Exit(0)
+	}
+
+	executable, err := os.Executable()
+	if err == nil {
+		// the open files and sockets are closed on exec
+		err = syscall.Exec(executable, os.Args, os.Environ())
+	}
+	// a service manager restarts it after a failure
+	logrus.Errorf("Failed to restart: %v", err)
+	os.Exit(1)
+}
+
+// closeLogging closes the log file, the logs still go to the console.
+func closeLogging() {
+	logrus.SetOutput(os.Stdout)
+	if logWriter == nil {
+		return
+	}
+	if err := logWriter.Close(); err != nil {
+		logrus.Errorf("Failed to close the log file: %v", err)
+	}
+}
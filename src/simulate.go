@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// simulating is set while a day is simulated: the clock is fake, nothing is
+// spoken and the events are only kept in memory, the real state is untouched.
+var simulating bool
+
+// runSimulateCommand runs the reminders of a whole day on a fake clock,
+// printing the announcements instead of speaking them:
+//
+//	simple-reminder simulate [YYYY-MM-DD]
+//
+// It returns the process exit code.
+func runSimulateCommand(args []string) int {
+	day := startOfDay(time.Now())
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s simulate [YYYY-MM-DD]\n", os.Args[0])
+		return 2
+	}
+	if len(args) == 1 {
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", args[0], time.Local); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid date %q, expected YYYY-MM-DD\n", args[0])
+			return 2
+		}
+	}
+
+	simulating = true
+	// only the announcements and the problems are of interest
+	logrus.SetLevel(logrus.WarnLevel)
+
+	start, end := startOfDay(day), endOfDay(day)
+	setFakeClock(start)
+	startedAt = start
+
+	if err := loadHolidays(); err != nil {
+		fmt.Fprintf(os.Stderr, "Holidays could not be read, simulating without them: %v\n", err)
+	}
+
+	events, err := getCalEvents(start, end)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Some calendars could not be read, simulating without them: %v\n", err)
+	}
+	if err := syncLocalEvents(events, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load events: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Simulating %s, %d events\n", day.Format("Monday, January 2 2006"), len(events))
+
+	for t := start; t.Before(end); {
+		setFakeClock(t)
+		for i := 0; i < schedulerMaxRounds; i++ {
+			if !remindCurrentEvents() {
+				break
+			}
+		}
+
+		next := nextAnnouncementTime()
+		if !next.After(t) {
+			next = t.Add(time.Second)
+		}
+		t = next
+	}
+
+	return 0
+}
+
+// printSimulated prints an announcement made during a simulation, with the simulated time.
+func printSimulated(text string) {
+	fmt.Printf("%s  %s\n", clockNow().Format(time.TimeOnly), text)
+}
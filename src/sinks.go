@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Audio sink types, besides the network speakers
+const (
+	sinkLocal     = "local"
+	sinkBluetooth = "bluetooth"
+)
+
+var (
+	sinksMutex sync.Mutex
+	audioSinks []AudioSinkConfig
+	// sinkOverrides are the sinks switched on or off from the web
+	// interface, by name, until the next restart
+	sinkOverrides = make(map[string]bool)
+)
+
+// SinkStatus is an audio sink as returned by the API
+type SinkStatus struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Enabled  bool   `json:"enabled"`
+	Override bool   `json:"override"` // Switched on or off from the web interface
+}
+
+// compileSinks checks the sinks of the config, the invalid ones are ignored.
+func compileSinks() {
+	sinks := []AudioSinkConfig{}
+	for i, sink := range SysConfig.Sinks {
+		sink.Type = strings.ToLower(sink.Type)
+		if sink.Name == "" {
+			sink.Name = fmt.Sprintf("sink-%d", i+1)
+		}
+
+		switch sink.Type {
+		case sinkLocal:
+		case sinkBluetooth, netSpeakerChromecast, netSpeakerSonos:
+			if sink.Address == "" {
+				logError("audio sink %s has no address, ignoring it", sink.Name)
+				continue
+			}
+		default:
+			logError("invalid type %q of audio sink %s, ignoring it", sink.Type, sink.Name)
+			continue
+		}
+
+		if sinkIndex(sinks, sink.Name) >= 0 {
+			logError("duplicate audio sink %s, ignoring it", sink.Name)
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+	audioSinks = sinks
+}
+
+func sinkIndex(sinks []AudioSinkConfig, name string) int {
+	for i, sink := range sinks {
+		if sink.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// configuredSinks returns the sinks of the config.
+func configuredSinks() []AudioSinkConfig {
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+	return audioSinks
+}
+
+// listSinks returns the sinks with their state.
+func listSinks() []SinkStatus {
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+
+	list := make([]SinkStatus, 0, len(audioSinks))
+	for _, sink := range audioSinks {
+		enabled, override := sinkOverrides[sink.Name]
+		if !override {
+			enabled = !sink.Disabled
+		}
+		list = append(list, SinkStatus{Name: sink.Name, Type: sink.Type, Enabled: enabled, Override: override})
+	}
+	return list
+}
+
+// setSinkEnabled switches a sink on or off until the next restart.
+func setSinkEnabled(name string, enabled bool) error {
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+
+	if sinkIndex(audioSinks, name) < 0 {
+		return fmt.Errorf("unknown audio sink %q", name)
+	}
+	sinkOverrides[name] = enabled
+	return nil
+}
+
+// playOnSinks plays the clip on all the enabled sinks at once, and returns
+// once it has been played everywhere. It only fails if no sink played it.
+func playOnSinks(clip audioClip) error {
+	sinks := configuredSinks()
+
+	var (
+		wg     sync.WaitGroup
+		mutex  sync.Mutex
+		errs   []error
+		played int
+	)
+	for _, status := range listSinks() {
+		if !status.Enabled {
+			continue
+		}
+		sink := sinks[sinkIndex(sinks, status.Name)]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := playOnSink(sink, clip)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				logWarn("Failed to play on audio sink %s: %v", sink.Name, err)
+				errs = append(errs, fmt.Errorf("%s: %v", sink.Name, err))
+				return
+			}
+			played++
+		}()
+	}
+	wg.Wait()
+
+	if played == 0 && len(errs) == 0 {
+		logWarn("All the audio sinks are disabled, nothing was played")
+		return nil
+	}
+	if played == 0 {
+		return fmt.Errorf("failed to play on the audio sinks: %v", errors.Join(errs...))
+	}
+	return nil
+}
+
+// playOnSink plays the clip on a sink and returns once it has been played.
+func playOnSink(sink AudioSinkConfig, clip audioClip) error {
+	switch sink.Type {
+	case sinkLocal:
+		if sink.Device != "" {
+			return playOnDevice(sink.Device, clip.PCM, clip.SampleRate, clip.Channels)
+		}
+		return playOnContext(convertClip(clip, audioOutputRate, audioOutputChannels).PCM)
+	case sinkBluetooth:
+		return playOnBluetooth(sink.Address, sink.Device, clip.PCM, clip.SampleRate, clip.Channels)
+	default:
+		return playOnNetSpeaker(NetworkSpeakerConfig{Type: sink.Type, Address: sink.Address, MediaURL: sink.MediaURL}, clip)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	twilioAPI  = "https://api.twilio.com/2010-04-01/Accounts/"
+	smsTimeout = 15 * time.Second
+)
+
+// smsEnabled returns true if the texts are enabled and Twilio is set up.
+func smsEnabled() bool {
+	secrets := SysSecrets.Twilio
+	return SysConfig.SMS.Enabled && secrets.AccountSID != "" && secrets.AuthToken != "" && secrets.From != "" && len(secrets.Caregivers) > 0
+}
+
+// isCritical returns true if the caregivers are texted about the event, it
+// has the [critical] tag or one of the categories of sms.categories.
+func isCritical(e *LocalEvent) bool {
+	return eventTags(&e.Event).Critical || hasAnyCategory(&e.Event, SysConfig.SMS.Categories)
+}
+
+// textMissedCriticalEvent texts the caregivers once about a critical event
+// that was announced but is still not acknowledged, sms.window after its
+// start.
+func textMissedCriticalEvent(e *LocalEvent) {
+	if simulating || SysConfig.DryRun || !smsEnabled() || e.CaregiversTexted || !e.StartAnnounced || e.Acknowledged || e.Completed {
+		return
+	}
+	if e.Event.AllDay || clockNow().Before(e.Event.StartTime.Add(SysConfig.SMS.Window)) || !isCritical(e) {
+		return
+	}
+
+	// marked first, a failed text is not sent again every round
+	if err := e.update(func(l *LocalEvent) { l.CaregiversTexted = true }); err != nil {
+		logError("failed to save the text about event %s: %v", e.Event.ID, err)
+		return
+	}
+
+	text := fmt.Sprintf("%s, at %s, was not acknowledged after %s.",
+		e.spokenTitle(), e.Event.StartTime.Format("15:04"), formatDuration(SysConfig.SMS.Window))
+	textCaregivers(e, text)
+}
+
+// textCaregivers texts every caregiver in the background about the event, or
+// about the emergency if there is none, with the location of the device if
+// set. The texts are retried and counted like the notification channels.
+func textCaregivers(e *LocalEvent, text string) {
+	if location := SysConfig.PanicConfig.Location; location != "" {
+		text += " (" + location + ")"
+	}
+	about := "the emergency"
+	if e != nil {
+		about = "event " + e.Event.ID
+	}
+	for _, caregiver := range SysSecrets.Twilio.Caregivers {
+		deliverAsync(smsChannel, func() error {
+			return sendSMS(caregiver.Phone, text)
+		}, func(err error) {
+			if err != nil {
+				logError("Failed to text %s about %s: %v", caregiver.Name, about, err)
+				return
+			}
+			logInfo("Texted %s about %s", caregiver.Name, about)
+		})
+	}
+}
+
+// sendSMS sends the text to the phone number through Twilio. A text Twilio
+// rejects is a permanentError.
+func sendSMS(to, text string) error {
+	secrets := SysSecrets.Twilio
+	form := url.Values{
+		"To":   {to},
+		"From": {secrets.From},
+		"Body": {text},
+	}
+	endpoint := twilioAPI + url.PathEscape(secrets.AccountSID) + "/Messages.json"
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(secrets.AccountSID, secrets.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := (&http.Client{Timeout: smsTimeout}).Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	permanent := resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
+
+	var answer struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	err = fmt.Errorf("%s", resp.Status)
+	if json.NewDecoder(resp.Body).Decode(&answer) == nil && answer.Message != "" {
+		err = fmt.Errorf("%s: %s (error %d)", resp.Status, answer.Message, answer.Code)
+	}
+	if permanent {
+		return permanentError{err}
+	}
+	return err
+}
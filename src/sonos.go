@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sonosPort         = "1400"
+	sonosControlPath  = "/MediaRenderer/AVTransport/Control"
+	sonosService      = "urn:schemas-upnp-org:service:AVTransport:1"
+	sonosPollInterval = time.Second
+)
+
+// sonosPlay plays the media URL on a Sonos speaker through UPnP, and returns
+// once it was played or the timeout is reached. It replaces what the speaker
+// was playing.
+func sonosPlay(address, url string, timeout time.Duration) error {
+	client := &http.Client{Timeout: netSpeakerDialTimeout}
+	control := fmt.Sprintf("http://%s%s", address, sonosControlPath)
+
+	args := fmt.Sprintf("<InstanceID>0</InstanceID><CurrentURI>%s</CurrentURI><CurrentURIMetaData></CurrentURIMetaData>", html.EscapeString(url))
+	if _, err := sonosAction(client, control, "SetAVTransportURI", args); err != nil {
+		return err
+	}
+	if _, err := sonosAction(client, control, "Play", "<InstanceID>0</InstanceID><Speed>1</Speed>"); err != nil {
+		return err
+	}
+
+	// played once the speaker stops
+	deadline := time.Now().Add(timeout)
+	played := false
+	for time.Now().Before(deadline) {
+		time.Sleep(sonosPollInterval)
+
+		body, err := sonosAction(client, control, "GetTransportInfo", "<InstanceID>0</InstanceID>")
+		if err != nil {
+			logWarn("Failed to get the Sonos state: %v", err)
+			continue
+		}
+		var info struct {
+			State string `xml:"Body>GetTransportInfoResponse>CurrentTransportState"`
+		}
+		if err := xml.Unmarshal(body, &info); err != nil {
+			return fmt.Errorf("invalid Sonos state: %v", err)
+		}
+
+		switch info.State {
+		case "PLAYING", "TRANSITIONING":
+			played = true
+		default:
+			if played {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// sonosAction calls an AVTransport action and returns the response.
+func sonosAction(client *http.Client, control, action, args string) ([]byte, error) {
+	envelope := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:%s xmlns:u="%s">%s</u:%s></s:Body></s:Envelope>`, action, sonosService, args, action)
+
+	req, err := http.NewRequest(http.MethodPost, control, strings.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPACTION", fmt.Sprintf(`"%s#%s"`, sonosService, action))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s to the Sonos: %v", action, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Sonos response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the Sonos refused %s: %s %s", action, resp.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
@@ -2,136 +2,279 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
 	"os"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-audio/wav"
-	"github.com/hajimehoshi/oto/v2"
 	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
 )
 
 var (
-	ttsHandle *sherpa.OfflineTts
-	ttsConfig sherpa.OfflineTtsConfig
+	// speaking serializes access to the audio device, announcements can
+	// come from the reminder loop and from the web interface at the same time.
+	speaking sync.Mutex
+	// speakingNow is set while speaking, for the status LED
+	speakingNow atomic.Bool
 )
 
+// playbackPollInterval is how often the end of the playback is checked.
+const playbackPollInterval = 10 * time.Millisecond
+
 func initSherpaTts() error {
-	ttsConfig.Model.NumThreads = SysConfig.TtsConfig.Model.NumThreads
-	ttsConfig.Model.Provider = SysConfig.TtsConfig.Model.Provider
-	ttsConfig.MaxNumSentences = SysConfig.TtsConfig.MaxNumSentences
-
-	if strings.ToLower(SysConfig.AiSpeechTtsConfig.TtsModel) == "kokoro" {
-		ttsConfig.Model.Kokoro.Model = realPath(SysConfig.AiSpeechTtsConfig.KokoroModel)
-		ttsConfig.Model.Kokoro.Voices = realPath(SysConfig.AiSpeechTtsConfig.KokoroVoices)
-		ttsConfig.Model.Kokoro.Tokens = realPath(SysConfig.AiSpeechTtsConfig.KokoroTokens)
-		ttsConfig.Model.Kokoro.DataDir = realPath(SysConfig.AiSpeechTtsConfig.KokoroDataDir)
-		ttsConfig.Model.Kokoro.LengthScale = SysConfig.AiSpeechTtsConfig.KokoroLengthScale
-		SysConfig.AiSpeechTtsConfig.Speaker = SysConfig.AiSpeechTtsConfig.KokoroSpeaker
-	} else if strings.ToLower(SysConfig.AiSpeechTtsConfig.TtsModel) == "glados" {
-		ttsConfig.Model.Vits.Model = realPath(SysConfig.AiSpeechTtsConfig.GladosModel)
-		ttsConfig.Model.Vits.Lexicon = realPath(SysConfig.AiSpeechTtsConfig.GladosLexicon)
-		ttsConfig.Model.Vits.Tokens = realPath(SysConfig.AiSpeechTtsConfig.GladosTokens)
-		ttsConfig.Model.Vits.DataDir = realPath(SysConfig.AiSpeechTtsConfig.GladosDataDir)
-		ttsConfig.Model.Vits.NoiseScale = 0.667
-		ttsConfig.Model.Vits.NoiseScaleW = 0.8
-		SysConfig.AiSpeechTtsConfig.Speaker = 0
-	}
-
-	ttsHandle = sherpa.NewOfflineTts(&ttsConfig)
+	model := defaultTtsModel()
+	if _, err := ttsModel(model); err != nil {
+		logError("Failed to load the %s TTS model: %v", model, err)
+	}
+
+	// the models of the other voices are loaded now too, loading one takes seconds
+	for kind, v := range SysConfig.Voices {
+		preloadVoice(kind, v)
+	}
+	for lang, v := range SysConfig.Languages.Voices {
+		preloadVoice(lang, v)
+	}
 	return nil
 }
 
 func aiSpeak(text string) error {
-	err := sherpaSpeak(ttsHandle, text, SysConfig.AiSpeechTtsConfig.Speaker)
+	return aiSpeakAs("", nil, text)
+}
+
+// aiSpeakAs speaks an announcement of the given kind, after its chime. The
+// event, if any, can have its own voice.
+func aiSpeakAs(kind string, e *LocalEvent, text string) error {
+	if simulating {
+		printSimulated(text)
+		return nil
+	}
+	if SysConfig.DryRun {
+		logInfo("Dry run, not speaking: %s", text)
+		return nil
+	}
+
+	speaking.Lock()
+	defer speaking.Unlock()
+	speakingNow.Store(true)
+	defer speakingNow.Store(false)
+
+	// for those who may not hear it
+	if kind != "" {
+		flashLights(kind)
+	}
+	// a dark room gets the lights and the LED only
+	if nightMode(kind) == nightActionSilent {
+		logInfo("Room is dark, not speaking: %s", text)
+		showSilentAnnouncement()
+		return nil
+	}
+
+	// the sound of the event replaces the chime, or the speech
+	chime := chimeSound(kind)
+	sound, soundOnly := eventSound(e)
+	if soundOnly {
+		logInfo("Playing %s instead of: %s", sound, text)
+		if err := playSoundFile(realPath(sound), speechVolume(kind)); err != nil {
+			return fmt.Errorf("failed to play %s: %w", sound, err)
+		}
+		return nil
+	}
+	if sound != "" {
+		chime = sound
+	}
+
+	err := speakText(kind, text, voiceFor(kind, e), chime, speechVolume(kind))
 	if err != nil {
+		// the system voice or its cached speech may still work, tell that
+		// something was missed
+		if kind != "" {
+			apology := SysMessages.SpeechErrorMessages.pick()
+			if err := speakText("", apology, voiceFor("", nil), "", speechVolume("")); err != nil {
+				logError("Failed to speak the speech error message: %v", err)
+			}
+		}
 		return fmt.Errorf("failed to speak: %w", err)
 	}
 	return nil
 }
 
-func sherpaSpeak(ttsHandle *sherpa.OfflineTts, text string, ttsSpeaker int) error {
-	logDebug("Generating audio for %s", text)
+// speakText generates the speech and plays it, after the chime if one is
+// given, the chime is played once the speech is ready so there is no gap.
+func speakText(kind, text string, v voice, chime string, volume float64) error {
+	text = applyPronunciations(text)
+	engine := speechEngine(kind, v.Model)
+	clip, cached := cachedSpeech(engine, text, v.Speaker)
+	if !cached {
+		used, generated, err := generateSpeech(kind, text, v)
+		if err != nil {
+			return err
+		}
+		clip = generated
+		cacheSpeech(used, text, v.Speaker, clip)
+	}
 
-	audio := ttsHandle.Generate(text, ttsSpeaker, SysConfig.AiSpeechTtsConfig.Speed)
-	if audio == nil {
-		return fmt.Errorf("failed to generate audio")
+	if chime != "" {
+		playChime(chime, volume)
 	}
 
-	tempFile, err := os.CreateTemp("/tmp", "speech_generated_*.wav")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+	if err := playClip(clip, volume); err != nil {
+		logError("Failed to play speech: %v", err)
+		return fmt.Errorf("failed to play audio: %w", err)
+	}
+
+	return nil
+}
+
+// generateSpeech generates the speech with the local model of the voice,
+// unless the kind is spoken by the cloud provider. Local generation falls back to the cloud
+// if configured so, and the cloud always falls back to local. It returns the
+// engine that generated the speech.
+func generateSpeech(kind, text string, v voice) (string, audioClip, error) {
+	if useCloudTts(kind) {
+		clip, err := cloudGenerate(text)
+		if err == nil {
+			return cloudEngine(), clip, nil
+		}
+		logWarn("Cloud speech generation failed, using the local model: %v", err)
+	}
+
+	tts, err := ttsModel(v.Model)
+	if err == nil {
+		var clip audioClip
+		if clip, err = sherpaGenerate(tts, text, v.Speaker); err == nil {
+			return localEngine(v.Model), clip, nil
+		}
 	}
-	filename := tempFile.Name()
-	tempFile.Close()
-	defer func() {
-		if err := os.Remove(filename); err != nil {
-			logError("Failed to remove temporary audio file %s: %v", filename, err)
+	if SysConfig.CloudTts.Fallback && cloudTtsEnabled() && !useCloudTts(kind) {
+		logWarn("Local speech generation failed, using %s: %v", SysConfig.CloudTts.Provider, err)
+		clip, err := cloudGenerate(text)
+		if err != nil {
+			return "", audioClip{}, err
 		}
-	}()
+		return cloudEngine(), clip, nil
+	}
+	return "", audioClip{}, err
+}
 
-	// save and play
-	ok := audio.Save(filename)
-	if !ok {
-		return fmt.Errorf("failed to save audio")
+// sherpaGenerate generates the speech with the local model.
+func sherpaGenerate(ttsHandle *sherpa.OfflineTts, text string, ttsSpeaker int) (audioClip, error) {
+	if ttsHandle == nil {
+		return audioClip{}, fmt.Errorf("no TTS model loaded")
 	}
 
-	if err := playWavFile(filename); err != nil {
-		logError("Failed to play audio file %s: %v", filename, err)
-		return fmt.Errorf("failed to play audio: %w", err)
+	logDebug("Generating audio for %s", text)
+	audio := ttsHandle.Generate(text, ttsSpeaker, SysConfig.AiSpeechTtsConfig.Speed)
+	if audio == nil || len(audio.Samples) == 0 {
+		return audioClip{}, fmt.Errorf("failed to generate audio")
 	}
 
-	return nil
+	// the model generates mono samples from -1 to 1
+	pcm := make([]byte, len(audio.Samples)*2)
+	for i, sample := range audio.Samples {
+		s16 := int16(max(-1, min(1, sample)) * math.MaxInt16)
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(s16))
+	}
+	return audioClip{PCM: pcm, SampleRate: audio.SampleRate, Channels: 1}, nil
 }
 
-func playWavFile(filename string) error {
-	logDebug("Playing audio...")
+// audioClip is audio ready to be played, 16-bit little-endian PCM samples.
+type audioClip struct {
+	PCM        []byte
+	SampleRate int
+	Channels   int
+}
 
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open WAV file: %w", err)
+// duration returns how long the clip plays.
+func (c audioClip) duration() time.Duration {
+	if c.SampleRate == 0 || c.Channels == 0 {
+		return 0
 	}
-	defer file.Close()
+	return time.Duration(len(c.PCM)/(2*c.Channels)) * time.Second / time.Duration(c.SampleRate)
+}
 
-	decoder := wav.NewDecoder(file)
+// decodeWav reads a 16-bit WAV file from memory.
+func decodeWav(data []byte) (audioClip, error) {
+	decoder := wav.NewDecoder(bytes.NewReader(data))
 	if !decoder.IsValidFile() {
-		return fmt.Errorf("invalid WAV file: %s", filename)
+		return audioClip{}, fmt.Errorf("invalid WAV data")
+	}
+	if decoder.BitDepth != 16 {
+		return audioClip{}, fmt.Errorf("unsupported WAV bit depth %d, expected 16", decoder.BitDepth)
 	}
-	format := decoder.Format()
-	sampleRate := int(format.SampleRate)
-	channels := int(format.NumChannels)
-	logDebug("Playing WAV file: %s (Sample Rate: %d, Channels: %d)", filename, sampleRate, channels)
 
 	buf, err := decoder.FullPCMBuffer()
 	if err != nil {
-		return fmt.Errorf("failed to decode WAV file: %w", err)
+		return audioClip{}, fmt.Errorf("failed to decode WAV data: %w", err)
 	}
 
-	// Convert audio data to bytes (16-bit PCM, little-endian)
-	audioData := make([]byte, len(buf.Data)*2)
+	pcm := make([]byte, len(buf.Data)*2)
 	for i, sample := range buf.Data {
-		s16 := int16(sample)
-		audioData[i*2] = byte(s16)
-		audioData[i*2+1] = byte(s16 >> 8)
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(sample)))
 	}
+	return audioClip{PCM: pcm, SampleRate: int(decoder.SampleRate), Channels: int(decoder.NumChans)}, nil
+}
 
-	// Initialize the audio context, create a reader from the audio data, and play!
-	ctx, ready, err := oto.NewContext(sampleRate, channels, oto.FormatSignedInt16LE)
+// playWavFile plays a 16-bit WAV file, with the samples scaled by volume, from 0 to 1.
+func playWavFile(filename string, volume float64) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read WAV file: %w", err)
+	}
+	clip, err := decodeWav(data)
 	if err != nil {
-		return fmt.Errorf("failed to create audio context: %w", err)
+		return fmt.Errorf("%s: %w", filename, err)
 	}
-	<-ready
+	return playClip(clip, volume)
+}
 
-	reader := bytes.NewReader(audioData)
-	player := ctx.NewPlayer(reader)
-	defer player.Close()
-	player.Play()
+// playClip plays the clip, with the samples scaled by volume, from 0 to 1,
+// and returns once it has been played.
+func playClip(clip audioClip, volume float64) error {
+	logDebug("Playing audio (Sample Rate: %d, Channels: %d, Duration: %s)", clip.SampleRate, clip.Channels, clip.duration())
 
-	// Wait for playback to complete, duration is based on sample rate and data length + small buffer
-	// this can be improved by using actual audio-device-specific timing information, but fine for now!
-	duration := time.Duration(len(buf.Data)) * time.Second / time.Duration(sampleRate*channels)
-	time.Sleep(duration + 100*time.Millisecond)
+	if volume != 1 {
+		pcm := make([]byte, len(clip.PCM))
+		for i := 0; i+1 < len(pcm); i += 2 {
+			sample := int16(binary.LittleEndian.Uint16(clip.PCM[i:]))
+			binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(float64(sample)*volume)))
+		}
+		clip.PCM = pcm
+	}
 
-	return nil
+	err := playOnOutputs(clip)
+	recordPlayback(err)
+	return err
+}
+
+// playOnOutputs plays the clip on the configured outputs.
+func playOnOutputs(clip audioClip) error {
+	if len(configuredSinks()) > 0 {
+		return playOnSinks(clip)
+	}
+
+	if netSpeakerEnabled() {
+		err := playOnNetSpeaker(SysConfig.NetworkSpeaker, clip)
+		if err == nil {
+			return nil
+		}
+		logWarn("Playing on the local output instead: %v", err)
+	}
+
+	if bluetoothEnabled() {
+		err := playOnBluetooth(SysConfig.Bluetooth.Address, SysConfig.Bluetooth.Device, clip.PCM, clip.SampleRate, clip.Channels)
+		if err == nil || !SysConfig.Bluetooth.Fallback {
+			return err
+		}
+		logWarn("Playing on the local output instead: %v", err)
+	}
+
+	if SysConfig.AudioDevice != "" {
+		return playOnDevice(SysConfig.AudioDevice, clip.PCM, clip.SampleRate, clip.Channels)
+	}
+
+	return playOnContext(convertClip(clip, audioOutputRate, audioOutputChannels).PCM)
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// speechEngine identifies what generates the speech of the given kind with
+// the local model, the same text sounds different with another model or voice.
+func speechEngine(kind, model string) string {
+	if useCloudTts(kind) {
+		return cloudEngine()
+	}
+	return localEngine(model)
+}
+
+// localEngine identifies the local model and its settings.
+func localEngine(model string) string {
+	config, _ := ttsModelConfig(model)
+	return fmt.Sprintf("local:%s:%s%s%s:%g:%g", model, config.Model.Kokoro.Model, config.Model.Vits.Model,
+		config.Model.Matcha.AcousticModel, SysConfig.AiSpeechTtsConfig.Speed, config.Model.Kokoro.LengthScale)
+}
+
+// cloudEngine identifies the cloud provider and its voice.
+func cloudEngine() string {
+	c := SysConfig.CloudTts
+	return strings.Join([]string{"cloud", c.Provider, c.Voice, c.Language, c.Model}, ":")
+}
+
+// speechCacheFile returns the path of the cached speech of the text.
+func speechCacheFile(engine, text string, speaker int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s", engine, speaker, text)))
+	return filepath.Join(realPath(speechCachePath), hex.EncodeToString(sum[:])+".wav")
+}
+
+// cachedSpeech returns the cached speech of the text, if there is one.
+func cachedSpeech(engine, text string, speaker int) (audioClip, bool) {
+	if !SysConfig.TtsCache.Enabled {
+		return audioClip{}, false
+	}
+
+	path := speechCacheFile(engine, text, speaker)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return audioClip{}, false
+	}
+	clip, err := decodeWav(data)
+	if err != nil {
+		logWarn("failed to read cached speech %s, generating it again: %v", path, err)
+		return audioClip{}, false
+	}
+
+	// the modification time tells which entries were used last
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		logWarn("failed to touch cached speech %s: %v", path, err)
+	}
+	logDebug("Playing cached speech for %s", text)
+	return clip, true
+}
+
+// cacheSpeech stores the generated speech of the text, and drops the least
+// recently used entries over the size limit.
+func cacheSpeech(engine, text string, speaker int, clip audioClip) {
+	if !SysConfig.TtsCache.Enabled {
+		return
+	}
+
+	data := wavFromPCM(clip.PCM, clip.SampleRate, clip.Channels)
+	path := speechCacheFile(engine, text, speaker)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logError("failed to create speech cache directory: %v", err)
+		return
+	}
+	if err := writeFileAtomically(path, data, 0644); err != nil {
+		logError("failed to cache speech: %v", err)
+		return
+	}
+
+	if err := pruneSpeechCache(SysConfig.TtsCache.MaxSizeMB * 1024 * 1024); err != nil {
+		logError("failed to prune speech cache: %v", err)
+	}
+}
+
+// pruneSpeechCache removes the least recently used entries until the cache
+// is not larger than maxSize bytes.
+func pruneSpeechCache(maxSize int64) error {
+	dir := realPath(speechCachePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	files := []os.FileInfo{}
+	total := int64(0)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".wav" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, f := range files {
+		if total <= maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= f.Size()
+	}
+	return nil
+}
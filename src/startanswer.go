@@ -0,0 +1,71 @@
+package main
+
+import "strings"
+
+// yesNoPhrases are the phrases of the answers, "no" is looked for first as
+// "I haven't started yet" must not be taken for a yes.
+var yesNoPhrases = []struct {
+	answer  string
+	phrases []string
+}{
+	{reviewAnswerNo, []string{"no", "nope", "not yet", "didn't", "haven't", "did not", "have not", "not"}},
+	{reviewAnswerYes, []string{"yes", "yeah", "yep", "yup", "sure", "i did", "i have", "of course", "already"}},
+}
+
+// listenForStartAnswer listens for the answer to the question of the start
+// check and records it on the event, a yes stops its reminders.
+func listenForStartAnswer(e LocalEvent) {
+	if !SysConfig.VoiceAnswers.Enabled || simulating || SysConfig.DryRun {
+		return
+	}
+
+	samples, err := listen(SysConfig.VoiceAnswers.Window)
+	if err != nil {
+		logError("Failed to listen for the answer: %v", err)
+		return
+	}
+	if len(samples) == 0 {
+		logInfo("No answer to the start check of %s", e.Event.Description)
+		return
+	}
+
+	text, err := transcribe(samples)
+	if err != nil {
+		logError("Failed to recognize the answer: %v", err)
+		return
+	}
+	answer := parseYesNo(text)
+	if answer == "" {
+		logInfo("Answer %q to the start check of %s not understood", text, e.Event.Description)
+		return
+	}
+
+	if err := e.setStartAnswer(answer); err != nil {
+		logError("Failed to save the start answer of %s: %v", e.Event.ID, err)
+		return
+	}
+	logInfo("Start of %s answered %s by voice", e.Event.ID, answer)
+
+	reply := "Great, good luck!"
+	if answer == reviewAnswerNo {
+		reply = "Okay, I'll remind you."
+	}
+	if err := aiSpeak(reply); err != nil {
+		logError("Failed to answer: %v", err)
+	}
+}
+
+// parseYesNo returns reviewAnswerYes or reviewAnswerNo, or empty if the text
+// is neither.
+func parseYesNo(text string) string {
+	words := normalizeWords(text)
+	said := " " + strings.Join(words, " ") + " "
+	for _, a := range yesNoPhrases {
+		for _, phrase := range a.phrases {
+			if strings.Contains(said, " "+phrase+" ") {
+				return a.answer
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Status LED types
+const (
+	statusLEDGPIO     = "gpio"     // an RGB LED, or a single one, on GPIO pins
+	statusLEDNeoPixel = "neopixel" // WS2812 LEDs on the SPI MOSI pin
+)
+
+const (
+	ledRetry       = time.Minute
+	ledBlink       = 250 * time.Millisecond
+	ledHealthCheck = 30 * time.Second
+
+	// The WS2812 bits are sent as 3 SPI bits at 2.4 MHz, 110 for a 1 and
+	// 100 for a 0, and latched by 50µs of low.
+	spiSpeedHz          = 2400000
+	spiWriteMaxSpeedHz  = 0x40046b04 // _IOW('k', 4, __u32)
+	neoPixelResetBytes  = 20
+	neoPixelSPIBitsOne  = 0b110
+	neoPixelSPIBitsZero = 0b100
+	maxNeoPixels        = 450
+)
+
+// ledColor is what the status LED shows.
+type ledColor struct {
+	r, g, b uint8
+}
+
+var (
+	ledOff   = ledColor{}
+	ledGreen = ledColor{g: 255}
+	ledBlue  = ledColor{b: 255}
+	ledRed   = ledColor{r: 255}
+)
+
+// statusLight is the LED, or the strip, the status is shown with.
+type statusLight interface {
+	show(c ledColor) error
+	Close() error
+}
+
+// runStatusLED shows the state of the reminder: green when idle, blinking
+// blue while speaking, or for a while after an announcement night mode kept
+// silent, and red while /healthz reports a problem, e.g. a
+// calendar that can't be fetched or a TTS model that can't be loaded.
+func runStatusLED() {
+	warned := false
+	for {
+		cfg := SysConfig.StatusLED
+		if !cfg.Enabled {
+			time.Sleep(ledRetry)
+			continue
+		}
+		if err := showStatus(cfg); err != nil {
+			if !warned {
+				logError("Failed to drive the status LED, retrying every %s: %v", ledRetry, err)
+				warned = true
+			}
+			time.Sleep(ledRetry)
+			continue
+		}
+		warned = false
+	}
+}
+
+// showStatus keeps the LED up to date until the config changes.
+func showStatus(cfg StatusLEDConfig) error {
+	light, err := openStatusLight(cfg)
+	if err != nil {
+		return err
+	}
+	defer light.Close()
+	logInfo("Showing the status on the %s LED", cfg.Type)
+
+	blink := time.NewTicker(ledBlink)
+	defer blink.Stop()
+	healthy, checked := true, time.Time{}
+	on := false
+	for range blink.C {
+		if SysConfig.StatusLED != cfg {
+			logInfo("Status LED config changed, opening it again")
+			return light.show(ledOff)
+		}
+		if time.Since(checked) >= ledHealthCheck {
+			healthy = checkHealth().Status == "ok"
+			checked = time.Now()
+		}
+
+		on = !on
+		color := ledGreen
+		switch {
+		case speakingNow.Load() || silentAnnouncementShown():
+			color = ledBlue
+			if !on {
+				color = ledOff
+			}
+		case !healthy:
+			color = ledRed
+		}
+		if err := light.show(color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func openStatusLight(cfg StatusLEDConfig) (statusLight, error) {
+	switch cfg.Type {
+	case statusLEDGPIO:
+		return openGPIOLight(cfg)
+	case statusLEDNeoPixel:
+		return openNeoPixels(cfg)
+	}
+	return nil, fmt.Errorf("unknown status LED type %q", cfg.Type)
+}
+
+// gpioLight is an LED on a pin for each of its colors, the colors without a
+// pin are not shown.
+type gpioLight struct {
+	lines *gpioLines
+	pins  []int
+	// the color each pin is, in the order of pins
+	colors []func(ledColor) bool
+	last   *ledColor
+}
+
+func openGPIOLight(cfg StatusLEDConfig) (*gpioLight, error) {
+	l := &gpioLight{}
+	for _, p := range []struct {
+		pin   int
+		color func(ledColor) bool
+	}{
+		{cfg.RedPin, func(c ledColor) bool { return c.r > 0 }},
+		{cfg.GreenPin, func(c ledColor) bool { return c.g > 0 }},
+		{cfg.BluePin, func(c ledColor) bool { return c.b > 0 }},
+	} {
+		if p.pin > 0 {
+			l.pins = append(l.pins, p.pin)
+			l.colors = append(l.colors, p.color)
+		}
+	}
+	if len(l.pins) == 0 {
+		return nil, fmt.Errorf("no pins set")
+	}
+
+	lines, err := requestGPIOOutputs(cfg.Chip, l.pins)
+	if err != nil {
+		return nil, err
+	}
+	l.lines = lines
+	return l, nil
+}
+
+func (l *gpioLight) show(c ledColor) error {
+	if l.last != nil && *l.last == c {
+		return nil
+	}
+	var bits uint64
+	for i, isColor := range l.colors {
+		if isColor(c) {
+			bits |= 1 << i
+		}
+	}
+	if err := l.lines.setValues(bits, 1<<len(l.pins)-1); err != nil {
+		return err
+	}
+	l.last = &c
+	return nil
+}
+
+func (l *gpioLight) Close() error {
+	return l.lines.Close()
+}
+
+// neoPixels are WS2812 LEDs driven by the SPI bus, all of them the same
+// color.
+type neoPixels struct {
+	file       *os.File
+	count      int
+	brightness int
+	last       *ledColor
+}
+
+func openNeoPixels(cfg StatusLEDConfig) (*neoPixels, error) {
+	f, err := os.OpenFile(cfg.SPI, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	speed := uint32(spiSpeedHz)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), spiWriteMaxSpeedHz, uintptr(unsafe.Pointer(&speed))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("failed to set the speed of %s: %v", cfg.SPI, errno)
+	}
+	return &neoPixels{file: f, count: cfg.Count, brightness: cfg.Brightness}, nil
+}
+
+func (n *neoPixels) show(c ledColor) error {
+	if n.last != nil && *n.last == c {
+		return nil
+	}
+	// the green byte first, then the red and the blue ones
+	var data []byte
+	for i := 0; i < n.count; i++ {
+		for _, v := range []uint8{c.g, c.r, c.b} {
+			data = append(data, neoPixelByte(uint8(int(v)*n.brightness/100))...)
+		}
+	}
+	data = append(data, make([]byte, neoPixelResetBytes)...)
+	if _, err := n.file.Write(data); err != nil {
+		return err
+	}
+	n.last = &c
+	return nil
+}
+
+func (n *neoPixels) Close() error {
+	return n.file.Close()
+}
+
+// neoPixelByte returns the 3 SPI bytes sending a byte of a WS2812 color.
+func neoPixelByte(v uint8) []byte {
+	var bits uint32
+	for i := 7; i >= 0; i-- {
+		bits <<= 3
+		if v&(1<<i) != 0 {
+			bits |= neoPixelSPIBitsOne
+		} else {
+			bits |= neoPixelSPIBitsZero
+		}
+	}
+	return []byte{byte(bits >> 16), byte(bits >> 8), byte(bits)}
+}
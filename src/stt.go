@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+)
+
+// Speech recognition model types
+const (
+	sttModelWhisper    = "whisper"
+	sttModelTransducer = "transducer"
+)
+
+var (
+	// sttMutex serializes the recognitions, the model is loaded on first use
+	sttMutex      sync.Mutex
+	sttRecognizer *sherpa.OfflineRecognizer
+)
+
+// speechRecognizer returns the speech recognition model, loading it if it
+// isn't yet. The caller must hold sttMutex.
+func speechRecognizer() (*sherpa.OfflineRecognizer, error) {
+	if sttRecognizer != nil {
+		return sttRecognizer, nil
+	}
+
+	dir := realPath(SysConfig.SpeechRecognition.Model)
+	tokens, _ := filepath.Glob(filepath.Join(dir, "*tokens.txt"))
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens found in %s", dir)
+	}
+
+	config := sherpa.OfflineRecognizerConfig{}
+	config.FeatConfig.SampleRate = micSampleRate
+	config.FeatConfig.FeatureDim = 80
+	config.ModelConfig.Tokens = tokens[0]
+	config.ModelConfig.NumThreads = 2
+	config.ModelConfig.Provider = "cpu"
+	config.DecodingMethod = "greedy_search"
+
+	encoder, err := findModelFile(dir, "encoder")
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := findModelFile(dir, "decoder")
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(SysConfig.SpeechRecognition.Type) {
+	case sttModelWhisper:
+		config.ModelConfig.Whisper.Encoder = encoder
+		config.ModelConfig.Whisper.Decoder = decoder
+		config.ModelConfig.Whisper.Language = SysConfig.SpeechRecognition.Language
+		config.ModelConfig.Whisper.Task = "transcribe"
+	case sttModelTransducer:
+		joiner, err := findModelFile(dir, "joiner")
+		if err != nil {
+			return nil, err
+		}
+		config.ModelConfig.Transducer.Encoder = encoder
+		config.ModelConfig.Transducer.Decoder = decoder
+		config.ModelConfig.Transducer.Joiner = joiner
+	default:
+		return nil, fmt.Errorf("unknown speech recognition model type %q, expected whisper or transducer", SysConfig.SpeechRecognition.Type)
+	}
+
+	logInfo("Loading the speech recognition model from %s", dir)
+	recognizer := sherpa.NewOfflineRecognizer(&config)
+	if recognizer == nil {
+		return nil, fmt.Errorf("failed to load the speech recognition model from %s", dir)
+	}
+	sttRecognizer = recognizer
+	return recognizer, nil
+}
+
+// transcribe returns the text spoken in the samples, mono at micSampleRate.
+func transcribe(samples []float32) (string, error) {
+	sttMutex.Lock()
+	defer sttMutex.Unlock()
+
+	recognizer, err := speechRecognizer()
+	if err != nil {
+		return "", err
+	}
+
+	stream := sherpa.NewOfflineStream(recognizer)
+	defer sherpa.DeleteOfflineStream(stream)
+	stream.AcceptWaveform(micSampleRate, samples)
+	recognizer.Decode(stream)
+
+	text := strings.TrimSpace(stream.GetResult().Text)
+	logDebug("Heard: %s", text)
+	return text, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+// withDefaults returns the messages, with the defaults for the empty lists.
+func (m SystemMessages) withDefaults(d SystemMessages) SystemMessages {
+	pick := func(list, def MessageTemplate) MessageTemplate {
+		if len(list) == 0 {
+			return def
+		}
+		return list
+	}
+	return SystemMessages{
+		SpeechErrorMessages: pick(m.SpeechErrorMessages, d.SpeechErrorMessages),
+		SystemFailMessages:  pick(m.SystemFailMessages, d.SystemFailMessages),
+		SystemErrorMessages: pick(m.SystemErrorMessages, d.SystemErrorMessages),
+		SystemSleepMessages: pick(m.SystemSleepMessages, d.SystemSleepMessages),
+		SystemWakeMessages:  pick(m.SystemWakeMessages, d.SystemWakeMessages),
+		SystemInitMessages:  pick(m.SystemInitMessages, d.SystemInitMessages),
+	}
+}
+
+// speakSystemMessage speaks one of the messages, with the system voice.
+func speakSystemMessage(messages MessageTemplate) {
+	text := messages.pick()
+	if text == "" {
+		return
+	}
+	if err := aiSpeak(text); err != nil {
+		logError("Failed to speak system message: %v", err)
+	}
+}
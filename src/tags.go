@@ -0,0 +1,96 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags written in the title or notes of an event override how it is
+// reminded, e.g. "Laundry [remind:10m] [repeats:5]".
+const (
+	tagRemind   = "remind"   // [remind:10m] reminder interval, minutes if no unit
+	tagRepeats  = "repeats"  // [repeats:5] number of reminders during the event
+	tagSilent   = "silent"   // [silent] not announced at all
+	tagOnce     = "once"     // [once] announced at the start only
+	tagLang     = "lang"     // [lang:es] language of the event, spoken with the voice of that language
+	tagSound    = "sound"    // [sound:bell.ogg] sound played before the announcements, from resources/sounds
+	tagCritical = "critical" // [critical] the caregivers are texted if it is not acknowledged
+)
+
+var eventTagRegex = regexp.MustCompile(`(?i)\[\s*(remind|repeats|silent|once|lang|sound|critical)\s*(?::\s*([^\]]*?)\s*)?\]`)
+
+// EventTags are the overrides found in the text of an event.
+type EventTags struct {
+	RemindInterval time.Duration
+	Repeats        int
+	Silent         bool
+	Once           bool
+	Language       string
+	Sound          string
+	Critical       bool
+}
+
+// eventTags returns the tags found in the title and notes of the event,
+// invalid values are ignored.
+func eventTags(e *CalendarEvent) EventTags {
+	var tags EventTags
+	for _, text := range []string{e.Description, e.Notes} {
+		for _, m := range eventTagRegex.FindAllStringSubmatch(text, -1) {
+			name, value := strings.ToLower(m[1]), m[2]
+			switch name {
+			case tagRemind:
+				interval, err := parseTagDuration(value)
+				if err != nil || interval <= 0 {
+					logDebug("invalid %s tag value %q in event %s", name, value, e.ID)
+					continue
+				}
+				tags.RemindInterval = interval
+			case tagRepeats:
+				repeats, err := strconv.Atoi(value)
+				if err != nil || repeats <= 0 {
+					logDebug("invalid %s tag value %q in event %s", name, value, e.ID)
+					continue
+				}
+				tags.Repeats = repeats
+			case tagSilent:
+				tags.Silent = true
+			case tagOnce:
+				tags.Once = true
+			case tagCritical:
+				tags.Critical = true
+			case tagLang:
+				if value == "" {
+					logDebug("invalid %s tag value %q in event %s", name, value, e.ID)
+					continue
+				}
+				tags.Language = strings.ToLower(value)
+			case tagSound:
+				if value == "" {
+					logDebug("invalid %s tag value %q in event %s", name, value, e.ID)
+					continue
+				}
+				tags.Sound = value
+			}
+		}
+	}
+	return tags
+}
+
+// parseTagDuration parses a duration like "10m" or "1h30m", a plain number
+// is taken as minutes.
+func parseTagDuration(value string) (time.Duration, error) {
+	if minutes, err := strconv.Atoi(value); err == nil {
+		return time.Duration(minutes) * time.Minute, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// stripEventTags removes the tags from a text, so they are not spoken.
+func stripEventTags(text string) string {
+	if !strings.Contains(text, "[") {
+		return text
+	}
+	return strings.Join(strings.Fields(eventTagRegex.ReplaceAllString(text, "")), " ")
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	telegramAPI = "https://api.telegram.org/bot"
+	// telegramPollTimeout is how long a request for the updates waits for
+	// a message, the connection stays open in between.
+	telegramPollTimeout = 50 * time.Second
+	// telegramIdleCheck is how often a disabled bot checks whether it was
+	// enabled.
+	telegramIdleCheck = time.Minute
+	// telegramMaxCommandAge drops the commands sent while the reminder was
+	// off, they would be run long after they were meant for.
+	telegramMaxCommandAge = 5 * time.Minute
+	telegramMaxBackoff    = 5 * time.Minute
+)
+
+// telegramUpdate is a message sent to the bot, from the getUpdates answer.
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Date int64  `json:"date"`
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// telegramEnabled returns true if the bot is enabled and has its token.
+func telegramEnabled() bool {
+	return SysConfig.Telegram.Enabled && SysSecrets.Telegram.BotToken != ""
+}
+
+// telegramChannel mirrors the announcements to a Telegram chat, the failed
+// ones too since nobody heard them.
+type telegramChannel struct {
+	chat int64
+}
+
+func telegramChannels() []NotificationChannel {
+	if !telegramEnabled() {
+		return nil
+	}
+	channels := make([]NotificationChannel, 0, len(SysSecrets.Telegram.ChatIDs))
+	for _, chat := range SysSecrets.Telegram.ChatIDs {
+		channels = append(channels, telegramChannel{chat})
+	}
+	return channels
+}
+
+func (c telegramChannel) Name() string { return fmt.Sprintf("telegram:%d", c.chat) }
+
+func (c telegramChannel) Send(ev AnnouncementEvent) error {
+	text := announcementTitle(ev.Kind) + ": " + ev.Text
+	if !ev.Success {
+		text += "\n(could not be spoken: " + ev.Error + ")"
+	}
+	return sendTelegram(c.chat, text)
+}
+
+// sendTelegram sends a text message to the chat.
+func sendTelegram(chat int64, text string) error {
+	body, err := json.Marshal(map[string]interface{}{"chat_id": chat, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := telegramCall(context.Background(), "sendMessage", body, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// telegramCall posts to a method of the bot API. The errors never hold the
+// URL, it has the token.
+func telegramCall(ctx context.Context, method string, body []byte, wait time.Duration) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+SysSecrets.Telegram.BotToken+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s request", method)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := (&http.Client{Timeout: wait + discoveryTimeout}).Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%s failed: %v", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var answer struct {
+			Description string `json:"description"`
+		}
+		json.NewDecoder(resp.Body).Decode(&answer)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s failed: %s %s", method, resp.Status, answer.Description)
+	}
+	return resp, nil
+}
+
+// runTelegramBot answers the commands sent to the bot from the chats of
+// the secrets, polling for them with backoff when it fails.
+func runTelegramBot() {
+	offset := int64(0)
+	backoff := time.Second
+	warned := false
+	for {
+		if !telegramEnabled() {
+			if SysConfig.Telegram.Enabled && !warned {
+				logWarn("Telegram is enabled but telegram.bot_token is not set in the secrets")
+				warned = true
+			}
+			time.Sleep(telegramIdleCheck)
+			continue
+		}
+
+		updates, err := telegramUpdates(offset)
+		if err != nil {
+			logWarn("Failed to get the Telegram messages, retrying in %s: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, telegramMaxBackoff)
+			continue
+		}
+		backoff = time.Second
+
+		for _, u := range updates {
+			offset = max(offset, u.UpdateID+1)
+			if u.Message == nil || !strings.HasPrefix(u.Message.Text, "/") {
+				continue
+			}
+			chat := u.Message.Chat.ID
+			if !slices.Contains(SysSecrets.Telegram.ChatIDs, chat) {
+				logWarn("Ignoring Telegram command from chat %d, it is not in telegram.chat_ids", chat)
+				continue
+			}
+			if time.Since(time.Unix(u.Message.Date, 0)) > telegramMaxCommandAge {
+				logInfo("Ignoring old Telegram command %q from chat %d", u.Message.Text, chat)
+				continue
+			}
+
+			logInfo("Telegram command %q from chat %d", u.Message.Text, chat)
+			reply, err := runTelegramCommand(u.Message.Text)
+			if err != nil {
+				logError("Failed to run Telegram command %q: %v", u.Message.Text, err)
+				reply = "Sorry, that didn't work."
+			}
+			if err := sendTelegram(chat, reply); err != nil {
+				logError("Failed to answer the Telegram command: %v", err)
+			}
+		}
+	}
+}
+
+// telegramUpdates waits for the messages sent to the bot after the offset.
+func telegramUpdates(offset int64) ([]telegramUpdate, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(telegramPollTimeout.Seconds()),
+		"allowed_updates": []string{"message"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := telegramCall(context.Background(), "getUpdates", body, telegramPollTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var answer struct {
+		Result []telegramUpdate `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("failed to decode the updates: %v", err)
+	}
+	return answer.Result, nil
+}
+
+// runTelegramCommand runs a command and returns the answer. The events are
+// picked by their number in /today, or are the last one announced.
+func runTelegramCommand(text string) (string, error) {
+	fields := strings.Fields(text)
+	// in groups the commands are sent as /today@name_bot
+	command, _, _ := strings.Cut(strings.ToLower(fields[0]), "@")
+	args := fields[1:]
+
+	switch command {
+	case "/start", "/help":
+		return "Commands:\n" +
+			"/today - what is left for today, numbered\n" +
+			"/ack [number] - stop the reminders of an event\n" +
+			"/snooze [number] [duration] - remind later, e.g. /snooze 2 30m\n" +
+			"/done [number] - mark an event as done\n" +
+			"/pause [duration] - pause the announcements, e.g. /pause 2h\n" +
+			"/resume - announce again\n" +
+			"Without a number, the last event announced is meant.", nil
+
+	case "/today":
+		events, err := telegramEvents()
+		if err != nil {
+			return "", err
+		}
+		if len(events) == 0 {
+			return "Nothing left for today.", nil
+		}
+		lines := make([]string, 0, len(events))
+		for i := range events {
+			lines = append(lines, fmt.Sprintf("%d. %s", i+1, telegramEventLine(&events[i])))
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case "/ack", "/snooze", "/done":
+		number, d, err := parseTelegramArgs(args, SysConfig.Telegram.Snooze)
+		if err != nil {
+			return err.Error(), nil
+		}
+		e, ok, err := telegramEvent(number)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "I don't know which event you mean, see /today.", nil
+		}
+		return telegramEventAction(command, &e, d)
+
+	case "/pause":
+		_, d, err := parseTelegramArgs(args, SysConfig.Telegram.Pause)
+		if err != nil {
+			return err.Error(), nil
+		}
+		until := time.Now().Add(d)
+		if err := setPause(until); err != nil {
+			return "", err
+		}
+		logInfo("Announcements paused from Telegram")
+		return fmt.Sprintf("Paused until %s.", until.Format("15:04")), nil
+
+	case "/resume":
+		if err := setPause(time.Time{}); err != nil {
+			return "", err
+		}
+		logInfo("Announcements resumed from Telegram")
+		return "Announcing again.", nil
+	}
+	return "Unknown command, see /help.", nil
+}
+
+// telegramEventAction acknowledges, snoozes or completes the event.
+func telegramEventAction(command string, e *LocalEvent, snooze time.Duration) (string, error) {
+	switch command {
+	case "/ack":
+		if err := e.setAcknowledged(); err != nil {
+			return "", err
+		}
+		logInfo("Event %s acknowledged from Telegram", e.Event.ID)
+		return fmt.Sprintf("Okay, no more reminders about %s.", e.spokenTitle()), nil
+
+	case "/snooze":
+		if snooze > maxSnoozeMinutes*time.Minute {
+			return fmt.Sprintf("Snoozes are %d minutes at most.", maxSnoozeMinutes), nil
+		}
+		if err := e.setSnoozed(snooze); err != nil {
+			return "", err
+		}
+		logInfo("Event %s snoozed for %s from Telegram", e.Event.ID, snooze)
+		return fmt.Sprintf("Okay, %s is reminded again in %s.", e.spokenTitle(), formatDuration(snooze)), nil
+
+	default:
+		if err := e.setCompleted(); err != nil {
+			return "", err
+		}
+		logInfo("Event %s marked as completed from Telegram", e.Event.ID)
+		if err := writeCompletion(e.Event, e.CompletedAt); err != nil {
+			logError("Failed to write completion of event %s to calendar: %v", e.Event.ID, err)
+		}
+		return fmt.Sprintf("Well done, %s is done.", e.spokenTitle()), nil
+	}
+}
+
+// parseTelegramArgs reads the event number and the duration of a command,
+// both optional, e.g. "2 30m".
+func parseTelegramArgs(args []string, d time.Duration) (int, time.Duration, error) {
+	number := 0
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+			number = n
+			continue
+		}
+		parsed, err := time.ParseDuration(arg)
+		if err != nil || parsed <= 0 {
+			return 0, 0, fmt.Errorf("%q is not an event number or a duration like 30m", arg)
+		}
+		d = parsed
+	}
+	return number, d, nil
+}
+
+// telegramEvents returns what is left for today, in the order of /today.
+func telegramEvents() ([]LocalEvent, error) {
+	events, err := loadTodayEvents()
+	if err != nil {
+		return nil, err
+	}
+	left := []LocalEvent{}
+	for _, e := range events {
+		if !e.Completed && announceMode(&e) != announceModeSkip {
+			left = append(left, e)
+		}
+	}
+	return left, nil
+}
+
+// telegramEvent returns the event of that number in /today, or the last
+// one announced if it is 0.
+func telegramEvent(number int) (LocalEvent, bool, error) {
+	if number == 0 {
+		e, ok := lastAnnouncedEvent()
+		return e, ok, nil
+	}
+	events, err := telegramEvents()
+	if err != nil || number > len(events) {
+		return LocalEvent{}, false, err
+	}
+	return events[number-1], true, nil
+}
+
+// telegramEventLine describes the event and its state in a line.
+func telegramEventLine(e *LocalEvent) string {
+	line := e.spokenTitle()
+	if !e.Event.AllDay && !e.Event.StartTime.IsZero() {
+		line = e.Event.StartTime.Format("15:04") + " " + line
+	}
+	switch {
+	case e.Acknowledged:
+		line += " (acknowledged)"
+	case clockNow().Before(e.SnoozedUntil):
+		line += " (snoozed until " + e.SnoozedUntil.Format("15:04") + ")"
+	}
+	return line
+}
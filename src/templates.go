@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the formatting helpers available in all the message templates.
+var templateFuncs = template.FuncMap{
+	"humanTime": func(t time.Time) string { return t.Format("3:04 PM") },
+	"humanDate": func(t time.Time) string { return t.Format("Monday, January 2") },
+}
+
+// EventTemplateData is what the event message templates can use, e.g.
+// "{{.Event}} starts at {{humanTime .StartTime}}".
+type EventTemplateData struct {
+	Event     string
+	Location  string
+	Notes     string
+	Calendar  string    // Name of the calendar
+	StartTime time.Time // Use with humanTime or humanDate
+	EndTime   time.Time
+	Duration  string // e.g. "1 hour and 30 minutes"
+	TimeLeft  string // Until the end, e.g. "25 minutes"
+	StartsIn  string // Until the start, e.g. "15 minutes"
+	Now       time.Time
+}
+
+// newEventTemplateData returns the template data of an event, private
+// events only expose their times.
+func newEventTemplateData(e *LocalEvent) EventTemplateData {
+	calendar := e.Event.Calendar
+	if calendar == "" {
+		calendar = e.Event.Source
+	}
+	if isPrivate(&e.Event) {
+		calendar = ""
+	}
+
+	// round up, the announcements are made a few seconds after they are due
+	startsIn := (clockUntil(e.Event.StartTime) + time.Minute - 1).Truncate(time.Minute)
+
+	return EventTemplateData{
+		Event:     e.spokenTitle(),
+		Location:  e.spokenLocation(),
+		Notes:     e.spokenNotes(),
+		Calendar:  calendar,
+		StartTime: e.Event.StartTime,
+		EndTime:   e.Event.EndTime,
+		Duration:  formatDuration(e.Event.EndTime.Sub(e.Event.StartTime)),
+		TimeLeft:  timeLeftString(e),
+		StartsIn:  formatDuration(max(startsIn, 0)),
+		Now:       clockNow(),
+	}
+}
+
+// MessageTemplate is a message template setting, either a single template or
+// a list of alternatives, one of which is picked at random every time.
+type MessageTemplate []string
+
+var (
+	variantsMutex sync.Mutex
+	lastVariant   = make(map[string]int)
+)
+
+// UnmarshalYAML accepts a single template as well as a list.
+func (t *MessageTemplate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*t = MessageTemplate{}
+		if single != "" {
+			*t = MessageTemplate{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*t = MessageTemplate{}
+	for _, variant := range list {
+		if variant != "" {
+			*t = append(*t, variant)
+		}
+	}
+	return nil
+}
+
+// MarshalYAML writes a single template as a plain string.
+func (t MessageTemplate) MarshalYAML() (interface{}, error) {
+	if len(t) == 1 {
+		return t[0], nil
+	}
+	return []string(t), nil
+}
+
+// pick returns one of the alternatives at random, never the same one twice
+// in a row, or an empty string if there is none.
+func (t MessageTemplate) pick() string {
+	switch len(t) {
+	case 0:
+		return ""
+	case 1:
+		return t[0]
+	}
+
+	variantsMutex.Lock()
+	defer variantsMutex.Unlock()
+
+	// the templates are told apart by their first alternative
+	last, seen := lastVariant[t[0]]
+	i := rand.IntN(len(t))
+	if seen && i == last {
+		i = (i + 1 + rand.IntN(len(t)-1)) % len(t)
+	}
+	lastVariant[t[0]] = i
+	return t[i]
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// Time zones defined by the VTIMEZONE components of the fetched calendars,
+// keyed by TZID. Only zones unknown to the tz database end up here, like
+// the Windows names used by Outlook or custom X- zones.
+var (
+	calendarZones      = make(map[string]*time.Location)
+	calendarZonesMutex sync.RWMutex
+)
+
+// Transitions are generated for this range, it is what fits the 32-bit
+// transition times of the tz data format.
+var (
+	tzTransitionsFrom  = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	tzTransitionsUntil = time.Date(2037, 12, 31, 0, 0, 0, 0, time.UTC)
+)
+
+// tzObservance is a single STANDARD or DAYLIGHT part of a VTIMEZONE.
+type tzObservance struct {
+	name   string
+	offset int // seconds east of UTC
+	isDST  bool
+	onsets []time.Time
+}
+
+// registerTimeZones parses the VTIMEZONEs of the calendar, so event times
+// using them can be resolved.
+func registerTimeZones(cal *ical.Calendar) {
+	for _, child := range cal.Children {
+		if child.Name != ical.CompTimezone {
+			continue
+		}
+
+		tzid := child.Props.Get(ical.PropTimezoneID)
+		if tzid == nil || tzid.Value == "" {
+			continue
+		}
+
+		// the tz database knows the zone better than any embedded definition
+		if _, err := time.LoadLocation(tzid.Value); err == nil {
+			continue
+		}
+
+		loc, err := parseVTimezone(tzid.Value, child)
+		if err != nil {
+			logError("failed to parse timezone %s: %v", tzid.Value, err)
+			continue
+		}
+
+		calendarZonesMutex.Lock()
+		calendarZones[tzid.Value] = loc
+		calendarZonesMutex.Unlock()
+	}
+}
+
+// lookupTimeZone returns the location of a TZID, either from the calendar
+// definitions or the tz database.
+func lookupTimeZone(tzid string) (*time.Location, error) {
+	// times without a zone float, they are local times wherever we are
+	if tzid == "" {
+		return time.Local, nil
+	}
+
+	calendarZonesMutex.RLock()
+	loc, ok := calendarZones[tzid]
+	calendarZonesMutex.RUnlock()
+	if ok {
+		return loc, nil
+	}
+
+	return time.LoadLocation(tzid)
+}
+
+// parseVTimezone builds a location from a VTIMEZONE definition.
+func parseVTimezone(tzid string, comp *ical.Component) (*time.Location, error) {
+	observances := []tzObservance{}
+	for _, child := range comp.Children {
+		if child.Name != ical.CompTimezoneStandard && child.Name != ical.CompTimezoneDaylight {
+			continue
+		}
+
+		obs, err := parseObservance(child)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", child.Name, err)
+		}
+		if obs.name == "" {
+			obs.name = tzid
+		}
+		observances = append(observances, obs)
+	}
+
+	if len(observances) == 0 {
+		return nil, fmt.Errorf("no STANDARD or DAYLIGHT definitions")
+	}
+
+	data, err := buildTZData(observances)
+	if err != nil {
+		return nil, err
+	}
+
+	return time.LoadLocationFromTZData(tzid, data)
+}
+
+// parseObservance returns the offset of the observance and the UTC times it
+// comes into effect.
+func parseObservance(comp *ical.Component) (tzObservance, error) {
+	obs := tzObservance{isDST: comp.Name == ical.CompTimezoneDaylight}
+
+	offsetTo := comp.Props.Get(ical.PropTimezoneOffsetTo)
+	offsetFrom := comp.Props.Get(ical.PropTimezoneOffsetFrom)
+	dtStart := comp.Props.Get(ical.PropDateTimeStart)
+	if offsetTo == nil || offsetFrom == nil || dtStart == nil {
+		return obs, fmt.Errorf("missing TZOFFSETTO, TZOFFSETFROM or DTSTART")
+	}
+
+	var err error
+	if obs.offset, err = parseUTCOffset(offsetTo.Value); err != nil {
+		return obs, err
+	}
+	from, err := parseUTCOffset(offsetFrom.Value)
+	if err != nil {
+		return obs, err
+	}
+	if name := comp.Props.Get(ical.PropTimezoneName); name != nil {
+		obs.name = name.Value
+	}
+
+	// onsets are local times in the previous offset, they are expanded as
+	// wall clock times and then moved to UTC
+	start, err := time.ParseInLocation("20060102T150405", dtStart.Value, time.UTC)
+	if err != nil {
+		return obs, fmt.Errorf("invalid DTSTART %q: %v", dtStart.Value, err)
+	}
+
+	wallTimes := []time.Time{start}
+	if prop := comp.Props.Get(ical.PropRecurrenceRule); prop != nil {
+		roption, err := rrule.StrToROptionInLocation(prop.Value, time.UTC)
+		if err != nil {
+			return obs, fmt.Errorf("failed to parse rrule %q: %v", prop.Value, err)
+		}
+		// Outlook starts its rules in 1601, expanding from there overflows
+		// the durations rrule-go works with long before 1970, so the rule
+		// starts in the year before the range instead
+		if years := tzTransitionsFrom.Year() - 1 - start.Year(); years > 0 {
+			interval := max(roption.Interval, 1)
+			roption.Dtstart = start.AddDate(years-years%interval, 0, 0)
+		} else {
+			roption.Dtstart = start
+		}
+
+		rule, err := rrule.NewRRule(*roption)
+		if err != nil {
+			return obs, fmt.Errorf("failed to build rrule %q: %v", prop.Value, err)
+		}
+		wallTimes = rule.Between(roption.Dtstart, tzTransitionsUntil, true)
+	}
+
+	rdates, err := propDateTimes(comp.Props.Values(ical.PropRecurrenceDates), time.UTC)
+	if err != nil {
+		return obs, fmt.Errorf("failed to parse rdate: %v", err)
+	}
+	wallTimes = append(wallTimes, rdates...)
+
+	for _, t := range wallTimes {
+		obs.onsets = append(obs.onsets, t.Add(-time.Duration(from)*time.Second))
+	}
+
+	return obs, nil
+}
+
+// parseUTCOffset parses offsets like "+0100", "-0530" or "+013045" to seconds.
+func parseUTCOffset(s string) (int, error) {
+	if (len(s) != 5 && len(s) != 7) || (s[0] != '+' && s[0] != '-') {
+		return 0, fmt.Errorf("invalid UTC offset %q", s)
+	}
+
+	seconds := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(s) {
+			break
+		}
+		n, err := strconv.Atoi(s[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid UTC offset %q", s)
+		}
+		seconds += n * unit
+	}
+
+	if s[0] == '-' {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
+
+// buildTZData encodes the observances in the (version 1) tz data format, so
+// the standard library can do the offset lookups.
+func buildTZData(observances []tzObservance) ([]byte, error) {
+	type transition struct {
+		at   int64
+		zone int
+	}
+
+	transitions := []transition{}
+	for i, obs := range observances {
+		for _, onset := range obs.onsets {
+			if onset.Before(tzTransitionsFrom) || onset.After(tzTransitionsUntil) {
+				continue
+			}
+			transitions = append(transitions, transition{at: onset.Unix(), zone: i})
+		}
+	}
+	sort.Slice(transitions, func(i, j int) bool { return transitions[i].at < transitions[j].at })
+
+	// times before the first transition use the first zone, prefer the
+	// standard time for that
+	order := make([]int, 0, len(observances))
+	for i, obs := range observances {
+		if !obs.isDST {
+			order = append(order, i)
+		}
+	}
+	for i, obs := range observances {
+		if obs.isDST {
+			order = append(order, i)
+		}
+	}
+	zoneIndex := make(map[int]int)
+	for i, obs := range order {
+		zoneIndex[obs] = i
+	}
+
+	abbrevs := []byte{}
+	abbrevIndex := make([]int, len(order))
+	for i, obs := range order {
+		abbrevIndex[i] = len(abbrevs)
+		abbrevs = append(abbrevs, observances[obs].name...)
+		abbrevs = append(abbrevs, 0)
+	}
+	if len(abbrevs) > 255 {
+		return nil, fmt.Errorf("zone names too long")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("TZif")
+	buf.Write(make([]byte, 16)) // version and reserved
+	for _, n := range []int{0, 0, 0, len(transitions), len(order), len(abbrevs)} {
+		binary.Write(&buf, binary.BigEndian, uint32(n))
+	}
+	for _, t := range transitions {
+		binary.Write(&buf, binary.BigEndian, int32(t.at))
+	}
+	for _, t := range transitions {
+		buf.WriteByte(byte(zoneIndex[t.zone]))
+	}
+	for i, obs := range order {
+		binary.Write(&buf, binary.BigEndian, int32(observances[obs].offset))
+		isDST := byte(0)
+		if observances[obs].isDST {
+			isDST = 1
+		}
+		buf.WriteByte(isDST)
+		buf.WriteByte(byte(abbrevIndex[i]))
+	}
+	buf.Write(abbrevs)
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// decodeTimezone returns the VTIMEZONE of a calendar made of the lines.
+func decodeTimezone(t *testing.T, lines ...string) *ical.Component {
+	t.Helper()
+	text := strings.Join(append(append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:test"}, lines...), "END:VCALENDAR", ""), "\r\n")
+	cal, err := ical.NewDecoder(strings.NewReader(text)).Decode()
+	if err != nil {
+		t.Fatalf("failed to decode calendar: %v", err)
+	}
+	for _, child := range cal.Children {
+		if child.Name == ical.CompTimezone {
+			return child
+		}
+	}
+	t.Fatalf("no VTIMEZONE in the calendar")
+	return nil
+}
+
+// centralEurope is the VTIMEZONE Outlook sends for Europe/Berlin.
+var centralEurope = []string{
+	"BEGIN:VTIMEZONE",
+	"TZID:W. Europe Standard Time",
+	"BEGIN:STANDARD",
+	"DTSTART:16010101T030000",
+	"TZOFFSETFROM:+0200",
+	"TZOFFSETTO:+0100",
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=10",
+	"END:STANDARD",
+	"BEGIN:DAYLIGHT",
+	"DTSTART:16010101T020000",
+	"TZOFFSETFROM:+0100",
+	"TZOFFSETTO:+0200",
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=3",
+	"END:DAYLIGHT",
+	"END:VTIMEZONE",
+}
+
+func TestParseVTimezoneDST(t *testing.T) {
+	loc, err := parseVTimezone("W. Europe Standard Time", decodeTimezone(t, centralEurope...))
+	if err != nil {
+		t.Fatalf("parseVTimezone() error = %v", err)
+	}
+
+	tests := []struct {
+		utc    time.Time
+		offset int
+	}{
+		{time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), 3600},
+		// DST starts at 2:00 local time on the last Sunday of March
+		{time.Date(2024, 3, 31, 0, 59, 59, 0, time.UTC), 3600},
+		{time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC), 7200},
+		{time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), 7200},
+		// and ends at 3:00 local time on the last Sunday of October
+		{time.Date(2024, 10, 27, 0, 59, 59, 0, time.UTC), 7200},
+		{time.Date(2024, 10, 27, 1, 0, 0, 0, time.UTC), 3600},
+		{time.Date(2031, 3, 30, 1, 0, 0, 0, time.UTC), 7200},
+	}
+	for _, tt := range tests {
+		if _, offset := tt.utc.In(loc).Zone(); offset != tt.offset {
+			t.Errorf("offset at %s = %d, want %d", tt.utc, offset, tt.offset)
+		}
+	}
+
+	// the local times of the events resolve to the right instant
+	start := time.Date(2024, 7, 1, 9, 0, 0, 0, loc)
+	if want := time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("9:00 in summer = %s, want %s", start.UTC(), want)
+	}
+}
+
+func TestParseVTimezoneNames(t *testing.T) {
+	lines := []string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Custom Eastern",
+		"BEGIN:STANDARD",
+		"DTSTART:19701101T020000",
+		"TZOFFSETFROM:-0400",
+		"TZOFFSETTO:-0500",
+		"TZNAME:EST",
+		"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU",
+		"END:STANDARD",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:19700308T020000",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0400",
+		"TZNAME:EDT",
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU",
+		"END:DAYLIGHT",
+		"END:VTIMEZONE",
+	}
+	loc, err := parseVTimezone("Custom Eastern", decodeTimezone(t, lines...))
+	if err != nil {
+		t.Fatalf("parseVTimezone() error = %v", err)
+	}
+
+	tests := []struct {
+		utc    time.Time
+		name   string
+		offset int
+	}{
+		{time.Date(2024, 3, 10, 6, 59, 0, 0, time.UTC), "EST", -5 * 3600},
+		{time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC), "EDT", -4 * 3600},
+		{time.Date(2024, 11, 3, 5, 59, 0, 0, time.UTC), "EDT", -4 * 3600},
+		{time.Date(2024, 11, 3, 6, 0, 0, 0, time.UTC), "EST", -5 * 3600},
+	}
+	for _, tt := range tests {
+		if name, offset := tt.utc.In(loc).Zone(); name != tt.name || offset != tt.offset {
+			t.Errorf("zone at %s = %s %d, want %s %d", tt.utc, name, offset, tt.name, tt.offset)
+		}
+	}
+}
+
+func TestParseVTimezoneFixedOffset(t *testing.T) {
+	tests := []struct {
+		offset string
+		want   int
+	}{
+		{"+0530", 5*3600 + 30*60},
+		{"-0330", -(3*3600 + 30*60)},
+		{"+0545", 5*3600 + 45*60},
+		{"+013045", 3600 + 30*60 + 45},
+	}
+	for _, tt := range tests {
+		comp := decodeTimezone(t,
+			"BEGIN:VTIMEZONE",
+			"TZID:Custom",
+			"BEGIN:STANDARD",
+			"DTSTART:19700101T000000",
+			"TZOFFSETFROM:"+tt.offset,
+			"TZOFFSETTO:"+tt.offset,
+			"END:STANDARD",
+			"END:VTIMEZONE",
+		)
+		loc, err := parseVTimezone("Custom", comp)
+		if err != nil {
+			t.Errorf("parseVTimezone(%s) error = %v", tt.offset, err)
+			continue
+		}
+		for _, at := range []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)} {
+			if _, offset := at.In(loc).Zone(); offset != tt.want {
+				t.Errorf("offset of %s at %s = %d, want %d", tt.offset, at, offset, tt.want)
+			}
+		}
+	}
+}
+
+func TestParseVTimezoneInvalid(t *testing.T) {
+	tests := [][]string{
+		// no observances
+		{"BEGIN:VTIMEZONE", "TZID:Empty", "END:VTIMEZONE"},
+		// no TZOFFSETFROM
+		{"BEGIN:VTIMEZONE", "TZID:Broken", "BEGIN:STANDARD", "DTSTART:19700101T000000", "TZOFFSETTO:+0100", "END:STANDARD", "END:VTIMEZONE"},
+		// invalid offset
+		{"BEGIN:VTIMEZONE", "TZID:Broken", "BEGIN:STANDARD", "DTSTART:19700101T000000", "TZOFFSETFROM:+1", "TZOFFSETTO:+0100", "END:STANDARD", "END:VTIMEZONE"},
+		// invalid onset
+		{"BEGIN:VTIMEZONE", "TZID:Broken", "BEGIN:STANDARD", "DTSTART:1970-01-01", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0100", "END:STANDARD", "END:VTIMEZONE"},
+	}
+	for _, lines := range tests {
+		if _, err := parseVTimezone("Broken", decodeTimezone(t, lines...)); err == nil {
+			t.Errorf("parseVTimezone(%v) succeeded, want an error", lines)
+		}
+	}
+}
+
+func TestParseObservanceOnsets(t *testing.T) {
+	comp := decodeTimezone(t,
+		"BEGIN:VTIMEZONE",
+		"TZID:Custom",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20240331T020000",
+		"RDATE:20250330T020000,20260329T020000",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+		"TZNAME:CEST",
+		"END:DAYLIGHT",
+		"END:VTIMEZONE",
+	).Children[0]
+
+	obs, err := parseObservance(comp)
+	if err != nil {
+		t.Fatalf("parseObservance() error = %v", err)
+	}
+	if !obs.isDST || obs.offset != 7200 || obs.name != "CEST" {
+		t.Errorf("parseObservance() = %s %d dst %v, want CEST 7200 dst true", obs.name, obs.offset, obs.isDST)
+	}
+
+	// the onsets are local times in the offset before them
+	want := []time.Time{
+		time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 30, 1, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC),
+	}
+	if len(obs.onsets) != len(want) {
+		t.Fatalf("parseObservance() onsets = %v, want %v", obs.onsets, want)
+	}
+	for i := range want {
+		if !obs.onsets[i].Equal(want[i]) {
+			t.Errorf("onset %d = %s, want %s", i, obs.onsets[i], want[i])
+		}
+	}
+}
+
+func TestBuildTZData(t *testing.T) {
+	// the daylight observance first, times before the first onset still use
+	// the standard one
+	observances := []tzObservance{
+		{name: "DT", offset: 3600, isDST: true, onsets: []time.Time{time.Date(2000, 4, 1, 0, 0, 0, 0, time.UTC)}},
+		{name: "ST", offset: 0, onsets: []time.Time{
+			time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC), // before the range, dropped
+			time.Date(2000, 10, 1, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+	data, err := buildTZData(observances)
+	if err != nil {
+		t.Fatalf("buildTZData() error = %v", err)
+	}
+	loc, err := time.LoadLocationFromTZData("Test", data)
+	if err != nil {
+		t.Fatalf("LoadLocationFromTZData() error = %v", err)
+	}
+
+	tests := []struct {
+		utc  time.Time
+		name string
+	}{
+		{time.Date(1999, 6, 1, 0, 0, 0, 0, time.UTC), "ST"},
+		{time.Date(2000, 6, 1, 0, 0, 0, 0, time.UTC), "DT"},
+		{time.Date(2001, 6, 1, 0, 0, 0, 0, time.UTC), "ST"},
+	}
+	for _, tt := range tests {
+		if name, _ := tt.utc.In(loc).Zone(); name != tt.name {
+			t.Errorf("zone at %s = %s, want %s", tt.utc, name, tt.name)
+		}
+	}
+
+	long := []tzObservance{{name: strings.Repeat("X", 300)}}
+	if _, err := buildTZData(long); err == nil {
+		t.Errorf("buildTZData() with a long name succeeded, want an error")
+	}
+}
+
+func TestParseUTCOffset(t *testing.T) {
+	tests := []struct {
+		offset  string
+		want    int
+		wantErr bool
+	}{
+		{"+0000", 0, false},
+		{"+0100", 3600, false},
+		{"-0800", -8 * 3600, false},
+		{"+0530", 5*3600 + 30*60, false},
+		{"-013045", -(3600 + 30*60 + 45), false},
+		{"0100", 0, true},
+		{"+100", 0, true},
+		{"+01:00", 0, true},
+		{"+01x0", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUTCOffset(tt.offset)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseUTCOffset(%q) error = %v, want error %v", tt.offset, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseUTCOffset(%q) = %d, want %d", tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestLookupTimeZone(t *testing.T) {
+	registerTimeZones(&ical.Calendar{Component: &ical.Component{Children: []*ical.Component{decodeTimezone(t, centralEurope...)}}})
+
+	loc, err := lookupTimeZone("W. Europe Standard Time")
+	if err != nil {
+		t.Fatalf("lookupTimeZone() error = %v", err)
+	}
+	if _, offset := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC).In(loc).Zone(); offset != 7200 {
+		t.Errorf("offset in summer = %d, want 7200", offset)
+	}
+
+	if loc, err := lookupTimeZone(""); err != nil || loc != time.Local {
+		t.Errorf("lookupTimeZone(\"\") = %v, %v, want the local time", loc, err)
+	}
+	if _, err := lookupTimeZone("Nowhere/Unknown"); err == nil {
+		t.Errorf("lookupTimeZone() of an unknown zone succeeded, want an error")
+	}
+}
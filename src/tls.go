@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	selfSignedCert     = "cert.pem"
+	selfSignedKey      = "key.pem"
+	selfSignedValidity = 10 * 365 * 24 * time.Hour
+	acmeCacheDir       = "acme"
+	// certCheckInterval is how often the certificate file is checked for a
+	// renewed one.
+	certCheckInterval = time.Minute
+)
+
+// webTLSConfig returns the TLS configuration of the web server, with the
+// certificates from ACME or from the certificate files.
+func webTLSConfig() (*tls.Config, error) {
+	acme := SysConfig.WebServer.TLS.ACME
+	if acme.Enabled {
+		if len(acme.Domains) == 0 {
+			return nil, fmt.Errorf("web_server.tls.acme needs the domains to get certificates for")
+		}
+
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(filepath.Join(realPath(tlsPath), acmeCacheDir)),
+			HostPolicy: autocert.HostWhitelist(acme.Domains...),
+			Email:      acme.Email,
+		}
+		go serveACMEChallenges(manager, acme.ChallengeAddress)
+		// the TLS-ALPN-01 challenges are answered on the https port itself
+		return manager.TLSConfig(), nil
+	}
+
+	certFile, keyFile, err := webCertificate()
+	if err != nil {
+		return nil, err
+	}
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	return &tls.Config{GetCertificate: reloader.GetCertificate}, nil
+}
+
+// serveACMEChallenges answers the HTTP-01 challenges, port 80 of the domains
+// must reach the address.
+func serveACMEChallenges(manager *autocert.Manager, address string) {
+	logInfo("Answering the ACME challenges on %s", address)
+	if err := http.ListenAndServe(address, manager.HTTPHandler(nil)); err != nil {
+		logError("Failed to answer the ACME challenges on %s: %v", address, err)
+	}
+}
+
+// certReloader serves the certificate files and loads them again when they
+// are renewed, e.g. by certbot with a DNS-01 challenge.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cert != nil && time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+
+	info, err := os.Stat(c.certFile)
+	if err == nil && c.cert != nil && !info.ModTime().After(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			logWarn("Failed to load the renewed certificate, keeping the current one: %v", err)
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load the certificate: %v", err)
+	}
+	logInfo("Loaded the certificate %s", c.certFile)
+	c.cert = &cert
+	if info != nil {
+		c.modTime = info.ModTime()
+	}
+	return c.cert, nil
+}
+
+// webCertificate returns the certificate and key files of the web server,
+// the configured ones or a self-signed pair created on first use.
+func webCertificate() (string, string, error) {
+	tlsConfig := SysConfig.WebServer.TLS
+	if tlsConfig.Cert != "" || tlsConfig.Key != "" {
+		if tlsConfig.Cert == "" || tlsConfig.Key == "" {
+			return "", "", fmt.Errorf("web_server.tls needs both cert and key")
+		}
+		return realPath(tlsConfig.Cert), realPath(tlsConfig.Key), nil
+	}
+
+	dir := realPath(tlsPath)
+	certFile := filepath.Join(dir, selfSignedCert)
+	keyFile := filepath.Join(dir, selfSignedKey)
+	if _, err := os.Stat(certFile); err == nil {
+		if _, err := os.Stat(keyFile); err == nil {
+			return certFile, keyFile, nil
+		}
+	}
+
+	logInfo("Creating a self-signed certificate in %s", dir)
+	if err := createSelfSignedCert(certFile, keyFile); err != nil {
+		return "", "", fmt.Errorf("failed to create the self-signed certificate: %v", err)
+	}
+	return certFile, keyFile, nil
+}
+
+// createSelfSignedCert writes a certificate for the host name and the local
+// addresses of the device, browsers warn about it once.
+func createSelfSignedCert(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"rbpi-reminder"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{hostname, hostname + ".local", "localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of the web users
+const (
+	roleAdmin  = "admin"  // Can change the config, the secrets and the state
+	roleViewer = "viewer" // Can only look at the dashboard and the logs
+)
+
+// legacyUsername is the user of the single web_server_password.
+const legacyUsername = "admin"
+
+// dummyPasswordHash is compared with for unknown users, so they take as long
+// to reject as a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// webUsers returns the users of the web interface, an admin with the
+// web_server_password if no users are set.
+func webUsers() []WebUser {
+	if len(SysSecrets.Users) > 0 {
+		return SysSecrets.Users
+	}
+	if SysSecrets.WebServerPassword == "" {
+		return nil
+	}
+	return []WebUser{{Username: legacyUsername, Password: SysSecrets.WebServerPassword, Role: roleAdmin}}
+}
+
+// authenticateUser returns the user with that name and password.
+func authenticateUser(username, password string) (WebUser, bool) {
+	if username == "" {
+		username = legacyUsername
+	}
+
+	for _, user := range webUsers() {
+		if user.Username != username {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			return WebUser{}, false
+		}
+		user.Role = userRole(user.Role)
+		return user, true
+	}
+
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+	return WebUser{}, false
+}
+
+// userRole returns the role, anything but admin is a viewer.
+func userRole(role string) string {
+	if strings.EqualFold(strings.TrimSpace(role), roleAdmin) {
+		return roleAdmin
+	}
+	return roleViewer
+}
@@ -2,21 +2,65 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
 	"time"
 )
 
+// startOfDay returns the midnight that starts t's day, in t's location.
 func startOfDay(t time.Time) time.Time {
-	return t.Truncate(24 * time.Hour)
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
 }
 
 func endOfDay(t time.Time) time.Time {
 	return startOfDay(t).AddDate(0, 0, 1).Add(-time.Nanosecond)
 }
 
+// todayAt returns today's time for a "15:04" formatted clock time.
+func todayAt(clock string) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time of day %q: %v", clock, err)
+	}
+
+	now := clockNow()
+	return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location()), nil
+}
+
+// runDaily calls fn once a day at the time of day returned by clock, which is
+// re-evaluated on every reschedule so config changes are picked up. An empty
+// clock disables the task. If the device was off at that time, the run is
+// skipped rather than done late.
+func runDaily(name string, clock func() string, fn func()) {
+	const window = 15 * time.Minute
+
+	lastRun := time.Time{}
+	for {
+		changes := scheduleChanges()
+		next := time.Now().Add(schedulerMaxSleep)
+
+		if c := clock(); c != "" {
+			at, err := todayAt(c)
+			if err != nil {
+				logError("daily task %s: %v", name, err)
+			} else if now := time.Now(); now.After(at) && now.Before(at.Add(window)) && !lastRun.Equal(at) {
+				logInfo("Running daily task %s", name)
+				lastRun = at
+				fn()
+				continue
+			} else if now.Before(at) {
+				next = at.Add(schedulerSlack)
+			} else {
+				next = at.AddDate(0, 0, 1).Add(schedulerSlack)
+			}
+		}
+
+		sleepUntil(next, changes)
+	}
+}
+
 func realPath(path string) string {
 	return filepath.Join(SysRootDir, path)
 }
@@ -76,18 +120,60 @@ func formatDuration(duration time.Duration) string {
 	}
 }
 
-func writeFileAtomically(path string, data []byte) error {
-	tempPath := fmt.Sprintf("%s.tmp.%d", path, rand.Int63())
-	if err := os.WriteFile(tempPath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write temporary secrets file: %w", err)
+// writeFileAtomically replaces the file at path with data, so a power loss
+// leaves either the old or the new content, never a partial file.
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp.*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
 	}
+	tempPath := tmp.Name()
 
-	if err := os.Rename(tempPath, path); err != nil {
-		if rmErr := os.Remove(tempPath); rmErr != nil {
-			fmt.Printf("failed to remove temp file %s: %v\n", tempPath, rmErr)
+	cleanup := func() {
+		tmp.Close()
+		if rmErr := os.Remove(tempPath); rmErr != nil && !os.IsNotExist(rmErr) {
+			logError("failed to remove temp file %s: %v", tempPath, rmErr)
 		}
-		return fmt.Errorf("failed to save secrets file: %w", err)
 	}
 
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to set permissions of temporary file: %w", err)
+	}
+	// the data must be on disk before the rename makes it visible
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(path), err)
+	}
+
+	// and the rename itself must be on disk too
+	return syncDir(dir)
+}
+
+// syncDir flushes the directory entries of dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
 	return nil
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Voice command intents, the queries are in voicequery.go
+const (
+	intentSnooze      = "snooze"
+	intentDone        = "done"
+	intentRepeat      = "repeat"
+	intentAcknowledge = "acknowledge"
+	intentPanic       = "panic"
+)
+
+// voiceIntents are the phrases of the intents, the first intent with a
+// phrase in what was said wins.
+var voiceIntents = []struct {
+	intent  string
+	phrases []string
+}{
+	// first, nothing else said along with it may hold it back
+	{intentPanic, []string{"emergency", "help me", "call for help", "i need help"}},
+	{intentNext, []string{"what's next", "what is next", "what's coming", "what comes next", "next event"}},
+	{intentToday, []string{"today", "what's left", "what is left", "my schedule", "my day"}},
+	{intentRepeat, []string{"repeat", "say that again", "say it again", "what did you say"}},
+	{intentSnooze, []string{"snooze", "remind me later", "remind me in", "later"}},
+	{intentDone, []string{"done", "finished", "completed", "i did it"}},
+	{intentAcknowledge, []string{"okay", "ok", "got it", "stop", "thank you", "thanks"}},
+}
+
+// spokenNumbers are the numbers a snooze can be said with.
+var spokenNumbers = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10, "fifteen": 15,
+	"twenty": 20, "thirty": 30, "forty": 40, "forty-five": 45, "sixty": 60,
+}
+
+// handleVoiceCommand runs the command said after the wake word.
+func handleVoiceCommand(keyword string, samples []float32) {
+	if !SysConfig.VoiceCommands.Enabled || len(samples) == 0 {
+		return
+	}
+
+	text, err := transcribe(samples)
+	if err != nil {
+		logError("Failed to recognize the voice command: %v", err)
+		return
+	}
+
+	intent, words := parseIntent(text)
+	logInfo("Voice command %q understood as %q", text, intent)
+	reply, err := runIntent(intent, words)
+	if err != nil {
+		logError("Failed to run voice command %q: %v", text, err)
+		reply = "Sorry, that didn't work."
+	}
+	if reply == "" {
+		return
+	}
+	if err := aiSpeak(reply); err != nil {
+		logError("Failed to answer the voice command: %v", err)
+	}
+}
+
+// parseIntent returns the intent of what was said, empty if it was not
+// understood, along with the normalized words.
+func parseIntent(text string) (string, []string) {
+	words := normalizeWords(text)
+	said := " " + strings.Join(words, " ") + " "
+	for _, i := range voiceIntents {
+		for _, phrase := range i.phrases {
+			if strings.Contains(said, " "+phrase+" ") {
+				return i.intent, words
+			}
+		}
+	}
+	return "", words
+}
+
+// normalizeWords returns the lower-cased words of the text, without the
+// punctuation.
+func normalizeWords(text string) []string {
+	text = strings.ReplaceAll(strings.ToLower(text), "’", "'")
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '\''
+	})
+}
+
+// runIntent acts on the intent and returns what to answer.
+func runIntent(intent string, words []string) (string, error) {
+	if intent == "" {
+		return "Sorry, I didn't understand.", nil
+	}
+	if intent == intentRepeat {
+		return "", repeatLastAnnouncement()
+	}
+	if intent == intentPanic {
+		// it speaks the emergency message itself
+		go triggerPanic("voice command")
+		return "", nil
+	}
+	if intent == intentNext || intent == intentToday {
+		return answerQuery(intent)
+	}
+	if wakeUpRinging() {
+		switch intent {
+		case intentSnooze:
+			d, ok := parseSpokenDuration(words)
+			if !ok {
+				d = SysConfig.WakeUp.Snooze
+			}
+			return snoozeWakeUp(d), nil
+		case intentDone, intentAcknowledge:
+			return stopWakeUp(), nil
+		}
+	}
+
+	e, ok := lastAnnouncedEvent()
+	if !ok {
+		return "I don't know which event you mean.", nil
+	}
+
+	switch intent {
+	case intentSnooze:
+		d, ok := parseSpokenDuration(words)
+		if !ok {
+			d = SysConfig.VoiceCommands.Snooze
+		}
+		if err := e.setSnoozed(d); err != nil {
+			return "", err
+		}
+		logInfo("Event %s snoozed for %s by voice", e.Event.ID, d)
+		return fmt.Sprintf("Okay, I'll remind you about %s in %s.", e.spokenTitle(), formatDuration(d)), nil
+
+	case intentDone:
+		if err := e.setCompleted(); err != nil {
+			return "", err
+		}
+		logInfo("Event %s marked as completed by voice", e.Event.ID)
+		if err := writeCompletion(e.Event, e.CompletedAt); err != nil {
+			logError("Failed to write completion of event %s to calendar: %v", e.Event.ID, err)
+		}
+		return fmt.Sprintf("Well done, %s is done.", e.spokenTitle()), nil
+
+	case intentAcknowledge:
+		if err := e.setAcknowledged(); err != nil {
+			return "", err
+		}
+		logInfo("Event %s acknowledged by voice", e.Event.ID)
+		return "Okay.", nil
+	}
+	return "", fmt.Errorf("unknown intent %q", intent)
+}
+
+// lastAnnouncement returns the last announcement made today, of an event
+// only if eventOnly is set.
+func lastAnnouncement(eventOnly bool) (Announcement, bool) {
+	today := clockNow()
+	entries, err := loadHistory(today, today)
+	if err != nil {
+		logError("Failed to load the announcement history: %v", err)
+		return Announcement{}, false
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Success && (!eventOnly || entries[i].EventID != "") {
+			return entries[i], true
+		}
+	}
+	return Announcement{}, false
+}
+
+// lastAnnouncedEvent returns the event of the last announcement, if it is
+// still going on.
+func lastAnnouncedEvent() (LocalEvent, bool) {
+	entry, ok := lastAnnouncement(true)
+	if !ok {
+		return LocalEvent{}, false
+	}
+	e, err := loadEventByID(entry.EventID)
+	if err != nil || e.Completed || clockNow().After(e.Event.EndTime) {
+		return LocalEvent{}, false
+	}
+	return e, true
+}
+
+// repeatLastAnnouncement speaks the last announcement again.
+func repeatLastAnnouncement() error {
+	entry, ok := lastAnnouncement(false)
+	if !ok {
+		return aiSpeak("I haven't said anything yet today.")
+	}
+
+	var e *LocalEvent
+	if entry.EventID != "" {
+		if event, err := loadEventByID(entry.EventID); err == nil {
+			e = &event
+		}
+	}
+	return aiSpeakAs(entry.Kind, e, entry.Text)
+}
+
+// parseSpokenDuration returns the duration said, like "ten minutes",
+// "15 minutes", "an hour" or "half an hour".
+func parseSpokenDuration(words []string) (time.Duration, bool) {
+	said := strings.Join(words, " ")
+	if strings.Contains(said, "half an hour") {
+		return 30 * time.Minute, true
+	}
+
+	for i := 1; i < len(words); i++ {
+		var unit time.Duration
+		switch strings.TrimSuffix(words[i], "s") {
+		case "minute", "min":
+			unit = time.Minute
+		case "hour":
+			unit = time.Hour
+		default:
+			continue
+		}
+
+		n, ok := spokenNumbers[words[i-1]]
+		if !ok {
+			var err error
+			if n, err = strconv.Atoi(words[i-1]); err != nil {
+				continue
+			}
+		}
+		if n > 0 {
+			return time.Duration(n) * unit, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Voice query intents
+const (
+	intentNext  = "next"
+	intentToday = "today"
+)
+
+// answerQuery answers a question about the schedule.
+func answerQuery(intent string) (string, error) {
+	events, err := loadTodayEvents()
+	if err != nil {
+		return "", err
+	}
+
+	// what is left, as it would be announced
+	now := clockNow()
+	left := []LocalEvent{}
+	for _, e := range events {
+		if e.Completed || announceMode(&e) == announceModeSkip {
+			continue
+		}
+		left = append(left, e)
+	}
+
+	if intent == intentNext {
+		for i := range left {
+			if left[i].Event.StartTime.After(now) {
+				return fmt.Sprintf("Next, %s.", left[i].spokenSchedule()), nil
+			}
+		}
+		return "Nothing else is planned for today.", nil
+	}
+
+	if len(left) == 0 {
+		return "You have nothing left for today.", nil
+	}
+	parts := make([]string, 0, len(left))
+	for i := range left {
+		parts = append(parts, left[i].spokenSchedule())
+	}
+	return fmt.Sprintf("Today you have %s.", joinSpoken(parts)), nil
+}
+
+// spokenSchedule tells when the event is, like toString but as it should be
+// spoken aloud.
+func (e *LocalEvent) spokenSchedule() string {
+	title := e.spokenTitle()
+	switch {
+	case e.Event.AllDay || e.Event.StartTime.IsZero():
+		return fmt.Sprintf("\"%s\" for the whole day", title)
+	case e.Event.EndTime.IsZero():
+		return fmt.Sprintf("\"%s\" at %s", title, e.Event.StartTime.Format("3:04 PM"))
+	case e.scheduledForNow():
+		return fmt.Sprintf("\"%s\" until %s", title, e.Event.EndTime.Format("3:04 PM"))
+	}
+	return fmt.Sprintf("\"%s\" from %s to %s", title, e.Event.StartTime.Format("3:04 PM"), e.Event.EndTime.Format("3:04 PM"))
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+)
+
+// Local TTS models, and the model families of tts_models
+const (
+	ttsModelKokoro = "kokoro"
+	ttsModelGlados = "glados"
+	ttsModelVits   = "vits"
+	ttsModelMatcha = "matcha"
+)
+
+// voiceKindSystem is the voices key of the messages that are not about
+// events, like the connection problems.
+const voiceKindSystem = "system"
+
+var (
+	ttsModelsMutex sync.Mutex
+	ttsModels      = make(map[string]*sherpa.OfflineTts)
+	// ttsLoadErrors is why the models failed to load, for the health check
+	ttsLoadErrors = make(map[string]error)
+)
+
+// voice is the local model and speaker an announcement is spoken with.
+type voice struct {
+	Model   string
+	Speaker int
+}
+
+// defaultTtsModel returns the model of the announcements without a voice of
+// their own, the one chosen in the web interface if any.
+func defaultTtsModel() string {
+	if active := activeTtsModel(); active != "" {
+		return active
+	}
+	return strings.ToLower(SysConfig.AiSpeechTtsConfig.TtsModel)
+}
+
+// modelSpeaker returns the default speaker of the model.
+func modelSpeaker(model string) int {
+	if def, ok := ttsModelDefinition(model); ok {
+		return def.Speaker
+	}
+	if model == ttsModelKokoro {
+		return SysConfig.AiSpeechTtsConfig.KokoroSpeaker
+	}
+	return 0
+}
+
+// voiceFor returns the voice of an announcement of the given kind, from the
+// voices setting. The profile of the event, if any, can change its speaker,
+// and an event in another language is spoken with the voice of that language.
+func voiceFor(kind string, e *LocalEvent) voice {
+	model := defaultTtsModel()
+	v := voice{Model: model, Speaker: modelSpeaker(model)}
+
+	if kind == "" {
+		kind = voiceKindSystem
+	}
+	if config, ok := SysConfig.Voices[kind]; ok {
+		v = config.apply(v)
+	}
+
+	if e == nil {
+		return v
+	}
+	if profile := reminderProfile(&e.Event); profile.Speaker != nil {
+		v.Speaker = *profile.Speaker
+	}
+	if config, ok := languageVoice(eventLanguage(&e.Event)); ok {
+		v = config.apply(v)
+	}
+	return v
+}
+
+// apply returns the voice changed by the config.
+func (c VoiceConfig) apply(v voice) voice {
+	if model := strings.ToLower(c.Model); model != "" && model != v.Model {
+		v = voice{Model: model, Speaker: modelSpeaker(model)}
+	}
+	if c.Speaker != nil {
+		v.Speaker = *c.Speaker
+	}
+	return v
+}
+
+// preloadVoice loads the model of a voice, if it has one of its own.
+func preloadVoice(name string, v VoiceConfig) {
+	if v.Model == "" {
+		return
+	}
+	if _, err := ttsModel(strings.ToLower(v.Model)); err != nil {
+		logError("Failed to load the %s TTS model of the %s voice: %v", v.Model, name, err)
+	}
+}
+
+// ttsModelConfig returns the sherpa configuration of a local model.
+func ttsModelConfig(model string) (sherpa.OfflineTtsConfig, error) {
+	config := sherpa.OfflineTtsConfig{}
+	config.Model.NumThreads = SysConfig.TtsConfig.Model.NumThreads
+	config.Model.Provider = SysConfig.TtsConfig.Model.Provider
+	config.MaxNumSentences = SysConfig.TtsConfig.MaxNumSentences
+
+	if def, ok := ttsModelDefinition(model); ok {
+		return definedModelConfig(config, def)
+	}
+
+	switch model {
+	case ttsModelKokoro:
+		config.Model.Kokoro.Model = realPath(SysConfig.AiSpeechTtsConfig.KokoroModel)
+		config.Model.Kokoro.Voices = realPath(SysConfig.AiSpeechTtsConfig.KokoroVoices)
+		config.Model.Kokoro.Tokens = realPath(SysConfig.AiSpeechTtsConfig.KokoroTokens)
+		config.Model.Kokoro.DataDir = realPath(SysConfig.AiSpeechTtsConfig.KokoroDataDir)
+		config.Model.Kokoro.LengthScale = SysConfig.AiSpeechTtsConfig.KokoroLengthScale
+	case ttsModelGlados:
+		config.Model.Vits.Model = realPath(SysConfig.AiSpeechTtsConfig.GladosModel)
+		config.Model.Vits.Lexicon = realPath(SysConfig.AiSpeechTtsConfig.GladosLexicon)
+		config.Model.Vits.Tokens = realPath(SysConfig.AiSpeechTtsConfig.GladosTokens)
+		config.Model.Vits.DataDir = realPath(SysConfig.AiSpeechTtsConfig.GladosDataDir)
+		config.Model.Vits.NoiseScale = 0.667
+		config.Model.Vits.NoiseScaleW = 0.8
+	default:
+		return config, fmt.Errorf("unknown TTS model %q", model)
+	}
+	return config, nil
+}
+
+// ttsModelDefinition returns the tts_models entry of the model, or its
+// definition if it was downloaded.
+func ttsModelDefinition(model string) (TtsModelDefinition, bool) {
+	for name, def := range SysConfig.TtsModels {
+		if strings.EqualFold(name, model) {
+			return def, true
+		}
+	}
+	return installedModel(model)
+}
+
+// definedModelConfig fills the sherpa configuration from a tts_models entry.
+func definedModelConfig(config sherpa.OfflineTtsConfig, def TtsModelDefinition) (sherpa.OfflineTtsConfig, error) {
+	if def.Model == "" || def.Tokens == "" {
+		return config, fmt.Errorf("a %s model needs a model and tokens", def.Type)
+	}
+
+	noiseScale, noiseScaleW, lengthScale := def.NoiseScale, def.NoiseScaleW, def.LengthScale
+	if noiseScale == 0 {
+		noiseScale = 0.667
+	}
+	if noiseScaleW == 0 {
+		noiseScaleW = 0.8
+	}
+	if lengthScale == 0 {
+		lengthScale = 1
+	}
+
+	config.RuleFsts = realPaths(def.RuleFsts)
+	switch strings.ToLower(def.Type) {
+	case ttsModelVits:
+		config.Model.Vits = sherpa.OfflineTtsVitsModelConfig{
+			Model:       realPath(def.Model),
+			Lexicon:     optionalPath(def.Lexicon),
+			Tokens:      realPath(def.Tokens),
+			DataDir:     optionalPath(def.DataDir),
+			DictDir:     optionalPath(def.DictDir),
+			NoiseScale:  noiseScale,
+			NoiseScaleW: noiseScaleW,
+			LengthScale: lengthScale,
+		}
+	case ttsModelMatcha:
+		if def.Vocoder == "" {
+			return config, fmt.Errorf("a matcha model needs a vocoder")
+		}
+		config.Model.Matcha = sherpa.OfflineTtsMatchaModelConfig{
+			AcousticModel: realPath(def.Model),
+			Vocoder:       realPath(def.Vocoder),
+			Lexicon:       optionalPath(def.Lexicon),
+			Tokens:        realPath(def.Tokens),
+			DataDir:       optionalPath(def.DataDir),
+			DictDir:       optionalPath(def.DictDir),
+			NoiseScale:    noiseScale,
+			LengthScale:   lengthScale,
+		}
+	case ttsModelKokoro:
+		if def.Voices == "" {
+			return config, fmt.Errorf("a kokoro model needs voices")
+		}
+		config.Model.Kokoro = sherpa.OfflineTtsKokoroModelConfig{
+			Model:       realPath(def.Model),
+			Voices:      realPath(def.Voices),
+			Tokens:      realPath(def.Tokens),
+			Lexicon:     realPaths(def.Lexicon),
+			DataDir:     optionalPath(def.DataDir),
+			DictDir:     optionalPath(def.DictDir),
+			Lang:        def.Lang,
+			LengthScale: lengthScale,
+		}
+	default:
+		return config, fmt.Errorf("unknown TTS model type %q, expected vits, matcha or kokoro", def.Type)
+	}
+	return config, nil
+}
+
+// optionalPath returns the real path of an optional file, empty if not set.
+func optionalPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return realPath(path)
+}
+
+// realPaths returns the real paths of a comma separated list of files, like
+// the kokoro lexicons or the rule FSTs.
+func realPaths(paths string) string {
+	list := []string{}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			list = append(list, realPath(path))
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+// ttsModel returns the loaded local model, loading it on first use. The
+// models stay loaded, switching between them is then immediate.
+func ttsModel(model string) (*sherpa.OfflineTts, error) {
+	ttsModelsMutex.Lock()
+	defer ttsModelsMutex.Unlock()
+
+	if tts, ok := ttsModels[model]; ok {
+		return tts, nil
+	}
+
+	config, err := ttsModelConfig(model)
+	if err != nil {
+		ttsLoadErrors[model] = err
+		return nil, err
+	}
+	logInfo("Loading the %s TTS model", model)
+	tts := sherpa.NewOfflineTts(&config)
+	if tts == nil {
+		ttsLoadErrors[model] = fmt.Errorf("failed to load the %s TTS model", model)
+		return nil, ttsLoadErrors[model]
+	}
+	ttsModels[model] = tts
+	delete(ttsLoadErrors, model)
+	return tts, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	volumeMutex sync.Mutex
+	// volumeOverride is the volume set at runtime, -1 if the configured one applies
+	volumeOverride = -1
+	// unmuteVolume is the override to go back to when unmuted, -2 if not muted
+	unmuteVolume = -2
+)
+
+// configuredVolume returns the volume of the time of day, from the first
+// matching window, or the base level outside of them.
+func configuredVolume() int {
+	now := clockNow()
+	for _, window := range SysConfig.Volume.Windows {
+		from, err := todayAt(window.From)
+		if err != nil {
+			logError("invalid volume window: %v", err)
+			continue
+		}
+		to, err := todayAt(window.To)
+		if err != nil {
+			logError("invalid volume window: %v", err)
+			continue
+		}
+
+		// windows like 21:00-07:00 go over midnight
+		inWindow := !now.Before(from) && now.Before(to)
+		if !to.After(from) {
+			inWindow = !now.Before(from) || now.Before(to)
+		}
+		if inWindow {
+			return window.Level
+		}
+	}
+	return SysConfig.Volume.Level
+}
+
+// currentVolume returns the volume in percent, the one set at runtime if any.
+func currentVolume() int {
+	volumeMutex.Lock()
+	defer volumeMutex.Unlock()
+
+	if volumeOverride >= 0 {
+		return volumeOverride
+	}
+	return configuredVolume()
+}
+
+// setVolume sets the volume in percent until it is reset, -1 goes back to
+// the configured volume.
+func setVolume(level int) error {
+	if level < -1 || level > 100 {
+		return fmt.Errorf("volume must be between 0 and 100")
+	}
+
+	volumeMutex.Lock()
+	defer volumeMutex.Unlock()
+	volumeOverride = level
+	unmuteVolume = -2
+	return nil
+}
+
+// toggleMute sets the volume to 0, or back to what it was before, and
+// returns true if it is now muted.
+func toggleMute() bool {
+	volumeMutex.Lock()
+	defer volumeMutex.Unlock()
+
+	if unmuteVolume != -2 {
+		volumeOverride = unmuteVolume
+		unmuteVolume = -2
+		return false
+	}
+	unmuteVolume = volumeOverride
+	volumeOverride = 0
+	return true
+}
+
+// volumeOverridden returns true if the volume was set at runtime.
+func volumeOverridden() bool {
+	volumeMutex.Lock()
+	defer volumeMutex.Unlock()
+	return volumeOverride >= 0
+}
+
+// speechVolume returns the scale of the samples of an announcement of the
+// given kind, emergencies are always played at full scale and the wake-up
+// alarms at their own volume.
+func speechVolume(kind string) float64 {
+	if kind == announceKindPanic {
+		return 1
+	}
+	if kind == announceKindWakeUp && SysConfig.WakeUp.Volume > 0 {
+		return float64(SysConfig.WakeUp.Volume) / 100
+	}
+	level := currentVolume()
+	if nightMode(kind) == nightActionQuiet {
+		level = min(level, SysConfig.NightMode.Volume)
+	}
+	return float64(level) / 100
+}
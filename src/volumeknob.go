@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Volume knob types
+const (
+	volumeKnobEncoder       = "encoder"       // a rotary encoder, its push switch mutes
+	volumeKnobPotentiometer = "potentiometer" // a potentiometer read by an MCP3008
+)
+
+const (
+	volumeKnobRetry = time.Minute
+	// potentiometerInterval is how often the potentiometer is read, and
+	// potentiometerDeadband how far it has to turn to change the volume, the
+	// volume set from the web interface stays until it is turned.
+	potentiometerInterval = 100 * time.Millisecond
+	potentiometerDeadband = 2
+
+	spiMessageIoctl     = 0x40206b00 // SPI_IOC_MESSAGE(1)
+	spiTransferSize     = 32         // struct spi_ioc_transfer
+	mcp3008SpeedHz      = 1000000
+	mcp3008MaxReading   = 1023
+	mcp3008SingleEnded  = 0x80
+	mcp3008StartBit     = 0x01
+	mcp3008ChannelShift = 4
+)
+
+// encoderSteps is the step of a quadrature encoder going from one state of
+// its pins to the next, by the previous state of A and B and the new one. 0
+// is no change, or a state that was skipped.
+var encoderSteps = [16]int{0, -1, 1, 0, 1, 0, 0, -1, -1, 0, 0, 1, 0, 1, -1, 0}
+
+// runVolumeKnob changes the volume with a knob next to the speaker, the same
+// way the web interface does.
+func runVolumeKnob() {
+	warned := false
+	for {
+		cfg := SysConfig.VolumeKnob
+		if !cfg.Enabled {
+			time.Sleep(volumeKnobRetry)
+			continue
+		}
+		var err error
+		switch cfg.Type {
+		case volumeKnobEncoder:
+			err = watchEncoder(cfg)
+		case volumeKnobPotentiometer:
+			err = watchPotentiometer(cfg)
+		default:
+			err = fmt.Errorf("unknown volume knob type %q", cfg.Type)
+		}
+		if err != nil {
+			if !warned {
+				logError("Failed to read the volume knob, retrying every %s: %v", volumeKnobRetry, err)
+				warned = true
+			}
+			time.Sleep(volumeKnobRetry)
+			continue
+		}
+		warned = false
+	}
+}
+
+// watchEncoder turns the volume up and down with the rotary encoder, and
+// mutes it with its switch, until the config changes.
+func watchEncoder(cfg VolumeKnobConfig) error {
+	// encoder modules pull their pins up, and connect them to ground
+	lines, err := requestGPIOInputs(cfg.Chip, []int{cfg.PinA, cfg.PinB}, true, 0)
+	if err != nil {
+		return err
+	}
+	defer lines.Close()
+	var button *gpioLines
+	if cfg.SwitchPin > 0 {
+		button, err = requestGPIOInputs(cfg.Chip, []int{cfg.SwitchPin}, true, DefaultButtonDebounce)
+		if err != nil {
+			return err
+		}
+		defer button.Close()
+	}
+	logInfo("Watching the volume knob on pins %d and %d of %s", cfg.PinA, cfg.PinB, cfg.Chip)
+
+	// closing the pins ends the wait for a turn
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(configCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if SysConfig.VolumeKnob != cfg {
+					lines.Close()
+					return
+				}
+			}
+		}
+	}()
+	if button != nil {
+		go func() {
+			for {
+				event, err := button.readEvent()
+				if err != nil {
+					// the turns are not read either then
+					lines.Close()
+					return
+				}
+				if !event.Rising {
+					continue
+				}
+				if toggleMute() {
+					logInfo("Volume muted with the knob")
+				} else {
+					logInfo("Volume unmuted with the knob")
+				}
+			}
+		}()
+	}
+
+	values, err := lines.values()
+	if err != nil {
+		return err
+	}
+	state := int(values & 0b11)
+	steps := 0
+	for {
+		event, err := lines.readEvent()
+		if err != nil {
+			if SysConfig.VolumeKnob != cfg {
+				logInfo("Volume knob config changed, opening it again")
+				return nil
+			}
+			return err
+		}
+
+		bit := 0b01
+		if event.Pin == cfg.PinB {
+			bit = 0b10
+		}
+		next := state &^ bit
+		if event.Rising {
+			next |= bit
+		}
+		steps += encoderSteps[state<<2|next]
+		state = next
+
+		// a click of the knob is a few steps
+		if steps >= cfg.StepsPerDetent || steps <= -cfg.StepsPerDetent {
+			turnVolume(steps / cfg.StepsPerDetent * cfg.Step)
+			steps = 0
+		}
+	}
+}
+
+// turnVolume changes the volume by delta percent, which unmutes it.
+func turnVolume(delta int) {
+	level := min(max(currentVolume()+delta, 0), 100)
+	if err := setVolume(level); err != nil {
+		logError("Failed to set the volume: %v", err)
+		return
+	}
+	logDebug("Volume set to %d%% with the knob", level)
+}
+
+// watchPotentiometer sets the volume to the position of the potentiometer,
+// when it is turned, until the config changes.
+func watchPotentiometer(cfg VolumeKnobConfig) error {
+	f, err := os.OpenFile(cfg.SPI, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	logInfo("Reading the volume knob on channel %d of %s", cfg.Channel, cfg.SPI)
+
+	ticker := time.NewTicker(potentiometerInterval)
+	defer ticker.Stop()
+	last := -1
+	for range ticker.C {
+		if SysConfig.VolumeKnob != cfg {
+			logInfo("Volume knob config changed, opening it again")
+			return nil
+		}
+		reading, err := readMCP3008(f, cfg.Channel)
+		if err != nil {
+			return err
+		}
+		level := reading * 100 / mcp3008MaxReading
+		if last >= 0 && level > last-potentiometerDeadband && level < last+potentiometerDeadband {
+			continue
+		}
+		// where it is at startup is not a turn
+		if last >= 0 {
+			if err := setVolume(level); err != nil {
+				return err
+			}
+			logDebug("Volume set to %d%% with the knob", level)
+		}
+		last = level
+	}
+	return nil
+}
+
+// readMCP3008 returns the 10 bit reading of a single-ended channel of an
+// MCP3008.
+func readMCP3008(f *os.File, channel int) (int, error) {
+	tx := []byte{mcp3008StartBit, byte(mcp3008SingleEnded | channel<<mcp3008ChannelShift), 0}
+	rx := make([]byte, len(tx))
+
+	// struct spi_ioc_transfer: tx_buf, rx_buf, len, speed_hz, then the
+	// delays and the bits per word, 0 for the defaults
+	transfer := make([]byte, spiTransferSize)
+	le := binary.LittleEndian
+	le.PutUint64(transfer[0:], uint64(uintptr(unsafe.Pointer(&tx[0]))))
+	le.PutUint64(transfer[8:], uint64(uintptr(unsafe.Pointer(&rx[0]))))
+	le.PutUint32(transfer[16:], uint32(len(tx)))
+	le.PutUint32(transfer[20:], mcp3008SpeedHz)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), spiMessageIoctl, uintptr(unsafe.Pointer(&transfer[0]))); errno != 0 {
+		return 0, fmt.Errorf("failed to read the MCP3008: %v", errno)
+	}
+	runtime.KeepAlive(tx)
+	return int(rx[1]&0b11)<<8 | int(rx[2]), nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// wakeUpAnswerWindow is how long after it rings the button, the voice and
+	// the web interface stop or snooze the alarm, instead of acting on the
+	// last event.
+	wakeUpAnswerWindow = 10 * time.Minute
+	// wakeUpLate is how late an alarm still rings, e.g. after a restart.
+	wakeUpLate = 15 * time.Minute
+)
+
+var (
+	wakeUpMutex sync.Mutex
+	// wakeUpRang is when the alarm last rang, zero once it is stopped or
+	// snoozed
+	wakeUpRang time.Time
+	// wakeUpLast is the configured time that rang last, not to ring it twice
+	wakeUpLast time.Time
+	// wakeUpSnoozedUntil is when a snoozed alarm rings again
+	wakeUpSnoozedUntil time.Time
+	// wakeUpSnoozes counts the snoozes since the alarm first rang
+	wakeUpSnoozes int
+)
+
+// wakeUpTemplateData is what the greeting template can use, e.g.
+// "Good morning, it's {{humanTime .Now}}".
+type wakeUpTemplateData struct {
+	Now time.Time
+}
+
+// WakeUpState is the state of the wake-up alarms, as returned by the API.
+type WakeUpState struct {
+	Enabled bool      `json:"enabled"`
+	Ringing bool      `json:"ringing"`
+	Snoozed bool      `json:"snoozed"`
+	Next    time.Time `json:"next"` // When it rings next, the end of the snooze if snoozed
+}
+
+// runWakeUpAlarms rings the wake-up alarms at their time, and again after a
+// snooze.
+func runWakeUpAlarms() {
+	for {
+		changes := scheduleChanges()
+		next := time.Now().Add(schedulerMaxSleep)
+
+		if SysConfig.WakeUp.Enabled && !simulating {
+			at, snoozed := nextWakeUp()
+			if !at.IsZero() && !time.Now().Before(at) {
+				ringWakeUp(at, snoozed)
+				continue
+			}
+			if !at.IsZero() && at.Before(next) {
+				next = at.Add(schedulerSlack)
+			}
+		}
+
+		sleepUntil(next, changes)
+	}
+}
+
+// nextWakeUp returns when the alarm rings next, at the end of a snooze or at
+// the first configured time after the one that rang last, and whether it is
+// a snooze. The time is zero if no alarm is set.
+func nextWakeUp() (time.Time, bool) {
+	wakeUpMutex.Lock()
+	defer wakeUpMutex.Unlock()
+	if !wakeUpSnoozedUntil.IsZero() {
+		return wakeUpSnoozedUntil, true
+	}
+
+	now := time.Now()
+	var next time.Time
+	// from the alarms that are late, up to the ones of a week later
+	for day := startOfDay(now.Add(-wakeUpLate)); day.Before(now.AddDate(0, 0, 8)); day = day.AddDate(0, 0, 1) {
+		for _, alarm := range SysConfig.WakeUp.Alarms {
+			if len(alarm.Days) > 0 && !slices.Contains(alarm.Days, weekdays[day.Weekday()]) {
+				continue
+			}
+			clock, err := time.Parse("15:04", alarm.Time)
+			if err != nil {
+				logError("invalid wake-up alarm time %q: %v", alarm.Time, err)
+				continue
+			}
+			at := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
+			if !at.After(wakeUpLast) || at.Before(now.Add(-wakeUpLate)) {
+				continue
+			}
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+		if !next.IsZero() {
+			break
+		}
+	}
+	return next, false
+}
+
+// ringWakeUp rings the alarm: the sound, the greeting and, the first time
+// it rings, the events of the day.
+func ringWakeUp(at time.Time, snoozed bool) {
+	wakeUpMutex.Lock()
+	if snoozed {
+		wakeUpSnoozedUntil = time.Time{}
+	} else {
+		wakeUpLast = at
+		wakeUpSnoozes = 0
+	}
+	paused := isPaused()
+	if !paused {
+		wakeUpRang = time.Now()
+	}
+	wakeUpMutex.Unlock()
+
+	if paused {
+		logInfo("Announcements are paused, the wake-up alarm of %s doesn't ring", at.Format("15:04"))
+		return
+	}
+
+	logInfo("Wake-up alarm of %s ringing", at.Format("15:04"))
+	text := renderWakeUpGreeting()
+	if SysConfig.WakeUp.Briefing && !snoozed {
+		briefing, err := answerQuery(intentToday)
+		if err != nil {
+			logError("failed to load the events for the wake-up briefing: %v", err)
+		} else {
+			text += " " + briefing
+		}
+	}
+	announceTask(nil, announceKindWakeUp, text)
+}
+
+func renderWakeUpGreeting() string {
+	defaultMessage := fmt.Sprintf("Good morning! It's %s, time to get up.", time.Now().Format("3:04 PM"))
+	tmplText := SysConfig.WakeUp.Greeting.pick()
+	if tmplText == "" {
+		return defaultMessage
+	}
+
+	tmpl, err := template.New("wake_up").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		logError("failed to parse wake-up greeting: %v", err)
+		return defaultMessage
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, wakeUpTemplateData{Now: time.Now()}); err != nil {
+		logError("failed to execute wake-up greeting: %v", err)
+		return defaultMessage
+	}
+	return buf.String()
+}
+
+// wakeUpRinging returns true while the alarm that rang can be stopped or
+// snoozed.
+func wakeUpRinging() bool {
+	wakeUpMutex.Lock()
+	defer wakeUpMutex.Unlock()
+	return !wakeUpRang.IsZero() && time.Since(wakeUpRang) < wakeUpAnswerWindow
+}
+
+// stopWakeUp stops the alarm until its next time, and returns what to
+// answer.
+func stopWakeUp() string {
+	wakeUpMutex.Lock()
+	defer wakeUpMutex.Unlock()
+	wakeUpRang = time.Time{}
+	wakeUpSnoozedUntil = time.Time{}
+	wakeUpSnoozes = 0
+	logInfo("Wake-up alarm stopped")
+	return "Okay, have a good day."
+}
+
+// snoozeWakeUp rings the alarm again after d, up to wake_up.max_snoozes
+// times in a row, and returns what to answer.
+func snoozeWakeUp(d time.Duration) string {
+	wakeUpMutex.Lock()
+	if wakeUpSnoozes >= SysConfig.WakeUp.MaxSnoozes {
+		wakeUpMutex.Unlock()
+		stopWakeUp()
+		return "No more snoozing, it's time to get up!"
+	}
+	wakeUpSnoozes++
+	wakeUpRang = time.Time{}
+	wakeUpSnoozedUntil = time.Now().Add(d)
+	wakeUpMutex.Unlock()
+
+	logInfo("Wake-up alarm snoozed for %s", d)
+	reschedule()
+	return fmt.Sprintf("Okay, I'll wake you up again in %s.", formatDuration(d))
+}
+
+// wakeUpState returns the state of the wake-up alarms.
+func wakeUpState() WakeUpState {
+	state := WakeUpState{Enabled: SysConfig.WakeUp.Enabled, Ringing: wakeUpRinging()}
+	if !state.Enabled {
+		return state
+	}
+	state.Next, state.Snoozed = nextWakeUp()
+	return state
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+)
+
+const (
+	// micRetryDelay is the pause before the microphone is opened again.
+	micRetryDelay = 10 * time.Second
+)
+
+// wakeHandler is called with what was said after the wake word, the samples
+// are mono at micSampleRate and empty if nothing was said.
+type wakeHandler func(keyword string, samples []float32)
+
+// wakeHandlers are called in order each time the wake word is heard, they
+// are registered before listening starts.
+var wakeHandlers []wakeHandler
+
+// onWake registers a handler of the wake word.
+func onWake(handler wakeHandler) {
+	wakeHandlers = append(wakeHandlers, handler)
+}
+
+// listenForWakeWord listens to the microphone for the wake word, and opens
+// a listening window each time it is heard.
+func listenForWakeWord() {
+	if !SysConfig.WakeWord.Enabled || simulating || SysConfig.DryRun {
+		return
+	}
+
+	spotter, err := newKeywordSpotter()
+	if err != nil {
+		logError("Wake word disabled: %v", err)
+		return
+	}
+	defer sherpa.DeleteKeywordSpotter(spotter)
+
+	for {
+		if err := spotWakeWord(spotter); err != nil {
+			logError("Wake word detection stopped: %v", err)
+		}
+		time.Sleep(micRetryDelay)
+	}
+}
+
+// newKeywordSpotter loads the keyword spotting model.
+func newKeywordSpotter() (*sherpa.KeywordSpotter, error) {
+	dir := realPath(SysConfig.WakeWord.Model)
+	files := map[string]string{}
+	for _, name := range []string{"encoder", "decoder", "joiner"} {
+		file, err := findModelFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = file
+	}
+
+	keywords := SysConfig.WakeWord.Keywords
+	if keywords == "" {
+		keywords = filepath.Join(dir, "keywords.txt")
+	} else {
+		keywords = realPath(keywords)
+	}
+	if _, err := os.Stat(keywords); err != nil {
+		return nil, fmt.Errorf("keywords file not found: %v", err)
+	}
+
+	config := sherpa.KeywordSpotterConfig{}
+	config.FeatConfig.SampleRate = micSampleRate
+	config.FeatConfig.FeatureDim = 80
+	config.ModelConfig.Transducer.Encoder = files["encoder"]
+	config.ModelConfig.Transducer.Decoder = files["decoder"]
+	config.ModelConfig.Transducer.Joiner = files["joiner"]
+	config.ModelConfig.Tokens = filepath.Join(dir, "tokens.txt")
+	config.ModelConfig.NumThreads = 1
+	config.ModelConfig.Provider = "cpu"
+	config.MaxActivePaths = 4
+	config.KeywordsFile = keywords
+	config.KeywordsScore = SysConfig.WakeWord.Score
+	config.KeywordsThreshold = SysConfig.WakeWord.Threshold
+
+	spotter := sherpa.NewKeywordSpotter(&config)
+	if spotter == nil {
+		return nil, fmt.Errorf("failed to load the keyword spotting model from %s", dir)
+	}
+	return spotter, nil
+}
+
+// findModelFile returns the ONNX file of a part of the model, like
+// "tiny.en-encoder.onnx", the int8 one if there is one as it is faster on a Pi.
+func findModelFile(dir, part string) (string, error) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+part+"*.onnx"))
+	if len(files) == 0 {
+		return "", fmt.Errorf("no %s found in %s", part, dir)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.Contains(files[i], ".int8.") && !strings.Contains(files[j], ".int8.")
+	})
+	return files[0], nil
+}
+
+// spotWakeWord listens until the microphone fails.
+func spotWakeWord(spotter *sherpa.KeywordSpotter) error {
+	mic, err := openMicrophone(SysConfig.Microphone)
+	if err != nil {
+		return err
+	}
+	defer mic.close()
+
+	stream := sherpa.NewKeywordStream(spotter)
+	defer sherpa.DeleteOnlineStream(stream)
+
+	logInfo("Listening for the wake word")
+	for {
+		// a question asked from elsewhere is listened to on this microphone
+		select {
+		case req := <-micRequests:
+			samples, err := recordSpeech(mic, req.window, nil)
+			req.result <- micResult{samples, err}
+			if err != nil {
+				return err
+			}
+			spotter.Reset(stream)
+			continue
+		default:
+		}
+
+		samples, err := mic.read()
+		if err != nil {
+			return err
+		}
+
+		// the announcements must not wake it up
+		if !speaking.TryLock() {
+			continue
+		}
+		speaking.Unlock()
+
+		stream.AcceptWaveform(micSampleRate, samples)
+		for spotter.IsReady(stream) {
+			spotter.Decode(stream)
+		}
+		keyword := spotter.GetResult(stream).Keyword
+		if keyword == "" {
+			continue
+		}
+		spotter.Reset(stream)
+
+		logInfo("Wake word %q heard", keyword)
+		command, err := captureCommand(mic)
+		if err != nil {
+			return err
+		}
+		go wakeUp(keyword, command)
+	}
+}
+
+// captureCommand plays the wake sound and records what is said next, until
+// a pause or the end of the listening window.
+func captureCommand(mic *microphone) ([]float32, error) {
+	played := make(chan struct{})
+	go func() {
+		defer close(played)
+		if SysConfig.WakeWord.Sound == "" {
+			return
+		}
+		speaking.Lock()
+		defer speaking.Unlock()
+		if err := playSoundFile(realPath(SysConfig.WakeWord.Sound), speechVolume("")); err != nil {
+			logError("Failed to play the wake sound: %v", err)
+		}
+	}()
+
+	return recordSpeech(mic, SysConfig.WakeWord.Window, played)
+}
+
+// wakeUp hands what was said to the handlers.
+func wakeUp(keyword string, command []float32) {
+	if len(command) == 0 {
+		logDebug("Nothing was said after the wake word")
+	}
+	if len(wakeHandlers) == 0 {
+		logDebug("No handler of the wake word, ignoring %s of speech", time.Duration(len(command))*time.Second/micSampleRate)
+		return
+	}
+	for _, handler := range wakeHandlers {
+		handler(keyword, command)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Webhook event types
+const (
+	webhookStart        = "start"        // the start is announced, or caught up on
+	webhookReminder     = "reminder"     // an alarm, pre-start, start check or periodic reminder
+	webhookEnd          = "end"          // the end is announced
+	webhookAcknowledged = "acknowledged" // from the voice, the web interface or a chat
+	webhookSnoozed      = "snoozed"
+	webhookCompleted    = "completed"
+	webhookAnnouncement = "announcement" // anything else said: the review, the recap, an emergency
+)
+
+// webhookTypes are the types the webhook events lists can have.
+var webhookTypes = []string{webhookStart, webhookReminder, webhookEnd, webhookAcknowledged, webhookSnoozed, webhookCompleted, webhookAnnouncement}
+
+const (
+	// webhookAttempts is how many times a webhook is tried, waiting
+	// webhookBackoff before the second try, doubled for the next ones.
+	webhookAttempts = 3
+	webhookBackoff  = 2 * time.Second
+	webhookTimeout  = 10 * time.Second
+	// webhookSignatureHeader holds the HMAC-SHA256 of the body with the
+	// secret of the webhook, as sha256=<hex>.
+	webhookSignatureHeader = "X-Reminder-Signature"
+)
+
+// WebhookPayload is the JSON body posted to the webhooks.
+type WebhookPayload struct {
+	Type  string        `json:"type"`
+	Time  time.Time     `json:"time"`
+	Event *WebhookEvent `json:"event,omitempty"`
+
+	// The announcement, for the announced types
+	Kind         string `json:"kind,omitempty"` // e.g. start, catch_up, remind
+	Text         string `json:"text,omitempty"`
+	Spoken       *bool  `json:"spoken,omitempty"`
+	Error        string `json:"error,omitempty"`
+	SnoozedUntil string `json:"snoozed_until,omitempty"` // For snoozed, RFC 3339
+}
+
+// WebhookEvent is the calendar event a webhook is about. Private events
+// have the title they are announced with.
+type WebhookEvent struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	AllDay   bool      `json:"all_day"`
+	Calendar string    `json:"calendar,omitempty"`
+	Priority string    `json:"priority"`
+}
+
+// webhookType returns the webhook event type of an announcement kind.
+func webhookType(kind string) string {
+	switch kind {
+	case announceKindStart, announceKindCatchUp, announceKindAllDay:
+		return webhookStart
+	case announceKindAlarm, announceKindPreStart, announceKindCheckStart, announceKindRemind:
+		return webhookReminder
+	case announceKindEnd:
+		return webhookEnd
+	}
+	return webhookAnnouncement
+}
+
+// webhookEvent returns what the webhooks are told about the event.
+func webhookEvent(e *LocalEvent) *WebhookEvent {
+	if e == nil {
+		return nil
+	}
+	return &WebhookEvent{
+		ID:       e.Event.ID,
+		Title:    e.spokenTitle(),
+		Start:    e.Event.StartTime,
+		End:      e.Event.EndTime,
+		AllDay:   e.Event.AllDay,
+		Calendar: e.Event.Calendar,
+		Priority: eventPriority(&e.Event),
+	}
+}
+
+// webhookChannel tells a webhook about the announcements, spoken or not.
+type webhookChannel struct {
+	hook WebhookConfig
+}
+
+func webhookChannels() []NotificationChannel {
+	channels := make([]NotificationChannel, 0, len(SysConfig.Webhooks))
+	for _, hook := range SysConfig.Webhooks {
+		channels = append(channels, webhookChannel{hook})
+	}
+	return channels
+}
+
+func (c webhookChannel) Name() string { return "webhook:" + c.hook.Name }
+
+func (c webhookChannel) Wants(ev AnnouncementEvent) bool {
+	return len(c.hook.Events) == 0 || slices.Contains(c.hook.Events, webhookType(ev.Kind))
+}
+
+func (c webhookChannel) Send(ev AnnouncementEvent) error {
+	spoken := ev.Success
+	payload := WebhookPayload{
+		Type:   webhookType(ev.Kind),
+		Time:   ev.Time,
+		Event:  webhookEvent(ev.Event),
+		Kind:   ev.Kind,
+		Text:   ev.Text,
+		Spoken: &spoken,
+		Error:  ev.Error,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return permanentError{err}
+	}
+	retry, err := sendWebhook(c.hook, payload.Type, body)
+	if err != nil && !retry {
+		return permanentError{err}
+	}
+	return err
+}
+
+// eventWebhook tells the webhooks about a change of the state of the event,
+// the announcements reach them through their notification channels.
+func eventWebhook(typ string, e *LocalEvent) {
+	payload := WebhookPayload{Type: typ, Time: clockNow(), Event: webhookEvent(e)}
+	if typ == webhookSnoozed {
+		payload.SnoozedUntil = e.SnoozedUntil.Format(time.RFC3339)
+	}
+	fireWebhooks(payload)
+}
+
+// fireWebhooks posts the payload to the webhooks that want its type, in
+// the background.
+func fireWebhooks(payload WebhookPayload) {
+	if simulating || len(SysConfig.Webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logError("Failed to marshal the webhook payload: %v", err)
+		return
+	}
+
+	for _, hook := range SysConfig.Webhooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, payload.Type) {
+			continue
+		}
+		go func() {
+			if err := postWebhook(hook, payload.Type, body); err != nil {
+				logError("Failed to call webhook %s: %v", hook.Name, err)
+			}
+		}()
+	}
+}
+
+// postWebhook posts the body to the webhook, retrying with backoff when it
+// fails or the server errors.
+func postWebhook(hook WebhookConfig, typ string, body []byte) error {
+	var err error
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			logWarn("Webhook %s failed, retrying in %s: %v", hook.Name, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		if retry, err = sendWebhook(hook, typ, body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// sendWebhook posts the body once, and tells whether a failure is worth
+// retrying.
+func sendWebhook(hook WebhookConfig, typ string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PiVoiceReminder")
+	req.Header.Set("X-Reminder-Event", typ)
+	if secret := webhookSecret(hook.Name); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := (&http.Client{Timeout: webhookTimeout}).Do(req)
+	if err != nil {
+		// the URL may have a token in it
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("server answered %s", resp.Status)
+	}
+	logDebug("Webhook %s called for %s", hook.Name, typ)
+	return false, nil
+}
+
+// webhookSecret returns the secret the payloads of the webhook are signed
+// with, empty if they are not.
+func webhookSecret(name string) string {
+	for _, s := range SysSecrets.Webhooks {
+		if s.Name == name {
+			return s.Secret
+		}
+	}
+	return ""
+}
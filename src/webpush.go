@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webPushRecordSize is the record size of the encrypted payloads, they
+	// fit in a single record.
+	webPushRecordSize = 4096
+	// webPushJWTLifetime is how long the VAPID signatures are valid, at
+	// most 24 hours.
+	webPushJWTLifetime = 12 * time.Hour
+	webPushTimeout     = 30 * time.Second
+)
+
+// WebPushSubscription is a browser that asked for the notifications, as
+// given by PushManager.subscribe.
+type WebPushSubscription struct {
+	ID        string    `json:"id"`
+	Endpoint  string    `json:"endpoint"`
+	P256dh    string    `json:"p256dh"` // Public key of the browser, base64url
+	Auth      string    `json:"auth"`   // Authentication secret, base64url
+	User      string    `json:"user"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// webPushState is what is kept in webPushPath: the VAPID key the
+// notifications are signed with and the subscriptions.
+type webPushState struct {
+	PrivateKey    string                `json:"private_key"` // DER of the EC key, base64
+	Subscriptions []WebPushSubscription `json:"subscriptions"`
+}
+
+// WebPushMessage is the payload of a notification, shown by the service
+// worker.
+type WebPushMessage struct {
+	Title   string    `json:"title"`
+	Body    string    `json:"body"`
+	Tag     string    `json:"tag,omitempty"` // Replaces the earlier notification with the same tag
+	URL     string    `json:"url"`           // Opened when the notification is clicked, relative to the interface
+	Time    time.Time `json:"time"`
+	EventID string    `json:"event_id,omitempty"`
+}
+
+var webPush sync.Mutex
+
+func readWebPushState() (webPushState, error) {
+	state := webPushState{Subscriptions: []WebPushSubscription{}}
+	data, err := os.ReadFile(realPath(webPushPath))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read web push state: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse web push state: %v", err)
+	}
+	return state, nil
+}
+
+func writeWebPushState(state webPushState) error {
+	data, err := json.MarshalIndent(state, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal web push state: %v", err)
+	}
+	return writeFileAtomically(realPath(webPushPath), data, 0600)
+}
+
+// webPushKey returns the VAPID key, created the first time. The caller must
+// hold webPush.
+func webPushKey(state *webPushState) (*ecdsa.PrivateKey, error) {
+	if state.PrivateKey != "" {
+		der, err := base64.StdEncoding.DecodeString(state.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the VAPID key: %v", err)
+		}
+		key, err := x509.ParseECPrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the VAPID key: %v", err)
+		}
+		return key, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the VAPID key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the VAPID key: %v", err)
+	}
+	state.PrivateKey = base64.StdEncoding.EncodeToString(der)
+	if err := writeWebPushState(*state); err != nil {
+		return nil, err
+	}
+	logInfo("Created the VAPID key of the web push notifications")
+	return key, nil
+}
+
+// webPushPublicKey returns the public VAPID key, as the browsers take it
+// for applicationServerKey.
+func webPushPublicKey() (string, error) {
+	webPush.Lock()
+	defer webPush.Unlock()
+
+	state, err := readWebPushState()
+	if err != nil {
+		return "", err
+	}
+	key, err := webPushKey(&state)
+	if err != nil {
+		return "", err
+	}
+	public, err := key.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(public.Bytes()), nil
+}
+
+// addWebPushSubscription keeps the subscription, replacing the one of the
+// same endpoint.
+func addWebPushSubscription(sub WebPushSubscription) (WebPushSubscription, error) {
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return sub, fmt.Errorf("invalid push endpoint")
+	}
+	if p256dh, err := decodeBase64URL(sub.P256dh); err != nil || len(p256dh) != 65 {
+		return sub, fmt.Errorf("invalid p256dh key")
+	}
+	if auth, err := decodeBase64URL(sub.Auth); err != nil || len(auth) != 16 {
+		return sub, fmt.Errorf("invalid auth secret")
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return sub, err
+	}
+	sub.ID = hex.EncodeToString(id)
+	sub.CreatedAt = time.Now()
+
+	webPush.Lock()
+	defer webPush.Unlock()
+
+	state, err := readWebPushState()
+	if err != nil {
+		return sub, err
+	}
+	subs := []WebPushSubscription{}
+	for _, s := range state.Subscriptions {
+		if s.Endpoint != sub.Endpoint {
+			subs = append(subs, s)
+		}
+	}
+	state.Subscriptions = append(subs, sub)
+	return sub, writeWebPushState(state)
+}
+
+// removeWebPushSubscription drops the subscription of the endpoint.
+func removeWebPushSubscription(endpoint string) error {
+	webPush.Lock()
+	defer webPush.Unlock()
+
+	state, err := readWebPushState()
+	if err != nil {
+		return err
+	}
+	for i, s := range state.Subscriptions {
+		if s.Endpoint == endpoint {
+			state.Subscriptions = append(state.Subscriptions[:i], state.Subscriptions[i+1:]...)
+			return writeWebPushState(state)
+		}
+	}
+	return fmt.Errorf("subscription not found")
+}
+
+// webPushChannel mirrors the spoken announcements to the subscribed
+// browsers, if web_push is enabled.
+type webPushChannel struct{}
+
+func webPushChannels() []NotificationChannel {
+	return []NotificationChannel{webPushChannel{}}
+}
+
+func (webPushChannel) Name() string { return "web_push" }
+
+func (webPushChannel) Wants(ev AnnouncementEvent) bool {
+	return SysConfig.WebPush.Enabled && ev.Success
+}
+
+func (webPushChannel) Send(ev AnnouncementEvent) error {
+	msg := WebPushMessage{
+		Title:   announcementTitle(ev.Kind),
+		Body:    ev.Text,
+		Tag:     ev.EventID,
+		URL:     "./",
+		Time:    ev.Time,
+		EventID: ev.EventID,
+	}
+	if ev.EventID != "" {
+		msg.URL = "events/" + url.PathEscape(ev.EventID)
+	}
+	// a retry shows again on the browsers that got it, replacing it by the tag
+	return sendWebPush(msg, "")
+}
+
+// announcementTitle returns the title of the notification of an
+// announcement of that kind.
+func announcementTitle(kind string) string {
+	switch kind {
+	case announceKindStart, announceKindCatchUp, announceKindAllDay:
+		return "Starting now"
+	case announceKindAlarm, announceKindPreStart:
+		return "Coming up"
+	case announceKindCheckStart:
+		return "Did it start?"
+	case announceKindRemind:
+		return "Reminder"
+	case announceKindEnd:
+		return "Ended"
+	case announceKindReview, announceKindRecap:
+		return "End of the day"
+	case announceKindPanic:
+		return "Emergency"
+	case announceKindMissedDose:
+		return "Missed dose"
+	case announceKindWakeUp:
+		return "Good morning"
+	}
+	return "Reminder"
+}
+
+// sendWebPush sends the message to every subscription, or only to the one
+// of the endpoint if given. The ones the push service says are gone are
+// dropped.
+func sendWebPush(msg WebPushMessage, endpoint string) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	webPush.Lock()
+	state, err := readWebPushState()
+	var key *ecdsa.PrivateKey
+	if err == nil {
+		key, err = webPushKey(&state)
+	}
+	webPush.Unlock()
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webPushTimeout}
+	sent, failed := 0, 0
+	for _, sub := range state.Subscriptions {
+		if endpoint != "" && sub.Endpoint != endpoint {
+			continue
+		}
+		sent++
+		status, err := sendWebPushTo(client, key, sub, payload)
+		switch {
+		case status == http.StatusNotFound || status == http.StatusGone:
+			logInfo("Web push subscription of %s expired, removing it", sub.User)
+			if err := removeWebPushSubscription(sub.Endpoint); err != nil {
+				logWarn("Failed to remove the web push subscription: %v", err)
+			}
+		case err != nil:
+			logWarn("Failed to send the web push notification to %s: %v", sub.User, err)
+			failed++
+		}
+	}
+	if endpoint != "" && sent == 0 {
+		return fmt.Errorf("subscription not found")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d notifications failed", failed, sent)
+	}
+	return nil
+}
+
+// sendWebPushTo sends the encrypted payload to the push service of the
+// subscription, and returns the status it answered with.
+func sendWebPushTo(client *http.Client, key *ecdsa.PrivateKey, sub WebPushSubscription, payload []byte) (int, error) {
+	body, err := encryptWebPush(sub, payload)
+	if err != nil {
+		return 0, err
+	}
+	authorization, err := vapidAuthorization(key, sub.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", fmt.Sprint(int(SysConfig.WebPush.TTL.Seconds())))
+	req.Header.Set("Urgency", "high")
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("push service answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// vapidAuthorization returns the Authorization header that identifies the
+// sender to the push service of the endpoint, RFC 8292.
+func vapidAuthorization(key *ecdsa.PrivateKey, endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(webPushJWTLifetime).Unix(),
+		"sub": SysConfig.WebPush.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	public, err := key.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", unsigned, base64.RawURLEncoding.EncodeToString(signature),
+		base64.RawURLEncoding.EncodeToString(public.Bytes())), nil
+}
+
+// encryptWebPush encrypts the payload for the browser of the subscription,
+// RFC 8291, in a single aes128gcm record.
+func encryptWebPush(sub WebPushSubscription, payload []byte) ([]byte, error) {
+	p256dh, err := decodeBase64URL(sub.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %v", err)
+	}
+	auth, err := decodeBase64URL(sub.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth secret: %v", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %v", err)
+	}
+
+	// a new key and salt for every message
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), p256dh...), asPublic...)
+	ikm := hkdf(auth, shared, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 0x02 marks the last record, no padding
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	if len(plaintext)+gcm.Overhead() > webPushRecordSize {
+		return nil, fmt.Errorf("payload too large: %d bytes", len(payload))
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// hkdf derives length bytes, at most 32, from the secret, RFC 5869.
+func hkdf(salt, secret, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:length]
+}
+
+// decodeBase64URL decodes base64url, with or without the padding the
+// browsers differ on.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -1,31 +1,45 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v2"
 )
 
 const (
 	webServerPort     = "8080"
+	webServerTLSPort  = "8443"
 	webServerAddr     = "0.0.0.0"
 	sessionTimeout    = 30 * time.Minute // Session expires after 30 minutes
 	sessionCookieName = "simple_reminder_session"
+	maxSpeakLength    = 500 // Characters of a speech test
+	maxSnoozeMinutes  = 240 // Longest snooze from the web interface
 )
 
+// tokenUserKey is the request context key of the user of an API token.
+type tokenUserKey struct{}
+
 type Session struct {
 	id         string
+	username   string
+	role       string
 	csrfToken  string
 	created    time.Time
 	lastAccess time.Time
@@ -49,8 +63,8 @@ func newSessionManager() *SessionManager {
 	return sm
 }
 
-// createSession creates a new session and returns the session ID
-func (sm *SessionManager) createSession() (string, error) {
+// createSession creates a new session of the user and returns the session ID
+func (sm *SessionManager) createSession(user WebUser) (string, error) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
@@ -70,6 +84,8 @@ func (sm *SessionManager) createSession() (string, error) {
 	csrfToken := base64.URLEncoding.EncodeToString(csrfBytes)
 	sm.sessions[sessionID] = &Session{
 		id:         sessionID,
+		username:   user.Username,
+		role:       user.Role,
 		csrfToken:  csrfToken,
 		created:    time.Now(),
 		lastAccess: time.Now(),
@@ -98,6 +114,28 @@ func (sm *SessionManager) isValidSession(sessionID string) bool {
 	return true
 }
 
+// hasSession reports whether the session is still valid, without counting
+// it as an access.
+func (sm *SessionManager) hasSession(sessionID string) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	session, exists := sm.sessions[sessionID]
+	return exists && time.Since(session.lastAccess) <= sessionTimeout
+}
+
+// sessionUser returns the user name and role of a session
+func (sm *SessionManager) sessionUser(sessionID string) (string, string, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	session, exists := sm.sessions[sessionID]
+	if !exists {
+		return "", "", false
+	}
+	return session.username, session.role, true
+}
+
 // deleteSession removes a session
 func (sm *SessionManager) deleteSession(sessionID string) {
 	sm.mutex.Lock()
@@ -150,6 +188,7 @@ func (sm *SessionManager) cleanupExpiredSessions() {
 // webServer handles the HTTP server for configuration management
 type webServer struct {
 	server         *http.Server
+	tlsServer      *http.Server
 	sessionManager *SessionManager
 	templates      *template.Template
 }
@@ -188,28 +227,140 @@ func (ws *webServer) start() error {
 	// Public endpoints (no authentication required)
 	mux.HandleFunc("/login", addSecurityHeaders(ws.handleLogin))
 	mux.HandleFunc("/logout", addSecurityHeaders(ws.handleLogout))
+	mux.HandleFunc("GET /media/{name}", addSecurityHeaders(ws.handleMedia))
+	mux.HandleFunc("GET /sw.js", addSecurityHeaders(ws.handleServiceWorker))
+	mux.HandleFunc("GET /healthz", addSecurityHeaders(ws.requireHealthAccess(ws.handleHealth)))
 
 	// Protected endpoints (require authentication)
 	mux.HandleFunc("/", addSecurityHeaders(ws.requireAuth(ws.handleIndex)))
+	mux.HandleFunc("GET /events/{id}", addSecurityHeaders(ws.requireAuth(ws.handleEventPage)))
 	mux.HandleFunc("/api/csrf-token", addSecurityHeaders(ws.requireAuth(ws.handleCSRFToken)))
 	mux.HandleFunc("/api/config", addSecurityHeaders(ws.requireAuth(ws.handleConfig)))
-	mux.HandleFunc("/api/config/save", addSecurityHeaders(ws.requireAuth(ws.handleConfigSave)))
-	mux.HandleFunc("/api/secrets", addSecurityHeaders(ws.requireAuth(ws.handleSecrets)))
-	mux.HandleFunc("/api/secrets/save", addSecurityHeaders(ws.requireAuth(ws.handleSecretsSave)))
+	mux.HandleFunc("/api/config/save", addSecurityHeaders(ws.requireAdmin(ws.handleConfigSave)))
+	mux.HandleFunc("POST /api/config/validate", addSecurityHeaders(ws.requireAdmin(ws.handleConfigValidate)))
+	mux.HandleFunc("GET /api/config/versions", addSecurityHeaders(ws.requireAdmin(ws.handleConfigVersions)))
+	mux.HandleFunc("GET /api/config/versions/{id}/diff", addSecurityHeaders(ws.requireAdmin(ws.handleConfigVersionDiff)))
+	mux.HandleFunc("POST /api/config/versions/{id}/rollback", addSecurityHeaders(ws.requireAdmin(ws.handleConfigVersionRollback)))
+	mux.HandleFunc("GET /api/config/fields", addSecurityHeaders(ws.requireAuth(ws.handleConfigFields)))
+	mux.HandleFunc("POST /api/config/fields", addSecurityHeaders(ws.requireAdmin(ws.handleConfigFieldsSave)))
+	mux.HandleFunc("/api/secrets", addSecurityHeaders(ws.requireAdmin(ws.handleSecrets)))
+	mux.HandleFunc("/api/secrets/save", addSecurityHeaders(ws.requireAdmin(ws.handleSecretsSave)))
+	mux.HandleFunc("GET /api/secrets/fields", addSecurityHeaders(ws.requireAdmin(ws.handleSecretFields)))
+	mux.HandleFunc("POST /api/secrets/fields", addSecurityHeaders(ws.requireAdmin(ws.handleSecretFieldsSave)))
 	mux.HandleFunc("/api/logs", addSecurityHeaders(ws.requireAuth(ws.handleLogs)))
-	mux.HandleFunc("/api/logs/clear", addSecurityHeaders(ws.requireAuth(ws.handleLogsClear)))
+	mux.HandleFunc("/api/logs/clear", addSecurityHeaders(ws.requireAdmin(ws.handleLogsClear)))
+	mux.HandleFunc("GET /api/logs/level", addSecurityHeaders(ws.requireAuth(ws.handleLogLevel)))
+	mux.HandleFunc("POST /api/logs/level", addSecurityHeaders(ws.requireAdmin(ws.handleLogLevelSet)))
+	mux.HandleFunc("GET /api/ws", addSecurityHeaders(ws.requireAuth(ws.handleLiveFeed)))
+	mux.HandleFunc("/api/panic", addSecurityHeaders(ws.requireAuth(ws.handlePanic)))
+	mux.HandleFunc("/api/review/answer", addSecurityHeaders(ws.requireAuth(ws.handleReviewAnswer)))
+	mux.HandleFunc("/api/history", addSecurityHeaders(ws.requireAuth(ws.handleHistory)))
+	mux.HandleFunc("GET /api/reminders", addSecurityHeaders(ws.requireAuth(ws.handleReminders)))
+	mux.HandleFunc("POST /api/reminders", addSecurityHeaders(ws.requireAdmin(ws.handleReminderAdd)))
+	mux.HandleFunc("POST /api/reminders/{id}/delete", addSecurityHeaders(ws.requireAdmin(ws.handleReminderDelete)))
+	mux.HandleFunc("GET /api/recap", addSecurityHeaders(ws.requireAuth(ws.handleRecap)))
+	mux.HandleFunc("GET /api/medications/adherence", addSecurityHeaders(ws.requireAuth(ws.handleAdherence)))
+	mux.HandleFunc("GET /api/pause", addSecurityHeaders(ws.requireAuth(ws.handlePause)))
+	mux.HandleFunc("POST /api/pause", addSecurityHeaders(ws.requireAdmin(ws.handlePauseSet)))
+	mux.HandleFunc("POST /api/pause/resume", addSecurityHeaders(ws.requireAdmin(ws.handlePauseResume)))
+	mux.HandleFunc("GET /api/volume", addSecurityHeaders(ws.requireAuth(ws.handleVolume)))
+	mux.HandleFunc("POST /api/volume", addSecurityHeaders(ws.requireAdmin(ws.handleVolumeSet)))
+	mux.HandleFunc("POST /api/volume/reset", addSecurityHeaders(ws.requireAdmin(ws.handleVolumeReset)))
+	mux.HandleFunc("GET /api/wake-up", addSecurityHeaders(ws.requireAuth(ws.handleWakeUp)))
+	mux.HandleFunc("POST /api/wake-up/stop", addSecurityHeaders(ws.requireAdmin(ws.handleWakeUpStop)))
+	mux.HandleFunc("POST /api/wake-up/snooze", addSecurityHeaders(ws.requireAdmin(ws.handleWakeUpSnooze)))
+	mux.HandleFunc("GET /api/sinks", addSecurityHeaders(ws.requireAuth(ws.handleSinks)))
+	mux.HandleFunc("POST /api/sinks/{name}", addSecurityHeaders(ws.requireAdmin(ws.handleSinkSet)))
+	mux.HandleFunc("GET /api/audio/devices", addSecurityHeaders(ws.requireAuth(ws.handleAudioDevices)))
+	mux.HandleFunc("POST /api/speak", addSecurityHeaders(ws.requireAdmin(ws.handleSpeak)))
+	mux.HandleFunc("GET /api/tts/models", addSecurityHeaders(ws.requireAuth(ws.handleTtsModels)))
+	mux.HandleFunc("POST /api/tts/models/{name}/install", addSecurityHeaders(ws.requireAdmin(ws.handleTtsModelInstall)))
+	mux.HandleFunc("POST /api/tts/models/{name}/activate", addSecurityHeaders(ws.requireAdmin(ws.handleTtsModelActivate)))
+	mux.HandleFunc("GET /api/backup", addSecurityHeaders(ws.requireAdmin(ws.handleBackup)))
+	mux.HandleFunc("POST /api/restore", addSecurityHeaders(ws.requireAdmin(ws.handleRestore)))
+	mux.HandleFunc("POST /api/restart", addSecurityHeaders(ws.requireAdmin(ws.handleRestart)))
+	mux.HandleFunc("POST /api/shutdown", addSecurityHeaders(ws.requireAdmin(ws.handleShutdown)))
+	mux.HandleFunc("GET /api/events", addSecurityHeaders(ws.requireAuth(ws.handleEvents)))
+	mux.HandleFunc("GET /api/events/{id}", addSecurityHeaders(ws.requireAuth(ws.handleEventDetail)))
+	mux.HandleFunc("POST /api/events/{id}/acknowledge", addSecurityHeaders(ws.requireAdmin(ws.handleEventAcknowledge)))
+	mux.HandleFunc("POST /api/events/{id}/complete", addSecurityHeaders(ws.requireAdmin(ws.handleEventComplete)))
+	mux.HandleFunc("POST /api/events/{id}/snooze", addSecurityHeaders(ws.requireAdmin(ws.handleEventSnooze)))
+	mux.HandleFunc("POST /api/events/{id}/announce", addSecurityHeaders(ws.requireAdmin(ws.handleEventAnnounce)))
+	mux.HandleFunc("GET /api/tokens", addSecurityHeaders(ws.requireAdmin(ws.handleTokens)))
+	mux.HandleFunc("POST /api/tokens", addSecurityHeaders(ws.requireAdmin(ws.handleTokenCreate)))
+	mux.HandleFunc("POST /api/tokens/{id}/revoke", addSecurityHeaders(ws.requireAdmin(ws.handleTokenRevoke)))
+	mux.HandleFunc("GET /api/push/key", addSecurityHeaders(ws.requireAuth(ws.handlePushKey)))
+	mux.HandleFunc("POST /api/push/subscribe", addSecurityHeaders(ws.requireAuth(ws.handlePushSubscribe)))
+	mux.HandleFunc("POST /api/push/unsubscribe", addSecurityHeaders(ws.requireAuth(ws.handlePushUnsubscribe)))
+	mux.HandleFunc("POST /api/push/test", addSecurityHeaders(ws.requireAuth(ws.handlePushTest)))
+
+	// behind a reverse proxy, possibly under a path prefix
+	handler := proxyHandler(mux)
 
 	ws.server = &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", webServerAddr, webServerPort),
-		Handler: mux,
+		Handler: handler,
+	}
+	if !SysConfig.WebServer.TLS.Enabled {
+		logInfo("Starting web server on http://%s:%s", webServerAddr, webServerPort)
+		return ws.server.ListenAndServe()
 	}
 
-	logInfo("Starting web server on http://%s:%s", webServerAddr, webServerPort)
-	return ws.server.ListenAndServe()
+	tlsConfig, err := webTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	// the plain port is kept for the network speakers, which fetch the media
+	// without checking certificates, the browsers are sent to the secure one
+	ws.server.Handler = proxyHandler(redirectToTLS(mux))
+	ws.tlsServer = &http.Server{
+		Addr:      fmt.Sprintf("%s:%s", webServerAddr, webServerTLSPort),
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	go func() {
+		logInfo("Starting web server on http://%s:%s", webServerAddr, webServerPort)
+		if err := ws.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logError("Web server error: %v", err)
+		}
+	}()
+
+	logInfo("Starting web server on https://%s:%s", webServerAddr, webServerTLSPort)
+	return ws.tlsServer.ListenAndServeTLS("", "")
+}
+
+// redirectToTLS sends every request but the media to the secure port, unless
+// a proxy in front already took it over https.
+func redirectToTLS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/media/") || secureRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		target := "https://" + net.JoinHostPort(host, webServerTLSPort) + basePath(r) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
+
+// webInterfaceURL returns where the web interface is served.
+func webInterfaceURL() string {
+	if SysConfig.WebServer.TLS.Enabled {
+		return fmt.Sprintf("https://%s:%s", webServerAddr, webServerTLSPort)
+	}
+	return fmt.Sprintf("http://%s:%s", webServerAddr, webServerPort)
 }
 
 // Stop gracefully stops the web server
 func (ws *webServer) Stop() error {
+	if ws.tlsServer != nil {
+		ws.tlsServer.Close()
+	}
 	if ws.server != nil {
 		return ws.server.Close()
 	}
@@ -231,16 +382,29 @@ func addSecurityHeaders(next http.HandlerFunc) http.HandlerFunc {
 // requireAuth is middleware that requires authentication
 func (ws *webServer) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// scripts can call the API with a token instead of logging in
+		if value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && (strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/healthz") {
+			token, valid := authenticateAPIToken(strings.TrimSpace(value))
+			if !valid {
+				logWarn("Invalid API token from %s", r.RemoteAddr)
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			user := WebUser{Username: "token " + token.Name, Role: token.Role}
+			next(w, r.WithContext(context.WithValue(r.Context(), tokenUserKey{}, user)))
+			return
+		}
+
 		cookie, err := r.Cookie(sessionCookieName)
 		if err != nil {
 			logDebug("No session cookie found for %s: %v", r.RemoteAddr, err)
-			http.Redirect(w, r, "/login", http.StatusFound)
+			http.Redirect(w, r, basePath(r)+"/login", http.StatusFound)
 			return
 		}
 
 		if !ws.sessionManager.isValidSession(cookie.Value) {
 			logDebug("Invalid session %s for %s", cookie.Value, r.RemoteAddr)
-			http.Redirect(w, r, "/login", http.StatusFound)
+			http.Redirect(w, r, basePath(r)+"/login", http.StatusFound)
 			return
 		}
 
@@ -248,27 +412,74 @@ func (ws *webServer) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// requireAdmin is middleware that requires an authenticated admin
+func (ws *webServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return ws.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if username, role := ws.currentUser(r); role != roleAdmin {
+			logWarn("User %s is not allowed to %s %s", username, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// currentUser returns the user name and role of the request's session
+func (ws *webServer) currentUser(r *http.Request) (string, string) {
+	if user, ok := r.Context().Value(tokenUserKey{}).(WebUser); ok {
+		return user.Username, user.Role
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", ""
+	}
+	username, role, _ := ws.sessionManager.sessionUser(cookie.Value)
+	return username, role
+}
+
+// checkCSRF validates the CSRF token of a state-changing request, it writes
+// the error response and returns false if the token is missing or invalid.
+func (ws *webServer) checkCSRF(w http.ResponseWriter, r *http.Request) bool {
+	// tokens are not sent by the browsers on their own
+	if _, ok := r.Context().Value(tokenUserKey{}).(WebUser); ok {
+		return true
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return false
+	}
+
+	csrfToken := r.Header.Get("X-CSRF-Token")
+	if csrfToken == "" {
+		csrfToken = r.FormValue("csrf_token")
+	}
+	if !ws.sessionManager.validateCSRFToken(cookie.Value, csrfToken) {
+		logError("CSRF token validation failed for session %s", cookie.Value)
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
 // handleLogin handles the login page and authentication
 func (ws *webServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
+		username := strings.TrimSpace(r.FormValue("username"))
 		password := r.FormValue("password")
 
-		// Get expected password from config or environment
-		expectedPassword := os.Getenv("WEB_SERVER_PASSWORD")
-		if expectedPassword == "" {
-			expectedPassword = SysSecrets.WebServerPassword
-		}
-
-		if expectedPassword == "" {
-			logError("No web server password configured")
-			genericError(w, "Server configuration error", errors.New("no web server password configured"), http.StatusInternalServerError)
+		if len(webUsers()) == 0 {
+			logError("No web server users or password configured")
+			genericError(w, "Server configuration error", errors.New("no web server users or password configured"), http.StatusInternalServerError)
 			return
 		}
 
-		err := bcrypt.CompareHashAndPassword([]byte(expectedPassword), []byte(password))
-		if err == nil {
+		user, ok := authenticateUser(username, password)
+		if ok {
 			// Password is correct, create session
-			sessionID, err := ws.sessionManager.createSession()
+			sessionID, err := ws.sessionManager.createSession(user)
 			if err != nil {
 				logError("Failed to create session: %v", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -279,22 +490,24 @@ func (ws *webServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 			cookie := &http.Cookie{
 				Name:     sessionCookieName,
 				Value:    sessionID,
-				Path:     "/",
+				Path:     basePath(r) + "/",
 				MaxAge:   int(sessionTimeout.Seconds()),
-				HttpOnly: true,  // do not allow access to cookie from javascript
-				Secure:   false, // TODO: fix this later use https
+				HttpOnly: true,             // do not allow access to cookie from javascript
+				Secure:   secureRequest(r), // only sent back over https once it is used
 				SameSite: http.SameSiteStrictMode,
 			}
-			logInfo("User %s logged in", r.RemoteAddr)
+			logInfo("User %s logged in as %s from %s", user.Username, user.Role, r.RemoteAddr)
 			http.SetCookie(w, cookie)
-			http.Redirect(w, r, "/", http.StatusFound)
+			http.Redirect(w, r, basePath(r)+"/", http.StatusFound)
 			return
 		} else {
-			logError("Failed login attempt from %s", r.RemoteAddr)
+			logError("Failed login attempt as %q from %s", username, r.RemoteAddr)
 			data := struct {
 				ErrorMessage string
+				BasePath     string
 			}{
-				ErrorMessage: "Invalid password. Please try again.",
+				ErrorMessage: "Invalid username or password. Please try again.",
+				BasePath:     basePath(r),
 			}
 			w.WriteHeader(http.StatusUnauthorized)
 			err := ws.templates.ExecuteTemplate(w, "login.html", data)
@@ -309,8 +522,10 @@ func (ws *webServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 	// Show login form
 	data := struct {
 		ErrorMessage string
+		BasePath     string
 	}{
 		ErrorMessage: "",
+		BasePath:     basePath(r),
 	}
 	err := ws.templates.ExecuteTemplate(w, "login.html", data)
 	if err != nil {
@@ -321,6 +536,7 @@ func (ws *webServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 // handleLogout handles user logout
 func (ws *webServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	username, _ := ws.currentUser(r)
 	cookie, err := r.Cookie(sessionCookieName)
 	if err == nil {
 		ws.sessionManager.deleteSession(cookie.Value)
@@ -330,14 +546,15 @@ func (ws *webServer) handleLogout(w http.ResponseWriter, r *http.Request) {
 	clearCookie := &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    "",
-		Path:     "/",
+		Path:     basePath(r) + "/",
 		MaxAge:   -1,
 		HttpOnly: true,
+		Secure:   secureRequest(r),
 	}
 
-	logInfo("User logged out from %s", r.RemoteAddr)
+	logInfo("User %s logged out from %s", username, r.RemoteAddr)
 	http.SetCookie(w, clearCookie)
-	http.Redirect(w, r, "/login", http.StatusFound)
+	http.Redirect(w, r, basePath(r)+"/login", http.StatusFound)
 }
 
 // handleCSRFToken returns the CSRF token for the current session
@@ -360,7 +577,17 @@ func (ws *webServer) handleCSRFToken(w http.ResponseWriter, r *http.Request) {
 
 // handleIndex serves the main configuration page
 func (ws *webServer) handleIndex(w http.ResponseWriter, r *http.Request) {
-	err := ws.templates.ExecuteTemplate(w, "index.html", nil)
+	username, role := ws.currentUser(r)
+	data := struct {
+		Username string
+		Role     string
+		BasePath string
+	}{
+		Username: username,
+		Role:     role,
+		BasePath: basePath(r),
+	}
+	err := ws.templates.ExecuteTemplate(w, "index.html", data)
 	if err != nil {
 		logError("Template execution error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -388,21 +615,7 @@ func (ws *webServer) handleConfigSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate CSRF token
-	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
-	}
-
-	csrfToken := r.Header.Get("X-CSRF-Token")
-	if csrfToken == "" {
-		csrfToken = r.FormValue("csrf_token")
-	}
-
-	if !ws.sessionManager.validateCSRFToken(cookie.Value, csrfToken) {
-		logError("CSRF token validation failed for session %s", cookie.Value)
-		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+	if !ws.checkCSRF(w, r) {
 		return
 	}
 
@@ -419,30 +632,243 @@ func (ws *webServer) handleConfigSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !ws.saveConfig(w, r, configData, "") {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Configuration saved successfully"))
+}
+
+// saveConfig validates, saves and reloads the configuration, keeping the
+// version with the note. It writes the error response and returns false if
+// it can't.
+func (ws *webServer) saveConfig(w http.ResponseWriter, r *http.Request, configData []byte, note string) bool {
 	// Validate YAML before saving
 	var tempConfig Config
 	if err := yaml.Unmarshal(configData, &tempConfig); err != nil {
 		logError("Invalid YAML in config save: %v", err)
 		http.Error(w, "Invalid YAML format", http.StatusBadRequest)
-		return
+		return false
+	}
+
+	// refuse what would break the running service, the problems it already
+	// has are left for later
+	currentData, err := os.ReadFile(realPath(defaultConfig))
+	if err != nil && !os.IsNotExist(err) {
+		genericError(w, "Failed to read config file", err, http.StatusInternalServerError)
+		return false
+	}
+	problems := newConfigErrors(validateConfigYAML(configData, false), validateConfigYAML(currentData, false))
+	if len(problems) > 0 {
+		errs := map[string]string{}
+		for _, p := range problems {
+			if errs[p.Path] != "" {
+				errs[p.Path] += "; "
+			}
+			errs[p.Path] += p.Message
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+		return false
 	}
 
-	if err := writeFileAtomically(realPath(defaultConfig), configData); err != nil {
+	if err := writeFileAtomically(realPath(defaultConfig), configData, 0600); err != nil {
 		logError("Failed to save config file: %v", err)
 		http.Error(w, "Failed to save config file", http.StatusInternalServerError)
-		return
+		return false
+	}
+
+	username, _ := ws.currentUser(r)
+	logInfo("Configuration saved by %s", username)
+	if err := recordConfigVersion(versionConfig, currentData, configData, username, note); err != nil {
+		logError("Failed to keep the configuration version: %v", err)
 	}
 
 	// Reload configuration in memory
 	if err := loadConfig(); err != nil {
 		logError("Failed to reload configuration after web save: %v", err)
 	}
+	return true
+}
+
+// handleConfigValidate checks the configuration in the request body without
+// saving it, the calendar servers of the secrets included
+func (ws *webServer) handleConfigValidate(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	configData, err := io.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		genericError(w, "Failed to read request body", err, http.StatusBadRequest)
+		return
+	}
+
+	problems := validateConfigYAML(configData, r.URL.Query().Get("network") != "false")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"problems": problems})
+}
+
+// handleConfigVersions lists the saved versions of the config or the
+// secrets file, the newest first
+func (ws *webServer) handleConfigVersions(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file != versionSecrets {
+		file = versionConfig
+	}
+
+	versions, err := loadConfigVersions(file)
+	if err != nil {
+		genericError(w, "Failed to load config versions", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// handleConfigVersionDiff shows what a version changed from the one before
+// it, or with ?against=current what rolling back to it would change
+func (ws *webServer) handleConfigVersionDiff(w http.ResponseWriter, r *http.Request) {
+	version, data, before, err := configVersion(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	from, to := before, data
+	if r.URL.Query().Get("against") == "current" {
+		path := realPath(defaultConfig)
+		if version.File == versionSecrets {
+			path = realPath(defaultSecrets)
+		}
+		if from, err = os.ReadFile(path); err != nil {
+			genericError(w, "Failed to read the current file", err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// the secrets are never shown, only whether they changed
+	a, b := string(from), string(to)
+	if version.File == versionSecrets {
+		a, b = maskSecrets(a), maskSecretsChanged(b, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version": version,
+		"diff":    diffLines(a, b),
+	})
+}
+
+// handleConfigVersionRollback saves a version again, as a new version
+func (ws *webServer) handleConfigVersionRollback(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	version, data, _, err := configVersion(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	note := "Rolled back to the version of " + version.Time.Format("2006-01-02 15:04:05")
+	if version.File == versionSecrets {
+		if !ws.saveSecrets(w, r, data, note) {
+			return
+		}
+	} else if !ws.saveConfig(w, r, data, note) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Rolled back"))
+}
+
+// handleConfigFields serves the options of the config form
+func (ws *webServer) handleConfigFields(w http.ResponseWriter, r *http.Request) {
+	configData, err := os.ReadFile(realPath(defaultConfig))
+	if err != nil {
+		genericError(w, "Failed to read config file", err, http.StatusInternalServerError)
+		return
+	}
+
+	// what is in the file, as it is used
+	cfg := newConfig()
+	if err := yaml.Unmarshal(configData, &cfg); err != nil {
+		http.Error(w, "The configuration is not valid YAML, fix it in the YAML editor", http.StatusConflict)
+		return
+	}
+	applyConfigDefaults(&cfg)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configFields(cfg, string(configData)))
+}
+
+// handleConfigFieldsSave saves the options changed in the config form, the
+// rest of the file and its comments are kept as they are
+func (ws *webServer) handleConfigFieldsSave(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	changes := map[string]string{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1024*1024)).Decode(&changes); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	configData, err := os.ReadFile(realPath(defaultConfig))
+	if err != nil {
+		genericError(w, "Failed to read config file", err, http.StatusInternalServerError)
+		return
+	}
+	text := string(configData)
+
+	fields := map[string]ConfigField{}
+	for _, field := range configFields(Config{}, text) {
+		fields[field.Path] = field
+	}
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	errs := map[string]string{}
+	for _, path := range paths {
+		field, ok := fields[path]
+		if !ok {
+			errs[path] = "unknown option"
+			continue
+		}
+		value, err := configFieldYAML(field, changes[path])
+		if err != nil {
+			errs[path] = err.Error()
+			continue
+		}
+		text = setYAMLValue(text, path, value)
+	}
+	if len(errs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+		return
+	}
+
+	if !ws.saveConfig(w, r, []byte(text), "") {
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Configuration saved successfully"))
 }
 
-// handleSecrets serves the current secrets configuration as YAML
+// handleSecrets serves the current secrets configuration as YAML, with the
+// passwords, tokens and keys masked
 func (ws *webServer) handleSecrets(w http.ResponseWriter, r *http.Request) {
 	secretsPath := realPath(defaultSecrets)
 	secretsData, err := os.ReadFile(secretsPath)
@@ -453,30 +879,18 @@ func (ws *webServer) handleSecrets(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Write(secretsData)
+	w.Write([]byte(maskSecrets(string(secretsData))))
 }
 
-// handleSecretsSave saves the updated secrets configuration
+// handleSecretsSave saves the updated secrets configuration, the masked
+// values are kept as they are in the file
 func (ws *webServer) handleSecretsSave(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Validate CSRF token
-	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
-	}
-
-	csrfToken := r.Header.Get("X-CSRF-Token")
-	if csrfToken == "" {
-		csrfToken = r.FormValue("csrf_token")
-	}
-	if !ws.sessionManager.validateCSRFToken(cookie.Value, csrfToken) {
-		logError("CSRF token validation failed for session %s", cookie.Value)
-		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+	if !ws.checkCSRF(w, r) {
 		return
 	}
 
@@ -493,81 +907,271 @@ func (ws *webServer) handleSecretsSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate YAML before saving
-	var tempSecrets Secrets
-	if err := yaml.Unmarshal(secretsData, &tempSecrets); err != nil {
-		logError("Invalid YAML in secrets save: %v", err)
-		http.Error(w, "Invalid YAML format", http.StatusBadRequest)
+	currentData, err := os.ReadFile(realPath(defaultSecrets))
+	if err != nil && !os.IsNotExist(err) {
+		genericError(w, "Failed to read secrets file", err, http.StatusInternalServerError)
 		return
 	}
-
-	if err := writeFileAtomically(realPath(defaultSecrets), secretsData); err != nil {
-		logError("Failed to save config file: %v", err)
-		http.Error(w, "Failed to save config file", http.StatusInternalServerError)
+	text, err := unmaskSecrets(string(secretsData), string(currentData))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Reload configuration in memory
-	if err := loadConfig(); err != nil {
-		logError("Failed to reload configuration after web save: %v", err)
+	if !ws.saveSecrets(w, r, []byte(text), "") {
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Secrets saved successfully"))
 }
 
-// handleLogs serves the current logs
-func (ws *webServer) handleLogs(w http.ResponseWriter, r *http.Request) {
-	logFilePath := realPath(logPath)
+// saveSecrets validates, saves and reloads the secrets, keeping the version
+// with the note. It writes the error response and returns false if it can't.
+func (ws *webServer) saveSecrets(w http.ResponseWriter, r *http.Request, secretsData []byte, note string) bool {
+	// Validate YAML before saving
+	var tempSecrets Secrets
+	if err := yaml.Unmarshal(secretsData, &tempSecrets); err != nil {
+		logError("Invalid YAML in secrets save: %v", err)
+		http.Error(w, "Invalid YAML format", http.StatusBadRequest)
+		return false
+	}
 
-	// Read current log file
-	currentLogs := ""
-	if data, err := os.ReadFile(logFilePath); err == nil {
-		currentLogs = string(data)
+	currentData, err := os.ReadFile(realPath(defaultSecrets))
+	if err != nil && !os.IsNotExist(err) {
+		genericError(w, "Failed to read secrets file", err, http.StatusInternalServerError)
+		return false
 	}
 
-	// Also try to read the old rotated log file if it exists
-	oldLogPath := logFilePath + ".old"
-	if data, err := os.ReadFile(oldLogPath); err == nil {
-		// Prepend old logs with a separator
-		if currentLogs != "" {
-			currentLogs = string(data) + "\n--- LOG ROTATION ---\n" + currentLogs
-		} else {
-			currentLogs = string(data)
-		}
+	if err := writeFileAtomically(realPath(defaultSecrets), secretsData, 0600); err != nil {
+		logError("Failed to save secrets file: %v", err)
+		http.Error(w, "Failed to save secrets file", http.StatusInternalServerError)
+		return false
 	}
 
-	// If no logs found, show a message
-	if currentLogs == "" {
-		currentLogs = "No logs found or logs are empty."
+	username, _ := ws.currentUser(r)
+	logInfo("Secrets saved by %s", username)
+	if err := recordConfigVersion(versionSecrets, currentData, secretsData, username, note); err != nil {
+		logError("Failed to keep the secrets version: %v", err)
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Write([]byte(currentLogs))
+	// Reload configuration in memory
+	if err := loadConfig(); err != nil {
+		logError("Failed to reload configuration after web save: %v", err)
+	}
+	return true
 }
 
-// handleLogsClear clears the application logs
-func (ws *webServer) handleLogsClear(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleSecretFields serves the values of the secrets form, the secret ones
+// only say whether they are set
+func (ws *webServer) handleSecretFields(w http.ResponseWriter, r *http.Request) {
+	secretsData, err := os.ReadFile(realPath(defaultSecrets))
+	if err != nil {
+		genericError(w, "Failed to read secrets file", err, http.StatusInternalServerError)
 		return
 	}
 
-	// Validate CSRF token
-	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secretFields(string(secretsData)))
+}
+
+// handleSecretFieldsSave saves the values changed in the secrets form, the
+// others are left as they are in the file
+func (ws *webServer) handleSecretFieldsSave(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
 		return
 	}
 
-	csrfToken := r.Header.Get("X-CSRF-Token")
-	if csrfToken == "" {
-		csrfToken = r.FormValue("csrf_token")
+	changes := map[string]string{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1024*1024)).Decode(&changes); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
 	}
-	if !ws.sessionManager.validateCSRFToken(cookie.Value, csrfToken) {
-		logError("CSRF token validation failed for session %s", cookie.Value)
-		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+
+	secretsData, err := os.ReadFile(realPath(defaultSecrets))
+	if err != nil {
+		genericError(w, "Failed to read secrets file", err, http.StatusInternalServerError)
+		return
+	}
+	text := string(secretsData)
+
+	fields := map[string]SecretField{}
+	for _, field := range secretFields(text) {
+		fields[field.Path] = field
+	}
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	errs := map[string]string{}
+	for _, path := range paths {
+		field, ok := fields[path]
+		if !ok {
+			errs[path] = "unknown value, add it in the YAML editor"
+			continue
+		}
+		value, err := secretFieldYAML(field, changes[path])
+		if err != nil {
+			errs[path] = err.Error()
+			continue
+		}
+		text = setYAMLValue(text, path, value)
+	}
+	if len(errs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+		return
+	}
+
+	if !ws.saveSecrets(w, r, []byte(text), "") {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Secrets saved successfully"))
+}
+
+// handleLogs serves the last lines of the logs that match the query, a page
+// at a time, e.g. ?tail=200&level=warning&search=caldav&before=<cursor>
+func (ws *webServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	query, err := parseLogQuery(r.URL.Query().Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := queryLogs(query)
+	if err != nil {
+		genericError(w, "Failed to read logs", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleLogLevel returns the current log level and the ones it can be set to
+func (ws *webServer) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"level":  currentLogLevel(),
+		"levels": logLevelNames,
+	})
+}
+
+// handleLogLevelSet changes the log level right away, and saves it as the
+// log_level of the config so it stays after a restart
+func (ws *webServer) handleLogLevelSet(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	level, err := configLogLevel(Config{LogLevel: strings.TrimSpace(r.FormValue("level"))})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	configData, err := os.ReadFile(realPath(defaultConfig))
+	if err != nil {
+		genericError(w, "Failed to read config file", err, http.StatusInternalServerError)
+		return
+	}
+	text := setYAMLValue(string(configData), "log_level", strconv.Quote(level.String()))
+	if !ws.saveConfig(w, r, []byte(text), "Log level set to "+level.String()) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"level":  currentLogLevel(),
+		"levels": logLevelNames,
+	})
+}
+
+// handleLiveFeed pushes the new log lines and announcements to the browser
+// over a websocket, as they happen.
+func (ws *webServer) handleLiveFeed(w http.ResponseWriter, r *http.Request) {
+	if username, _ := ws.currentUser(r); username == "" {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+	// the session cookie is sent by any site opening the websocket, an API
+	// token has to be set by the client itself
+	sessionID := ""
+	if _, isToken := r.Context().Value(tokenUserKey{}).(WebUser); !isToken {
+		if origin, err := url.Parse(r.Header.Get("Origin")); err != nil || origin.Host != r.Host {
+			http.Error(w, "Cross-origin request denied", http.StatusForbidden)
+			return
+		}
+		cookie, err := r.Cookie(sessionCookieName)
+		if err != nil {
+			http.Error(w, "Invalid session", http.StatusUnauthorized)
+			return
+		}
+		sessionID = cookie.Value
+	}
+
+	conn, err := acceptWebSocket(w, r)
+	if err != nil {
+		logDebug("Failed to open the live feed for %s: %v", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	feed := subscribeLive()
+	defer unsubscribeLive(feed)
+
+	// nothing is expected from the browser, reading only answers the pings
+	// and notices it leaving
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-keepAlive.C:
+			// a session can end with a logout or a timeout, a token is only
+			// checked when connecting
+			if sessionID != "" && !ws.sessionManager.hasSession(sessionID) {
+				return
+			}
+			if err := conn.WriteMessage(wsOpPing, nil); err != nil {
+				return
+			}
+		case msg := <-feed:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			if err := conn.WriteMessage(wsOpText, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleLogsClear clears the application logs
+func (ws *webServer) handleLogsClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !ws.checkCSRF(w, r) {
 		return
 	}
 
@@ -594,6 +1198,906 @@ func (ws *webServer) handleLogsClear(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Logs cleared successfully"))
 }
 
+// handlePanic triggers the emergency announcement
+func (ws *webServer) handlePanic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	logWarn("Panic triggered from web interface by %s", r.RemoteAddr)
+	go triggerPanic("web interface " + r.RemoteAddr)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Emergency announcement triggered"))
+}
+
+// handleRestart restarts the application, e.g. to load a new model
+func (ws *webServer) handleRestart(w http.ResponseWriter, r *http.Request) {
+	ws.stopFromWeb(w, r, true, "Restarting")
+}
+
+// handleShutdown stops the application, the service manager doesn't
+// start it again
+func (ws *webServer) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	ws.stopFromWeb(w, r, false, "Shutting down")
+}
+
+// stopFromWeb answers the request before stopping, once the answer is on
+// its way
+func (ws *webServer) stopFromWeb(w http.ResponseWriter, r *http.Request, restart bool, message string) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(message))
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	go stopApplication("a request from "+r.RemoteAddr, restart)
+}
+
+// handleReviewAnswer answers the question asked by the end-of-day review
+func (ws *webServer) handleReviewAnswer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	if err := answerReview(r.FormValue("answer")); err != nil {
+		logWarn("Review answer from %s rejected: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Answer recorded"))
+}
+
+// handleRecap returns how today went so far
+func (ws *webServer) handleRecap(w http.ResponseWriter, r *http.Request) {
+	recap, err := buildRecap()
+	if err != nil {
+		logError("Failed to build recap: %v", err)
+		http.Error(w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recap)
+}
+
+// handleAdherence returns how the doses of the medications were taken over
+// the last days, 30 unless given
+func (ws *webServer) handleAdherence(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAdherenceDays {
+			http.Error(w, fmt.Sprintf("Invalid days, expected 1 to %d", maxAdherenceDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	report, err := adherenceReport(startOfDay(time.Now()).AddDate(0, 0, 1-days))
+	if err != nil {
+		logError("Failed to load the adherence history: %v", err)
+		http.Error(w, "Failed to load the adherence history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// pauseResponse is the pause state as returned by the API
+type pauseResponse struct {
+	Paused bool      `json:"paused"`
+	Until  time.Time `json:"until"`
+	Since  time.Time `json:"since"`
+}
+
+// handlePause returns whether the announcements are paused, and until when
+func (ws *webServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	state := getPause()
+	resp := pauseResponse{}
+	if time.Now().Before(state.Until) {
+		resp = pauseResponse{Paused: true, Until: state.Until, Since: state.Since}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handlePauseSet pauses the announcements until a given time, or for a number of minutes
+func (ws *webServer) handlePauseSet(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	var until time.Time
+	if value := r.FormValue("until"); value != "" {
+		var err error
+		if until, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local); err != nil {
+			http.Error(w, "Invalid pause end, expected YYYY-MM-DDTHH:MM", http.StatusBadRequest)
+			return
+		}
+	} else if value := r.FormValue("minutes"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes <= 0 {
+			http.Error(w, "Invalid pause duration, expected minutes", http.StatusBadRequest)
+			return
+		}
+		until = time.Now().Add(time.Duration(minutes) * time.Minute)
+	} else {
+		http.Error(w, "Either until or minutes is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := setPause(until); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logInfo("Announcements paused by %s", r.RemoteAddr)
+
+	ws.handlePause(w, r)
+}
+
+// handlePauseResume ends the pause of the announcements
+func (ws *webServer) handlePauseResume(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	if err := setPause(time.Time{}); err != nil {
+		logError("Failed to resume announcements: %v", err)
+		http.Error(w, "Failed to resume announcements", http.StatusInternalServerError)
+		return
+	}
+	logInfo("Announcements resumed by %s", r.RemoteAddr)
+
+	ws.handlePause(w, r)
+}
+
+// handleWakeUp returns when the wake-up alarm rings next, and whether it is ringing
+func (ws *webServer) handleWakeUp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wakeUpState())
+}
+
+// handleWakeUpStop stops the wake-up alarm until its next time
+func (ws *webServer) handleWakeUpStop(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	stopWakeUp()
+	ws.handleWakeUp(w, r)
+}
+
+// handleWakeUpSnooze rings the wake-up alarm again after wake_up.snooze
+func (ws *webServer) handleWakeUpSnooze(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	if !wakeUpRinging() {
+		http.Error(w, "The wake-up alarm is not ringing", http.StatusConflict)
+		return
+	}
+	snoozeWakeUp(SysConfig.WakeUp.Snooze)
+	ws.handleWakeUp(w, r)
+}
+
+// volumeResponse is the volume as returned by the API
+type volumeResponse struct {
+	Level      int  `json:"level"`      // Current volume in percent
+	Configured int  `json:"configured"` // Volume from the configuration at this time of day
+	Override   bool `json:"override"`   // The volume was set at runtime
+}
+
+// handleVolume returns the current volume
+func (ws *webServer) handleVolume(w http.ResponseWriter, r *http.Request) {
+	resp := volumeResponse{
+		Level:      currentVolume(),
+		Configured: configuredVolume(),
+		Override:   volumeOverridden(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleVolumeSet sets the volume until it is reset or the device restarts
+func (ws *webServer) handleVolumeSet(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	level, err := strconv.Atoi(r.FormValue("level"))
+	if err != nil || level < 0 || level > 100 {
+		http.Error(w, "Invalid volume, expected 0 to 100", http.StatusBadRequest)
+		return
+	}
+	setVolume(level)
+	logInfo("Volume set to %d%% by %s", level, r.RemoteAddr)
+
+	ws.handleVolume(w, r)
+}
+
+// handleVolumeReset goes back to the configured volume
+func (ws *webServer) handleVolumeReset(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	setVolume(-1)
+	logInfo("Volume reset by %s", r.RemoteAddr)
+
+	ws.handleVolume(w, r)
+}
+
+// handleSinks returns the audio sinks with their state
+func (ws *webServer) handleSinks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listSinks())
+}
+
+// handleSinkSet switches an audio sink on or off until the device restarts
+func (ws *webServer) handleSinkSet(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+	if err != nil {
+		http.Error(w, "Invalid state, expected true or false", http.StatusBadRequest)
+		return
+	}
+	name := r.PathValue("name")
+	if err := setSinkEnabled(name, enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	logInfo("Audio sink %s switched %s by %s", name, state, r.RemoteAddr)
+
+	ws.handleSinks(w, r)
+}
+
+// handleAudioDevices lists the audio output devices, for audio_device
+func (ws *webServer) handleAudioDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := listAudioDevices()
+	if err != nil {
+		logError("Failed to list audio devices: %v", err)
+		http.Error(w, "Failed to list audio devices", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
+}
+
+// handleSpeak speaks a text with the voice, chime and volume of an
+// announcement kind, to try the speech settings
+func (ws *webServer) handleSpeak(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	text := strings.TrimSpace(r.FormValue("text"))
+	if text == "" || len(text) > maxSpeakLength {
+		http.Error(w, fmt.Sprintf("Invalid text, expected 1 to %d characters", maxSpeakLength), http.StatusBadRequest)
+		return
+	}
+	kind := r.FormValue("kind")
+
+	logInfo("Speech test requested by %s", r.RemoteAddr)
+	// the request waits for the speech, so generation errors are reported
+	if err := aiSpeakAs(kind, nil, text); err != nil {
+		logError("Speech test failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Spoken"))
+}
+
+// handleTtsModels lists the voices of the manifest and the configured ones
+func (ws *webServer) handleTtsModels(w http.ResponseWriter, r *http.Request) {
+	models, err := listModels()
+	if err != nil {
+		logError("Failed to list TTS models: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models)
+}
+
+// handleTtsModelInstall starts downloading a voice of the manifest
+func (ws *webServer) handleTtsModelInstall(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := installModel(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logInfo("Download of the %s TTS model started by %s", name, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Download started"))
+}
+
+// handleTtsModelActivate switches the default voice to another model
+func (ws *webServer) handleTtsModelActivate(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := activateModel(name); err != nil {
+		logError("Failed to switch the TTS model to %s: %v", name, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logInfo("TTS model switched to %s by %s", name, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Model switched"))
+}
+
+// handleBackup streams a backup of the configuration, secrets and event state
+func (ws *webServer) handleBackup(w http.ResponseWriter, r *http.Request) {
+	logInfo("Backup downloaded by %s", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", backupFileName()))
+	if err := writeBackup(w); err != nil {
+		// the headers are gone already, all we can do is cut the download short
+		logError("Failed to write backup: %v", err)
+	}
+}
+
+// handleRestore validates and applies a backup
+func (ws *webServer) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRestoreBytes)
+	if err := restoreBackup(r.Body); err != nil {
+		logError("Failed to restore backup from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Failed to restore backup: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	logInfo("Backup restored by %s", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Backup restored"))
+}
+
+// EventStatus is an event of today with what is planned for it, as returned by the API
+type EventStatus struct {
+	LocalEvent
+	Mode             string     `json:"mode"`                        // How the event is announced
+	NextAnnouncement *time.Time `json:"next_announcement,omitempty"` // When something is next announced about it
+}
+
+// handleEvents returns today's events with their announcement state
+func (ws *webServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := loadAllTodayEvents()
+	if err != nil {
+		logError("Failed to load events: %v", err)
+		http.Error(w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+
+	statuses := make([]EventStatus, 0, len(events))
+	for i := range events {
+		e := &events[i]
+		status := EventStatus{LocalEvent: *e, Mode: announceMode(e)}
+		if next := nextEventAnnouncement(e); !next.IsZero() {
+			status.NextAnnouncement = &next
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// EventDetail is an event with its state and what was announced about it.
+type EventDetail struct {
+	EventStatus
+	Timeline []Announcement `json:"timeline"` // The announcements made about it, the oldest first
+}
+
+// handleEventDetail returns an event, its state flags and its timeline
+func (ws *webServer) handleEventDetail(w http.ResponseWriter, r *http.Request) {
+	e, err := loadEventByID(r.PathValue("id"))
+	if err != nil {
+		logWarn("Failed to load event for details: %v", err)
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	history, err := loadEventHistory(&e)
+	if err != nil {
+		logError("Failed to load the history of event %s: %v", e.Event.ID, err)
+		http.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	detail := EventDetail{
+		EventStatus: EventStatus{LocalEvent: e, Mode: announceMode(&e)},
+		Timeline:    history,
+	}
+	if next := nextEventAnnouncement(&e); !next.IsZero() {
+		detail.NextAnnouncement = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// handleEventPage serves the page of a single event, its data comes from
+// /api/events/{id}
+func (ws *webServer) handleEventPage(w http.ResponseWriter, r *http.Request) {
+	username, role := ws.currentUser(r)
+	data := struct {
+		Username string
+		Role     string
+		ID       string
+		BasePath string
+	}{
+		Username: username,
+		Role:     role,
+		ID:       r.PathValue("id"),
+		BasePath: basePath(r),
+	}
+	if err := ws.templates.ExecuteTemplate(w, "event.html", data); err != nil {
+		logError("Template execution error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// handleEventAcknowledge stops the reminders for an event, its end is still announced
+func (ws *webServer) handleEventAcknowledge(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	e, err := loadEventByID(r.PathValue("id"))
+	if err != nil {
+		logWarn("Failed to load event for acknowledgment: %v", err)
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	if err := e.setAcknowledged(); err != nil {
+		logError("Failed to acknowledge event %s: %v", e.Event.ID, err)
+		http.Error(w, "Failed to save event", http.StatusInternalServerError)
+		return
+	}
+	logInfo("Event %s acknowledged by %s", e.Event.ID, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Event acknowledged"))
+}
+
+// handleEventSnooze holds the reminders of an event for some minutes
+func (ws *webServer) handleEventSnooze(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	minutes, err := strconv.Atoi(r.FormValue("minutes"))
+	if err != nil || minutes <= 0 || minutes > maxSnoozeMinutes {
+		http.Error(w, fmt.Sprintf("Invalid snooze, expected 1 to %d minutes", maxSnoozeMinutes), http.StatusBadRequest)
+		return
+	}
+
+	e, err := loadEventByID(r.PathValue("id"))
+	if err != nil {
+		logWarn("Failed to load event for snooze: %v", err)
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	if err := e.setSnoozed(time.Duration(minutes) * time.Minute); err != nil {
+		logError("Failed to snooze event %s: %v", e.Event.ID, err)
+		http.Error(w, "Failed to save event", http.StatusInternalServerError)
+		return
+	}
+	logInfo("Event %s snoozed for %d minutes by %s", e.Event.ID, minutes, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Event snoozed"))
+}
+
+// handleEventAnnounce speaks an announcement of an event again, the one of
+// the kind given or the one that fits now, without changing the event
+func (ws *webServer) handleEventAnnounce(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	e, err := loadEventByID(r.PathValue("id"))
+	if err != nil {
+		logWarn("Failed to load event for announcement: %v", err)
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	kind := r.FormValue("kind")
+	if kind == "" {
+		kind = currentAnnouncementKind(&e)
+	}
+	text, err := renderAnnouncement(&e, kind)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logInfo("Announcement of event %s requested by %s", e.Event.ID, r.RemoteAddr)
+	// the request waits for the speech, so generation errors are reported
+	err = aiSpeakAs(kind, &e, text)
+	recordAnnouncement(&e, kind, text, err)
+	if err != nil {
+		logError("Failed to announce event %s: %v", e.Event.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(text))
+}
+
+// handleEventComplete marks an event as completed and, if enabled, records it on the calendar
+func (ws *webServer) handleEventComplete(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	e, err := loadEventByID(r.PathValue("id"))
+	if err != nil {
+		logWarn("Failed to load event for completion: %v", err)
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	if err := e.setCompleted(); err != nil {
+		logError("Failed to mark event %s as completed: %v", e.Event.ID, err)
+		http.Error(w, "Failed to save event", http.StatusInternalServerError)
+		return
+	}
+	logInfo("Event %s marked as completed by %s", e.Event.ID, r.RemoteAddr)
+
+	if err := writeCompletion(e.Event, e.CompletedAt); err != nil {
+		logError("Failed to write completion of event %s to calendar: %v", e.Event.ID, err)
+		http.Error(w, "Event completed locally, but updating the calendar failed", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Event completed"))
+}
+
+// handleHistory returns the announcements made on a day, or a range of days
+func (ws *webServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parseDay := func(name string, fallback time.Time) (time.Time, error) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return fallback, nil
+		}
+		return time.ParseInLocation("2006-01-02", value, time.Local)
+	}
+
+	day, err := parseDay("date", startOfDay(time.Now()))
+	if err != nil {
+		http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	from, err := parseDay("from", day)
+	if err != nil {
+		http.Error(w, "Invalid from date, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	to, err := parseDay("to", day)
+	if err != nil {
+		http.Error(w, "Invalid to date, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if to.Before(from) || to.Sub(from) > maxHistoryDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf("Invalid range, at most %d days can be requested", maxHistoryDays), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := loadHistory(from, to)
+	if err != nil {
+		logError("Failed to load announcement history: %v", err)
+		http.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handleReminders returns the local reminders, with the streaks of the habits
+func (ws *webServer) handleReminders(w http.ResponseWriter, r *http.Request) {
+	reminders, err := loadLocalReminders()
+	if err != nil {
+		logError("Failed to load local reminders: %v", err)
+		http.Error(w, "Failed to load reminders", http.StatusInternalServerError)
+		return
+	}
+	records, err := habitRecords()
+	if err != nil {
+		logError("Failed to load the habits: %v", err)
+	}
+
+	type reminderStatus struct {
+		LocalReminder
+		Streak int `json:"streak"`
+	}
+	statuses := make([]reminderStatus, 0, len(reminders))
+	for _, reminder := range reminders {
+		statuses = append(statuses, reminderStatus{
+			LocalReminder: reminder,
+			Streak:        habitStreak(records[reminder.ID]),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// handleReminderAdd creates a local reminder
+func (ws *webServer) handleReminderAdd(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	start, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("start"), time.Local)
+	if err != nil {
+		http.Error(w, "Invalid start time, expected YYYY-MM-DDTHH:MM", http.StatusBadRequest)
+		return
+	}
+
+	duration := 0
+	if value := r.FormValue("duration"); value != "" {
+		if duration, err = strconv.Atoi(value); err != nil || duration < 0 {
+			http.Error(w, "Invalid duration, expected minutes", http.StatusBadRequest)
+			return
+		}
+	}
+
+	habit := false
+	if value := r.FormValue("habit"); value != "" {
+		if habit, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid habit, expected true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	reminder, err := addLocalReminder(LocalReminder{
+		Title:           r.FormValue("title"),
+		Start:           start,
+		DurationMinutes: duration,
+		Repeat:          r.FormValue("repeat"),
+		Notes:           r.FormValue("notes"),
+		Habit:           habit,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminder)
+}
+
+// handleReminderDelete deletes a local reminder
+func (ws *webServer) handleReminderDelete(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	if err := deleteLocalReminder(r.PathValue("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Reminder deleted"))
+}
+
+// handleTokens lists the API tokens
+func (ws *webServer) handleTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := loadAPITokens()
+	if err != nil {
+		genericError(w, "Failed to load API tokens", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handleTokenCreate creates an API token, its value is only ever returned here
+func (ws *webServer) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	username, _ := ws.currentUser(r)
+	token, value, err := createAPIToken(r.FormValue("name"), r.FormValue("role"), username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logInfo("API token %s (%s) created by %s", token.Name, token.Role, username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		APIToken
+		Token string `json:"token"`
+	}{token, value})
+}
+
+// handleTokenRevoke revokes an API token
+func (ws *webServer) handleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	if err := revokeAPIToken(r.PathValue("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	username, _ := ws.currentUser(r)
+	logInfo("API token %s revoked by %s", r.PathValue("id"), username)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Token revoked"))
+}
+
+// handleServiceWorker serves the service worker of the notifications from
+// the root, so that its scope is the whole interface
+func (ws *webServer) handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, "web/static/js/sw.js")
+}
+
+// handlePushKey returns the public key the browsers subscribe with
+func (ws *webServer) handlePushKey(w http.ResponseWriter, r *http.Request) {
+	key, err := webPushPublicKey()
+	if err != nil {
+		genericError(w, "Failed to load the web push key", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":    SysConfig.WebPush.Enabled,
+		"public_key": key,
+	})
+}
+
+// pushSubscriptionRequest is a subscription as PushSubscription.toJSON
+// gives it
+type pushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// handlePushSubscribe keeps the subscription of the browser
+func (ws *webServer) handlePushSubscribe(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	var req pushSubscriptionRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	username, _ := ws.currentUser(r)
+	sub, err := addWebPushSubscription(WebPushSubscription{
+		Endpoint:  req.Endpoint,
+		P256dh:    req.Keys.P256dh,
+		Auth:      req.Keys.Auth,
+		User:      username,
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logInfo("Web push subscription %s added by %s", sub.ID, username)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Subscribed"))
+}
+
+// handlePushUnsubscribe drops the subscription of the browser
+func (ws *webServer) handlePushUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	var req pushSubscriptionRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := removeWebPushSubscription(req.Endpoint); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Unsubscribed"))
+}
+
+// handlePushTest sends a notification to the browser of the subscription
+func (ws *webServer) handlePushTest(w http.ResponseWriter, r *http.Request) {
+	if !ws.checkCSRF(w, r) {
+		return
+	}
+
+	var req pushSubscriptionRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	msg := WebPushMessage{
+		Title: "Reminder",
+		Body:  "Notifications work, the announcements will show up here too.",
+		Tag:   "test",
+		URL:   "./",
+		Time:  time.Now(),
+	}
+	if err := sendWebPush(msg, req.Endpoint); err != nil {
+		logWarn("Failed to send the test notification: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Notification sent"))
+}
+
 // setupWebServer initializes and starts the web server in a goroutine
 func setupWebServer() {
 	webServer := newWebServer()
@@ -605,5 +2109,5 @@ func setupWebServer() {
 		}
 	}()
 
-	logInfo("Configuration web interface available at http://%s:%s", webServerAddr, webServerPort)
+	logInfo("Configuration web interface available at %s", webInterfaceURL())
 }
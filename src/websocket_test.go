@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsPipe returns the two ends of an in-memory connection, the client one
+// masking its frames.
+func wsPipe(t *testing.T) (client, server *wsConn) {
+	t.Helper()
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return &wsConn{conn: c, reader: bufio.NewReader(c), client: true},
+		&wsConn{conn: s, reader: bufio.NewReader(s)}
+}
+
+func TestWSAcceptKey(t *testing.T) {
+	// the example of RFC 6455
+	if got := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wsAcceptKey() = %q", got)
+	}
+}
+
+func TestWSFraming(t *testing.T) {
+	// the three length encodings, at their limits
+	sizes := []int{0, 1, 125, 126, 0xFFFF, 0x10000}
+	for _, size := range sizes {
+		payload := bytes.Repeat([]byte("x"), size)
+		for _, fromClient := range []bool{true, false} {
+			client, server := wsPipe(t)
+			from, to := server, client
+			if fromClient {
+				from, to = client, server
+			}
+
+			errs := make(chan error, 1)
+			go func() { errs <- from.WriteMessage(wsOpBinary, payload) }()
+			op, msg, err := to.ReadMessage()
+			if err != nil {
+				t.Fatalf("size %d: ReadMessage() error = %v", size, err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatalf("size %d: WriteMessage() error = %v", size, err)
+			}
+			if op != wsOpBinary || !bytes.Equal(msg, payload) {
+				t.Errorf("size %d from client %v: got opcode %d and %d bytes", size, fromClient, op, len(msg))
+			}
+		}
+	}
+}
+
+func TestWSClientFramesMasked(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	client := &wsConn{conn: c, reader: bufio.NewReader(c), client: true}
+	go func() {
+		client.WriteMessage(wsOpText, []byte("hello"))
+		c.Close()
+	}()
+
+	frame, _ := io.ReadAll(s)
+	if len(frame) != 2+4+5 {
+		t.Fatalf("frame is %d bytes, want 11", len(frame))
+	}
+	if frame[0] != 0x80|wsOpText || frame[1] != 0x80|5 {
+		t.Errorf("header = %x, want fin, text, masked, length 5", frame[:2])
+	}
+	if bytes.Contains(frame, []byte("hello")) {
+		t.Errorf("payload sent unmasked")
+	}
+}
+
+func TestWSControlFrames(t *testing.T) {
+	client, server := wsPipe(t)
+
+	// a ping is answered while reading, a pong skipped
+	go func() {
+		server.writeFrame(wsOpPing, []byte("ping"))
+		server.writeFrame(wsOpPong, nil)
+		server.WriteMessage(wsOpText, []byte("after"))
+	}()
+	pongs := make(chan []byte, 1)
+	go func() {
+		_, op, payload, err := server.readFrame()
+		if err == nil && op == wsOpPong {
+			pongs <- payload
+		}
+		close(pongs)
+	}()
+
+	_, msg, err := client.ReadMessage()
+	if err != nil || string(msg) != "after" {
+		t.Fatalf("ReadMessage() = %q, %v, want the text after the ping", msg, err)
+	}
+	if pong := <-pongs; string(pong) != "ping" {
+		t.Errorf("pong = %q, want the payload of the ping", pong)
+	}
+
+	// a close ends the reading
+	go server.writeFrame(wsOpClose, nil)
+	go server.readFrame()
+	if _, _, err := client.ReadMessage(); err != io.EOF {
+		t.Errorf("ReadMessage() after close error = %v, want io.EOF", err)
+	}
+}
+
+// writeRawFrame writes a frame with the given first byte, unmasked.
+func writeRawFrame(conn net.Conn, head byte, payload string) {
+	conn.Write(append([]byte{head, byte(len(payload))}, payload...))
+}
+
+func TestWSFragmentedMessage(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	client := &wsConn{conn: c, reader: bufio.NewReader(c), client: true}
+
+	go func() {
+		writeRawFrame(s, wsOpText, "hel")
+		// control frames can come between the fragments
+		writeRawFrame(s, 0x80|wsOpPong, "")
+		writeRawFrame(s, wsOpContinuation, "lo ")
+		writeRawFrame(s, 0x80|wsOpContinuation, "world")
+	}()
+
+	op, msg, err := client.ReadMessage()
+	if err != nil || op != wsOpText || string(msg) != "hello world" {
+		t.Errorf("ReadMessage() = %d %q %v, want the text of the fragments", op, msg, err)
+	}
+
+	go writeRawFrame(s, 0x80|wsOpContinuation, "orphan")
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Errorf("ReadMessage() of a continuation without a start succeeded")
+	}
+}
+
+func TestWSFrameTooLarge(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	client := &wsConn{conn: c, reader: bufio.NewReader(c), client: true}
+
+	go s.Write([]byte{0x80 | wsOpBinary, 127, 0, 0, 0, 0, 0xFF, 0, 0, 0})
+	if _, _, err := client.ReadMessage(); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("ReadMessage() error = %v, want too large", err)
+	}
+}
+
+func TestWSReadTimeout(t *testing.T) {
+	client, server := wsPipe(t)
+	client.readTimeout = 50 * time.Millisecond
+
+	// each frame gives it more time
+	go func() {
+		for range 3 {
+			time.Sleep(30 * time.Millisecond)
+			server.writeFrame(wsOpPong, nil)
+		}
+		server.WriteMessage(wsOpText, []byte("alive"))
+	}()
+	if _, msg, err := client.ReadMessage(); err != nil || string(msg) != "alive" {
+		t.Fatalf("ReadMessage() = %q, %v, want the message after the pongs", msg, err)
+	}
+
+	_, _, err := client.ReadMessage()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("ReadMessage() of a silent connection error = %v, want a timeout", err)
+	}
+}
+
+func TestWSHandshake(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := acceptWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// echo the messages back in upper case
+		for {
+			op, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(op, bytes.ToUpper(msg))
+		}
+	}))
+	defer srv.Close()
+
+	endpoint := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, err := dialWebSocket(context.Background(), endpoint, http.Header{"X-Test": {"1"}})
+	if err != nil {
+		t.Fatalf("dialWebSocket() error = %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(wsOpText, []byte("echo")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "ECHO" {
+		t.Errorf("ReadMessage() = %q, %v, want ECHO", msg, err)
+	}
+
+	// a plain request is refused
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET status = %d, want 400", resp.StatusCode)
+	}
+
+	if _, err := dialWebSocket(context.Background(), "http://localhost", nil); err == nil {
+		t.Errorf("dialWebSocket() of an http URL succeeded")
+	}
+}
+
+func TestHeaderContains(t *testing.T) {
+	header := http.Header{"Connection": {"keep-alive, Upgrade"}}
+	if !headerContains(header, "Connection", "upgrade") {
+		t.Errorf("headerContains() missed the upgrade token")
+	}
+	if headerContains(header, "Connection", "close") || headerContains(header, "Upgrade", "websocket") {
+		t.Errorf("headerContains() found a missing token")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Editing of YAML files line by line, so that the comments documenting the
+// options and the layout of the files are kept, which yaml.v2 can't do.
+
+// yamlKeyLine is a "key: value" line of a YAML file.
+type yamlKeyLine struct {
+	line      int
+	indent    int    // Column of the key
+	prefix    string // What is before the key, the indentation or "- "
+	key       string
+	path      string // Dotted keys leading to it, with the indexes of the list items
+	value     string // As written, quoted or not
+	comment   string
+	commented bool   // "# key: value", a documented option left out
+	help      string // Its trailing comment, or the comments right above it
+}
+
+var (
+	yamlKeyPattern      = regexp.MustCompile(`^(\s*)([A-Za-z0-9_]+)\s*:(\s.*)?$`)
+	yamlCommentedKey    = regexp.MustCompile(`^(\s*)#\s?([A-Za-z0-9_]+):(\s.*)?$`)
+	yamlBlockScalarMark = regexp.MustCompile(`^[|>][-+0-9]*$`)
+)
+
+// yamlKeyLines returns the keys of a YAML mapping file, along with the ones
+// commented out.
+func yamlKeyLines(text string) []yamlKeyLine {
+	type parent struct {
+		indent int
+		key    string
+		item   bool
+	}
+	stack := []parent{}
+	keys := []yamlKeyLine{}
+	comments := []string{}
+	items := map[string]int{}
+	blockIndent := -1
+
+	pathOf := func(indent int, key string) string {
+		parts := []string{}
+		for _, p := range stack {
+			if p.indent < indent {
+				parts = append(parts, p.key)
+			}
+		}
+		return strings.Join(append(parts, key), ".")
+	}
+	// addKey records a key line, the rest being what follows the colon
+	addKey := func(i, indent int, prefix, key, rest string) {
+		value, comment := splitYAMLComment(rest)
+		help := comment
+		if help == "" {
+			help = strings.Join(comments, " ")
+		}
+		keys = append(keys, yamlKeyLine{line: i, indent: indent, prefix: prefix, key: key, path: pathOf(indent, key),
+			value: value, comment: comment, help: help})
+		stack = append(stack, parent{indent: indent, key: key})
+		comments = nil
+		if yamlBlockScalarMark.MatchString(value) {
+			blockIndent = indent
+		}
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if blockIndent >= 0 {
+			if trimmed == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+
+		switch {
+		case trimmed == "":
+			comments = nil
+			continue
+		case strings.HasPrefix(trimmed, "#"):
+			if m := yamlCommentedKey.FindStringSubmatch(line); m != nil {
+				_, comment := splitYAMLComment(m[3])
+				help := comment
+				if help == "" {
+					help = strings.Join(comments, " ")
+				}
+				keys = append(keys, yamlKeyLine{line: i, indent: len(m[1]), prefix: m[1], key: m[2],
+					path: pathOf(len(m[1]), m[2]), commented: true, help: help})
+				continue
+			}
+			comments = append(comments, strings.TrimSpace(strings.TrimPrefix(trimmed, "#")))
+			continue
+		}
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			// the items of a list can be at the indentation of its key
+			for len(stack) > 0 && (stack[len(stack)-1].indent > indent ||
+				stack[len(stack)-1].indent == indent && stack[len(stack)-1].item) {
+				stack = stack[:len(stack)-1]
+			}
+			list := pathOf(indent+1, "")
+			index := items[list]
+			items[list]++
+			stack = append(stack, parent{indent: indent, key: strconv.Itoa(index), item: true})
+			comments = nil
+
+			item := strings.TrimLeft(trimmed[1:], " ")
+			itemIndent := len(line) - len(item)
+			if m := yamlKeyPattern.FindStringSubmatch(item); m != nil {
+				addKey(i, itemIndent, line[:itemIndent], m[2], m[3])
+			}
+			continue
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		m := yamlKeyPattern.FindStringSubmatch(line)
+		if m == nil {
+			comments = nil
+			continue
+		}
+		addKey(i, indent, m[1], m[2], m[3])
+	}
+	return keys
+}
+
+// splitYAMLComment splits the value of a line from its trailing comment.
+func splitYAMLComment(rest string) (string, string) {
+	var quote rune
+	for i, r := range rest {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || rest[i-1] == ' ' || rest[i-1] == '\t'):
+			return strings.TrimSpace(rest[:i]), strings.TrimSpace(rest[i+1:])
+		}
+	}
+	return strings.TrimSpace(rest), ""
+}
+
+// yamlScalar returns the string a scalar value as written stands for.
+func yamlScalar(value string) string {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+		return value[1 : len(value)-1]
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return value
+}
+
+// yamlKeyLineWith returns the line of the key with another value, the
+// spacing and the comment kept.
+func yamlKeyLineWith(line string, k yamlKeyLine, value string) string {
+	start := len(k.prefix) + len(k.key)
+	if i := strings.Index(line[start:], k.value); k.value != "" && i >= 0 {
+		return line[:start+i] + value + line[start+i+len(k.value):]
+	}
+	line = k.prefix + k.key + ": " + value
+	if k.comment != "" {
+		line += " # " + k.comment
+	}
+	return line
+}
+
+// setYAMLValue sets the value of a key of a YAML file, keeping its comments
+// and layout. A missing key is added below its commented out line, or at the
+// end of its parent.
+func setYAMLValue(text, path, value string) string {
+	lines := strings.Split(text, "\n")
+	keys := yamlKeyLines(text)
+
+	for _, k := range keys {
+		if k.commented || k.path != path {
+			continue
+		}
+
+		// drop the lines of the old value, a block list or scalar
+		end := k.line
+		for j := k.line + 1; j < len(lines); j++ {
+			trimmed := strings.TrimSpace(lines[j])
+			indent := len(lines[j]) - len(strings.TrimLeft(lines[j], " "))
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			if indent > k.indent || (indent == k.indent && strings.HasPrefix(trimmed, "- ")) {
+				end = j
+				continue
+			}
+			break
+		}
+
+		line := yamlKeyLineWith(lines[k.line], k, value)
+		lines = append(lines[:k.line], append([]string{line}, lines[end+1:]...)...)
+		return strings.Join(lines, "\n")
+	}
+
+	for _, k := range keys {
+		if k.commented && k.path == path {
+			line := k.prefix + k.key + ": " + value
+			lines = append(lines[:k.line+1], append([]string{line}, lines[k.line+1:]...)...)
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	// add it with the missing parents, under the closest existing one
+	unit := 4
+	for _, k := range keys {
+		if !k.commented && k.indent > 0 {
+			unit = k.indent
+			break
+		}
+	}
+	parts := strings.Split(path, ".")
+	at, indent := len(lines), 0
+	for n := len(parts) - 1; n > 0; n-- {
+		parentPath := strings.Join(parts[:n], ".")
+		found := false
+		for _, k := range keys {
+			if k.commented || k.path != parentPath {
+				continue
+			}
+			at, indent, found = k.line+1, k.indent+unit, true
+			for j := k.line + 1; j < len(lines); j++ {
+				trimmed := strings.TrimSpace(lines[j])
+				lineIndent := len(lines[j]) - len(strings.TrimLeft(lines[j], " "))
+				if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+					continue
+				}
+				if lineIndent <= k.indent {
+					break
+				}
+				at = j + 1
+			}
+			parts = parts[n:]
+			break
+		}
+		if found {
+			break
+		}
+	}
+	if at == len(lines) && indent == 0 && len(lines) > 0 && lines[len(lines)-1] == "" {
+		// keep the final newline of the file
+		at--
+	}
+
+	added := []string{}
+	for i, part := range parts {
+		line := strings.Repeat(" ", indent+i*unit) + part + ":"
+		if i == len(parts)-1 {
+			line += " " + value
+		}
+		added = append(added, line)
+	}
+	lines = append(lines[:at], append(added, lines[at:]...)...)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const testYAML = `# Settings of the device
+
+# Name said in the greetings
+device_name: "Kitchen" # Shown in the web interface
+volume: 80
+night_mode:
+    enabled: false # Quieter at night
+    start: "22:00"
+    # end: "07:00"
+sinks:
+    - type: ntfy
+      topic: 'reminders'
+    - type: script # Runs a command
+      command:
+          - /usr/bin/notify
+          - --urgent
+notes: |
+    first line
+    second: line
+log_level: info
+`
+
+func TestYAMLKeyLines(t *testing.T) {
+	tests := []struct {
+		path      string
+		value     string
+		commented bool
+		help      string
+	}{
+		{"device_name", `"Kitchen"`, false, "Shown in the web interface"},
+		{"volume", "80", false, ""},
+		{"night_mode", "", false, ""},
+		{"night_mode.enabled", "false", false, "Quieter at night"},
+		{"night_mode.start", `"22:00"`, false, ""},
+		{"night_mode.end", "", true, ""},
+		{"sinks", "", false, ""},
+		{"sinks.0.type", "ntfy", false, ""},
+		{"sinks.0.topic", "'reminders'", false, ""},
+		{"sinks.1.type", "script", false, "Runs a command"},
+		{"sinks.1.command", "", false, ""},
+		{"notes", "|", false, ""},
+		{"log_level", "info", false, ""},
+	}
+
+	keys := yamlKeyLines(testYAML)
+	byPath := map[string]yamlKeyLine{}
+	for _, k := range keys {
+		if _, ok := byPath[k.path]; ok {
+			t.Errorf("key %s found twice", k.path)
+		}
+		byPath[k.path] = k
+	}
+	if len(byPath) != len(tests) {
+		paths := []string{}
+		for _, k := range keys {
+			paths = append(paths, k.path)
+		}
+		t.Errorf("yamlKeyLines() = %v, want %d keys", paths, len(tests))
+	}
+	for _, tt := range tests {
+		k, ok := byPath[tt.path]
+		if !ok {
+			t.Errorf("key %s not found", tt.path)
+			continue
+		}
+		if k.value != tt.value || k.commented != tt.commented || k.help != tt.help {
+			t.Errorf("key %s = %q commented %v help %q, want %q commented %v help %q",
+				tt.path, k.value, k.commented, k.help, tt.value, tt.commented, tt.help)
+		}
+	}
+
+	// the comments right above a key are its help
+	if k := byPath["device_name"]; k.comment != "Shown in the web interface" {
+		t.Errorf("device_name comment = %q", k.comment)
+	}
+	text := "# Name said in the greetings\ndevice_name: Kitchen\n"
+	if keys := yamlKeyLines(text); len(keys) != 1 || keys[0].help != "Name said in the greetings" {
+		t.Errorf("yamlKeyLines(%q) = %+v, want the comment above as help", text, keys)
+	}
+}
+
+func TestSplitYAMLComment(t *testing.T) {
+	tests := []struct {
+		rest, value, comment string
+	}{
+		{" 80", "80", ""},
+		{" 80 # percent", "80", "percent"},
+		{` "a # b" # c`, `"a # b"`, "c"},
+		{` 'it''s # here'`, `'it''s # here'`, ""},
+		{" http://host/#anchor", "http://host/#anchor", ""},
+		{" #only a comment", "", "only a comment"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		value, comment := splitYAMLComment(tt.rest)
+		if value != tt.value || comment != tt.comment {
+			t.Errorf("splitYAMLComment(%q) = %q, %q, want %q, %q", tt.rest, value, comment, tt.value, tt.comment)
+		}
+	}
+}
+
+func TestYAMLScalar(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"plain", "plain"},
+		{`"quoted"`, "quoted"},
+		{`"tab\there"`, "tab\there"},
+		{`'single'`, "single"},
+		{`'it''s'`, "it's"},
+		{`"`, `"`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := yamlScalar(tt.value); got != tt.want {
+			t.Errorf("yamlScalar(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSetYAMLValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		value string
+		// lines that must be in the result, and ones that must not
+		has    []string
+		hasNot []string
+	}{
+		{
+			name: "value and comment kept", path: "device_name", value: `"Bedroom"`,
+			has:    []string{`device_name: "Bedroom" # Shown in the web interface`, "# Name said in the greetings"},
+			hasNot: []string{"Kitchen"},
+		},
+		{
+			name: "nested", path: "night_mode.enabled", value: "true",
+			has: []string{"    enabled: true # Quieter at night"},
+		},
+		{
+			name: "list item", path: "sinks.0.topic", value: "'alerts'",
+			has: []string{"      topic: 'alerts'"},
+		},
+		{
+			name: "block list replaced", path: "sinks.1.command", value: "[/bin/true]",
+			has:    []string{"      command: [/bin/true]", "log_level: info"},
+			hasNot: []string{"--urgent", "/usr/bin/notify"},
+		},
+		{
+			name: "block scalar replaced", path: "notes", value: `"one line"`,
+			has:    []string{`notes: "one line"`},
+			hasNot: []string{"second: line"},
+		},
+		{
+			name: "commented out option set below its comment", path: "night_mode.end", value: `"06:30"`,
+			has: []string{`    # end: "07:00"`, `    end: "06:30"`},
+		},
+		{
+			name: "missing key added to its parent", path: "night_mode.volume", value: "20",
+			has: []string{"    volume: 20"},
+		},
+		{
+			name: "missing parents added", path: "wake_up.snooze", value: "5m",
+			has: []string{"wake_up:", "    snooze: 5m"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := setYAMLValue(testYAML, tt.path, tt.value)
+		lines := strings.Split(got, "\n")
+		for _, want := range tt.has {
+			found := false
+			for _, line := range lines {
+				if line == want {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s: line %q missing from\n%s", tt.name, want, got)
+			}
+		}
+		for _, unwanted := range tt.hasNot {
+			if strings.Contains(got, unwanted) {
+				t.Errorf("%s: %q still in\n%s", tt.name, unwanted, got)
+			}
+		}
+		if !strings.HasSuffix(got, "\n") {
+			t.Errorf("%s: final newline lost", tt.name)
+		}
+	}
+}
+
+// TestSetYAMLValueRoundTrip checks the edited files still parse, with the
+// new values and the others unchanged.
+func TestSetYAMLValueRoundTrip(t *testing.T) {
+	type config struct {
+		DeviceName string `yaml:"device_name"`
+		Volume     int    `yaml:"volume"`
+		NightMode  struct {
+			Enabled bool   `yaml:"enabled"`
+			Start   string `yaml:"start"`
+			End     string `yaml:"end"`
+			Volume  int    `yaml:"volume"`
+		} `yaml:"night_mode"`
+		Sinks []struct {
+			Type    string   `yaml:"type"`
+			Topic   string   `yaml:"topic"`
+			Command []string `yaml:"command"`
+		} `yaml:"sinks"`
+		Notes    string `yaml:"notes"`
+		LogLevel string `yaml:"log_level"`
+		WakeUp   struct {
+			Snooze string `yaml:"snooze"`
+		} `yaml:"wake_up"`
+	}
+
+	edits := []struct{ path, value string }{
+		{"device_name", `"Living \"room\""`},
+		{"volume", "35"},
+		{"night_mode.enabled", "true"},
+		{"night_mode.end", `"06:30"`},
+		{"night_mode.volume", "20"},
+		{"sinks.0.topic", "'it''s mine'"},
+		{"sinks.1.command", "[/bin/true, --quiet]"},
+		{"notes", `"short"`},
+		{"wake_up.snooze", "5m"},
+	}
+	text := testYAML
+	for _, edit := range edits {
+		text = setYAMLValue(text, edit.path, edit.value)
+	}
+
+	var got config
+	if err := yaml.Unmarshal([]byte(text), &got); err != nil {
+		t.Fatalf("edited file doesn't parse: %v\n%s", err, text)
+	}
+
+	switch {
+	case got.DeviceName != `Living "room"`:
+		t.Errorf("device_name = %q", got.DeviceName)
+	case got.Volume != 35:
+		t.Errorf("volume = %d", got.Volume)
+	case !got.NightMode.Enabled || got.NightMode.Start != "22:00" || got.NightMode.End != "06:30" || got.NightMode.Volume != 20:
+		t.Errorf("night_mode = %+v", got.NightMode)
+	case len(got.Sinks) != 2 || got.Sinks[0].Type != "ntfy" || got.Sinks[0].Topic != "it's mine":
+		t.Errorf("sinks = %+v", got.Sinks)
+	case strings.Join(got.Sinks[1].Command, " ") != "/bin/true --quiet":
+		t.Errorf("sinks.1.command = %v", got.Sinks[1].Command)
+	case got.Notes != "short" || got.LogLevel != "info":
+		t.Errorf("notes = %q, log_level = %q", got.Notes, got.LogLevel)
+	case got.WakeUp.Snooze != "5m":
+		t.Errorf("wake_up.snooze = %q", got.WakeUp.Snooze)
+	}
+
+	// the documentation stays
+	for _, comment := range []string{"# Settings of the device", "# Name said in the greetings", "# Quieter at night", "# Runs a command"} {
+		if !strings.Contains(text, comment) {
+			t.Errorf("comment %q lost", comment)
+		}
+	}
+
+	// setting what is already there changes nothing
+	if again := setYAMLValue(text, "volume", "35"); again != text {
+		t.Errorf("setting the same value changed the file:\n%s", again)
+	}
+}
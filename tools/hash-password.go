@@ -30,8 +30,10 @@ func main() {
 	}
 
 	fmt.Printf("Bcrypt hash for password: %s\n", string(hash))
-	fmt.Println("\nAdd this hash to your config.yml:")
+	fmt.Println("\nAdd this hash to your secrets.yml:")
 	fmt.Printf("web_server_password: \"%s\"\n", string(hash))
+	fmt.Println("\nOr as the password of a user in the users list of secrets.yml:")
+	fmt.Printf("  - username: \"name\"\n    password: \"%s\"\n    role: \"admin\"\n", string(hash))
 	fmt.Println("\nOr set as environment variable:")
 	fmt.Printf("export WEB_SERVER_PASSWORD=\"%s\"\n", string(hash))
 }
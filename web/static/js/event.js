@@ -0,0 +1,91 @@
+// Page of a single event: its state, what was announced about it and its
+// data as stored
+
+function formatTime(value) {
+    const time = new Date(value);
+    // the zero time of Go, for the fields that were never set
+    if (isNaN(time) || time.getFullYear() <= 1) {
+        return "-";
+    }
+    return time.toLocaleString();
+}
+
+function addRow(body, values) {
+    const row = document.createElement("tr");
+    values.forEach((value) => {
+        const cell = document.createElement("td");
+        cell.textContent = value;
+        row.appendChild(cell);
+    });
+    body.appendChild(row);
+    return row;
+}
+
+function showEvent(e) {
+    document.getElementById("event-title").textContent = e.Event.Description;
+    document.title = e.Event.Description + " - PiVoiceReminder";
+
+    const state = document.getElementById("event-state");
+    state.replaceChildren();
+    [
+        ["Starts", formatTime(e.Event.StartTime)],
+        ["Ends", formatTime(e.Event.EndTime)],
+        ["Announced as", e.mode],
+        [
+            "Next announcement",
+            e.next_announcement ? formatTime(e.next_announcement) : "-",
+        ],
+        ["Start announced", e.StartAnnounced ? "Yes" : "No"],
+        ["Start checks sent", e.CheckStartsSent],
+        ["Start answer", e.StartAnswer || "-"],
+        ["Reminders sent", e.RemindersSent],
+        ["Last reminded", formatTime(e.LastTimeReminded)],
+        ["Escalations", e.EscalationNotified],
+        ["Snoozed until", formatTime(e.SnoozedUntil)],
+        [
+            "Acknowledged",
+            e.Acknowledged ? formatTime(e.AcknowledgedAt) : "No",
+        ],
+        ["Done", e.Completed ? formatTime(e.CompletedAt) : "No"],
+        ["End announced", e.EndAnnounced ? "Yes" : "No"],
+        ["Review answer", e.ReviewAnswer || "-"],
+    ].forEach((values) => addRow(state, values));
+
+    const timeline = document.getElementById("event-timeline");
+    timeline.replaceChildren();
+    if (e.timeline.length === 0) {
+        addRow(timeline, ["-", "-", "Nothing was announced yet", ""]);
+    }
+    e.timeline.forEach((a) => {
+        addRow(timeline, [
+            formatTime(a.time),
+            a.kind.replaceAll("_", " "),
+            a.text,
+            a.success ? "Spoken" : "Failed: " + a.error,
+        ]);
+    });
+
+    document.getElementById("event-data").textContent = JSON.stringify(
+        e.Event,
+        null,
+        2,
+    );
+}
+
+async function loadEvent() {
+    const id = document.body.dataset.eventId;
+    try {
+        const response = await fetch("api/events/" + encodeURIComponent(id));
+        if (!response.ok) {
+            throw new Error(await response.text());
+        }
+        showEvent(await response.json());
+    } catch (error) {
+        const message = document.getElementById("event-message");
+        message.textContent = "Failed to load the event: " + error.message;
+        message.className = "message error";
+        message.style.display = "block";
+    }
+}
+
+window.onload = loadEvent;
@@ -1,11 +1,12 @@
 // PiVoiceReminder Configuration Management
 let csrfToken = "";
-let autoRefreshInterval = null;
+let liveFeed = null;
+let eventsTimer = null;
 
 // Get CSRF token from session
 async function getCSRFToken() {
     try {
-        const response = await fetch("/api/csrf-token");
+        const response = await fetch("api/csrf-token");
         if (response.ok) {
             csrfToken = await response.text();
         }
@@ -27,13 +28,23 @@ function showTab(tabName, event) {
     // Show selected tab
     document.getElementById(tabName + "-tab").classList.add("active");
 
+    // the dashboard is only kept up to date while it is shown
+    if (eventsTimer) {
+        clearInterval(eventsTimer);
+        eventsTimer = null;
+    }
+
     // Find and activate the correct button
     const buttons = document.querySelectorAll(".nav-btn");
     buttons.forEach((btn, index) => {
         if (
             (tabName === "config" && index === 0) ||
             (tabName === "secrets" && index === 1) ||
-            (tabName === "logs" && index === 2)
+            (tabName === "logs" && index === 2) ||
+            (tabName === "reminders" && index === 3) ||
+            (tabName === "events" && index === 4) ||
+            (tabName === "voices" && index === 5) ||
+            (tabName === "medications" && index === 6)
         ) {
             btn.classList.add("active");
         }
@@ -41,34 +52,118 @@ function showTab(tabName, event) {
 
     // Load data for the selected tab
     if (tabName === "logs") {
-        loadLogs();
+        loadLogLevel();
+        // the live feed keeps them up to date
+        if (!liveFeed) {
+            loadLogs();
+        }
     } else if (tabName === "config") {
         loadConfig();
+        loadConfigForm();
+        loadVersions();
     } else if (tabName === "secrets") {
         loadSecrets();
+        loadSecretsForm();
+        loadTokens();
+    } else if (tabName === "reminders") {
+        loadReminders();
+    } else if (tabName === "events") {
+        loadEvents();
+        loadHealth();
+        loadPause();
+        loadVolume();
+        loadWakeUp();
+        loadSinks();
+        eventsTimer = setInterval(() => {
+            loadEvents();
+            loadHealth();
+            loadWakeUp();
+        }, 30000);
+    } else if (tabName === "voices") {
+        loadVoices();
+    } else if (tabName === "medications") {
+        loadAdherence();
+    }
+}
+
+// the cursor of the lines before the ones shown, empty if there are none
+let logsBefore = "";
+
+// logsQuery returns the parameters of the log filters
+function logsQuery() {
+    const query = new URLSearchParams({
+        tail: document.getElementById("logs-tail").value,
+    });
+    const filters = {
+        level: document.getElementById("logs-level").value,
+        search: document.getElementById("logs-search").value.trim(),
+        since: document.getElementById("logs-since").value,
+        until: document.getElementById("logs-until").value,
+    };
+    Object.entries(filters).forEach(([name, value]) => {
+        if (value) {
+            query.set(name, value);
+        }
+    });
+    return query;
+}
+
+// logsFiltered reports whether only some of the lines are shown, the live
+// feed doesn't add to them then
+function logsFiltered() {
+    return [...logsQuery().keys()].some((name) => name !== "tail");
+}
+
+async function fetchLogs(before) {
+    const query = logsQuery();
+    if (before) {
+        query.set("before", before);
     }
+    const response = await fetch("api/logs?" + query);
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+    const page = await response.json();
+    logsBefore = page.before || "";
+    document.getElementById("logs-older-btn").disabled = !logsBefore;
+    return page.lines.length > 0 ? page.lines.join("\n") + "\n" : "";
 }
 
 // Load logs function
 async function loadLogs() {
     try {
-        const response = await fetch("/api/logs");
-        const data = await response.text();
-        document.getElementById("logs-textarea").value = data;
-        // Scroll to bottom to show latest logs
+        const lines = await fetchLogs("");
         const textarea = document.getElementById("logs-textarea");
+        textarea.value = lines || "No logs found.";
+        // Scroll to bottom to show latest logs
         textarea.scrollTop = textarea.scrollHeight;
     } catch (error) {
         showMessage("logs", "Failed to load logs: " + error.message, "error");
     }
 }
 
+async function loadOlderLogs() {
+    if (!logsBefore) {
+        return;
+    }
+    try {
+        const textarea = document.getElementById("logs-textarea");
+        const height = textarea.scrollHeight;
+        textarea.value = (await fetchLogs(logsBefore)) + textarea.value;
+        // stay on the lines that were shown
+        textarea.scrollTop += textarea.scrollHeight - height;
+    } catch (error) {
+        showMessage("logs", "Failed to load logs: " + error.message, "error");
+    }
+}
+
 // Load configuration data
 async function loadConfig() {
     try {
-        const response = await fetch("/api/config");
+        const response = await fetch("api/config");
         const data = await response.text();
         document.getElementById("config-textarea").value = data;
+        validateConfig(false);
     } catch (error) {
         showMessage(
             "config",
@@ -80,7 +175,7 @@ async function loadConfig() {
 
 async function loadSecrets() {
     try {
-        const response = await fetch("/api/secrets");
+        const response = await fetch("api/secrets");
         const data = await response.text();
         document.getElementById("secrets-textarea").value = data;
     } catch (error) {
@@ -93,16 +188,131 @@ async function loadSecrets() {
 }
 
 // Save configuration
-async function saveConfig() {
-    const configData = document.getElementById("config-textarea").value;
+function showConfigEditor(mode) {
+    document.getElementById("config-form-editor").style.display =
+        mode === "form" ? "block" : "none";
+    document.getElementById("config-yaml-editor").style.display =
+        mode === "yaml" ? "block" : "none";
+}
+
+// Label of a config section, e.g. "wake_word" is "Wake word"
+function configSectionLabel(section) {
+    if (!section) {
+        return "General";
+    }
+    const label = section.replaceAll("_", " ");
+    return label.charAt(0).toUpperCase() + label.slice(1);
+}
+
+async function loadConfigForm() {
+    try {
+        const response = await fetch("api/config/fields");
+        if (!response.ok) {
+            const error = await response.text();
+            showMessage("config", error, "error");
+            showConfigEditor("yaml");
+            return;
+        }
+        renderConfigForm(await response.json());
+    } catch (error) {
+        showMessage(
+            "config",
+            "Failed to load configuration: " + error.message,
+            "error",
+        );
+    }
+}
+
+function renderConfigForm(fields) {
+    const form = document.getElementById("config-form");
+    form.replaceChildren();
+
+    const sections = new Map();
+    fields.forEach((field) => {
+        if (!sections.has(field.section)) {
+            const details = document.createElement("details");
+            details.className = "config-section";
+            details.open = field.section === "";
+            const summary = document.createElement("summary");
+            summary.textContent = configSectionLabel(field.section);
+            details.appendChild(summary);
+            form.appendChild(details);
+            sections.set(field.section, details);
+        }
+
+        const row = document.createElement("div");
+        row.className = "config-field";
+
+        const label = document.createElement("label");
+        // the nested options keep the rest of their path in the label
+        const path = field.path.split(".");
+        label.textContent =
+            path.length > 2
+                ? configSectionLabel(path[path.length - 2]) + ": " + field.label
+                : field.label;
+
+        const input = document.createElement("input");
+        input.dataset.path = field.path;
+        input.dataset.value = field.value;
+        if (field.type === "bool") {
+            input.type = "checkbox";
+            input.checked = field.value === "true";
+        } else if (field.type === "int" || field.type === "float") {
+            input.type = "number";
+            input.step = field.type === "float" ? "any" : "1";
+            input.value = field.value;
+        } else {
+            input.type = "text";
+            input.value = field.value;
+            input.placeholder = field.default;
+        }
+        label.htmlFor = input.id = "config-field-" + field.path;
+        row.appendChild(label);
+        row.appendChild(input);
+
+        if (field.help) {
+            const help = document.createElement("span");
+            help.className = "field-help";
+            help.textContent = field.help;
+            row.appendChild(help);
+        }
+        if (field.default !== "" && field.default !== field.value) {
+            const def = document.createElement("span");
+            def.className = "field-help";
+            def.textContent = "Default: " + field.default;
+            row.appendChild(def);
+        }
+        const error = document.createElement("span");
+        error.className = "field-error";
+        row.appendChild(error);
+
+        sections.get(field.section).appendChild(row);
+    });
+}
+
+async function saveConfigForm() {
+    const changes = {};
+    document.querySelectorAll("#config-form [data-path]").forEach((input) => {
+        const value =
+            input.type === "checkbox" ? String(input.checked) : input.value;
+        input.parentElement.querySelector(".field-error").textContent = "";
+        if (value !== input.dataset.value) {
+            changes[input.dataset.path] = value;
+        }
+    });
+    if (Object.keys(changes).length === 0) {
+        showMessage("config", "Nothing was changed", "success");
+        return;
+    }
+
     try {
-        const response = await fetch("/api/config/save", {
+        const response = await fetch("api/config/fields", {
             method: "POST",
             headers: {
-                "Content-Type": "text/plain",
+                "Content-Type": "application/json",
                 "X-CSRF-Token": csrfToken,
             },
-            body: configData,
+            body: JSON.stringify(changes),
         });
 
         if (response.ok) {
@@ -111,6 +321,31 @@ async function saveConfig() {
                 "Configuration saved successfully!",
                 "success",
             );
+            loadConfigForm();
+            loadConfig();
+            loadVersions();
+            return;
+        }
+
+        if (response.headers.get("Content-Type") === "application/json") {
+            const result = await response.json();
+            // the problems of other options are told in the message
+            const others = [];
+            Object.entries(result.errors).forEach(([path, message]) => {
+                const input = document.getElementById("config-field-" + path);
+                if (input) {
+                    input.parentElement.querySelector(
+                        ".field-error",
+                    ).textContent = message;
+                } else {
+                    others.push(path ? path + ": " + message : message);
+                }
+            });
+            showMessage(
+                "config",
+                ["Some options are not valid"].concat(others).join(". "),
+                "error",
+            );
         } else {
             const error = await response.text();
             showMessage(
@@ -128,109 +363,1753 @@ async function saveConfig() {
     }
 }
 
-async function saveSecrets() {
-    const secretsData = document.getElementById("secrets-textarea").value;
+let configValidationTimer = null;
+
+// validate the YAML a moment after the typing stops, without the calendar
+// servers which take a while
+function scheduleConfigValidation() {
+    clearTimeout(configValidationTimer);
+    configValidationTimer = setTimeout(() => validateConfig(false), 1000);
+}
+
+async function validateConfig(network) {
+    const configData = document.getElementById("config-textarea").value;
+    try {
+        const response = await fetch(
+            "api/config/validate?network=" + network,
+            {
+                method: "POST",
+                headers: {
+                    "Content-Type": "text/plain",
+                    "X-CSRF-Token": csrfToken,
+                },
+                body: configData,
+            },
+        );
+        if (!response.ok) {
+            return;
+        }
+        const result = await response.json();
+        showConfigProblems(result.problems);
+        if (network && result.problems.length === 0) {
+            showMessage("config", "No problems found", "success");
+        }
+    } catch (error) {
+        showMessage(
+            "config",
+            "Failed to check configuration: " + error.message,
+            "error",
+        );
+    }
+}
+
+function showConfigProblems(problems) {
+    const list = document.getElementById("config-problems");
+    list.replaceChildren();
+    problems.forEach((problem) => {
+        const item = document.createElement("li");
+        item.className = problem.warning ? "warning" : "error";
+        item.textContent = problem.path
+            ? problem.path + ": " + problem.message
+            : problem.message;
+        list.appendChild(item);
+    });
+}
+
+async function loadVersions() {
+    const file = document.getElementById("versions-file").value;
+    try {
+        const response = await fetch(
+            "api/config/versions?file=" + encodeURIComponent(file),
+        );
+        const versions = await response.json();
+        const list = document.getElementById("versions-list");
+        list.replaceChildren();
+        document.getElementById("version-diff").style.display = "none";
+
+        versions.forEach((version) => {
+            const row = document.createElement("tr");
+            [
+                new Date(version.time).toLocaleString(),
+                version.user || "-",
+                version.note || "",
+            ].forEach((value) => {
+                const cell = document.createElement("td");
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+
+            const actions = document.createElement("td");
+            [
+                ["Changes", () => showVersionDiff(version.id, "")],
+                ["Compare", () => showVersionDiff(version.id, "current")],
+                ["Roll Back", () => rollbackVersion(version)],
+            ].forEach(([label, action]) => {
+                const button = document.createElement("button");
+                button.className = "refresh-btn";
+                button.textContent = label;
+                button.onclick = action;
+                actions.appendChild(button);
+            });
+            row.appendChild(actions);
+
+            list.appendChild(row);
+        });
+    } catch (error) {
+        showMessage(
+            "config",
+            "Failed to load the history: " + error.message,
+            "error",
+        );
+    }
+}
+
+// showVersionDiff shows what the version changed, or against the current
+// file what rolling back to it would change
+async function showVersionDiff(id, against) {
+    try {
+        const response = await fetch(
+            "api/config/versions/" +
+                encodeURIComponent(id) +
+                "/diff?against=" +
+                against,
+        );
+        if (!response.ok) {
+            const error = await response.text();
+            showMessage("config", error, "error");
+            return;
+        }
+        const result = await response.json();
+
+        const view = document.getElementById("version-diff");
+        view.replaceChildren();
+        if (result.diff.length === 0) {
+            view.textContent = "No changes";
+        }
+        result.diff.forEach((line) => {
+            const div = document.createElement("div");
+            if (line.op === "@") {
+                div.className = "skipped";
+                div.textContent = "... " + line.text;
+            } else {
+                div.className =
+                    line.op === "+" ? "added" : line.op === "-" ? "removed" : "";
+                div.textContent = line.op + " " + line.text;
+            }
+            view.appendChild(div);
+        });
+        view.style.display = "block";
+    } catch (error) {
+        showMessage(
+            "config",
+            "Failed to load the changes: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function rollbackVersion(version) {
+    const saved = new Date(version.time).toLocaleString();
+    if (
+        !confirm(
+            "Roll " + version.file + " back to the version of " + saved + "?",
+        )
+    ) {
+        return;
+    }
+
+    try {
+        const response = await fetch(
+            "api/config/versions/" +
+                encodeURIComponent(version.id) +
+                "/rollback",
+            {
+                method: "POST",
+                headers: {
+                    "X-CSRF-Token": csrfToken,
+                },
+            },
+        );
+
+        if (response.ok) {
+            showMessage("config", "Rolled back to " + saved, "success");
+            loadVersions();
+            loadConfig();
+            loadConfigForm();
+            loadSecrets();
+            loadSecretsForm();
+        } else if (
+            response.headers.get("Content-Type") === "application/json"
+        ) {
+            const result = await response.json();
+            showMessage(
+                "config",
+                "Not rolled back: " +
+                    Object.entries(result.errors)
+                        .map(([path, message]) =>
+                            path ? path + ": " + message : message,
+                        )
+                        .join(". "),
+                "error",
+            );
+        } else {
+            const error = await response.text();
+            showMessage("config", "Failed to roll back: " + error, "error");
+        }
+    } catch (error) {
+        showMessage(
+            "config",
+            "Failed to roll back: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function saveConfig() {
+    const configData = document.getElementById("config-textarea").value;
     try {
-        const response = await fetch("/api/secrets/save", {
+        const response = await fetch("api/config/save", {
             method: "POST",
             headers: {
                 "Content-Type": "text/plain",
                 "X-CSRF-Token": csrfToken,
             },
-            body: secretsData,
+            body: configData,
         });
 
         if (response.ok) {
-            showMessage("secrets", "Secrets saved successfully!", "success");
+            showMessage(
+                "config",
+                "Configuration saved successfully!",
+                "success",
+            );
+            loadConfigForm();
+            loadVersions();
+            showConfigProblems([]);
+        } else if (
+            response.headers.get("Content-Type") === "application/json"
+        ) {
+            const result = await response.json();
+            showConfigProblems(
+                Object.entries(result.errors).map(([path, message]) => ({
+                    path,
+                    message,
+                })),
+            );
+            showMessage(
+                "config",
+                "Not saved, it would break the running configuration",
+                "error",
+            );
         } else {
             const error = await response.text();
-            showMessage("secrets", "Failed to save secrets: " + error, "error");
+            showMessage(
+                "config",
+                "Failed to save configuration: " + error,
+                "error",
+            );
         }
     } catch (error) {
         showMessage(
-            "secrets",
-            "Failed to save secrets: " + error.message,
+            "config",
+            "Failed to save configuration: " + error.message,
             "error",
         );
     }
 }
 
-async function clearLogs() {
-    if (
-        !confirm(
-            "Are you sure you want to clear all logs? This action cannot be undone.",
-        )
-    ) {
+async function restoreBackup() {
+    const file = document.getElementById("restore-file").files[0];
+    if (!file) {
+        showMessage("config", "Choose a backup file first", "error");
+        return;
+    }
+    if (!confirm("Replace the configuration, secrets and events with the backup?")) {
         return;
     }
 
     try {
-        const response = await fetch("/api/logs/clear", {
+        const response = await fetch("api/restore", {
             method: "POST",
             headers: {
+                "Content-Type": "application/gzip",
                 "X-CSRF-Token": csrfToken,
             },
+            body: file,
         });
 
         if (response.ok) {
-            document.getElementById("logs-textarea").value = "";
-            showMessage("logs", "Logs cleared successfully!", "success");
+            showMessage("config", "Backup restored successfully!", "success");
+            loadConfig();
         } else {
             const error = await response.text();
-            showMessage("logs", "Failed to clear logs: " + error, "error");
+            showMessage("config", "Failed to restore backup: " + error, "error");
         }
     } catch (error) {
-        showMessage("logs", "Failed to clear logs: " + error.message, "error");
+        showMessage(
+            "config",
+            "Failed to restore backup: " + error.message,
+            "error",
+        );
     }
 }
 
-function toggleAutoRefresh() {
-    const checkbox = document.getElementById("auto-refresh");
+async function stopApplication(action) {
+    const question =
+        action === "restart"
+            ? "Restart the reminder? You will have to log in again."
+            : "Shut the reminder down? It has to be started again on the device.";
+    if (!confirm(question)) {
+        return;
+    }
+
+    try {
+        const response = await fetch("api/" + action, {
+            method: "POST",
+            headers: {
+                "X-CSRF-Token": csrfToken,
+            },
+        });
+        if (!response.ok) {
+            const error = await response.text();
+            showMessage(
+                "config",
+                "Failed to " + action + ": " + error,
+                "error",
+            );
+            return;
+        }
+    } catch (error) {
+        showMessage(
+            "config",
+            "Failed to " + action + ": " + error.message,
+            "error",
+        );
+        return;
+    }
+
+    if (action !== "restart") {
+        showMessage("config", "The reminder is shutting down", "success");
+        return;
+    }
 
-    if (checkbox.checked) {
-        // Start auto-refresh every 30 seconds
-        autoRefreshInterval = setInterval(() => {
-            // Only refresh if logs tab is active
-            if (
-                document.getElementById("logs-tab").classList.contains("active")
-            ) {
-                loadLogs();
+    // reload once it answers again, the sessions don't survive a restart
+    showMessage(
+        "config",
+        "Restarting, the page reloads when it is back",
+        "success",
+    );
+    await new Promise((resolve) => setTimeout(resolve, 3000));
+    for (let i = 0; i < 60; i++) {
+        try {
+            const response = await fetch("login", { cache: "no-store" });
+            if (response.ok) {
+                location.reload();
+                return;
             }
-        }, 30000);
-        showMessage("logs", "Auto-refresh enabled (30 seconds)", "success");
-    } else {
-        // Stop auto-refresh
-        if (autoRefreshInterval) {
-            clearInterval(autoRefreshInterval);
-            autoRefreshInterval = null;
+        } catch (error) {
+            // not listening yet
         }
-        showMessage("logs", "Auto-refresh disabled", "success");
+        await new Promise((resolve) => setTimeout(resolve, 2000));
     }
+    showMessage(
+        "config",
+        "The reminder did not come back, check the device",
+        "error",
+    );
 }
 
-function showMessage(type, message, level) {
-    const messageDiv = document.getElementById(type + "-message");
-    messageDiv.textContent = message;
-    messageDiv.className = "message " + level;
-    messageDiv.style.display = "block";
+function showSecretsEditor(mode) {
+    document.getElementById("secrets-form-editor").style.display =
+        mode === "form" ? "block" : "none";
+    document.getElementById("secrets-yaml-editor").style.display =
+        mode === "yaml" ? "block" : "none";
+}
 
-    // Hide message after 5 seconds
-    setTimeout(() => {
-        messageDiv.style.display = "none";
-    }, 5000);
+async function loadSecretsForm() {
+    try {
+        const response = await fetch("api/secrets/fields");
+        if (!response.ok) {
+            const error = await response.text();
+            showMessage("secrets", error, "error");
+            return;
+        }
+        renderSecretsForm(await response.json());
+    } catch (error) {
+        showMessage(
+            "secrets",
+            "Failed to load secrets: " + error.message,
+            "error",
+        );
+    }
 }
 
-// Initialize application when page loads
-window.onload = async function () {
-    await getCSRFToken();
-    loadConfig();
-    loadSecrets();
-    loadLogs();
+function renderSecretsForm(fields) {
+    const form = document.getElementById("secrets-form");
+    form.replaceChildren();
+
+    // grouped by what holds them, e.g. "calendar_sources.0"
+    const sections = new Map();
+    fields.forEach((field) => {
+        const path = field.path.split(".");
+        const section = path.slice(0, -1).join(".");
+        if (!sections.has(section)) {
+            const details = document.createElement("details");
+            details.className = "config-section";
+            details.open = true;
+            const summary = document.createElement("summary");
+            summary.textContent = section
+                .split(".")
+                .map((part) =>
+                    /^\d+$/.test(part)
+                        ? "#" + (Number(part) + 1)
+                        : configSectionLabel(part),
+                )
+                .join(" ");
+            details.appendChild(summary);
+            form.appendChild(details);
+            sections.set(section, details);
+        }
+
+        const row = document.createElement("div");
+        row.className = "config-field";
+
+        const label = document.createElement("label");
+        label.textContent = field.label;
+
+        const input = document.createElement("input");
+        input.dataset.path = field.path;
+        if (field.secret) {
+            input.type = "password";
+            input.autocomplete = "new-password";
+            input.placeholder = field.set ? "unchanged" : "not set";
+            input.dataset.value = "";
+        } else {
+            input.type = "text";
+            input.value = field.value || "";
+            input.dataset.value = input.value;
+        }
+        label.htmlFor = input.id = "secrets-field-" + field.path;
+        row.appendChild(label);
+        row.appendChild(input);
+
+        if (field.help) {
+            const help = document.createElement("span");
+            help.className = "field-help";
+            help.textContent = field.help;
+            row.appendChild(help);
+        }
+        if (field.hashed) {
+            const help = document.createElement("span");
+            help.className = "field-help";
+            help.textContent = "Login password, saved hashed";
+            row.appendChild(help);
+        }
+        const error = document.createElement("span");
+        error.className = "field-error";
+        row.appendChild(error);
+
+        sections.get(section).appendChild(row);
+    });
+}
+
+async function saveSecretsForm() {
+    const changes = {};
+    document.querySelectorAll("#secrets-form [data-path]").forEach((input) => {
+        input.parentElement.querySelector(".field-error").textContent = "";
+        if (input.value !== input.dataset.value) {
+            changes[input.dataset.path] = input.value;
+        }
+    });
+    if (Object.keys(changes).length === 0) {
+        showMessage("secrets", "Nothing was changed", "success");
+        return;
+    }
+
+    try {
+        const response = await fetch("api/secrets/fields", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/json",
+                "X-CSRF-Token": csrfToken,
+            },
+            body: JSON.stringify(changes),
+        });
+
+        if (response.ok) {
+            showMessage("secrets", "Secrets saved successfully!", "success");
+            loadSecretsForm();
+            loadSecrets();
+            loadVersions();
+            return;
+        }
+
+        if (response.headers.get("Content-Type") === "application/json") {
+            const result = await response.json();
+            Object.entries(result.errors).forEach(([path, message]) => {
+                const input = document.getElementById("secrets-field-" + path);
+                if (input) {
+                    input.parentElement.querySelector(
+                        ".field-error",
+                    ).textContent = message;
+                }
+            });
+            showMessage("secrets", "Some values are not valid", "error");
+        } else {
+            const error = await response.text();
+            showMessage("secrets", "Failed to save secrets: " + error, "error");
+        }
+    } catch (error) {
+        showMessage(
+            "secrets",
+            "Failed to save secrets: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function saveSecrets() {
+    const secretsData = document.getElementById("secrets-textarea").value;
+    try {
+        const response = await fetch("api/secrets/save", {
+            method: "POST",
+            headers: {
+                "Content-Type": "text/plain",
+                "X-CSRF-Token": csrfToken,
+            },
+            body: secretsData,
+        });
+
+        if (response.ok) {
+            showMessage("secrets", "Secrets saved successfully!", "success");
+            loadSecretsForm();
+            loadVersions();
+        } else {
+            const error = await response.text();
+            showMessage("secrets", "Failed to save secrets: " + error, "error");
+        }
+    } catch (error) {
+        showMessage(
+            "secrets",
+            "Failed to save secrets: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function loadLogLevel() {
+    try {
+        const response = await fetch("api/logs/level");
+        showLogLevel(await response.json());
+    } catch (error) {
+        showMessage(
+            "logs",
+            "Failed to load the log level: " + error.message,
+            "error",
+        );
+    }
+}
+
+function showLogLevel(data) {
+    const select = document.getElementById("log-level");
+    select.innerHTML = "";
+    data.levels.forEach((level) => {
+        const option = document.createElement("option");
+        option.value = level;
+        option.textContent = level;
+        select.appendChild(option);
+    });
+    select.value = data.level;
+    // only admins can change it
+    select.disabled = document.body.dataset.role !== "admin";
+}
+
+async function setLogLevel() {
+    try {
+        const response = await fetch("api/logs/level", {
+            method: "POST",
+            headers: {
+                "X-CSRF-Token": csrfToken,
+            },
+            body: new URLSearchParams({
+                level: document.getElementById("log-level").value,
+            }),
+        });
+
+        if (response.ok) {
+            const data = await response.json();
+            showLogLevel(data);
+            showMessage("logs", "Log level set to " + data.level, "success");
+        } else {
+            const error = await response.text();
+            showMessage(
+                "logs",
+                "Failed to set the log level: " + error,
+                "error",
+            );
+            loadLogLevel();
+        }
+    } catch (error) {
+        showMessage(
+            "logs",
+            "Failed to set the log level: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function clearLogs() {
+    if (
+        !confirm(
+            "Are you sure you want to clear all logs? This action cannot be undone.",
+        )
+    ) {
+        return;
+    }
+
+    try {
+        const response = await fetch("api/logs/clear", {
+            method: "POST",
+            headers: {
+                "X-CSRF-Token": csrfToken,
+            },
+        });
+
+        if (response.ok) {
+            document.getElementById("logs-textarea").value = "";
+            showMessage("logs", "Logs cleared successfully!", "success");
+        } else {
+            const error = await response.text();
+            showMessage("logs", "Failed to clear logs: " + error, "error");
+        }
+    } catch (error) {
+        showMessage("logs", "Failed to clear logs: " + error.message, "error");
+    }
+}
+
+async function triggerPanic() {
+    if (
+        !confirm(
+            "Trigger the emergency announcement now? The device will speak the emergency message.",
+        )
+    ) {
+        return;
+    }
+
+    try {
+        const response = await fetch("api/panic", {
+            method: "POST",
+            headers: {
+                "X-CSRF-Token": csrfToken,
+            },
+        });
+
+        if (response.ok) {
+            alert("Emergency announcement triggered.");
+        } else {
+            const error = await response.text();
+            alert("Failed to trigger emergency announcement: " + error);
+        }
+    } catch (error) {
+        alert("Failed to trigger emergency announcement: " + error.message);
+    }
+}
+
+async function answerReview(answer) {
+    try {
+        const response = await fetch("api/review/answer", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/x-www-form-urlencoded",
+                "X-CSRF-Token": csrfToken,
+            },
+            body: "answer=" + encodeURIComponent(answer),
+        });
+
+        if (!response.ok) {
+            const error = await response.text();
+            alert("Failed to answer review: " + error);
+        }
+    } catch (error) {
+        alert("Failed to answer review: " + error.message);
+    }
+}
+
+async function loadTokens() {
+    try {
+        const response = await fetch("api/tokens");
+        const tokens = await response.json();
+        const list = document.getElementById("tokens-list");
+        list.replaceChildren();
+
+        tokens.forEach((token) => {
+            const row = document.createElement("tr");
+            [
+                token.name,
+                token.role,
+                token.created_by,
+                new Date(token.created_at).toLocaleString(),
+            ].forEach((value) => {
+                const cell = document.createElement("td");
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+
+            const actions = document.createElement("td");
+            const button = document.createElement("button");
+            button.className = "clear-btn";
+            button.textContent = "Revoke";
+            button.onclick = () => revokeToken(token.id, token.name);
+            actions.appendChild(button);
+            row.appendChild(actions);
+
+            list.appendChild(row);
+        });
+    } catch (error) {
+        showMessage(
+            "secrets",
+            "Failed to load API tokens: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function createToken(event) {
+    event.preventDefault();
+
+    const body = new URLSearchParams({
+        name: document.getElementById("token-name").value,
+        role: document.getElementById("token-role").value,
+    });
+
+    try {
+        const response = await fetch("api/tokens", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/x-www-form-urlencoded",
+                "X-CSRF-Token": csrfToken,
+            },
+            body: body,
+        });
+
+        if (response.ok) {
+            const token = await response.json();
+            event.target.reset();
+            // the token can't be shown again
+            const value = document.getElementById("token-value");
+            value.value = token.token;
+            value.style.display = "block";
+            value.select();
+            showMessage(
+                "secrets",
+                "Token created, copy it now, it won't be shown again",
+                "success",
+            );
+            loadTokens();
+        } else {
+            const error = await response.text();
+            showMessage("secrets", "Failed to create token: " + error, "error");
+        }
+    } catch (error) {
+        showMessage(
+            "secrets",
+            "Failed to create token: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function revokeToken(id, name) {
+    if (!confirm('Revoke the token "' + name + '"?')) {
+        return;
+    }
+
+    try {
+        const response = await fetch(
+            "api/tokens/" + encodeURIComponent(id) + "/revoke",
+            {
+                method: "POST",
+                headers: {
+                    "X-CSRF-Token": csrfToken,
+                },
+            },
+        );
+
+        if (response.ok) {
+            loadTokens();
+        } else {
+            const error = await response.text();
+            showMessage("secrets", "Failed to revoke token: " + error, "error");
+        }
+    } catch (error) {
+        showMessage(
+            "secrets",
+            "Failed to revoke token: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function loadReminders() {
+    try {
+        const response = await fetch("api/reminders");
+        const reminders = await response.json();
+        const list = document.getElementById("reminders-list");
+        list.replaceChildren();
+
+        reminders.forEach((reminder) => {
+            const row = document.createElement("tr");
+            [
+                reminder.title,
+                new Date(reminder.start).toLocaleString(),
+                reminder.duration_minutes,
+                reminder.repeat || "Once",
+                reminder.habit ? reminder.streak + " in a row" : "",
+            ].forEach((value) => {
+                const cell = document.createElement("td");
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+
+            const actions = document.createElement("td");
+            const button = document.createElement("button");
+            button.className = "clear-btn";
+            button.textContent = "Delete";
+            button.onclick = () => deleteReminder(reminder.id);
+            actions.appendChild(button);
+            row.appendChild(actions);
+
+            list.appendChild(row);
+        });
+    } catch (error) {
+        showMessage(
+            "reminders",
+            "Failed to load reminders: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function loadAdherence() {
+    try {
+        const days = document.getElementById("adherence-days").value;
+        const response = await fetch("api/medications/adherence?days=" + days);
+        if (!response.ok) {
+            throw new Error(await response.text());
+        }
+        const report = await response.json();
+
+        const list = document.getElementById("adherence-list");
+        list.replaceChildren();
+        report.medications.forEach((med) => {
+            const row = document.createElement("tr");
+            [
+                med.medication,
+                med.taken,
+                med.missed,
+                Math.round(med.adherence * 100) + "%",
+            ].forEach((value) => {
+                const cell = document.createElement("td");
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+            list.appendChild(row);
+        });
+
+        const doses = document.getElementById("doses-list");
+        doses.replaceChildren();
+        report.doses.forEach((dose) => {
+            const row = document.createElement("tr");
+            [
+                new Date(dose.scheduled).toLocaleString(),
+                dose.medication,
+                dose.taken ? "Taken" : "Missed",
+                dose.confirmed_at ? new Date(dose.confirmed_at).toLocaleTimeString() : "",
+            ].forEach((value) => {
+                const cell = document.createElement("td");
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+            doses.appendChild(row);
+        });
+    } catch (error) {
+        showMessage(
+            "medications",
+            "Failed to load the adherence: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function addReminder(event) {
+    event.preventDefault();
+
+    const body = new URLSearchParams({
+        title: document.getElementById("reminder-title").value,
+        start: document.getElementById("reminder-start").value,
+        duration: document.getElementById("reminder-duration").value,
+        repeat:
+            document.getElementById("reminder-cron").value.trim() ||
+            document.getElementById("reminder-repeat").value,
+        habit: document.getElementById("reminder-habit").checked,
+    });
+
+    try {
+        const response = await fetch("api/reminders", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/x-www-form-urlencoded",
+                "X-CSRF-Token": csrfToken,
+            },
+            body: body,
+        });
+
+        if (response.ok) {
+            event.target.reset();
+            showMessage("reminders", "Reminder added!", "success");
+            loadReminders();
+        } else {
+            const error = await response.text();
+            showMessage(
+                "reminders",
+                "Failed to add reminder: " + error,
+                "error",
+            );
+        }
+    } catch (error) {
+        showMessage(
+            "reminders",
+            "Failed to add reminder: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function deleteReminder(id) {
+    if (!confirm("Delete this reminder, with all its repeats?")) {
+        return;
+    }
+
+    try {
+        const response = await fetch(
+            "api/reminders/" + encodeURIComponent(id) + "/delete",
+            {
+                method: "POST",
+                headers: {
+                    "X-CSRF-Token": csrfToken,
+                },
+            },
+        );
+
+        if (response.ok) {
+            loadReminders();
+        } else {
+            const error = await response.text();
+            showMessage(
+                "reminders",
+                "Failed to delete reminder: " + error,
+                "error",
+            );
+        }
+    } catch (error) {
+        showMessage(
+            "reminders",
+            "Failed to delete reminder: " + error.message,
+            "error",
+        );
+    }
+}
+
+function eventState(e) {
+    if (e.Completed) {
+        return "Done";
+    }
+    if (e.mode === "skip") {
+        return "Not announced";
+    }
+    if (e.Acknowledged) {
+        return "Acknowledged";
+    }
+    if (new Date(e.SnoozedUntil) > new Date()) {
+        return (
+            "Snoozed until " + new Date(e.SnoozedUntil).toLocaleTimeString()
+        );
+    }
+    if (e.EndAnnounced) {
+        return "Ended";
+    }
+    if (e.StartAnswer === "no") {
+        return "Not started yet";
+    }
+    if (e.StartAnnounced) {
+        return "Started";
+    }
+    return "Upcoming";
+}
+
+async function loadEvents() {
+    try {
+        const response = await fetch("api/events");
+        const events = await response.json();
+        const list = document.getElementById("events-list");
+        list.replaceChildren();
+
+        showEventsSummary(events);
+        events.forEach((e) => {
+            const row = document.createElement("tr");
+            const title = document.createElement("td");
+            const link = document.createElement("a");
+            link.href = "events/" + encodeURIComponent(e.Event.ID);
+            link.textContent = e.Event.Description;
+            title.appendChild(link);
+            row.appendChild(title);
+            [
+                new Date(e.Event.StartTime).toLocaleTimeString(),
+                new Date(e.Event.EndTime).toLocaleTimeString(),
+                e.next_announcement
+                    ? new Date(e.next_announcement).toLocaleTimeString()
+                    : "-",
+                eventState(e),
+            ].forEach((value) => {
+                const cell = document.createElement("td");
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+
+            const actions = document.createElement("td");
+            if (!e.Completed) {
+                if (!e.Acknowledged) {
+                    const ack = document.createElement("button");
+                    ack.className = "refresh-btn";
+                    ack.textContent = "I'm on it";
+                    ack.onclick = () => eventAction(e.Event.ID, "acknowledge");
+                    actions.appendChild(ack);
+                }
+                if (!e.Acknowledged) {
+                    const minutes = document.createElement("select");
+                    [5, 10, 15, 30, 60].forEach((m) => {
+                        const option = document.createElement("option");
+                        option.value = m;
+                        option.textContent = m + " min";
+                        minutes.appendChild(option);
+                    });
+                    minutes.value = 10;
+                    const snooze = document.createElement("button");
+                    snooze.className = "refresh-btn";
+                    snooze.textContent = "Snooze";
+                    snooze.onclick = () =>
+                        eventAction(e.Event.ID, "snooze", {
+                            minutes: minutes.value,
+                        });
+                    actions.appendChild(minutes);
+                    actions.appendChild(snooze);
+                }
+                const announce = document.createElement("button");
+                announce.className = "refresh-btn";
+                announce.textContent = "Announce";
+                announce.onclick = () => eventAction(e.Event.ID, "announce");
+                actions.appendChild(announce);
+                const done = document.createElement("button");
+                done.className = "save-btn";
+                done.textContent = "Done";
+                done.onclick = () => eventAction(e.Event.ID, "complete");
+                actions.appendChild(done);
+            }
+            row.appendChild(actions);
+
+            list.appendChild(row);
+        });
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to load events: " + error.message,
+            "error",
+        );
+    }
+}
+
+function showEventsSummary(events) {
+    const summary = document.getElementById("events-summary");
+    const planned = events
+        .filter((e) => e.next_announcement)
+        .sort(
+            (a, b) =>
+                new Date(a.next_announcement) - new Date(b.next_announcement),
+        );
+    const left = events.filter(
+        (e) => !e.Completed && new Date(e.Event.EndTime) > new Date(),
+    ).length;
+
+    let text = left + " of " + events.length + " events left today.";
+    if (planned.length > 0) {
+        text +=
+            " Next announcement at " +
+            new Date(planned[0].next_announcement).toLocaleTimeString() +
+            ", about \"" +
+            planned[0].Event.Description +
+            "\".";
+    }
+    summary.textContent = text;
+}
+
+async function loadHealth() {
+    try {
+        // a degraded health comes with 503, and the same details
+        const response = await fetch("healthz");
+        showHealth(await response.json());
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to load health: " + error.message,
+            "error",
+        );
+    }
+}
+
+function showHealth(health) {
+    const state = document.getElementById("health-state");
+    state.className = "health " + health.status;
+    if (health.status === "ok") {
+        state.textContent =
+            "Healthy: calendars synced, speech and audio ready, " +
+            Math.round(health.disk.free_bytes / 1024 / 1024) +
+            " MB free.";
+    } else {
+        state.textContent = "Something is wrong, announcements may be missed:";
+    }
+
+    const list = document.getElementById("health-problems");
+    list.innerHTML = "";
+    (health.problems || []).forEach((problem) => {
+        const item = document.createElement("li");
+        item.textContent = problem;
+        list.appendChild(item);
+    });
+}
+
+async function eventAction(id, action, form) {
+    try {
+        const response = await fetch(
+            "api/events/" + encodeURIComponent(id) + "/" + action,
+            {
+                method: "POST",
+                headers: {
+                    "X-CSRF-Token": csrfToken,
+                },
+                body: new URLSearchParams(form || {}),
+            },
+        );
+
+        if (!response.ok) {
+            const error = await response.text();
+            showMessage("events", "Failed to update event: " + error, "error");
+        }
+        loadEvents();
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to update event: " + error.message,
+            "error",
+        );
+    }
+}
+
+function showPause(pause) {
+    const state = document.getElementById("pause-state");
+    if (pause.paused) {
+        state.textContent =
+            "Announcements paused until " +
+            new Date(pause.until).toLocaleString();
+    } else {
+        state.textContent = "Announcements are on. Pause until:";
+    }
+    document.getElementById("resume-btn").style.display = pause.paused
+        ? ""
+        : "none";
+}
+
+async function loadPause() {
+    try {
+        const response = await fetch("api/pause");
+        showPause(await response.json());
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to load pause state: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function pauseAnnouncements() {
+    await updatePause("api/pause", {
+        until: document.getElementById("pause-until").value,
+    });
+}
+
+async function resumeAnnouncements() {
+    await updatePause("api/pause/resume", {});
+}
+
+async function updatePause(url, form) {
+    try {
+        const response = await fetch(url, {
+            method: "POST",
+            headers: {
+                "X-CSRF-Token": csrfToken,
+            },
+            body: new URLSearchParams(form),
+        });
+
+        if (response.ok) {
+            showPause(await response.json());
+        } else {
+            const error = await response.text();
+            showMessage("events", "Failed to update pause: " + error, "error");
+        }
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to update pause: " + error.message,
+            "error",
+        );
+    }
+}
+
+function showWakeUp(wakeUp) {
+    document.getElementById("wake-up").style.display = wakeUp.enabled
+        ? ""
+        : "none";
+    const state = document.getElementById("wake-up-state");
+    if (wakeUp.ringing) {
+        state.textContent = "Wake-up alarm ringing.";
+    } else if (wakeUp.snoozed) {
+        state.textContent =
+            "Wake-up alarm snoozed until " +
+            new Date(wakeUp.next).toLocaleTimeString();
+    } else {
+        state.textContent =
+            "Next wake-up alarm " + new Date(wakeUp.next).toLocaleString();
+    }
+    document.getElementById("wake-up-snooze-btn").style.display =
+        wakeUp.ringing ? "" : "none";
+    document.getElementById("wake-up-stop-btn").style.display =
+        wakeUp.ringing || wakeUp.snoozed ? "" : "none";
+}
+
+async function loadWakeUp() {
+    try {
+        const response = await fetch("api/wake-up");
+        showWakeUp(await response.json());
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to load the wake-up alarm: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function snoozeWakeUp() {
+    await updateWakeUp("api/wake-up/snooze");
+}
+
+async function stopWakeUp() {
+    await updateWakeUp("api/wake-up/stop");
+}
+
+async function updateWakeUp(url) {
+    try {
+        const response = await fetch(url, {
+            method: "POST",
+            headers: {
+                "X-CSRF-Token": csrfToken,
+            },
+        });
+
+        if (response.ok) {
+            showWakeUp(await response.json());
+        } else {
+            const error = await response.text();
+            showMessage(
+                "events",
+                "Failed to update the wake-up alarm: " + error,
+                "error",
+            );
+        }
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to update the wake-up alarm: " + error.message,
+            "error",
+        );
+    }
+}
+
+function showVolume(volume) {
+    document.getElementById("volume-state").textContent =
+        "Volume " +
+        volume.level +
+        "%" +
+        (volume.override ? " (set by hand)" : " (scheduled)") +
+        ":";
+    document.getElementById("volume-level").value = volume.level;
+    document.getElementById("volume-reset-btn").style.display =
+        volume.override ? "" : "none";
+}
+
+async function loadVolume() {
+    try {
+        const response = await fetch("api/volume");
+        showVolume(await response.json());
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to load volume: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function setVolume() {
+    await updateVolume("api/volume", {
+        level: document.getElementById("volume-level").value,
+    });
+}
+
+async function resetVolume() {
+    await updateVolume("api/volume/reset", {});
+}
+
+async function updateVolume(url, form) {
+    try {
+        const response = await fetch(url, {
+            method: "POST",
+            headers: {
+                "X-CSRF-Token": csrfToken,
+            },
+            body: new URLSearchParams(form),
+        });
+
+        if (response.ok) {
+            showVolume(await response.json());
+        } else {
+            const error = await response.text();
+            showMessage("events", "Failed to update volume: " + error, "error");
+        }
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to update volume: " + error.message,
+            "error",
+        );
+    }
+}
+
+function showSinks(sinks) {
+    const container = document.getElementById("sinks");
+    container.innerHTML = "";
+    if (sinks.length === 0) {
+        container.style.display = "none";
+        return;
+    }
+    container.style.display = "";
+
+    const label = document.createElement("span");
+    label.textContent = "Play on:";
+    container.appendChild(label);
+    sinks.forEach((sink) => {
+        const item = document.createElement("label");
+        const checkbox = document.createElement("input");
+        checkbox.type = "checkbox";
+        checkbox.checked = sink.enabled;
+        checkbox.onchange = () => setSink(sink.name, checkbox.checked);
+        item.appendChild(checkbox);
+        item.appendChild(
+            document.createTextNode(" " + sink.name + " (" + sink.type + ")"),
+        );
+        container.appendChild(item);
+    });
+}
+
+async function loadSinks() {
+    try {
+        const response = await fetch("api/sinks");
+        showSinks(await response.json());
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to load audio sinks: " + error.message,
+            "error",
+        );
+    }
+}
+
+async function setSink(name, enabled) {
+    try {
+        const response = await fetch(
+            "api/sinks/" + encodeURIComponent(name),
+            {
+                method: "POST",
+                headers: {
+                    "X-CSRF-Token": csrfToken,
+                },
+                body: new URLSearchParams({ enabled: enabled }),
+            },
+        );
+
+        if (response.ok) {
+            showSinks(await response.json());
+        } else {
+            const error = await response.text();
+            showMessage(
+                "events",
+                "Failed to update audio sink: " + error,
+                "error",
+            );
+            loadSinks();
+        }
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to update audio sink: " + error.message,
+            "error",
+        );
+    }
+}
+
+let voicesTimer = null;
+
+async function loadVoices() {
+    clearTimeout(voicesTimer);
+    try {
+        const response = await fetch("api/tts/models");
+        if (!response.ok) {
+            throw new Error(await response.text());
+        }
+        const models = await response.json();
+        const list = document.getElementById("voices-list");
+        list.replaceChildren();
+
+        models.forEach((model) => {
+            const row = document.createElement("tr");
+            let state = model.active ? "In use" : model.state;
+            if (model.state === "downloading") {
+                state += " (" + (model.downloaded / 1048576).toFixed(0) + " MB)";
+            } else if (model.state === "failed") {
+                state += ": " + model.error;
+            }
+            [model.name, model.language, model.description, state].forEach(
+                (value) => {
+                    const cell = document.createElement("td");
+                    cell.textContent = value || "";
+                    row.appendChild(cell);
+                },
+            );
+
+            const actions = document.createElement("td");
+            const button = document.createElement("button");
+            if (model.state === "installed") {
+                button.className = "save-btn";
+                button.textContent = "Use";
+                button.disabled = model.active;
+                button.onclick = () => updateVoice(model.name, "activate");
+            } else {
+                button.className = "refresh-btn";
+                button.textContent = "Download";
+                button.disabled = model.state === "downloading";
+                button.onclick = () => updateVoice(model.name, "install");
+            }
+            actions.appendChild(button);
+            row.appendChild(actions);
+
+            list.appendChild(row);
+        });
+
+        // follow the downloads
+        if (models.some((model) => model.state === "downloading")) {
+            voicesTimer = setTimeout(loadVoices, 2000);
+        }
+    } catch (error) {
+        showMessage("voices", "Failed to load voices: " + error.message, "error");
+    }
+}
+
+async function updateVoice(name, action) {
+    try {
+        const response = await fetch(
+            "api/tts/models/" + encodeURIComponent(name) + "/" + action,
+            {
+                method: "POST",
+                headers: {
+                    "X-CSRF-Token": csrfToken,
+                },
+            },
+        );
+
+        if (response.ok) {
+            showMessage("voices", await response.text(), "success");
+        } else {
+            const error = await response.text();
+            showMessage("voices", "Failed to " + action + ": " + error, "error");
+        }
+    } catch (error) {
+        showMessage("voices", "Failed to " + action + ": " + error.message, "error");
+    }
+    loadVoices();
+}
+
+async function testSpeech() {
+    const text = document.getElementById("speak-text").value.trim();
+    if (!text) {
+        showMessage("events", "Enter a text to speak", "error");
+        return;
+    }
+
+    // the speech is generated and played before the request returns
+    const button = document.getElementById("speak-btn");
+    button.disabled = true;
+    try {
+        const response = await fetch("api/speak", {
+            method: "POST",
+            headers: {
+                "X-CSRF-Token": csrfToken,
+            },
+            body: new URLSearchParams({
+                text: text,
+                kind: document.getElementById("speak-kind").value,
+            }),
+        });
+
+        if (response.ok) {
+            showMessage("events", "Speech played", "success");
+        } else {
+            const error = await response.text();
+            showMessage("events", "Failed to speak: " + error, "error");
+        }
+    } catch (error) {
+        showMessage("events", "Failed to speak: " + error.message, "error");
+    } finally {
+        button.disabled = false;
+    }
+}
+
+// Follow the new log lines and announcements as they happen
+function connectLiveFeed() {
+    const url = new URL("api/ws", document.baseURI);
+    url.protocol = url.protocol === "https:" ? "wss:" : "ws:";
+    const feed = new WebSocket(url);
+    liveFeed = feed;
+
+    feed.onmessage = (message) => {
+        const msg = JSON.parse(message.data);
+        if (msg.type === "log") {
+            appendLog(msg.data);
+        } else if (
+            msg.type === "announcement" &&
+            document.getElementById("events-tab").classList.contains("active")
+        ) {
+            loadEvents();
+        }
+    };
+    feed.onclose = () => {
+        // reconnect, unless it was turned off
+        if (liveFeed === feed) {
+            liveFeed = null;
+            setTimeout(() => {
+                if (document.getElementById("live-logs").checked) {
+                    connectLiveFeed();
+                }
+            }, 5000);
+        }
+    };
+}
+
+function appendLog(line) {
+    if (logsFiltered()) {
+        return;
+    }
+    const textarea = document.getElementById("logs-textarea");
+    const atBottom =
+        textarea.scrollTop + textarea.clientHeight >= textarea.scrollHeight - 5;
+
+    textarea.value += line;
+    // keep the page light when left open for days
+    if (textarea.value.length > 1000000) {
+        textarea.value = textarea.value.slice(-500000);
+    }
+    if (atBottom) {
+        textarea.scrollTop = textarea.scrollHeight;
+    }
+}
+
+function toggleLiveFeed() {
+    if (document.getElementById("live-logs").checked) {
+        // catch up on what was missed while it was off
+        loadLogs();
+        connectLiveFeed();
+        showMessage("logs", "Live logs enabled", "success");
+    } else {
+        if (liveFeed) {
+            const feed = liveFeed;
+            liveFeed = null;
+            feed.close();
+        }
+        showMessage("logs", "Live logs disabled", "success");
+    }
+}
+
+function showMessage(type, message, level) {
+    const messageDiv = document.getElementById(type + "-message");
+    messageDiv.textContent = message;
+    messageDiv.className = "message " + level;
+    messageDiv.style.display = "block";
+
+    // Hide message after 5 seconds
+    setTimeout(() => {
+        messageDiv.style.display = "none";
+    }, 5000);
+}
+
+// pushSubscription returns the push subscription of this browser, null if
+// it has none or can't have one, e.g. over plain http
+async function pushSubscription() {
+    if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+        return null;
+    }
+    const registration = await navigator.serviceWorker.ready;
+    return registration.pushManager.getSubscription();
+}
+
+async function showNotifications() {
+    const state = document.getElementById("notifications-state");
+    const button = document.getElementById("notifications-btn");
+    const test = document.getElementById("notifications-test-btn");
+    if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+        state.textContent =
+            "Notifications need https, or a browser that supports them.";
+        button.style.display = "none";
+        test.style.display = "none";
+        return;
+    }
+
+    const subscription = await pushSubscription();
+    state.textContent = subscription
+        ? "Announcements are shown as notifications on this device."
+        : "Notifications on this device are off.";
+    button.textContent = subscription ? "Turn off" : "Turn on";
+    test.style.display = subscription ? "" : "none";
+}
+
+// applicationServerKey decodes the base64url public key of the server
+function applicationServerKey(key) {
+    const base64 = (key + "===".slice((key.length + 3) % 4))
+        .replace(/-/g, "+")
+        .replace(/_/g, "/");
+    return Uint8Array.from(atob(base64), (c) => c.charCodeAt(0));
+}
+
+async function postSubscription(url, subscription) {
+    const response = await fetch(url, {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json",
+            "X-CSRF-Token": csrfToken,
+        },
+        body: JSON.stringify(subscription),
+    });
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+}
+
+async function toggleNotifications() {
+    try {
+        const existing = await pushSubscription();
+        if (existing) {
+            await postSubscription("api/push/unsubscribe", existing).catch(
+                () => {},
+            );
+            await existing.unsubscribe();
+            showMessage("events", "Notifications turned off", "success");
+        } else {
+            if ((await Notification.requestPermission()) !== "granted") {
+                throw new Error("notifications are blocked in the browser");
+            }
+            const response = await fetch("api/push/key");
+            const key = await response.json();
+            const registration = await navigator.serviceWorker.ready;
+            const subscription = await registration.pushManager.subscribe({
+                userVisibleOnly: true,
+                applicationServerKey: applicationServerKey(key.public_key),
+            });
+            await postSubscription("api/push/subscribe", subscription);
+            showMessage(
+                "events",
+                key.enabled
+                    ? "Notifications turned on"
+                    : "Notifications turned on, they are sent once web_push is enabled in the configuration",
+                key.enabled ? "success" : "warning",
+            );
+        }
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to change the notifications: " + error.message,
+            "error",
+        );
+    }
+    showNotifications();
+}
+
+async function testNotification() {
+    try {
+        const subscription = await pushSubscription();
+        if (subscription) {
+            await postSubscription("api/push/test", subscription);
+        }
+    } catch (error) {
+        showMessage(
+            "events",
+            "Failed to send a test notification: " + error.message,
+            "error",
+        );
+    }
+}
+
+// Initialize application when page loads
+window.onload = async function () {
+    await getCSRFToken();
+    // viewers can't see or change the configuration
+    if (document.body.dataset.role === "admin") {
+        loadConfig();
+        loadConfigForm();
+        loadVersions();
+        loadSecrets();
+        loadSecretsForm();
+    } else {
+        document.querySelectorAll(".admin-only").forEach((element) => {
+            element.style.display = "none";
+        });
+    }
+    loadLogs();
+    connectLiveFeed();
+    // the service worker shows the pushed notifications
+    if ("serviceWorker" in navigator) {
+        navigator.serviceWorker.register("sw.js").catch((error) => {
+            console.warn("Service worker not registered:", error);
+        });
+    }
+    showNotifications();
+    // the dashboard of today is shown first
+    showTab("events");
 };
 
-// Clean up auto-refresh interval when page is unloaded
+// Close the live feed when page is unloaded
 window.onbeforeunload = function () {
-    if (autoRefreshInterval) {
-        clearInterval(autoRefreshInterval);
+    if (liveFeed) {
+        const feed = liveFeed;
+        liveFeed = null;
+        feed.close();
     }
 };
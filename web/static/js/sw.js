@@ -0,0 +1,50 @@
+// Service worker of the web interface: shows the notifications pushed for
+// the announcements, and opens the interface when one is clicked
+
+self.addEventListener("install", () => {
+    self.skipWaiting();
+});
+
+self.addEventListener("activate", (event) => {
+    event.waitUntil(self.clients.claim());
+});
+
+self.addEventListener("push", (event) => {
+    let message = {};
+    try {
+        message = event.data ? event.data.json() : {};
+    } catch (error) {
+        message = { body: event.data.text() };
+    }
+
+    event.waitUntil(
+        self.registration.showNotification(message.title || "Reminder", {
+            body: message.body || "",
+            tag: message.tag || undefined,
+            renotify: !!message.tag,
+            icon: "static/icons/icon-192.png",
+            badge: "static/icons/icon-192.png",
+            timestamp: message.time ? Date.parse(message.time) : Date.now(),
+            data: { url: message.url || "./" },
+        }),
+    );
+});
+
+self.addEventListener("notificationclick", (event) => {
+    event.notification.close();
+    // the URLs are relative to the interface, which may be under a prefix
+    const url = new URL(event.notification.data.url, self.registration.scope)
+        .href;
+
+    event.waitUntil(
+        self.clients
+            .matchAll({ type: "window", includeUncontrolled: true })
+            .then((windows) => {
+                const open = windows.find((w) => w.url === url);
+                if (open) {
+                    return open.focus();
+                }
+                return self.clients.openWindow(url);
+            }),
+    );
+});